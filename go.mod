@@ -0,0 +1,69 @@
+module github.com/couchbaselabs/gocb-example
+
+go 1.23.0
+
+require (
+	// Couchbase SDK, with scopes and collections
+	github.com/couchbase/gocb/v2 v2.9.4
+
+	// Unicode normalization of sanitize
+	golang.org/x/text v0.24.0
+
+	// The StreamDocs gRPC service of import/export -grpc
+	google.golang.org/grpc v1.65.0
+	google.golang.org/protobuf v1.34.2
+
+	// The NATS sink of export -nats
+	github.com/nats-io/nats.go v1.42.0
+
+	// The Redis cache the redis config warms
+	github.com/redis/go-redis/v9 v9.7.3
+
+	// The Postgres table of export -postgres
+	github.com/jackc/pgx/v5 v5.7.5
+
+	// The DCP client of the dcp engine, which gocb doesn't expose
+	github.com/couchbase/gocbcore/v10 v10.5.4
+
+	// The sandbox of wasmPlugins
+	github.com/tetratelabs/wazero v1.9.0
+
+	// The CEL expressions of filter.expr and celTransforms
+	github.com/google/cel-go v0.26.1
+)
+
+require (
+	cel.dev/expr v0.24.0 // indirect
+	github.com/antlr4-go/antlr/v4 v4.13.0 // indirect
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/couchbase/gocbcoreps v0.1.3 // indirect
+	github.com/couchbase/goprotostellar v1.0.2 // indirect
+	github.com/couchbaselabs/gocbconnstr/v2 v2.0.0-20240607131231-fb385523de28 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/go-logr/logr v1.4.1 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/golang/snappy v0.0.4 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/grpc-ecosystem/go-grpc-middleware v1.4.0 // indirect
+	github.com/jackc/pgpassfile v1.0.0 // indirect
+	github.com/jackc/pgservicefile v0.0.0-20240606120523-5a60cdf6a761 // indirect
+	github.com/klauspost/compress v1.18.0 // indirect
+	github.com/nats-io/nkeys v0.4.11 // indirect
+	github.com/nats-io/nuid v1.0.1 // indirect
+	github.com/stoewer/go-strcase v1.2.0 // indirect
+	go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc v0.49.0 // indirect
+	go.opentelemetry.io/otel v1.24.0 // indirect
+	go.opentelemetry.io/otel/metric v1.24.0 // indirect
+	go.opentelemetry.io/otel/trace v1.24.0 // indirect
+	go.uber.org/multierr v1.11.0 // indirect
+	go.uber.org/zap v1.27.0 // indirect
+	golang.org/x/crypto v0.37.0 // indirect
+	golang.org/x/exp v0.0.0-20230515195305-f3d0a9c9a5cc // indirect
+	golang.org/x/net v0.28.0 // indirect
+	golang.org/x/sys v0.32.0 // indirect
+	google.golang.org/genproto/googleapis/api v0.0.0-20240826202546-f6391c0de4c7 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240826202546-f6391c0de4c7 // indirect
+)
+
+// github.com/tleyden/json-anonymizer has no tagged releases.  `go mod tidy` pins it to a
+// pseudo-version of its latest commit and writes go.sum.
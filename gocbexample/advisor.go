@@ -0,0 +1,272 @@
+package gocbexample
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// The number of batch latencies kept per direction, the most recent replacing the oldest
+	maxLatencySamples = 10000
+
+	// Above this share of docs retried or failed with temporary errors (incl timeouts), the cluster is pushed too hard
+	maxHealthyErrorRate = 0.01
+
+	// Batches faster than this leave room for more workers or bigger pages, slower ones risk timeouts
+	fastBatchLatency = 50 * time.Millisecond
+	slowBatchLatency = 2 * time.Second
+
+	// The most workers the advisor recommends, and the smallest page size
+	maxAdvisedWorkers  = 64
+	minAdvisedPageSize = 100
+)
+
+// The latencies and errors of the reads or writes of a run
+type throughputDirection struct {
+	batches   int64
+	docs      int64
+	bytes     int64
+	retries   int64
+	failures  int64
+	elapsed   time.Duration
+	latencies []time.Duration
+}
+
+func (d *throughputDirection) record(docs, bytes int, latency time.Duration, retries, failures int) {
+	d.batches++
+	d.docs += int64(docs)
+	d.bytes += int64(bytes)
+	d.retries += int64(retries)
+	d.failures += int64(failures)
+	d.elapsed += latency
+	if len(d.latencies) < maxLatencySamples {
+		d.latencies = append(d.latencies, latency)
+	} else {
+		d.latencies[d.batches%maxLatencySamples] = latency
+	}
+}
+
+// The stats of the reads or writes, as reported
+func (d *throughputDirection) stats(wallClock time.Duration) *ThroughputStats {
+
+	if d.batches == 0 {
+		return nil
+	}
+	latencies := append([]time.Duration{}, d.latencies...)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	percentile := func(p float64) time.Duration {
+		return latencies[int(p*float64(len(latencies)-1))]
+	}
+
+	stats := &ThroughputStats{
+		Batches:        d.batches,
+		Docs:           d.docs,
+		Bytes:          d.bytes,
+		Retries:        d.retries,
+		Failures:       d.failures,
+		LatencyP50:     percentile(0.5).Round(time.Millisecond).String(),
+		LatencyP95:     percentile(0.95).Round(time.Millisecond).String(),
+		ErrorRate:      float64(d.retries+d.failures) / float64(d.docs),
+		latencyP95:     percentile(0.95),
+		averageLatency: d.elapsed / time.Duration(d.batches),
+	}
+	if seconds := wallClock.Seconds(); seconds > 0 {
+		stats.DocsPerSecond = float64(d.docs) / seconds
+		stats.BytesPerSecond = float64(d.bytes) / seconds
+	}
+	return stats
+}
+
+// Records the latency and errors of every batch of KV reads and writes, for the tuning advice
+type throughputStats struct {
+	mutex     sync.Mutex
+	startedAt time.Time
+	reads     throughputDirection
+	writes    throughputDirection
+}
+
+func (s *throughputStats) recordReads(docs int, latency time.Duration, retries, failures int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.startedAt.IsZero() {
+		s.startedAt = time.Now().Add(-latency)
+	}
+	s.reads.record(docs, 0, latency, retries, failures)
+}
+
+func (s *throughputStats) recordWrites(docs, bytes int, latency time.Duration, failures int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.startedAt.IsZero() {
+		s.startedAt = time.Now().Add(-latency)
+	}
+	s.writes.record(docs, bytes, latency, 0, failures)
+}
+
+// The number of the docs of a write that failed with a temporary error (incl timeouts).  Other failures, eg a doc
+// that already exists, say nothing about how hard the cluster is pushed
+func temporaryWriteFailures(results []WriteResult, err error) int {
+	failures := 0
+	for _, result := range results {
+		if result.Err != nil && errors.Is(result.Err, ErrTemporary) {
+			failures++
+		}
+	}
+	if failures == 0 && len(results) == 0 && err != nil && errors.Is(err, ErrTemporary) {
+		failures = 1
+	}
+	return failures
+}
+
+// Observed throughput of the KV reads or writes of a run.  Bytes are only measured for writes
+type ThroughputStats struct {
+	Batches        int64   `json:"batches"`
+	Docs           int64   `json:"docs"`
+	Bytes          int64   `json:"bytes,omitempty"`
+	Retries        int64   `json:"retries"`
+	Failures       int64   `json:"failures"`
+	LatencyP50     string  `json:"latencyP50"`
+	LatencyP95     string  `json:"latencyP95"`
+	ErrorRate      float64 `json:"errorRate"`
+	DocsPerSecond  float64 `json:"docsPerSecond"`
+	BytesPerSecond float64 `json:"bytesPerSecond,omitempty"`
+
+	latencyP95     time.Duration
+	averageLatency time.Duration
+}
+
+// The settings the advisor recommends, as config file keys so they can be pasted into one.  Zero rate limits
+// mean no limit
+type TunedSettings struct {
+	Workers             int               `json:"workers"`
+	PageSize            int               `json:"pageSize"`
+	WriteBatch          *WriteBatchConfig `json:"writeBatch,omitempty"`
+	ReadBytesPerSecond  int64             `json:"readBytesPerSecond,omitempty"`
+	WriteBytesPerSecond int64             `json:"writeBytesPerSecond,omitempty"`
+}
+
+// Recommended settings for the next run, based on the latencies and error rates of this one
+type TuningAdvice struct {
+	Reads       *ThroughputStats `json:"reads,omitempty"`
+	Writes      *ThroughputStats `json:"writes,omitempty"`
+	Current     TunedSettings    `json:"current"`
+	Recommended TunedSettings    `json:"recommended"`
+
+	// Why each recommended setting differs from the current one
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+// Recommend workers, page size, write batch size and rate limits from the reads and writes of the run so far.
+// Returns nil if nothing has been read or written yet.  The rules of thumb:
+//   - More than 1% of docs retried or failing with temporary errors means the cluster is pushed too hard: halve
+//     the workers and cap the write byte rate a fifth below what was reached
+//   - Otherwise, if batches are fast, double the workers, since the cluster has room for more concurrent batches
+//   - Halve the page size if batches are slow enough to risk timeouts, double it if they're fast
+//   - If writes are much faster than reads, batch the writes of several read pages together
+func (e *ExampleApp) TuningAdvice() *TuningAdvice {
+
+	e.throughput.mutex.Lock()
+	wallClock := time.Since(e.throughput.startedAt)
+	reads := e.throughput.reads.stats(wallClock)
+	writes := e.throughput.writes.stats(wallClock)
+	e.throughput.mutex.Unlock()
+
+	if reads == nil && writes == nil {
+		return nil
+	}
+
+	current := TunedSettings{
+		Workers:             e.Workers,
+		PageSize:            e.PageSize,
+		ReadBytesPerSecond:  e.ReadBytesPerSecond,
+		WriteBytesPerSecond: e.WriteBytesPerSecond,
+	}
+	if e.WriteBatching.enabled() {
+		current.WriteBatch = &WriteBatchConfig{
+			MaxDocs:       e.WriteBatching.MaxDocs,
+			MaxBytes:      e.WriteBatching.MaxBytes,
+			MaxWaitMillis: int(e.WriteBatching.MaxWait / time.Millisecond),
+		}
+	}
+	advice := &TuningAdvice{Reads: reads, Writes: writes, Current: current, Recommended: current}
+	recommended := &advice.Recommended
+
+	var errorRate float64
+	var slowest time.Duration
+	for _, stats := range []*ThroughputStats{reads, writes} {
+		if stats == nil {
+			continue
+		}
+		if stats.ErrorRate > errorRate {
+			errorRate = stats.ErrorRate
+		}
+		if stats.latencyP95 > slowest {
+			slowest = stats.latencyP95
+		}
+	}
+
+	switch {
+	case errorRate > maxHealthyErrorRate:
+		recommended.Workers = maxInt(1, e.Workers/2)
+		if writes != nil && writes.BytesPerSecond > 0 {
+			recommended.WriteBytesPerSecond = int64(writes.BytesPerSecond * 0.8)
+		}
+		advice.Reasons = append(advice.Reasons, fmt.Sprintf("%.1f%% of docs were retried or failed with temporary errors, so the cluster "+
+			"is pushed too hard: fewer workers, and the write rate capped a fifth below the rate reached", errorRate*100))
+	case slowest < fastBatchLatency && e.Workers < maxAdvisedWorkers:
+		recommended.Workers = minInt(maxAdvisedWorkers, e.Workers*2)
+		advice.Reasons = append(advice.Reasons, fmt.Sprintf("Batches took at most %v (p95) without errors, so the cluster has room "+
+			"for more workers", slowest.Round(time.Millisecond)))
+	}
+
+	switch {
+	case slowest > slowBatchLatency && e.PageSize > minAdvisedPageSize:
+		recommended.PageSize = maxInt(minAdvisedPageSize, e.PageSize/2)
+		advice.Reasons = append(advice.Reasons, fmt.Sprintf("Batches took up to %v (p95), which risks timeouts: smaller pages",
+			slowest.Round(time.Millisecond)))
+	case slowest < fastBatchLatency && errorRate <= maxHealthyErrorRate && e.PageSize < maxPageSize:
+		recommended.PageSize = minInt(maxPageSize, e.PageSize*2)
+		advice.Reasons = append(advice.Reasons, "Batches were fast, so bigger pages cut the per-page overhead")
+	}
+
+	if reads != nil && writes != nil && errorRate <= maxHealthyErrorRate &&
+		writes.averageLatency > 0 && reads.averageLatency > 4*writes.averageLatency {
+		recommended.WriteBatch = &WriteBatchConfig{MaxDocs: minInt(maxPageSize, recommended.PageSize*4), MaxWaitMillis: 1000}
+		advice.Reasons = append(advice.Reasons, fmt.Sprintf("Writes (%v per batch) were much faster than reads (%v per page), so "+
+			"writing several pages per batch saves round trips", writes.averageLatency.Round(time.Millisecond), reads.averageLatency.Round(time.Millisecond)))
+	}
+
+	return advice
+}
+
+// Log the recommendations that differ from the current settings
+func (a *TuningAdvice) log() {
+	if len(a.Reasons) == 0 {
+		log.Printf("Tuning advice: the current settings look right (workers %v, pageSize %v)", a.Current.Workers, a.Current.PageSize)
+		return
+	}
+	for _, reason := range a.Reasons {
+		log.Printf("Tuning advice: %v", reason)
+	}
+	recommended, _ := json.Marshal(a.Recommended)
+	log.Printf("Tuning advice: recommended config settings %s", recommended)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,73 @@
+package gocbexample
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// The Analytics query listing the ids of the docs in a dataset, eg "Default.airlines" ->
+// "SELECT VALUE META(d).id FROM `Default`.`airlines` d"
+func AnalyticsIdQuery(dataset string) string {
+	parts := strings.Split(dataset, ".")
+	for i, part := range parts {
+		parts[i] = "`" + strings.Trim(part, "`") + "`"
+	}
+	return fmt.Sprintf("SELECT VALUE META(d).id FROM %s d", strings.Join(parts, "."))
+}
+
+// Loop over the docs listed by the AnalyticsDataset, hydrating their bodies from KV a page of bulk gets at a
+// time.  The dataset is the authoritative list of ids, which helps when the query service isn't deployed but
+// Analytics shadows the bucket.  Docs deleted since Analytics ingested them are skipped.
+func (e *ExampleApp) ForEachDocIdAnalytics(docProcessor DocProcessor, bucket *gocb.Bucket) (err error) {
+
+	e.logf("Performing operation over analytics dataset %v, hydrating from bucket: %v", e.AnalyticsDataset, bucket.Name())
+	defer e.logf("Finished operation over analytics dataset %v", e.AnalyticsDataset)
+
+	statement := AnalyticsIdQuery(e.AnalyticsDataset)
+	var results n1qlRows
+	_, err = e.RetryPolicy.do(e.logf, "analytics query", func(error) bool { return true }, func() (err error) {
+		result, err := e.cluster(bucket).AnalyticsQuery(statement, nil)
+		if err == nil {
+			results = &gocbRows{result: result}
+		}
+		return err
+	})
+	if err != nil {
+		return newDocError(PhaseSourceRead, "", fmt.Errorf("Error running analytics query: %v.  Err: %v", statement, err))
+	}
+	defer results.Close()
+
+	docIds := make([]string, 0, e.PageSize)
+	hydrate := func() error {
+		if len(docIds) == 0 {
+			return nil
+		}
+		foundDocIds, docs, err := e.fetchDocs(bucket, docIds)
+		docIds = docIds[:0]
+		if err != nil {
+			return err
+		}
+		if docProcessor != nil && len(foundDocIds) > 0 {
+			return docProcessor(foundDocIds, docs)
+		}
+		return nil
+	}
+
+	var docId string
+	for results.Next(&docId) {
+		docIds = append(docIds, docId)
+		if len(docIds) >= e.PageSize {
+			if err := hydrate(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := hydrate(); err != nil {
+		return err
+	}
+
+	// Surface any errors that were reported after the results
+	return newDocError(PhaseSourceRead, "", results.Close())
+}
@@ -0,0 +1,483 @@
+package gocbexample
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tleyden/json-anonymizer"
+)
+
+// Name of the rule set applied to docs that no configured rule set matches
+const defaultAnonymizeRuleSet = "default"
+
+// Fields starting with an underscore (eg _provenance) are left alone by the default rule set
+const defaultAnonymizeSkipFields = "_(.)*"
+
+// A set of anonymization rules scoped to the docs it matches.  A rule set with neither Types
+// nor KeyPattern matches every doc.
+type AnonymizeRuleSet struct {
+	Name string `json:"name"`
+
+	// Match docs whose type field is one of these, eg ["user"]
+	Types []string `json:"types,omitempty"`
+
+	// Match docs whose id matches this regex, eg "^airport_"
+	KeyPattern string `json:"keyPattern,omitempty"`
+
+	// Copy matching docs untouched, eg for static reference data
+	PassThrough bool `json:"passThrough,omitempty"`
+
+	// Regexes of field names that are left as is
+	SkipFields []string `json:"skipFields,omitempty"`
+
+	// Anonymize the doc ids as well as the bodies
+	AnonymizeKeys bool `json:"anonymizeKeys,omitempty"`
+
+	// Only anonymize the capture groups of this regex in doc ids, rather than the whole id, eg
+	// "^user::(.+)$" turns "user::jane.doe@example.com" into "user::<anonymized>".  Ids it doesn't
+	// match are anonymized whole.  Implies anonymizeKeys
+	KeySegments string `json:"keySegments,omitempty"`
+
+	// Anonymize with a keyed hash of the salt, so the same value always anonymizes to the same result
+	// and references between docs (eg airline ids in routes) still line up.  Requires a salt
+	Deterministic bool `json:"deterministic,omitempty"`
+
+	// Keep the JSON structure and types but replace every leaf value: strings become random strings of
+	// the same length, numbers are jittered by up to JitterPercent (10 by default) and booleans are kept
+	KeepStructure bool    `json:"keepStructure,omitempty"`
+	JitterPercent float64 `json:"jitterPercent,omitempty"`
+}
+
+// Anonymization settings.  Rule sets are evaluated in order and the first match wins.  Docs that no
+// rule set matches get the default rules: every field but those starting with an underscore is
+// anonymized, as are the doc ids.
+type AnonymizeConfig struct {
+
+	// The doc field that rule set Types are matched against.  Defaults to "type"
+	TypeField string `json:"typeField,omitempty"`
+
+	RuleSets []AnonymizeRuleSet `json:"ruleSets,omitempty"`
+
+	// Where the salt for deterministic rule sets is read from: a file, or else an environment variable
+	// (ANONYMIZE_SALT by default).  The salt itself is never logged or written to reports.
+	SaltFile string `json:"saltFile,omitempty"`
+	SaltEnv  string `json:"saltEnv,omitempty"`
+
+	// Seed of the random values of keepStructure rule sets.  The values for a doc depend only on the seed
+	// and the doc id.  Generated if 0
+	Seed int64 `json:"seed,omitempty"`
+}
+
+type compiledRuleSet struct {
+	AnonymizeRuleSet
+	types          map[string]bool
+	keyRegexp      *regexp.Regexp
+	segmentsRegexp *regexp.Regexp
+	skipRegexps    []*regexp.Regexp
+	anonymizer     *json_anonymizer.JsonAnonymizer
+}
+
+func (r *compiledRuleSet) matches(typeField string, docId string, doc interface{}) bool {
+	if len(r.types) > 0 {
+		docMap, ok := doc.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		docType, _ := docMap[typeField].(string)
+		if !r.types[docType] {
+			return false
+		}
+	}
+	if r.keyRegexp != nil && !r.keyRegexp.MatchString(docId) {
+		return false
+	}
+	return true
+}
+
+// A pre-insert stage that anonymizes docs with the rule set matching each doc
+type Anonymizer struct {
+	typeField string
+	ruleSets  []*compiledRuleSet
+	salt      secret
+	seed      int64
+
+	mutex    sync.Mutex
+	docs     map[string]int
+	coverage map[string]map[string]int
+}
+
+// Summary of what an Anonymizer did
+type AnonymizeReport struct {
+	// Number of docs each rule set was applied to, keyed by rule set name
+	RuleSetDocs map[string]int
+
+	// Every field path seen, with what was done to it, so reviewers can confirm nothing sensitive was
+	// copied untouched
+	Fields []FieldCoverage
+
+	// Paths that were copied untouched in at least one doc
+	UntouchedPaths []string
+}
+
+// What was done to a field path, eg "$.geo.lat" or "$.schedule[*].day".  The doc id is reported as "$id".
+type FieldCoverage struct {
+	Path string
+
+	// Number of docs the path appeared in
+	Count int
+
+	// Number of docs each treatment was applied in, keyed by "<rule set>: <treatment>", where the
+	// treatment is anonymized, passThrough or skipped (<regex>)
+	Treatments map[string]int
+}
+
+// Treatments of a field
+const (
+	treatmentAnonymized  = "anonymized"
+	treatmentPassThrough = "passThrough"
+	treatmentSkipped     = "skipped"
+)
+
+// Pseudo path of the doc id in the coverage report
+const docIdCoveragePath = "$id"
+
+// Compile the rule sets in config.  Returns an error if a regex is invalid.
+func NewAnonymizer(config AnonymizeConfig) (*Anonymizer, error) {
+
+	a := &Anonymizer{
+		typeField: config.TypeField,
+		docs:      map[string]int{},
+		coverage:  map[string]map[string]int{},
+	}
+	if a.typeField == "" {
+		a.typeField = defaultTypeField
+	}
+
+	salt, err := config.loadSalt()
+	if err != nil {
+		return nil, err
+	}
+	a.salt = salt
+	a.seed = config.Seed
+	if a.seed == 0 {
+		a.seed = time.Now().UnixNano()
+	}
+
+	ruleSets := append([]AnonymizeRuleSet{}, config.RuleSets...)
+	ruleSets = append(ruleSets, AnonymizeRuleSet{
+		Name:          defaultAnonymizeRuleSet,
+		SkipFields:    []string{defaultAnonymizeSkipFields},
+		AnonymizeKeys: true,
+	})
+
+	for i, ruleSet := range ruleSets {
+		if ruleSet.Name == "" {
+			ruleSet.Name = fmt.Sprintf("ruleSet%v", i)
+		}
+		if ruleSet.Deterministic && len(salt) == 0 {
+			return nil, fmt.Errorf("Rule set: %v is deterministic, but no salt is set.  Set saltFile or the %v environment variable", ruleSet.Name, config.saltEnv())
+		}
+		if ruleSet.Deterministic && ruleSet.KeepStructure {
+			return nil, fmt.Errorf("Rule set: %v can't be both deterministic and keepStructure", ruleSet.Name)
+		}
+		if ruleSet.JitterPercent < 0 {
+			return nil, fmt.Errorf("Invalid jitterPercent: %v in rule set: %v", ruleSet.JitterPercent, ruleSet.Name)
+		}
+		if ruleSet.JitterPercent == 0 {
+			ruleSet.JitterPercent = defaultJitterPercent
+		}
+		compiled := &compiledRuleSet{AnonymizeRuleSet: ruleSet}
+		if len(ruleSet.Types) > 0 {
+			compiled.types = map[string]bool{}
+			for _, docType := range ruleSet.Types {
+				compiled.types[docType] = true
+			}
+		}
+		if ruleSet.KeyPattern != "" {
+			keyRegexp, err := regexp.Compile(ruleSet.KeyPattern)
+			if err != nil {
+				return nil, fmt.Errorf("Error compiling key pattern of rule set: %v.  Err: %v", ruleSet.Name, err)
+			}
+			compiled.keyRegexp = keyRegexp
+		}
+		if ruleSet.KeySegments != "" {
+			segmentsRegexp, err := regexp.Compile(ruleSet.KeySegments)
+			if err != nil {
+				return nil, fmt.Errorf("Error compiling key segments of rule set: %v.  Err: %v", ruleSet.Name, err)
+			}
+			if segmentsRegexp.NumSubexp() == 0 {
+				return nil, fmt.Errorf("Key segments of rule set: %v has no capture groups, so nothing would be anonymized", ruleSet.Name)
+			}
+			compiled.segmentsRegexp = segmentsRegexp
+			compiled.AnonymizeKeys = true
+		}
+		anonymizerConfig := json_anonymizer.JsonAnonymizerConfig{
+			AnonymizeKeys: ruleSet.AnonymizeKeys,
+		}
+		for _, skipField := range ruleSet.SkipFields {
+			skipRegexp, err := regexp.Compile(skipField)
+			if err != nil {
+				return nil, fmt.Errorf("Error compiling skip field of rule set: %v.  Err: %v", ruleSet.Name, err)
+			}
+			anonymizerConfig.SkipFieldsMatchingRegex = append(anonymizerConfig.SkipFieldsMatchingRegex, skipRegexp)
+		}
+		compiled.skipRegexps = anonymizerConfig.SkipFieldsMatchingRegex
+		compiled.anonymizer = json_anonymizer.NewJsonAnonymizer(anonymizerConfig)
+		a.ruleSets = append(a.ruleSets, compiled)
+	}
+
+	return a, nil
+}
+
+// The first rule set matching the doc.  The default rule set matches every doc.
+func (a *Anonymizer) ruleSet(docId string, doc interface{}) *compiledRuleSet {
+	for _, ruleSet := range a.ruleSets {
+		if ruleSet.matches(a.typeField, docId, doc) {
+			return ruleSet
+		}
+	}
+	return a.ruleSets[len(a.ruleSets)-1]
+}
+
+func (a *Anonymizer) Transform(input DocProcessorInput) (output DocProcessorInput, err error) {
+
+	output = DocProcessorInput{
+		DocIds: make([]string, 0, len(input.DocIds)),
+		Docs:   make([]interface{}, 0, len(input.Docs)),
+	}
+	applied := map[string]int{}
+	coverage := map[string]map[string]int{}
+	var failed DocErrors
+
+	for i, docId := range input.DocIds {
+		doc := input.Docs[i]
+
+		ruleSet := a.ruleSet(docId, doc)
+		newDocId, anonymizedVal := docId, doc
+		if !ruleSet.PassThrough {
+			rng := rand.New(rand.NewSource(docRandSeed(a.seed, docId)))
+			anonymizedVal, err = a.anonymizeValue(ruleSet, doc, rng)
+			if err != nil {
+				failed = failed.add(docId, doc, fmt.Errorf("Error anonymizing doc with rule set: %v.  Err: %w", ruleSet.Name, err))
+				continue
+			}
+			if ruleSet.AnonymizeKeys {
+				newDocId, err = a.anonymizeKey(ruleSet, docId, rng)
+				if err != nil {
+					failed = failed.add(docId, doc, fmt.Errorf("Error anonymizing doc id itself.  Err: %w", err))
+					continue
+				}
+			}
+		}
+
+		// Only the docs anonymized are counted, so that the docs left out of the copy aren't
+		applied[ruleSet.Name] += 1
+		ruleSet.recordCoverage(docId, doc, coverage)
+		output.DocIds = append(output.DocIds, newDocId)
+		output.Docs = append(output.Docs, anonymizedVal)
+	}
+
+	a.mutex.Lock()
+	for name, count := range applied {
+		a.docs[name] += count
+	}
+	for path, treatments := range coverage {
+		if a.coverage[path] == nil {
+			a.coverage[path] = map[string]int{}
+		}
+		for treatment, count := range treatments {
+			a.coverage[path][treatment] += count
+		}
+	}
+	a.mutex.Unlock()
+
+	return output, failed.err()
+}
+
+// Anonymize a doc body or doc id with the rule set's anonymizer
+func (a *Anonymizer) anonymizeValue(ruleSet *compiledRuleSet, val interface{}, rng *rand.Rand) (interface{}, error) {
+	switch {
+	case ruleSet.Deterministic:
+		return a.salt.anonymize(val, ruleSet.skipField), nil
+	case ruleSet.KeepStructure:
+		return scrubLeaves(val, rng, ruleSet.JitterPercent, ruleSet.skipField), nil
+	default:
+		return ruleSet.anonymizer.Anonymize(val)
+	}
+}
+
+// Anonymize a doc id: just the key segments captured by the rule set's keySegments regex if it matches,
+// otherwise the whole id
+func (a *Anonymizer) anonymizeKey(ruleSet *compiledRuleSet, docId string, rng *rand.Rand) (string, error) {
+
+	var match []int
+	if ruleSet.segmentsRegexp != nil {
+		match = ruleSet.segmentsRegexp.FindStringSubmatchIndex(docId)
+	}
+	if match == nil {
+		anonymized, err := a.anonymizeValue(ruleSet, docId, rng)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%v", anonymized), nil
+	}
+
+	var newDocId strings.Builder
+	end := 0
+	for group := 1; group <= ruleSet.segmentsRegexp.NumSubexp(); group++ {
+		start, stop := match[2*group], match[2*group+1]
+		if start < end {
+			// Unmatched optional group, or nested in a group that was already anonymized
+			continue
+		}
+		anonymized, err := a.anonymizeValue(ruleSet, docId[start:stop], rng)
+		if err != nil {
+			return "", err
+		}
+		newDocId.WriteString(docId[end:start])
+		newDocId.WriteString(fmt.Sprintf("%v", anonymized))
+		end = stop
+	}
+	newDocId.WriteString(docId[end:])
+	return newDocId.String(), nil
+}
+
+// The seed of the random values of keepStructure rule sets.  Returns false if no rule set uses it.
+func (a *Anonymizer) Seed() (int64, bool) {
+	for _, ruleSet := range a.ruleSets {
+		if ruleSet.KeepStructure {
+			return a.seed, true
+		}
+	}
+	return 0, false
+}
+
+func (a *Anonymizer) Report() AnonymizeReport {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	report := AnonymizeReport{RuleSetDocs: map[string]int{}}
+	for name, count := range a.docs {
+		report.RuleSetDocs[name] = count
+	}
+
+	paths := make([]string, 0, len(a.coverage))
+	for path := range a.coverage {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		field := FieldCoverage{Path: path, Treatments: map[string]int{}}
+		untouched := false
+		for treatment, count := range a.coverage[path] {
+			field.Treatments[treatment] = count
+			field.Count += count
+			if !strings.HasSuffix(treatment, treatmentAnonymized) {
+				untouched = true
+			}
+		}
+		report.Fields = append(report.Fields, field)
+		if untouched {
+			report.UntouchedPaths = append(report.UntouchedPaths, path)
+		}
+	}
+
+	return report
+}
+
+// Record what the rule set does to each field path of a doc.  A path is counted once per doc, even if
+// it appears in several elements of an array.
+func (r *compiledRuleSet) recordCoverage(docId string, doc interface{}, coverage map[string]map[string]int) {
+
+	seen := map[string]bool{}
+	record := func(path, treatment string) {
+		if seen[path] {
+			return
+		}
+		seen[path] = true
+		key := fmt.Sprintf("%v: %v", r.Name, treatment)
+		if coverage[path] == nil {
+			coverage[path] = map[string]int{}
+		}
+		coverage[path][key] += 1
+	}
+
+	switch {
+	case r.PassThrough || !r.AnonymizeKeys:
+		record(docIdCoveragePath, treatmentPassThrough)
+	default:
+		record(docIdCoveragePath, treatmentAnonymized)
+	}
+
+	var walk func(path string, val interface{}, treatment string)
+	walk = func(path string, val interface{}, treatment string) {
+		switch v := val.(type) {
+		case map[string]interface{}:
+			for key, child := range v {
+				childTreatment := treatment
+				if treatment == treatmentAnonymized {
+					// Skipped fields are left as is, along with everything under them
+					for _, skipRegexp := range r.skipRegexps {
+						if skipRegexp.MatchString(key) {
+							childTreatment = fmt.Sprintf("%v (%v)", treatmentSkipped, skipRegexp)
+							break
+						}
+					}
+				}
+				walk(path+"."+key, child, childTreatment)
+			}
+		case []interface{}:
+			for _, child := range v {
+				walk(path+"[*]", child, treatment)
+			}
+		default:
+			record(path, treatment)
+		}
+	}
+
+	if r.PassThrough {
+		walk("$", doc, treatmentPassThrough)
+	} else {
+		walk("$", doc, treatmentAnonymized)
+	}
+}
+
+// The configured rule sets that never matched a doc, which usually means a typo'd type or key pattern
+func (a *Anonymizer) UnusedRules() []string {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	var unused []string
+	for _, ruleSet := range a.ruleSets {
+		if ruleSet.Name != defaultAnonymizeRuleSet && a.docs[ruleSet.Name] == 0 {
+			unused = append(unused, fmt.Sprintf("anonymize.ruleSets %v", ruleSet.Name))
+		}
+	}
+	return unused
+}
+
+// Fingerprint of the salt, safe to store.  Empty if there's no salt.
+func (a *Anonymizer) SaltFingerprint() string {
+	return a.salt.fingerprint()
+}
+
+func (c AnonymizeConfig) saltEnv() string {
+	if c.SaltEnv != "" {
+		return c.SaltEnv
+	}
+	return defaultSaltEnv
+}
+
+// Is the field left as is by the rule set?
+func (r *compiledRuleSet) skipField(key string) bool {
+	for _, skipRegexp := range r.skipRegexps {
+		if skipRegexp.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
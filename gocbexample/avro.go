@@ -0,0 +1,567 @@
+package gocbexample
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+)
+
+const (
+	// Avro block compression codecs
+	AvroCodecNull    = "null"
+	AvroCodecDeflate = "deflate"
+
+	// Records are buffered into blocks of about this many bytes, each followed by the file's sync marker
+	avroBlockSize = 64 * 1024
+
+	// Namespace of inferred schemas, unless set
+	defaultAvroNamespace = "couchbase"
+
+	// The field of each record holding the doc id, unless set
+	defaultAvroIdField = "docId"
+)
+
+// A parsed Avro schema, as needed to encode docs with it
+type avroSchema struct {
+
+	// A primitive type, or record, enum, array, map, fixed or union
+	Type string
+
+	// Full name of a record, enum or fixed
+	Name string
+
+	Fields   []avroField
+	Symbols  []string
+	Items    *avroSchema
+	Values   *avroSchema
+	Size     int
+	Branches []*avroSchema
+
+	// Record fields by the doc field they're read from
+	fieldsByJSON map[string]*avroField
+}
+
+type avroField struct {
+	Name string
+
+	// The doc field the value is read from, if not Name, eg when the doc field isn't a valid Avro name.  Set by the
+	// "jsonField" attribute of the field
+	JSONField string
+
+	Schema     *avroSchema
+	Default    interface{}
+	HasDefault bool
+}
+
+func isAvroPrimitive(avroType string) bool {
+	switch avroType {
+	case "null", "boolean", "int", "long", "float", "double", "bytes", "string":
+		return true
+	}
+	return false
+}
+
+// Parse an Avro schema in its JSON form
+func parseAvroSchema(schemaJSON []byte) (*avroSchema, error) {
+	var raw interface{}
+	if err := json.Unmarshal(schemaJSON, &raw); err != nil {
+		return nil, fmt.Errorf("Invalid Avro schema JSON.  Err: %v", err)
+	}
+	return parseAvroSchemaValue(raw, "", map[string]*avroSchema{})
+}
+
+// Parse a decoded schema.  named holds the records, enums and fixed types defined so far, by full name
+func parseAvroSchemaValue(raw interface{}, namespace string, named map[string]*avroSchema) (*avroSchema, error) {
+
+	switch schema := raw.(type) {
+	case string:
+		if isAvroPrimitive(schema) {
+			return &avroSchema{Type: schema}, nil
+		}
+		if defined, ok := named[avroFullName(schema, namespace)]; ok {
+			return defined, nil
+		}
+		if defined, ok := named[schema]; ok {
+			return defined, nil
+		}
+		return nil, fmt.Errorf("Unknown Avro type: %v", schema)
+
+	case []interface{}:
+		union := &avroSchema{Type: "union"}
+		for _, branch := range schema {
+			parsed, err := parseAvroSchemaValue(branch, namespace, named)
+			if err != nil {
+				return nil, err
+			}
+			if parsed.Type == "union" {
+				return nil, fmt.Errorf("Avro unions can't directly hold other unions")
+			}
+			union.Branches = append(union.Branches, parsed)
+		}
+		if len(union.Branches) == 0 {
+			return nil, fmt.Errorf("Empty Avro union")
+		}
+		return union, nil
+
+	case map[string]interface{}:
+		avroType, ok := schema["type"].(string)
+		if !ok {
+			// eg {"type": {"type": "array", ..}}
+			return parseAvroSchemaValue(schema["type"], namespace, named)
+		}
+		switch avroType {
+		case "record", "error", "enum", "fixed":
+			return parseAvroNamedSchema(schema, avroType, namespace, named)
+		case "array":
+			items, err := parseAvroSchemaValue(schema["items"], namespace, named)
+			if err != nil {
+				return nil, fmt.Errorf("array items: %v", err)
+			}
+			return &avroSchema{Type: "array", Items: items}, nil
+		case "map":
+			values, err := parseAvroSchemaValue(schema["values"], namespace, named)
+			if err != nil {
+				return nil, fmt.Errorf("map values: %v", err)
+			}
+			return &avroSchema{Type: "map", Values: values}, nil
+		}
+		// A primitive, possibly with a logical type, which is written as the primitive
+		return parseAvroSchemaValue(avroType, namespace, named)
+	}
+	return nil, fmt.Errorf("Invalid Avro schema: %v", raw)
+}
+
+func parseAvroNamedSchema(schema map[string]interface{}, avroType, namespace string, named map[string]*avroSchema) (*avroSchema, error) {
+
+	name, _ := schema["name"].(string)
+	if name == "" {
+		return nil, fmt.Errorf("Avro %v has no name", avroType)
+	}
+	if ns, ok := schema["namespace"].(string); ok && !strings.Contains(name, ".") {
+		namespace = ns
+	}
+	fullName := avroFullName(name, namespace)
+	if _, ok := named[fullName]; ok {
+		return nil, fmt.Errorf("Avro type: %v is defined twice", fullName)
+	}
+	if i := strings.LastIndex(fullName, "."); i >= 0 {
+		namespace = fullName[:i]
+	}
+
+	parsed := &avroSchema{Type: avroType, Name: fullName}
+	named[fullName] = parsed
+
+	switch avroType {
+	case "enum":
+		symbols, _ := schema["symbols"].([]interface{})
+		for _, symbol := range symbols {
+			s, ok := symbol.(string)
+			if !ok {
+				return nil, fmt.Errorf("Enum: %v has a symbol that isn't a string: %v", fullName, symbol)
+			}
+			parsed.Symbols = append(parsed.Symbols, s)
+		}
+		if len(parsed.Symbols) == 0 {
+			return nil, fmt.Errorf("Enum: %v has no symbols", fullName)
+		}
+
+	case "fixed":
+		size, ok := schema["size"].(float64)
+		if !ok || size < 0 || size != math.Trunc(size) {
+			return nil, fmt.Errorf("Fixed: %v has no valid size", fullName)
+		}
+		parsed.Size = int(size)
+
+	default:
+		parsed.Type = "record"
+		parsed.fieldsByJSON = map[string]*avroField{}
+		fields, ok := schema["fields"].([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Record: %v has no fields array", fullName)
+		}
+		parsed.Fields = make([]avroField, len(fields))
+		for i, rawField := range fields {
+			field, _ := rawField.(map[string]interface{})
+			name, _ := field["name"].(string)
+			if name == "" {
+				return nil, fmt.Errorf("Field %v of record: %v has no name", i, fullName)
+			}
+			fieldSchema, err := parseAvroSchemaValue(field["type"], namespace, named)
+			if err != nil {
+				return nil, fmt.Errorf("Field: %v of record: %v: %v", name, fullName, err)
+			}
+			jsonField, _ := field["jsonField"].(string)
+			if jsonField == "" {
+				jsonField = name
+			}
+			defaultVal, hasDefault := field["default"]
+			parsed.Fields[i] = avroField{Name: name, JSONField: jsonField, Schema: fieldSchema, Default: defaultVal, HasDefault: hasDefault}
+			if _, ok := parsed.fieldsByJSON[jsonField]; ok {
+				return nil, fmt.Errorf("Record: %v has two fields read from: %v", fullName, jsonField)
+			}
+			parsed.fieldsByJSON[jsonField] = &parsed.Fields[i]
+		}
+	}
+	return parsed, nil
+}
+
+func avroFullName(name, namespace string) string {
+	if strings.Contains(name, ".") || namespace == "" {
+		return name
+	}
+	return namespace + "." + name
+}
+
+// A valid Avro name made from s, with any other characters replaced by underscores, eg "first-name" -> "first_name"
+func avroName(s string) string {
+	runes := []rune(s)
+	for i, r := range runes {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			runes[i] = '_'
+		}
+	}
+	if len(runes) == 0 || runes[0] >= '0' && runes[0] <= '9' {
+		return "_" + string(runes)
+	}
+	return string(runes)
+}
+
+// Append the Avro binary encoding of a doc value.  path locates the value in the doc, for errors
+func (s *avroSchema) encode(buf []byte, val interface{}, path string) ([]byte, error) {
+
+	switch s.Type {
+	case "null":
+		if val != nil {
+			return nil, fmt.Errorf("%v: expected null, got: %v", path, val)
+		}
+		return buf, nil
+
+	case "boolean":
+		b, ok := val.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%v: expected a boolean, got: %v", path, val)
+		}
+		if b {
+			return append(buf, 1), nil
+		}
+		return append(buf, 0), nil
+
+	case "int", "long":
+		i, ok := avroInteger(val, s.Type == "int")
+		if !ok {
+			return nil, fmt.Errorf("%v: expected an %v, got: %v", path, s.Type, val)
+		}
+		return appendAvroLong(buf, i), nil
+
+	case "float":
+		f, ok := avroNumber(val)
+		if !ok {
+			return nil, fmt.Errorf("%v: expected a float, got: %v", path, val)
+		}
+		return appendLittleEndian(buf, uint64(math.Float32bits(float32(f))), 4), nil
+
+	case "double":
+		f, ok := avroNumber(val)
+		if !ok {
+			return nil, fmt.Errorf("%v: expected a double, got: %v", path, val)
+		}
+		return appendLittleEndian(buf, math.Float64bits(f), 8), nil
+
+	case "string", "bytes":
+		str, ok := val.(string)
+		if !ok {
+			return nil, fmt.Errorf("%v: expected a string, got: %v", path, val)
+		}
+		return appendAvroString(buf, str), nil
+
+	case "enum":
+		str, _ := val.(string)
+		for i, symbol := range s.Symbols {
+			if symbol == str {
+				return appendAvroLong(buf, int64(i)), nil
+			}
+		}
+		return nil, fmt.Errorf("%v: %v isn't a symbol of enum: %v", path, val, s.Name)
+
+	case "fixed":
+		str, ok := val.(string)
+		if !ok || len(str) != s.Size {
+			return nil, fmt.Errorf("%v: expected a string of %v bytes for fixed: %v, got: %v", path, s.Size, s.Name, val)
+		}
+		return append(buf, str...), nil
+
+	case "array":
+		elems, ok := val.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%v: expected an array, got: %v", path, val)
+		}
+		if len(elems) > 0 {
+			buf = appendAvroLong(buf, int64(len(elems)))
+			for i, elem := range elems {
+				var err error
+				if buf, err = s.Items.encode(buf, elem, fmt.Sprintf("%v[%v]", path, i)); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return appendAvroLong(buf, 0), nil
+
+	case "map":
+		obj, ok := val.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%v: expected an object, got: %v", path, val)
+		}
+		if len(obj) > 0 {
+			buf = appendAvroLong(buf, int64(len(obj)))
+			for key, elem := range obj {
+				buf = appendAvroString(buf, key)
+				var err error
+				if buf, err = s.Values.encode(buf, elem, path+"."+key); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return appendAvroLong(buf, 0), nil
+
+	case "record":
+		obj, ok := val.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%v: expected an object, got: %v", path, val)
+		}
+		for key := range obj {
+			if _, ok := s.fieldsByJSON[key]; !ok {
+				return nil, fmt.Errorf("%v.%v: not a field of record: %v", path, key, s.Name)
+			}
+		}
+		for _, field := range s.Fields {
+			fieldVal, ok := obj[field.JSONField]
+			if !ok && field.HasDefault {
+				fieldVal = field.Default
+			}
+			var err error
+			if buf, err = field.Schema.encode(buf, fieldVal, path+"."+field.JSONField); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+
+	case "union":
+		for i, branch := range s.Branches {
+			if branch.matches(val) {
+				return branch.encode(appendAvroLong(buf, int64(i)), val, path)
+			}
+		}
+		return nil, fmt.Errorf("%v: %v matches none of the types of its union", path, val)
+	}
+	return nil, fmt.Errorf("%v: unknown Avro type: %v", path, s.Type)
+}
+
+// Could the value be encoded as the schema?  Picks the branch of a union
+func (s *avroSchema) matches(val interface{}) bool {
+	switch s.Type {
+	case "null":
+		return val == nil
+	case "boolean":
+		_, ok := val.(bool)
+		return ok
+	case "int", "long":
+		_, ok := avroInteger(val, s.Type == "int")
+		return ok
+	case "float", "double":
+		_, ok := avroNumber(val)
+		return ok
+	case "string", "bytes":
+		_, ok := val.(string)
+		return ok
+	case "enum":
+		str, _ := val.(string)
+		for _, symbol := range s.Symbols {
+			if symbol == str {
+				return true
+			}
+		}
+		return false
+	case "fixed":
+		str, ok := val.(string)
+		return ok && len(str) == s.Size
+	case "record", "map":
+		_, ok := val.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := val.([]interface{})
+		return ok
+	}
+	return false
+}
+
+func avroNumber(val interface{}) (float64, bool) {
+	switch number := val.(type) {
+	case float64:
+		return number, true
+	case json.Number:
+		f, err := number.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// A whole number that fits an int (32 bits) or a long
+func avroInteger(val interface{}, isInt bool) (int64, bool) {
+	var i int64
+	switch number := val.(type) {
+	case float64:
+		if number != math.Trunc(number) || number < math.MinInt64 || number >= math.MaxInt64 {
+			return 0, false
+		}
+		i = int64(number)
+	case json.Number:
+		parsed, err := number.Int64()
+		if err != nil {
+			return 0, false
+		}
+		i = parsed
+	default:
+		return 0, false
+	}
+	if isInt && (i < math.MinInt32 || i > math.MaxInt32) {
+		return 0, false
+	}
+	return i, true
+}
+
+// Avro longs are zig-zag encoded varints, as are Go's
+func appendAvroLong(buf []byte, i int64) []byte {
+	var varint [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(varint[:], i)
+	return append(buf, varint[:n]...)
+}
+
+func appendAvroString(buf []byte, s string) []byte {
+	return append(appendAvroLong(buf, int64(len(s))), s...)
+}
+
+func appendLittleEndian(buf []byte, bits uint64, size int) []byte {
+	for i := 0; i < size; i++ {
+		buf = append(buf, byte(bits>>(8*uint(i))))
+	}
+	return buf
+}
+
+// An Avro object container file of records of one schema
+type avroFile struct {
+	Path   string
+	Schema *avroSchema
+	Codec  string
+
+	file    *os.File
+	writer  *bufio.Writer
+	sync    [16]byte
+	block   []byte
+	count   int64
+	Records int64
+}
+
+// Create (or truncate) the file at path and write its header.  metadata is added to the header, eg the id of the
+// schema in a schema registry
+func newAvroFile(path string, schemaJSON []byte, codec string, metadata map[string]string) (*avroFile, error) {
+
+	schema, err := parseAvroSchema(schemaJSON)
+	if err != nil {
+		return nil, err
+	}
+	if codec == "" {
+		codec = AvroCodecNull
+	}
+
+	f := &avroFile{Path: path, Schema: schema, Codec: codec}
+	if _, err := rand.Read(f.sync[:]); err != nil {
+		return nil, err
+	}
+
+	header := []byte("Obj\x01")
+	header = appendAvroLong(header, int64(len(metadata)+2))
+	header = appendAvroString(appendAvroString(header, "avro.schema"), string(schemaJSON))
+	header = appendAvroString(appendAvroString(header, "avro.codec"), codec)
+	for key, val := range metadata {
+		header = appendAvroString(appendAvroString(header, key), val)
+	}
+	header = appendAvroLong(header, 0)
+	header = append(header, f.sync[:]...)
+
+	if f.file, err = os.Create(path); err != nil {
+		return nil, err
+	}
+	f.writer = bufio.NewWriter(f.file)
+	if _, err := f.writer.Write(header); err != nil {
+		f.file.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// Encode a doc into the current block, writing the block out once it's full
+func (f *avroFile) append(doc interface{}) error {
+	block, err := f.Schema.encode(f.block, doc, "$")
+	if err != nil {
+		return err
+	}
+	f.block = block
+	f.count++
+	f.Records++
+	if len(f.block) >= avroBlockSize {
+		return f.flushBlock()
+	}
+	return nil
+}
+
+func (f *avroFile) flushBlock() error {
+
+	if f.count == 0 {
+		return nil
+	}
+	data := f.block
+	if f.Codec == AvroCodecDeflate {
+		var compressed bytes.Buffer
+		deflater, err := flate.NewWriter(&compressed, flate.DefaultCompression)
+		if err != nil {
+			return err
+		}
+		if _, err := deflater.Write(data); err != nil {
+			return err
+		}
+		if err := deflater.Close(); err != nil {
+			return err
+		}
+		data = compressed.Bytes()
+	}
+
+	header := appendAvroLong(appendAvroLong(nil, f.count), int64(len(data)))
+	for _, part := range [][]byte{header, data, f.sync[:]} {
+		if _, err := f.writer.Write(part); err != nil {
+			return fmt.Errorf("Error writing to file: %v.  Err: %v", f.Path, err)
+		}
+	}
+	f.block = f.block[:0]
+	f.count = 0
+	return nil
+}
+
+// Write the last block and close the file
+func (f *avroFile) Close() error {
+	if err := f.flushBlock(); err != nil {
+		f.file.Close()
+		return err
+	}
+	if err := f.writer.Flush(); err != nil {
+		f.file.Close()
+		return err
+	}
+	return f.file.Close()
+}
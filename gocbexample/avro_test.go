@@ -0,0 +1,171 @@
+package gocbexample
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+const hotelAvroSchema = `{"type": "record", "name": "hotel", "namespace": "travel", "fields": [
+	{"name": "docId", "type": "string"},
+	{"name": "type", "type": "string"},
+	{"name": "name", "type": ["null", "string"], "default": null},
+	{"name": "stars", "type": ["null", "long"], "default": null}
+]}`
+
+func readAvroLong(t *testing.T, r *bytes.Reader) int64 {
+	var u uint64
+	for shift := uint(0); ; shift += 7 {
+		b, err := r.ReadByte()
+		if err != nil {
+			t.Fatalf("Error reading a long: %v", err)
+		}
+		u |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	return int64(u>>1) ^ -int64(u&1)
+}
+
+func readAvroBytes(t *testing.T, r *bytes.Reader) []byte {
+	b := make([]byte, readAvroLong(t, r))
+	if _, err := io.ReadFull(r, b); err != nil {
+		t.Fatalf("Error reading bytes: %v", err)
+	}
+	return b
+}
+
+// Read an object container file, returning its header metadata and the records of its blocks, decompressed
+func readAvroFile(t *testing.T, path string) (metadata map[string]string, records []byte, count int64) {
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bytes.NewReader(data)
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != "Obj\x01" {
+		t.Fatalf("%v isn't an Avro object container file", path)
+	}
+
+	metadata = map[string]string{}
+	for entries := readAvroLong(t, r); entries != 0; entries = readAvroLong(t, r) {
+		for i := int64(0); i < entries; i++ {
+			key := readAvroBytes(t, r)
+			metadata[string(key)] = string(readAvroBytes(t, r))
+		}
+	}
+	sync := make([]byte, 16)
+	io.ReadFull(r, sync)
+
+	for r.Len() > 0 {
+		count += readAvroLong(t, r)
+		block := readAvroBytes(t, r)
+		if metadata["avro.codec"] == AvroCodecDeflate {
+			if block, err = ioutil.ReadAll(flate.NewReader(bytes.NewReader(block))); err != nil {
+				t.Fatalf("Error inflating a block: %v", err)
+			}
+		}
+		records = append(records, block...)
+		blockSync := make([]byte, 16)
+		if _, err := io.ReadFull(r, blockSync); err != nil || !bytes.Equal(blockSync, sync) {
+			t.Fatalf("Block of %v isn't followed by the sync marker", path)
+		}
+	}
+	return metadata, records, count
+}
+
+func TestAvroSink(t *testing.T) {
+
+	for _, codec := range []string{AvroCodecNull, AvroCodecDeflate} {
+		dir := t.TempDir()
+		sink, err := NewAvroSink(dir, AvroConfig{Codec: codec}, map[string][]byte{"hotel": []byte(hotelAvroSchema)})
+		if err != nil {
+			t.Fatalf("NewAvroSink failed: %v", err)
+		}
+
+		docs := []interface{}{
+			map[string]interface{}{"type": "hotel", "name": "x", "stars": 4.0},
+			map[string]interface{}{"type": "hotel"},
+		}
+		if err := sink.WriteDocs([]string{"h1", "h2"}, docs); err != nil {
+			t.Fatalf("WriteDocs failed: %v", err)
+		}
+		for _, doc := range []interface{}{
+			map[string]interface{}{"type": "airline"},
+			map[string]interface{}{"type": "hotel", "stars": "four"},
+			map[string]interface{}{"type": "hotel", "rooms": 12.0},
+		} {
+			if err := sink.WriteDocs([]string{"bad"}, []interface{}{doc}); err == nil {
+				t.Errorf("WriteDocs(%v) succeeded, want an error", doc)
+			}
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+
+		metadata, records, count := readAvroFile(t, filepath.Join(dir, "hotel.avro"))
+		if metadata["avro.codec"] != codec || metadata["avro.schema"] == "" {
+			t.Errorf("%v: metadata = %v, want the codec and schema", codec, metadata)
+		}
+		want := []byte{
+			0x04, 'h', '1', 0x0a, 'h', 'o', 't', 'e', 'l', 0x02, 0x02, 'x', 0x02, 0x08,
+			0x04, 'h', '2', 0x0a, 'h', 'o', 't', 'e', 'l', 0x00, 0x00,
+		}
+		if count != 2 || !bytes.Equal(records, want) {
+			t.Errorf("%v: %v records % x, want 2 records % x", codec, count, records, want)
+		}
+	}
+}
+
+func TestAvroSinkRegistry(t *testing.T) {
+
+	var subject, schema string
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		subject = r.URL.Path
+		body := struct{ Schema string }{}
+		json.NewDecoder(r.Body).Decode(&body)
+		schema = body.Schema
+		w.Write([]byte(`{"id": 7}`))
+	}))
+	defer registry.Close()
+
+	dir := t.TempDir()
+	config := AvroConfig{Registry: &SchemaRegistryConfig{URL: registry.URL, SubjectPrefix: "travel."}}
+	sink, err := NewAvroSink(dir, config, map[string][]byte{"hotel": []byte(hotelAvroSchema)})
+	if err != nil {
+		t.Fatalf("NewAvroSink failed: %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	if subject != "/subjects/travel.hotel-value/versions" || schema != hotelAvroSchema {
+		t.Errorf("Registered %v at %v, want the schema at /subjects/travel.hotel-value/versions", schema, subject)
+	}
+	metadata, _, _ := readAvroFile(t, filepath.Join(dir, "hotel.avro"))
+	if metadata["schema.registry.id"] != "7" || metadata["schema.registry.subject"] != "travel.hotel-value" {
+		t.Errorf("Metadata = %v, want the registry subject and id", metadata)
+	}
+	if report := sink.Report(); len(report) != 1 || report[0].SchemaId != 7 {
+		t.Errorf("Report = %+v, want schema id 7", report)
+	}
+
+	// A schema the registry rejects, eg as incompatible with the subject's earlier versions, fails the export
+	rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`{"error_code": 409, "message": "Schema being registered is incompatible"}`))
+	}))
+	defer rejecting.Close()
+	config.Registry.URL = rejecting.URL
+	if _, err := NewAvroSink(t.TempDir(), config, map[string][]byte{"hotel": []byte(hotelAvroSchema)}); err == nil {
+		t.Errorf("NewAvroSink with a rejected schema succeeded, want an error")
+	}
+}
@@ -0,0 +1,425 @@
+package gocbexample
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The export format of an AvroSink
+const AvroFormat = "avro"
+
+// How export -format avro writes docs
+type AvroConfig struct {
+
+	// The field holding each doc's type.  Docs of each type are written to their own .avro file, with the type's
+	// schema.  Defaults to "type"
+	TypeField string `json:"typeField,omitempty"`
+
+	// Avro schema files (.avsc), by doc type.  The schemas of the other types are inferred from the source docs
+	Schemas map[string]string `json:"schemas,omitempty"`
+
+	// Namespace of inferred schemas.  Defaults to "couchbase"
+	Namespace string `json:"namespace,omitempty"`
+
+	// Docs sampled per type to infer schemas.  0, the default, reads every doc, so that the schemas fit every doc
+	InferSample int `json:"inferSample,omitempty"`
+
+	// The string field each record holds its doc id in.  Defaults to "docId".  Left out with schema files that
+	// don't have the field
+	IdField string `json:"idField,omitempty"`
+
+	// Compression of the blocks of records: null (none, the default) or deflate
+	Codec string `json:"codec,omitempty"`
+
+	// Register the schemas with a schema registry before writing any docs
+	Registry *SchemaRegistryConfig `json:"registry,omitempty"`
+}
+
+// A Confluent-compatible schema registry
+type SchemaRegistryConfig struct {
+	URL      string `json:"url"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	// Schemas are registered under the subject <subjectPrefix><type>-value, the subject of the values of the topic
+	// <subjectPrefix><type>, eg "travel.airline-value"
+	SubjectPrefix string `json:"subjectPrefix,omitempty"`
+}
+
+func (c AvroConfig) validate() error {
+	switch c.Codec {
+	case "", AvroCodecNull, AvroCodecDeflate:
+	default:
+		return fmt.Errorf("Unknown Avro codec: %v.  Expected %v or %v", c.Codec, AvroCodecNull, AvroCodecDeflate)
+	}
+	if c.InferSample < 0 {
+		return fmt.Errorf("Invalid avro.inferSample: %v.  Must be 0 (every doc) or more", c.InferSample)
+	}
+	if c.IdField != "" && avroName(c.IdField) != c.IdField {
+		return fmt.Errorf("Invalid avro.idField: %v.  Must be a valid Avro name, eg %v", c.IdField, avroName(c.IdField))
+	}
+	if c.Registry != nil {
+		registryURL, err := url.Parse(c.Registry.URL)
+		if err != nil || (registryURL.Scheme != "http" && registryURL.Scheme != "https") || registryURL.Host == "" {
+			return fmt.Errorf("Invalid avro.registry.url: %v.  Expected eg http://localhost:8081", c.Registry.URL)
+		}
+	}
+	return nil
+}
+
+func (c AvroConfig) withDefaults() AvroConfig {
+	if c.TypeField == "" {
+		c.TypeField = defaultTypeField
+	}
+	if c.Namespace == "" {
+		c.Namespace = defaultAvroNamespace
+	}
+	if c.IdField == "" {
+		c.IdField = defaultAvroIdField
+	}
+	if c.Codec == "" {
+		c.Codec = AvroCodecNull
+	}
+	return c
+}
+
+// Read the schema files of the config, by doc type
+func (c AvroConfig) readSchemaFiles() (map[string][]byte, error) {
+	schemas := map[string][]byte{}
+	for docType, path := range c.Schemas {
+		schemaJSON, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading the Avro schema of doc type: %v.  Err: %v", docType, err)
+		}
+		if _, err := parseAvroSchema(schemaJSON); err != nil {
+			return nil, fmt.Errorf("Error parsing Avro schema file: %v.  Err: %v", path, err)
+		}
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, schemaJSON); err != nil {
+			return nil, err
+		}
+		schemas[docType] = compact.Bytes()
+	}
+	return schemas, nil
+}
+
+// Get the Avro schema of each doc type: read from the schema files of the config, or inferred from the docs of the
+// source bucket, as transformed by transform if set (eg by an anonymizer, so that the schemas fit the exported docs)
+func (e *ExampleApp) AvroSchemas(config AvroConfig, transform DocProcessorReturnDocs) (map[string][]byte, error) {
+
+	config = config.withDefaults()
+	schemas, err := config.readSchemaFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	inferrer := NewSchemaInferrer(config.TypeField, config.InferSample)
+	infer := func(docIds []string, docs []interface{}) error {
+		if transform != nil {
+			output, err := transform(DocProcessorInput{DocIds: docIds, Docs: docs})
+			if err != nil {
+				return err
+			}
+			docIds, docs = output.DocIds, output.Docs
+		}
+		return inferrer.Process(docIds, docs)
+	}
+
+	e.startPhase("infer-avro-schemas")
+	if err := e.Walk(SourceBucketRole, WalkOptions{}, e.countProgress(infer)); err != nil {
+		return nil, err
+	}
+
+	inferrer.mutex.Lock()
+	defer inferrer.mutex.Unlock()
+	for docType, sample := range inferrer.types {
+		if _, ok := schemas[docType]; ok {
+			continue
+		}
+		schema, err := inferAvroSchema(docType, sample.root, config)
+		if err != nil {
+			return nil, err
+		}
+		if schemas[docType], err = json.Marshal(schema); err != nil {
+			return nil, err
+		}
+		e.logf("Inferred the Avro schema of doc type: %v from %v of its %v docs", docType, sample.sampled, sample.seen)
+	}
+	return schemas, nil
+}
+
+// The Avro schema of a doc type, from the schema inferred from its docs.  Every field is optional, a union with
+// null, so that docs missing fields, or with null values, fit the schema too
+func inferAvroSchema(docType string, root *schemaNode, config AvroConfig) (map[string]interface{}, error) {
+
+	if len(root.types) != 1 || !root.types["object"] {
+		return nil, fmt.Errorf("Doc type: %v has docs that aren't JSON objects, which can't be written as Avro records", docType)
+	}
+	if _, ok := root.properties[config.IdField]; ok {
+		return nil, fmt.Errorf("Docs of type: %v have a %v field, where the doc id would be written.  Set a different avro.idField", docType, config.IdField)
+	}
+
+	names := map[string]bool{}
+	schema := avroRecordSchema(avroName(docType), root, names)
+	schema["namespace"] = config.Namespace
+	idField := map[string]interface{}{"name": config.IdField, "type": "string"}
+	schema["fields"] = append([]interface{}{idField}, schema["fields"].([]interface{})...)
+	return schema, nil
+}
+
+// A record of the properties of an object node.  names holds the record names used so far, which must be unique
+func avroRecordSchema(name string, n *schemaNode, names map[string]bool) map[string]interface{} {
+
+	unique := name
+	for i := 2; names[unique]; i++ {
+		unique = fmt.Sprintf("%v_%v", name, i)
+	}
+	names[unique] = true
+
+	fields := []interface{}{}
+	fieldNames := map[string]bool{}
+	for _, key := range n.sortedProperties() {
+		fieldName := avroName(key)
+		for i := 2; fieldNames[fieldName]; i++ {
+			fieldName = fmt.Sprintf("%v_%v", avroName(key), i)
+		}
+		fieldNames[fieldName] = true
+
+		field := map[string]interface{}{
+			"name":    fieldName,
+			"type":    avroOptionalSchema(unique+"_"+fieldName, n.properties[key], names),
+			"default": nil,
+		}
+		if fieldName != key {
+			field["jsonField"] = key
+		}
+		fields = append(fields, field)
+	}
+	return map[string]interface{}{"type": "record", "name": unique, "fields": fields}
+}
+
+// A union of null and the types of the values seen at the node
+func avroOptionalSchema(name string, n *schemaNode, names map[string]bool) interface{} {
+
+	union := []interface{}{"null"}
+	for _, t := range n.sortedTypes() {
+		switch t {
+		case "boolean":
+			union = append(union, "boolean")
+		case "integer":
+			// integer is a subset of number
+			if !n.types["number"] {
+				union = append(union, "long")
+			}
+		case "number":
+			union = append(union, "double")
+		case "string":
+			union = append(union, "string")
+		case "object":
+			union = append(union, avroRecordSchema(name, n, names))
+		case "array":
+			var items interface{} = "null"
+			if n.items != nil {
+				items = avroOptionalSchema(name+"_item", n.items, names)
+			}
+			union = append(union, map[string]interface{}{"type": "array", "items": items})
+		}
+	}
+	if len(union) == 1 {
+		return "null"
+	}
+	return union
+}
+
+// Register a schema under a subject, returning its id in the registry.  The registry returns the existing id if
+// the schema is already registered, and fails if the schema isn't compatible with the subject's earlier versions
+func (c SchemaRegistryConfig) register(subject string, schemaJSON []byte) (int, error) {
+
+	body, err := json.Marshal(map[string]string{"schema": string(schemaJSON)})
+	if err != nil {
+		return 0, err
+	}
+	registryURL := fmt.Sprintf("%v/subjects/%v/versions", strings.TrimSuffix(c.URL, "/"), url.PathEscape(subject))
+	req, err := http.NewRequest("POST", registryURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+
+	client := http.Client{Timeout: managementRequestTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("Error registering the schema of subject: %v.  Err: %v", subject, err)
+	}
+	defer resp.Body.Close()
+
+	result := struct {
+		Id      int    `json:"id"`
+		Message string `json:"message"`
+	}{}
+	decodeErr := json.NewDecoder(resp.Body).Decode(&result)
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("Error registering the schema of subject: %v.  Err: %v %v", subject, resp.Status, result.Message)
+	}
+	if decodeErr != nil {
+		return 0, fmt.Errorf("Error reading the schema registry's response for subject: %v.  Err: %v", subject, decodeErr)
+	}
+	return result.Id, nil
+}
+
+// A Sink that writes docs to a directory of Avro object container files, one per doc type, eg airline.avro,
+// each with the schema of its type.  Each record holds its doc id in IdField.  Docs of types without a schema, or
+// that don't fit their type's schema, fail to write.
+type AvroSink struct {
+	Dir    string
+	Config AvroConfig
+
+	mutex sync.Mutex
+	files map[string]*avroFile
+
+	// Ids of the schemas in the schema registry, by doc type
+	schemaIds map[string]int
+}
+
+// The .avro file and records written for a doc type
+type AvroFileReport struct {
+	Type     string
+	Path     string
+	Records  int64
+	Subject  string `json:",omitempty"`
+	SchemaId int    `json:",omitempty"`
+}
+
+// Register the schemas (by doc type) if the config has a registry, and create a file per type in dir
+func NewAvroSink(dir string, config AvroConfig, schemas map[string][]byte) (*AvroSink, error) {
+
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	config = config.withDefaults()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	s := &AvroSink{Dir: dir, Config: config, files: map[string]*avroFile{}, schemaIds: map[string]int{}}
+	fileTypes := map[string]string{}
+	for _, docType := range sortedSchemaTypes(schemas) {
+		fileName := avroName(docType) + ".avro"
+		if other, ok := fileTypes[fileName]; ok {
+			s.Close()
+			return nil, fmt.Errorf("Doc types: %v and %v would both be written to: %v", other, docType, fileName)
+		}
+		fileTypes[fileName] = docType
+
+		metadata := map[string]string{}
+		if config.Registry != nil {
+			subject := s.subject(docType)
+			id, err := config.Registry.register(subject, schemas[docType])
+			if err != nil {
+				s.Close()
+				return nil, err
+			}
+			s.schemaIds[docType] = id
+			metadata["schema.registry.subject"] = subject
+			metadata["schema.registry.id"] = fmt.Sprintf("%v", id)
+		}
+
+		file, err := newAvroFile(filepath.Join(dir, fileName), schemas[docType], config.Codec, metadata)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("Error creating the Avro file of doc type: %v.  Err: %v", docType, err)
+		}
+		s.files[docType] = file
+	}
+	return s, nil
+}
+
+func sortedSchemaTypes(m map[string][]byte) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *AvroSink) subject(docType string) string {
+	return s.Config.Registry.SubjectPrefix + docType + "-value"
+}
+
+func (s *AvroSink) Name() string {
+	return fmt.Sprintf("avro:%v", s.Dir)
+}
+
+func (s *AvroSink) WriteDocs(docIds []string, docs []interface{}) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i, docId := range docIds {
+		docType := schemaDocType(docs[i], s.Config.TypeField)
+		file, ok := s.files[docType]
+		if !ok {
+			return newDocError(PhaseTargetWrite, docId, fmt.Errorf("Doc type: %v has no Avro schema.  Was the doc created after the schemas were inferred?", docType))
+		}
+		if err := file.append(s.withId(file.Schema, docId, docs[i])); err != nil {
+			return newDocError(PhaseTargetWrite, docId, fmt.Errorf("Doc doesn't fit the Avro schema of doc type: %v.  Err: %w", docType, err))
+		}
+	}
+	return nil
+}
+
+// The doc with its id added as IdField, if the schema has the field
+func (s *AvroSink) withId(schema *avroSchema, docId string, doc interface{}) interface{} {
+	body, ok := doc.(map[string]interface{})
+	if !ok || schema.fieldsByJSON[s.Config.IdField] == nil {
+		return doc
+	}
+	withId := make(map[string]interface{}, len(body)+1)
+	for field, val := range body {
+		withId[field] = val
+	}
+	withId[s.Config.IdField] = docId
+	return withId
+}
+
+func (s *AvroSink) Report() []AvroFileReport {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	reports := []AvroFileReport{}
+	for docType, file := range s.files {
+		report := AvroFileReport{Type: docType, Path: file.Path, Records: file.Records}
+		if s.Config.Registry != nil {
+			report.Subject = s.subject(docType)
+			report.SchemaId = s.schemaIds[docType]
+		}
+		reports = append(reports, report)
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].Type < reports[j].Type
+	})
+	return reports
+}
+
+// Finish the files and close them
+func (s *AvroSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var firstErr error
+	for _, file := range s.files {
+		if err := file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,130 @@
+package gocbexample
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// A cbbackupmgr backup to copy from rather than the live bucket, eg last night's backup.  gocb can't read the
+// archive's storage files (SQLite shards, or Rift stores since 7.0) itself, so the backup is restored with
+// cbbackupmgr into the source bucket, which must be an empty staging bucket, and the copy reads it from there.
+// The restore keeps each doc's XATTRs, expiry and flags.
+type BackupSpec struct {
+
+	// The archive directory and the repo within it, eg "/backups" and "nightly"
+	Archive string `json:"archive"`
+	Repo    string `json:"repo"`
+
+	// The backup to restore, eg "2017-10-03T02_00_01.123456789-07_00".  Defaults to every backup of the repo,
+	// which restores the state of the latest one
+	Backup string `json:"backup,omitempty"`
+
+	// The name of the backed up bucket.  Its docs are restored into the source bucket, so it must be named
+	// something else, since restoring over the live bucket would defeat the purpose
+	Bucket string `json:"bucket"`
+
+	// The cbbackupmgr binary.  Defaults to cbbackupmgr on the PATH
+	Cbbackupmgr string `json:"cbbackupmgr,omitempty"`
+
+	// Number of restore threads.  Defaults to cbbackupmgr's default
+	Threads int `json:"threads,omitempty"`
+}
+
+// Copy from a cbbackupmgr backup, restored into the source bucket before the commands that write the target
+func WithBackup(backup BackupSpec) Option {
+	return func(e *ExampleApp) error {
+		if backup.Archive == "" || backup.Repo == "" || backup.Bucket == "" {
+			return fmt.Errorf("Invalid backup: %+v.  The archive, repo and bucket are required", backup)
+		}
+		if backup.Threads < 0 {
+			return fmt.Errorf("Invalid backup threads: %v.  Must not be negative", backup.Threads)
+		}
+		if backup.Cbbackupmgr == "" {
+			backup.Cbbackupmgr = "cbbackupmgr"
+		}
+		e.Backup = &backup
+		return nil
+	}
+}
+
+// A Source that reads docs restored from a backup into a staging bucket
+type BackupSource struct {
+	*BucketSource
+	Backup BackupSpec
+}
+
+func (e *ExampleApp) NewBackupSource(backup BackupSpec, staging *BucketSource) *BackupSource {
+	return &BackupSource{BucketSource: staging, Backup: backup}
+}
+
+func (s *BackupSource) Name() string {
+	backup := s.Backup.Backup
+	if backup == "" {
+		backup = "latest"
+	}
+	return fmt.Sprintf("backup:%v/%v/%v/%v via %v", s.Backup.Archive, s.Backup.Repo, backup, s.Backup.Bucket, s.BucketSource.Name())
+}
+
+// Restore the backup into the source bucket with cbbackupmgr, refusing to if the source already has docs, which
+// would be mixed up with the backed up ones.  The restore skips the backup's views and indexes.  Run before the
+// commands that write the target, so eg a later verify compares the target with the staging bucket as restored.
+func (e *ExampleApp) RestoreBackup() error {
+
+	backup, staging := e.Backup, e.SourceBucketSpec.Name
+	if backup.Bucket == staging {
+		return fmt.Errorf("Refusing to restore backup of bucket: %v into the bucket of the same name.  Set the source to an empty staging bucket", staging)
+	}
+	if e.InPlace {
+		return fmt.Errorf("A backup can't be transformed in place.  Copy it to a target instead")
+	}
+
+	stats, err := e.bucketBasicStats(staging)
+	if err != nil {
+		return fmt.Errorf("Error getting stats of staging bucket: %v.  Err: %v", staging, err)
+	}
+	if stats.BasicStats.ItemCount > 0 {
+		return fmt.Errorf("Staging bucket: %v has %v docs, which would be mixed up with the docs of the backup.  Flush it first", staging, stats.BasicStats.ItemCount)
+	}
+
+	cluster, err := managementURL(e.ConnSpec)
+	if err != nil {
+		return err
+	}
+	args := []string{"restore",
+		"--archive", backup.Archive,
+		"--repo", backup.Repo,
+		"--cluster", cluster,
+		"--include-data", backup.Bucket,
+		"--map-data", backup.Bucket + "=" + staging,
+		"--disable-views", "--disable-gsi-indexes", "--disable-ft-indexes", "--disable-analytics",
+		"--no-progress-bar",
+	}
+	if backup.Backup != "" {
+		args = append(args, "--start", backup.Backup, "--end", backup.Backup)
+	}
+	if backup.Threads > 0 {
+		args = append(args, "--threads", strconv.Itoa(backup.Threads))
+	}
+
+	// The credentials are passed via the environment, to keep the password out of the process list
+	cmd := exec.Command(backup.Cbbackupmgr, args...)
+	cmd.Env = append(os.Environ(), "CB_USERNAME=Administrator", "CB_PASSWORD="+e.SourceBucketSpec.AdminPassword)
+	cmd.Stdout = log.Writer()
+	cmd.Stderr = log.Writer()
+
+	e.logf("Restoring backup into staging bucket %v: %v %v", staging, backup.Cbbackupmgr, strings.Join(args, " "))
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Error restoring backup of bucket: %v from archive: %v repo: %v.  Err: %v", backup.Bucket, backup.Archive, backup.Repo, err)
+	}
+
+	stats, err = e.bucketBasicStats(staging)
+	if err != nil {
+		return fmt.Errorf("Error getting stats of staging bucket: %v.  Err: %v", staging, err)
+	}
+	e.logf("Restored %v docs of backup into staging bucket %v", stats.BasicStats.ItemCount, staging)
+	return nil
+}
@@ -0,0 +1,168 @@
+package gocbexample
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// Virtual XATTR holding the metadata of a doc
+const documentVirtualXattr = "$document"
+
+// Metadata of a doc, read from the bucket the doc is in
+type DocMeta struct {
+	Cas gocb.Cas
+
+	// Unix time the doc expires at, or 0 if it doesn't
+	Expiry uint32
+
+	// Sequence number of the last mutation of the doc within its vbucket
+	Seqno uint64
+
+	// Whether the doc has any XATTRs, user or system, including those not requested
+	HasXattrs bool
+
+	// The XATTRs requested via BatchXattrs.  XATTRs the doc doesn't have are left out
+	Xattrs map[string]interface{}
+}
+
+// A doc with its metadata
+type BatchDoc struct {
+	Id   string
+	Body interface{}
+	Meta DocMeta
+}
+
+// A batch of docs with their metadata, passed to BatchCallbacks.  Pre-insert callbacks may change
+// the ids and bodies, or drop docs, before they're written.
+type DocBatch struct {
+	BatchId string
+	Docs    []BatchDoc
+}
+
+// A callback that is passed batches with the doc metadata, unlike the simpler DocProcessor
+type BatchCallback func(batch *DocBatch) error
+
+// Copy the source to the sink like CopyBucketWithCallback, passing each batch along with the doc metadata
+// (CAS, expiry, seqno, XATTRs) to the callbacks.  Pre-insert batches have the metadata of the source
+// docs, and post-insert batches that of the docs written.  The metadata is read with a subdoc lookup per
+// doc, so it's only available for bucket sources and sinks, and costs a round trip per doc.
+func (e *ExampleApp) CopyBucketWithBatchCallbacks(preInsertCallback, postInsertCallback BatchCallback) error {
+	return e.copyBucket(copyCallbacks{preInsertBatch: preInsertCallback, postInsertBatch: postInsertCallback})
+}
+
+// The bucket the source reads, or nil if it isn't a bucket
+func (e *ExampleApp) sourceBucket() *gocb.Bucket {
+	if bucketSource, ok := e.Source.(*BucketSource); ok {
+		return bucketSource.Bucket
+	}
+	return nil
+}
+
+// The bucket the sink writes to, or nil if it isn't a bucket
+func (e *ExampleApp) sinkBucket() *gocb.Bucket {
+	switch sink := e.Sink.(type) {
+	case *BucketSink:
+		return sink.Bucket
+	case *InPlaceSink:
+		return sink.Bucket
+	}
+	return nil
+}
+
+// Adapt a BatchCallback into a DocProcessorReturnDocs, reading the doc metadata from bucket (if it's non-nil)
+func (e *ExampleApp) batchCallbackProcessor(bucket *gocb.Bucket, batchId string, callback BatchCallback) DocProcessorReturnDocs {
+	return func(input DocProcessorInput) (output DocProcessorInput, err error) {
+
+		batch := &DocBatch{BatchId: batchId, Docs: make([]BatchDoc, len(input.DocIds))}
+		for i, docId := range input.DocIds {
+			batch.Docs[i] = BatchDoc{Id: docId, Body: input.Docs[i]}
+			if bucket == nil {
+				continue
+			}
+			if batch.Docs[i].Meta, err = lookupDocMeta(e.collection(bucket), docId, e.BatchXattrs); err != nil {
+				return output, err
+			}
+		}
+
+		if err := callback(batch); err != nil {
+			return output, err
+		}
+
+		output = DocProcessorInput{
+			DocIds: make([]string, len(batch.Docs)),
+			Docs:   make([]interface{}, len(batch.Docs)),
+		}
+		for i, doc := range batch.Docs {
+			output.DocIds[i] = doc.Id
+			output.Docs[i] = doc.Body
+		}
+		return output, nil
+	}
+}
+
+// The subset of the $document virtual XATTR that makes up DocMeta.  CAS and seqno are hex strings
+type documentVirtualXattrValue struct {
+	Exptime  uint32   `json:"exptime"`
+	Seqno    string   `json:"seqno"`
+	Datatype []string `json:"datatype"`
+}
+
+// Read the metadata of a doc, along with the given XATTRs, in a single subdoc lookup
+func lookupDocMeta(collection *gocb.Collection, docId string, xattrs []string) (DocMeta, error) {
+
+	meta := DocMeta{}
+
+	specs := []gocb.LookupInSpec{gocb.GetSpec(documentVirtualXattr, &gocb.GetSpecOptions{IsXattr: true})}
+	for _, xattr := range xattrs {
+		specs = append(specs, gocb.GetSpec(xattr, &gocb.GetSpecOptions{IsXattr: true}))
+	}
+	result, err := collection.LookupIn(docId, specs, nil)
+	if err != nil {
+		// Wrapped, so that eg a doc that doesn't exist can be told apart
+		return meta, fmt.Errorf("Error getting metadata for doc id: %v.  Err: %w", docId, err)
+	}
+	meta.Cas = result.Cas()
+
+	var document documentVirtualXattrValue
+	if err := result.ContentAt(0, &document); err != nil {
+		return meta, fmt.Errorf("Error getting metadata for doc id: %v.  Err: %v", docId, err)
+	}
+	meta.Expiry = document.Exptime
+	for _, datatype := range document.Datatype {
+		if datatype == "xattr" {
+			meta.HasXattrs = true
+		}
+	}
+	if document.Seqno != "" {
+		seqno, err := strconv.ParseUint(strings.TrimPrefix(document.Seqno, "0x"), 16, 64)
+		if err != nil {
+			return meta, fmt.Errorf("Invalid seqno: %v for doc id: %v", document.Seqno, docId)
+		}
+		meta.Seqno = seqno
+	}
+
+	for i, xattr := range xattrs {
+		var val interface{}
+		if err := result.ContentAt(uint(i+1), &val); err == nil {
+			if meta.Xattrs == nil {
+				meta.Xattrs = map[string]interface{}{}
+			}
+			meta.Xattrs[xattr] = val
+		}
+	}
+
+	return meta, nil
+}
+
+// A post-insert callback that is passed the outcome of each write
+type WriteResultsCallback func(results []WriteResult) error
+
+// Copy the source to the sink like CopyBucketWithCallback, passing the post-insert callback the outcome of
+// each write (the new CAS, or the error), so it doesn't have to re-read the docs.  The callback is called
+// even if some writes in the batch failed, and the copy then fails with the first write error.
+func (e *ExampleApp) CopyBucketWithWriteResults(preInsertCallback DocProcessorReturnDocs, postInsertCallback WriteResultsCallback) error {
+	return e.copyBucket(copyCallbacks{preInsert: preInsertCallback, postInsertResults: postInsertCallback})
+}
@@ -0,0 +1,130 @@
+package gocbexample
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/url"
+)
+
+// Flag source vs target bucket stats that differ by more than this percentage, unless configured
+const defaultBucketStatsThresholdPercent = 5
+
+// The basicStats and quota of a bucket, from /pools/default/buckets/<bucket>
+type bucketBasicStats struct {
+	BasicStats struct {
+		ItemCount float64 `json:"itemCount"`
+		MemUsed   float64 `json:"memUsed"`
+		DataUsed  float64 `json:"dataUsed"`
+		DiskUsed  float64 `json:"diskUsed"`
+	} `json:"basicStats"`
+	Quota struct {
+		RAM float64 `json:"ram"`
+	} `json:"quota"`
+}
+
+func (s bucketBasicStats) values() map[string]float64 {
+	return map[string]float64{
+		"itemCount": s.BasicStats.ItemCount,
+		"ramQuota":  s.Quota.RAM,
+		"memUsed":   s.BasicStats.MemUsed,
+		"dataUsed":  s.BasicStats.DataUsed,
+		"diskUsed":  s.BasicStats.DiskUsed,
+	}
+}
+
+// The order stats are listed in
+var bucketStatNames = []string{"itemCount", "ramQuota", "memUsed", "dataUsed", "diskUsed"}
+
+// A stat of the source and target buckets side by side
+type BucketStatComparison struct {
+	Stat        string  `json:"stat"`
+	Source      float64 `json:"source"`
+	Target      float64 `json:"target"`
+	DiffPercent float64 `json:"diffPercent"`
+
+	// The difference is above the threshold
+	Flagged bool `json:"flagged,omitempty"`
+}
+
+// Source vs target bucket stats at the end of a copy
+type BucketStatsReport struct {
+	Source           string                 `json:"source"`
+	Target           string                 `json:"target"`
+	ThresholdPercent float64                `json:"thresholdPercent"`
+	Stats            []BucketStatComparison `json:"stats"`
+}
+
+func (e *ExampleApp) bucketBasicStats(bucketName string) (bucketBasicStats, error) {
+	stats := bucketBasicStats{}
+	err := e.managementGet("/pools/default/buckets/"+url.PathEscape(bucketName), &stats)
+	return stats, err
+}
+
+// Get the item count, RAM quota and memory, data and disk usage of the source and target buckets, flagging
+// the stats that differ by more than thresholdPercent.  Transforms that drop or reshape docs make them
+// differ legitimately, so the flags are for a human to review rather than failures.
+func (e *ExampleApp) CompareBucketStats(thresholdPercent float64) (*BucketStatsReport, error) {
+
+	sourceStats, err := e.bucketBasicStats(e.SourceBucketSpec.Name)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting stats of bucket: %v.  Err: %v", e.SourceBucketSpec.Name, err)
+	}
+	targetStats, err := e.bucketBasicStats(e.TargetBucketSpec.Name)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting stats of bucket: %v.  Err: %v", e.TargetBucketSpec.Name, err)
+	}
+
+	report := &BucketStatsReport{
+		Source:           e.SourceBucketSpec.Name,
+		Target:           e.TargetBucketSpec.Name,
+		ThresholdPercent: thresholdPercent,
+	}
+	sourceValues, targetValues := sourceStats.values(), targetStats.values()
+	for _, stat := range bucketStatNames {
+		comparison := BucketStatComparison{
+			Stat:   stat,
+			Source: sourceValues[stat],
+			Target: targetValues[stat],
+		}
+		switch {
+		case comparison.Source != 0:
+			comparison.DiffPercent = (comparison.Target - comparison.Source) / comparison.Source * 100
+		case comparison.Target != 0:
+			comparison.DiffPercent = 100
+		}
+		comparison.Flagged = math.Abs(comparison.DiffPercent) > thresholdPercent
+		report.Stats = append(report.Stats, comparison)
+	}
+
+	return report, nil
+}
+
+// Add the source vs target bucket stats to the report, logging them as a table.  Skipped unless docs were
+// copied bucket to bucket.  Failing to get the stats (eg without the admin password) is only a warning
+func (j *Job) CompareBucketStats() {
+
+	if j.App.sourceBucket() != j.App.SourceBucket || !j.App.sinkIsTargetBucket() {
+		return
+	}
+
+	threshold := j.Config.BucketStatsThresholdPercent
+	if threshold == 0 {
+		threshold = defaultBucketStatsThresholdPercent
+	}
+	report, err := j.App.CompareBucketStats(threshold)
+	if err != nil {
+		log.Printf("Warning: not comparing bucket stats.  Err: %v", err)
+		return
+	}
+	j.AddResult("bucketStats", report)
+
+	log.Printf("%-10v %16v %16v %8v", "stat", report.Source, report.Target, "diff")
+	for _, stat := range report.Stats {
+		flag := ""
+		if stat.Flagged {
+			flag = fmt.Sprintf("  <-- differs by more than %v%%", threshold)
+		}
+		log.Printf("%-10v %16.0f %16.0f %7.1f%%%v", stat.Stat, stat.Source, stat.Target, stat.DiffPercent, flag)
+	}
+}
@@ -0,0 +1,229 @@
+package gocbexample
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+const (
+	// Synthetic docs expire after this long, so they're cleaned up even if the calibration is killed mid-step
+	calibrationDocExpiry = time.Hour
+
+	// A step whose rate is less than this much faster than the step before means the cluster is saturated
+	minCalibrationSpeedup = 1.1
+
+	// The safe rate is this share of the best rate reached, leaving headroom for the reads and index updates of
+	// the real migration
+	calibrationHeadroom = 0.8
+)
+
+// How the calibrate command loads the target
+type CalibrationOptions struct {
+
+	// Size of the synthetic doc bodies in bytes.  Pick something close to the average doc of the migration
+	DocSize int
+
+	// Docs per bulk write
+	BatchSize int
+
+	// How long each concurrency level is run for
+	StepDuration time.Duration
+
+	// Concurrency is doubled from 1 up to this, unless the cluster saturates or errors first
+	MaxConcurrency int
+}
+
+// The load sustained at one concurrency level
+type CalibrationStep struct {
+	Concurrency int              `json:"concurrency"`
+	Writes      *ThroughputStats `json:"writes"`
+
+	// Why calibration stopped at this step, if it did
+	Stopped string `json:"stopped,omitempty"`
+}
+
+// The result of a calibration, which config files can set as the default write rate via calibrationFile
+type CalibrationResult struct {
+	Bucket       string            `json:"bucket"`
+	CalibratedAt time.Time         `json:"calibratedAt"`
+	DocSize      int               `json:"docSize"`
+	BatchSize    int               `json:"batchSize"`
+	Steps        []CalibrationStep `json:"steps"`
+
+	// The highest concurrency that ran without errors or saturation, and a share of the rate it reached
+	SafeConcurrency         int   `json:"safeConcurrency"`
+	SafeWriteBytesPerSecond int64 `json:"safeWriteBytesPerSecond"`
+}
+
+// Measure the write rate the target bucket sustains, by writing and deleting synthetic docs at doubling
+// concurrency until the rate stops rising, more than 1% of docs fail with temporary errors, or batches slow to
+// the point of risking timeouts.  Every doc is deleted at the end of its step, and expires after an hour in case
+// the calibration is killed.
+func (e *ExampleApp) CalibrateWrites(opts CalibrationOptions) (result CalibrationResult, err error) {
+
+	if opts.DocSize <= 0 || opts.BatchSize <= 0 || opts.StepDuration <= 0 || opts.MaxConcurrency <= 0 {
+		return result, fmt.Errorf("Invalid calibration options: %+v.  Must all be positive", opts)
+	}
+
+	result = CalibrationResult{
+		Bucket:       e.TargetBucket.Name(),
+		CalibratedAt: time.Now(),
+		DocSize:      opts.DocSize,
+		BatchSize:    opts.BatchSize,
+	}
+	body := map[string]interface{}{
+		"type":    "calibration",
+		"payload": strings.Repeat("x", opts.DocSize),
+	}
+	bestRate := 0.0
+
+	for concurrency := 1; concurrency <= opts.MaxConcurrency; concurrency *= 2 {
+
+		e.logf("Calibrating: writing %v byte docs with %v workers for %v", opts.DocSize, concurrency, opts.StepDuration)
+		writes, err := e.calibrationStep(len(result.Steps), concurrency, opts, body)
+		if err != nil {
+			return result, err
+		}
+		step := CalibrationStep{Concurrency: concurrency, Writes: writes}
+		e.logf("Calibrating: %v workers wrote %.0f docs/s (%.0f bytes/s), p95 %v, error rate %.2f%%",
+			concurrency, writes.DocsPerSecond, writes.BytesPerSecond, writes.LatencyP95, writes.ErrorRate*100)
+
+		switch {
+		case writes.ErrorRate > maxHealthyErrorRate:
+			step.Stopped = "error rate"
+		case writes.latencyP95 > slowBatchLatency:
+			step.Stopped = "latency"
+		case bestRate > 0 && writes.BytesPerSecond < bestRate*minCalibrationSpeedup:
+			step.Stopped = "saturated"
+			if writes.BytesPerSecond > bestRate {
+				bestRate = writes.BytesPerSecond
+			}
+		default:
+			bestRate = writes.BytesPerSecond
+			result.SafeConcurrency = concurrency
+		}
+		result.Steps = append(result.Steps, step)
+		if step.Stopped != "" {
+			break
+		}
+	}
+
+	if result.SafeConcurrency == 0 {
+		return result, fmt.Errorf("Calibration of bucket: %v failed at a single worker.  Is the cluster healthy?", result.Bucket)
+	}
+	result.SafeWriteBytesPerSecond = int64(bestRate * calibrationHeadroom)
+	return result, nil
+}
+
+// Write synthetic docs with concurrency workers for the step duration, then delete them
+func (e *ExampleApp) calibrationStep(step, concurrency int, opts CalibrationOptions, body interface{}) (*ThroughputStats, error) {
+
+	collection, err := e.writeCollection(e.TargetBucket, "Calibrating")
+	if err != nil {
+		return nil, err
+	}
+
+	var mutex sync.Mutex
+	writes := throughputDirection{}
+	var written []string
+	var writeErr error
+
+	keyPrefix := fmt.Sprintf("calibration::%v::%v::%v::", e.JobId, step, rand.Int63())
+	deadline := time.Now().Add(opts.StepDuration)
+	startedAt := time.Now()
+
+	var workers sync.WaitGroup
+	for worker := 0; worker < concurrency; worker++ {
+		workers.Add(1)
+		go func(worker int) {
+			defer workers.Done()
+			for batch := 0; time.Now().Before(deadline); batch++ {
+				items := make([]gocb.BulkOp, opts.BatchSize)
+				for i := range items {
+					key := fmt.Sprintf("%v%v::%v::%v", keyPrefix, worker, batch, i)
+					items[i] = &gocb.UpsertOp{ID: key, Value: body, Expiry: calibrationDocExpiry}
+				}
+
+				batchStartedAt := time.Now()
+				err := collection.Do(items, e.bulkOpOptions(e.TargetBucket))
+				latency := time.Since(batchStartedAt)
+
+				mutex.Lock()
+				if err != nil && writeErr == nil {
+					writeErr = err
+				}
+				failures := 0
+				for _, item := range items {
+					upsertItem := item.(*gocb.UpsertOp)
+					if upsertItem.Err != nil {
+						if isTemporaryError(upsertItem.Err) {
+							failures++
+						} else if writeErr == nil {
+							writeErr = upsertItem.Err
+						}
+						continue
+					}
+					written = append(written, upsertItem.ID)
+				}
+				writes.record(len(items), len(items)*opts.DocSize, latency, 0, failures)
+				stop := writeErr != nil
+				mutex.Unlock()
+				if stop {
+					return
+				}
+			}
+		}(worker)
+	}
+	workers.Wait()
+	stats := writes.stats(time.Since(startedAt))
+
+	if err := e.removeCalibrationDocs(written, opts.BatchSize); err != nil {
+		return nil, err
+	}
+	if writeErr != nil {
+		return nil, fmt.Errorf("Error writing calibration docs to bucket: %v.  Err: %v", e.TargetBucket.Name(), writeErr)
+	}
+	if stats == nil {
+		return nil, fmt.Errorf("No calibration docs were written to bucket: %v in %v", e.TargetBucket.Name(), opts.StepDuration)
+	}
+	return stats, nil
+}
+
+// Delete the synthetic docs of a step.  Docs that are already gone are fine
+func (e *ExampleApp) removeCalibrationDocs(docIds []string, batchSize int) error {
+
+	for start := 0; start < len(docIds); start += batchSize {
+		end := minInt(start+batchSize, len(docIds))
+		items := make([]gocb.BulkOp, 0, end-start)
+		for _, docId := range docIds[start:end] {
+			items = append(items, &gocb.RemoveOp{ID: docId})
+		}
+		if _, err := e.doBulk(e.TargetBucket, items, isTemporaryError); err != nil {
+			return fmt.Errorf("Error deleting calibration docs from bucket: %v.  They expire in an hour.  Err: %v", e.TargetBucket.Name(), err)
+		}
+	}
+	return nil
+}
+
+// Read the result of a calibration, written by the calibrate command's -save flag
+func ReadCalibration(path string) (CalibrationResult, error) {
+	result := CalibrationResult{}
+	resultBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return result, fmt.Errorf("Error reading calibration file: %v.  Err: %v", path, err)
+	}
+	if err := json.Unmarshal(resultBytes, &result); err != nil {
+		return result, fmt.Errorf("Error parsing calibration file: %v.  Err: %v", path, err)
+	}
+	if result.SafeWriteBytesPerSecond <= 0 {
+		return result, fmt.Errorf("Calibration file: %v has no safeWriteBytesPerSecond", path)
+	}
+	return result, nil
+}
@@ -0,0 +1,53 @@
+package gocbexample
+
+import (
+	"fmt"
+)
+
+// Probe the cluster version and bucket capabilities, so that features the server doesn't support are
+// turned off (or fail the job) up front, rather than failing cryptically part way through a copy
+func (e *ExampleApp) probeCapabilities() error {
+
+	info, err := e.ClusterInfo()
+	if err != nil {
+		// Eg the admin password isn't configured.  Carry on and let the server reject anything it doesn't support
+		e.logf("Warning: could not detect the server version and bucket capabilities, assuming XATTR support.  Err: %v", err)
+		return nil
+	}
+	e.Capabilities = info
+	e.logf("Connected to Couchbase Server %v", info.Version)
+
+	if len(e.BatchXattrs) > 0 {
+		if err := e.requireXattrs(e.SourceBucketSpec.Name, "Reading batch XATTRs"); err != nil {
+			return err
+		}
+	}
+	if !e.SupportsXattrs(e.TargetBucketSpec.Name) {
+		e.logf("Warning: target bucket %v doesn't support XATTRs, which need Couchbase Server 5.0 or later (the cluster runs %v).  "+
+			"Docs are copied without the %v XATTR", e.TargetBucketSpec.Name, info.Version, e.MetadataXattrKey)
+	}
+
+	return nil
+}
+
+// Does the bucket support XATTRs?  True if the capabilities couldn't be detected
+func (e *ExampleApp) SupportsXattrs(bucketName string) bool {
+	if e.Capabilities == nil {
+		return true
+	}
+	for _, bucket := range e.Capabilities.Buckets {
+		if bucket.Name == bucketName {
+			return bucket.Xattrs
+		}
+	}
+	return true
+}
+
+// Fail with an actionable error if feature needs XATTRs and the bucket doesn't support them
+func (e *ExampleApp) requireXattrs(bucketName, feature string) error {
+	if e.SupportsXattrs(bucketName) {
+		return nil
+	}
+	return fmt.Errorf("%v needs XATTR support, which bucket %v doesn't have.  Upgrade the cluster to Couchbase Server 5.0 or later "+
+		"(it runs %v), or don't use XATTRs with this bucket", feature, bucketName, e.Capabilities.Version)
+}
@@ -0,0 +1,131 @@
+package gocbexample
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+const (
+	// cbimport json formats: one doc per line, or a JSON array of docs
+	CbimportLines = "lines"
+	CbimportList  = "list"
+
+	// The field the doc id is written to, for cbimport's key generator to read back
+	defaultCbimportIdField = "cbimportKey"
+)
+
+// A Sink that writes docs to a file cbimport json can load, eg:
+//
+//	cbimport json -c couchbase://host -u Administrator -p password -b travel-sample -d file://docs.json \
+//	    -f lines -g %cbimportKey% --ignore-fields cbimportKey
+//
+// cbimport takes doc ids from the doc bodies, so each doc's id is added to it as IdField, and --ignore-fields
+// leaves it out of the loaded doc.  Only JSON objects can carry the id, so other docs fail to write.
+type CbimportSink struct {
+	Path    string
+	Format  string
+	IdField string
+
+	mutex  sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+	docs   int64
+}
+
+// Create (or truncate) the file at path, in the lines or list format
+func NewCbimportSink(path, format, idField string) (*CbimportSink, error) {
+
+	if format != CbimportLines && format != CbimportList {
+		return nil, fmt.Errorf("Unknown cbimport format: %v.  Expected %v or %v", format, CbimportLines, CbimportList)
+	}
+	if idField == "" {
+		idField = defaultCbimportIdField
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	sink := &CbimportSink{Path: path, Format: format, IdField: idField, file: file, writer: bufio.NewWriter(file)}
+	if format == CbimportList {
+		if _, err := sink.writer.WriteString("["); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return sink, nil
+}
+
+func (s *CbimportSink) Name() string {
+	return fmt.Sprintf("cbimport %v:%v", s.Format, s.Path)
+}
+
+func (s *CbimportSink) WriteDocs(docIds []string, docs []interface{}) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i, docId := range docIds {
+		docBytes, err := s.withId(docId, docs[i])
+		if err != nil {
+			return newDocError(PhaseTargetWrite, docId, err)
+		}
+		switch {
+		case s.Format == CbimportLines:
+			docBytes = append(docBytes, '\n')
+		case s.docs > 0:
+			docBytes = append([]byte(",\n"), docBytes...)
+		default:
+			docBytes = append([]byte("\n"), docBytes...)
+		}
+		if _, err := s.writer.Write(docBytes); err != nil {
+			return newDocError(PhaseTargetWrite, docId, fmt.Errorf("Error writing to file: %v.  Err: %w", s.Path, err))
+		}
+		s.docs++
+	}
+	return nil
+}
+
+// The doc with its id added as IdField, encoded
+func (s *CbimportSink) withId(docId string, doc interface{}) ([]byte, error) {
+	body, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Doc id: %v isn't a JSON object, so has nowhere to put the %v field cbimport reads the doc id from", docId, s.IdField)
+	}
+	if _, ok := body[s.IdField]; ok {
+		return nil, fmt.Errorf("Doc id: %v already has a %v field.  Set a different id field", docId, s.IdField)
+	}
+	withId := make(map[string]interface{}, len(body)+1)
+	for field, val := range body {
+		withId[field] = val
+	}
+	withId[s.IdField] = docId
+	return json.Marshal(withId)
+}
+
+// The cbimport json command that loads the file into a bucket
+func (s *CbimportSink) ImportCommand(connSpec, bucket string) string {
+	return fmt.Sprintf("cbimport json -c %v -u Administrator -p <password> -b %v -d file://%v -f %v -g %%%v%% --ignore-fields %v",
+		connSpec, bucket, s.Path, s.Format, s.IdField, s.IdField)
+}
+
+// Finish the file and close it
+func (s *CbimportSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.Format == CbimportList {
+		end := "\n]\n"
+		if s.docs == 0 {
+			end = "]\n"
+		}
+		if _, err := s.writer.WriteString(end); err != nil {
+			s.file.Close()
+			return err
+		}
+	}
+	if err := s.writer.Flush(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
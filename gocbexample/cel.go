@@ -0,0 +1,251 @@
+package gocbexample
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Cap on the cost of evaluating a CEL expression against one doc, so that eg a comprehension over a huge array
+// fails the doc rather than stalling the copy
+const celCostLimit = 1000000
+
+// CEL expressions see the doc as doc, and its id as id, eg "doc.type == 'hotel' && id.startsWith('hotel_')".
+// Fields are read with doc.field or doc['field'], and has(doc.field) tells whether a field is present.  JSON
+// numbers are doubles, which compare equal to the same int, eg doc.stars == 4.
+func newCelEnv() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("doc", cel.DynType),
+		cel.Variable("id", cel.StringType),
+	)
+}
+
+// A compiled CEL expression
+type celExpr struct {
+	expr    string
+	program cel.Program
+}
+
+// Compile a CEL expression.  If it's a condition, it has to evaluate to a bool.
+func compileCelExpr(env *cel.Env, expr string, condition bool) (*celExpr, error) {
+	ast, issues := env.Compile(expr)
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("Invalid CEL expression: %v.  Err: %v", expr, issues.Err())
+	}
+	if condition && !ast.OutputType().IsExactType(types.BoolType) && !ast.OutputType().IsExactType(types.DynType) {
+		return nil, fmt.Errorf("Invalid CEL expression: %v.  It evaluates to a %v rather than a bool", expr, ast.OutputType())
+	}
+	program, err := env.Program(ast, cel.CostLimit(celCostLimit))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid CEL expression: %v.  Err: %v", expr, err)
+	}
+	return &celExpr{expr: expr, program: program}, nil
+}
+
+func (c *celExpr) eval(docId string, doc interface{}) (ref.Val, error) {
+	val, _, err := c.program.Eval(map[string]interface{}{"doc": doc, "id": docId})
+	if err != nil {
+		return nil, fmt.Errorf("Error evaluating CEL expression: %v.  Err: %v", c.expr, err)
+	}
+	return val, nil
+}
+
+// Whether a condition holds for the doc.  A condition that fails, eg on a field the doc doesn't have, doesn't hold.
+func (c *celExpr) matches(docId string, doc interface{}) bool {
+	val, err := c.eval(docId, doc)
+	if err != nil {
+		return false
+	}
+	matched, ok := val.Value().(bool)
+	return ok && matched
+}
+
+// The JSON value of an expression for the doc
+func (c *celExpr) jsonValue(docId string, doc interface{}) (interface{}, error) {
+	val, err := c.eval(docId, doc)
+	if err != nil {
+		return nil, err
+	}
+	native, err := val.ConvertToNative(reflect.TypeOf(&structpb.Value{}))
+	if err != nil {
+		return nil, fmt.Errorf("CEL expression: %v evaluates to a %v, which isn't a JSON value.  Err: %v", c.expr, val.Type(), err)
+	}
+	return native.(*structpb.Value).AsInterface(), nil
+}
+
+// Sets fields of the docs a condition holds for to the values of CEL expressions
+type CelTransformConfig struct {
+
+	// Only transform the docs this CEL expression is true for, eg "doc.type == 'hotel'".  Defaults to every doc
+	When string `json:"when,omitempty"`
+
+	// The fields to set, by dotted path, and the CEL expressions of their values, eg
+	// {"country": "doc.country == 'FR' ? 'France' : doc.country", "geo.approx": "true"}.  Every expression sees
+	// the doc as it was before any of the fields were set.  Objects on the way to a field are created
+	Set map[string]string `json:"set"`
+}
+
+type celAssignment struct {
+	field []string
+	value *celExpr
+}
+
+type compiledCelTransform struct {
+	when        *celExpr
+	assignments []celAssignment
+}
+
+// A transform stage that sets fields with CEL expressions, so that simple rules can live in the config rather
+// than in a plugin or webhook
+type CelTransform struct {
+	transforms []compiledCelTransform
+
+	mutex  sync.Mutex
+	report CelTransformReport
+}
+
+// Summary of what a CelTransform did
+type CelTransformReport struct {
+
+	// Docs with at least one field set, and the fields set across them
+	Docs   int
+	Fields int
+}
+
+func NewCelTransform(configs []CelTransformConfig) (*CelTransform, error) {
+
+	env, err := newCelEnv()
+	if err != nil {
+		return nil, err
+	}
+	c := &CelTransform{}
+	for i, config := range configs {
+		compiled := compiledCelTransform{}
+		if config.When != "" {
+			if compiled.when, err = compileCelExpr(env, config.When, true); err != nil {
+				return nil, fmt.Errorf("Invalid celTransforms[%v].when.  Err: %v", i, err)
+			}
+		}
+		if len(config.Set) == 0 {
+			return nil, fmt.Errorf("celTransforms[%v] has no fields to set", i)
+		}
+
+		// In a fixed order, so that fields nested in each other are set the same way every run
+		fields := make([]string, 0, len(config.Set))
+		for field := range config.Set {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			path := strings.Split(field, ".")
+			for _, name := range path {
+				if name == "" {
+					return nil, fmt.Errorf("Invalid celTransforms[%v] field: %q.  Expected a dotted path, eg geo.lat", i, field)
+				}
+			}
+			value, err := compileCelExpr(env, config.Set[field], false)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid celTransforms[%v] value of field: %v.  Err: %v", i, field, err)
+			}
+			compiled.assignments = append(compiled.assignments, celAssignment{field: path, value: value})
+		}
+		c.transforms = append(c.transforms, compiled)
+	}
+	return c, nil
+}
+
+func (c *CelTransform) Transform(input DocProcessorInput) (output DocProcessorInput, err error) {
+
+	output = DocProcessorInput{
+		DocIds: make([]string, 0, len(input.DocIds)),
+		Docs:   make([]interface{}, 0, len(input.Docs)),
+	}
+	report := CelTransformReport{}
+	var failed DocErrors
+	for i, doc := range input.Docs {
+		docId := input.DocIds[i]
+		body, fields, err := c.transformDoc(docId, doc)
+		if err != nil {
+			failed = failed.add(docId, doc, err)
+			continue
+		}
+		output.DocIds = append(output.DocIds, docId)
+		output.Docs = append(output.Docs, body)
+		if fields > 0 {
+			report.Docs++
+			report.Fields += fields
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.report.Docs += report.Docs
+	c.report.Fields += report.Fields
+	return output, failed.err()
+}
+
+// Run the transforms on a doc.  Returns the doc and the number of fields set
+func (c *CelTransform) transformDoc(docId string, doc interface{}) (interface{}, int, error) {
+
+	fields := 0
+	for _, transform := range c.transforms {
+		if transform.when != nil && !transform.when.matches(docId, doc) {
+			continue
+		}
+		values := make([]interface{}, len(transform.assignments))
+		for j, assignment := range transform.assignments {
+			var err error
+			if values[j], err = assignment.value.jsonValue(docId, doc); err != nil {
+				return nil, 0, err
+			}
+		}
+		for j, assignment := range transform.assignments {
+			body, ok := setField(doc, assignment.field, values[j])
+			if !ok {
+				return nil, 0, fmt.Errorf("Can't set field: %v, since it's inside a value that isn't an object", strings.Join(assignment.field, "."))
+			}
+			doc = body
+			fields++
+		}
+	}
+	return doc, fields, nil
+}
+
+func (c *CelTransform) Report() CelTransformReport {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.report
+}
+
+// Return a copy of doc with the field at path set to val, creating the objects on the way to it.  The original
+// doc is not modified.  Returns false if a value on the way isn't an object.
+func setField(doc interface{}, path []string, val interface{}) (interface{}, bool) {
+	if len(path) == 0 {
+		return val, true
+	}
+	var body map[string]interface{}
+	switch typed := doc.(type) {
+	case map[string]interface{}:
+		body = make(map[string]interface{}, len(typed)+1)
+		for k, v := range typed {
+			body[k] = v
+		}
+	case nil:
+		body = map[string]interface{}{}
+	default:
+		return doc, false
+	}
+	child, ok := setField(body[path[0]], path[1:], val)
+	if !ok {
+		return doc, false
+	}
+	body[path[0]] = child
+	return body, true
+}
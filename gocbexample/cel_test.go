@@ -0,0 +1,138 @@
+package gocbexample
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func celTestDoc(t *testing.T, doc string) interface{} {
+	var val interface{}
+	if err := json.Unmarshal([]byte(doc), &val); err != nil {
+		t.Fatalf("Invalid JSON %v: %v", doc, err)
+	}
+	return val
+}
+
+func TestDocFilterExpr(t *testing.T) {
+
+	hotel := celTestDoc(t, `{"type": "hotel", "stars": 4, "owner": "x"}`)
+	airline := celTestDoc(t, `{"type": "airline"}`)
+
+	tests := []struct {
+		expr         string
+		matchHotel   bool
+		matchAirline bool
+	}{
+		{expr: "doc.type == 'hotel' && id.startsWith('hotel_')", matchHotel: true},
+		// JSON numbers are doubles, which equal the same int
+		{expr: "doc.stars == 4", matchHotel: true},
+		// A field the doc doesn't have fails the expression, so the doc doesn't match, even negated
+		{expr: "doc.stars < 3.0"},
+		{expr: "!(doc.stars > 3.0)"},
+		{expr: "!has(doc.stars)", matchAirline: true},
+		{expr: "has(doc.owner) && doc.owner == 'x' || doc.type == 'airline'", matchHotel: true, matchAirline: true},
+	}
+	for _, test := range tests {
+		filter, err := newDocFilter(DocFilter{Expr: test.expr})
+		if err != nil {
+			t.Fatalf("newDocFilter(%v) failed: %v", test.expr, err)
+		}
+		if got := filter.matches("hotel_1", hotel); got != test.matchHotel {
+			t.Errorf("%v matches hotel = %v, want %v", test.expr, got, test.matchHotel)
+		}
+		if got := filter.matches("airline_1", airline); got != test.matchAirline {
+			t.Errorf("%v matches airline = %v, want %v", test.expr, got, test.matchAirline)
+		}
+	}
+
+	for _, invalid := range []string{"doc.type ==", "'hotel'", "size(doc.type) + 1"} {
+		if _, err := newDocFilter(DocFilter{Expr: invalid}); err == nil {
+			t.Errorf("newDocFilter(%v) succeeded, want an error since it isn't a valid condition", invalid)
+		}
+	}
+	if (DocFilter{Expr: "true"}).isEmpty() {
+		t.Errorf("isEmpty() of a filter with an expression = true, want false")
+	}
+}
+
+func TestCelTransform(t *testing.T) {
+
+	transform, err := NewCelTransform([]CelTransformConfig{
+		{
+			When: "doc.type == 'hotel'",
+			Set: map[string]string{
+				"country":    "doc.country == 'FR' ? 'France' : doc.country",
+				"geo.source": "id",
+				// Every expression sees the doc before any field is set
+				"a": "doc.b",
+				"b": "doc.a",
+			},
+		},
+		{Set: map[string]string{"tags": "has(doc.geo) ? [doc.geo.source] : []"}},
+	})
+	if err != nil {
+		t.Fatalf("NewCelTransform failed: %v", err)
+	}
+
+	input := DocProcessorInput{
+		DocIds: []string{"hotel_1", "airline_1"},
+		Docs: []interface{}{
+			celTestDoc(t, `{"type": "hotel", "country": "FR", "a": 1, "b": 2}`),
+			celTestDoc(t, `{"type": "airline", "country": "FR"}`),
+		},
+	}
+	original, _ := json.Marshal(input.Docs)
+	output, err := transform.Transform(input)
+	if err != nil {
+		t.Fatalf("Transform failed: %v", err)
+	}
+	if after, _ := json.Marshal(input.Docs); string(after) != string(original) {
+		t.Errorf("Transform modified the input docs: %s", after)
+	}
+
+	want := []interface{}{
+		celTestDoc(t, `{"type": "hotel", "country": "France", "a": 2, "b": 1, "geo": {"source": "hotel_1"}, "tags": ["hotel_1"]}`),
+		celTestDoc(t, `{"type": "airline", "country": "FR", "tags": []}`),
+	}
+	if !reflect.DeepEqual(output.Docs, want) {
+		t.Errorf("Transform = %v, want %v", output.Docs, want)
+	}
+	if report := transform.Report(); report != (CelTransformReport{Docs: 2, Fields: 6}) {
+		t.Errorf("Report() = %+v, want 2 docs and 6 fields", report)
+	}
+}
+
+func TestCelTransformFails(t *testing.T) {
+
+	// A doc the transform fails on is reported, and the rest of the batch passes
+	for _, config := range []CelTransformConfig{
+		{Set: map[string]string{"name.first": "'a'"}},
+		{Set: map[string]string{"n": "doc.missing + 1.0"}},
+	} {
+		transform, err := NewCelTransform([]CelTransformConfig{{When: "id == 'a'", Set: config.Set}})
+		if err != nil {
+			t.Fatalf("NewCelTransform(%v) failed: %v", config.Set, err)
+		}
+		input := DocProcessorInput{DocIds: []string{"a", "b"}, Docs: []interface{}{celTestDoc(t, `{"name": "x"}`), celTestDoc(t, `{"name": "y"}`)}}
+		output, err := transform.Transform(input)
+		var docErrs DocErrors
+		if !errors.As(err, &docErrs) || len(docErrs) != 1 || docErrs[0].DocId != "a" {
+			t.Errorf("Transform with %v = %v, want doc a to fail", config.Set, err)
+		}
+		if !reflect.DeepEqual(output.DocIds, []string{"b"}) {
+			t.Errorf("Transform with %v passed on %v, want doc b", config.Set, output.DocIds)
+		}
+	}
+
+	for _, config := range []CelTransformConfig{
+		{},
+		{Set: map[string]string{"geo..lat": "1"}},
+		{When: "'x'", Set: map[string]string{"a": "1"}},
+	} {
+		if _, err := NewCelTransform([]CelTransformConfig{config}); err == nil {
+			t.Errorf("NewCelTransform(%+v) succeeded, want an error", config)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package gocbexample
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// State saved in the job workspace so that a rerun of the same job id can carry on consistently
+type Checkpoint struct {
+	JobId string `json:"jobId"`
+
+	// Fingerprint of the anonymization salt, so a resumed run can't silently use a different salt
+	SaltFingerprint string `json:"saltFingerprint,omitempty"`
+
+	// The last doc id of the last completed page of paged N1QL scans still in progress, keyed by bucket name
+	N1qlCursors map[string]string `json:"n1qlCursors,omitempty"`
+}
+
+// Load the workspace checkpoint.  Returns nil if there isn't one yet.
+func (w *Workspace) LoadCheckpoint() (*Checkpoint, error) {
+
+	checkpointBytes, err := ioutil.ReadFile(w.CheckpointPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	checkpoint := &Checkpoint{}
+	if err := json.Unmarshal(checkpointBytes, checkpoint); err != nil {
+		return nil, fmt.Errorf("Error parsing checkpoint: %v.  Err: %v", w.CheckpointPath(), err)
+	}
+	return checkpoint, nil
+}
+
+// Save the checkpoint, replacing the previous one atomically so that a crash can't leave it half written
+func (w *Workspace) SaveCheckpoint(checkpoint *Checkpoint) error {
+
+	checkpointBytes, err := json.MarshalIndent(checkpoint, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := w.CheckpointPath() + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, checkpointBytes, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, w.CheckpointPath())
+}
+
+// Check that the salt fingerprint matches the one recorded by an earlier run of the job, or record
+// it if this is the first run.  Anonymizing part of a bucket with one salt and the rest with another
+// would break references between docs.
+func (j *Job) checkSaltFingerprint(fingerprint string) error {
+
+	if fingerprint == "" {
+		return nil
+	}
+
+	checkpoint, err := j.Workspace.LoadCheckpoint()
+	if err != nil {
+		return err
+	}
+	if checkpoint == nil {
+		checkpoint = &Checkpoint{JobId: j.Id}
+	}
+
+	switch checkpoint.SaltFingerprint {
+	case fingerprint:
+		return nil
+	case "":
+		checkpoint.SaltFingerprint = fingerprint
+		return j.Workspace.SaveCheckpoint(checkpoint)
+	default:
+		return fmt.Errorf("The anonymization salt (fingerprint %v) is not the one an earlier run of job %v used (fingerprint %v).  "+
+			"Rerun with the original salt, or use a new job id", fingerprint, j.Id, checkpoint.SaltFingerprint)
+	}
+}
+
+// Resume the paged N1QL scans that an earlier run of the job didn't finish, and checkpoint the cursor of
+// each page from now on.  Only used by commands that write to the target: skipping the docs that were
+// already copied is what a rerun wants, whereas skipping docs would make eg a checksum wrong.
+func (j *Job) checkpointN1qlCursors() error {
+
+	checkpoint, err := j.Workspace.LoadCheckpoint()
+	if err != nil {
+		return err
+	}
+	if checkpoint != nil {
+		for bucketName, lastDocId := range checkpoint.N1qlCursors {
+			j.App.ResumeN1qlScan(bucketName, lastDocId)
+		}
+	}
+
+	j.App.OnN1qlPage = func(bucketName, lastDocId string) error {
+		checkpoint, err := j.Workspace.LoadCheckpoint()
+		if err != nil {
+			return err
+		}
+		if checkpoint == nil {
+			checkpoint = &Checkpoint{JobId: j.Id}
+		}
+		if checkpoint.N1qlCursors == nil {
+			checkpoint.N1qlCursors = map[string]string{}
+		}
+		if lastDocId == "" {
+			delete(checkpoint.N1qlCursors, bucketName)
+		} else {
+			checkpoint.N1qlCursors[bucketName] = lastDocId
+		}
+		return j.Workspace.SaveCheckpoint(checkpoint)
+	}
+	return nil
+}
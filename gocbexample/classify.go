@@ -0,0 +1,172 @@
+package gocbexample
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Default doc field the inferred type is written to
+const defaultTypeField = "type"
+
+// A rule that assigns a type to docs matching it.  If both KeyPrefix and RequiredFields are set,
+// both must match.
+type TypeClassifierRule struct {
+
+	// The type to assign to matching docs
+	Type string
+
+	// Match docs whose id starts with this prefix, eg "airline_"
+	KeyPrefix string
+
+	// Match docs that have all of these top-level fields, eg ["icao", "callsign"]
+	RequiredFields []string
+}
+
+func (r TypeClassifierRule) matches(docId string, doc map[string]interface{}) bool {
+	if r.KeyPrefix == "" && len(r.RequiredFields) == 0 {
+		return false
+	}
+	if r.KeyPrefix != "" && !strings.HasPrefix(docId, r.KeyPrefix) {
+		return false
+	}
+	for _, field := range r.RequiredFields {
+		if _, ok := doc[field]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Summary of what a TypeClassifier did during a copy
+type TypeClassifierReport struct {
+	// Number of docs that had a type inferred, keyed by type
+	Classified map[string]int
+
+	// Ids of docs that no rule matched
+	Unclassified []string
+}
+
+// A pre-insert stage that infers a type field for untyped documents, so that later filtered
+// copies are possible.  Rules are evaluated in order and the first match wins.  If no rule
+// matches and KeyPrefixSeparators is set, the type is taken from the doc id up to the first
+// separator (eg "airline_10123" -> "airline").
+type TypeClassifier struct {
+
+	// The field to write the inferred type to.  Defaults to "type"
+	TypeField string
+
+	Rules []TypeClassifierRule
+
+	// Fall back to deriving the type from the doc id prefix before any of these separators
+	KeyPrefixSeparators []string
+
+	// Replace type fields that are already present.  By default docs that already have a type are left as-is
+	Overwrite bool
+
+	mutex  sync.Mutex
+	report TypeClassifierReport
+}
+
+// Create a new TypeClassifier with the given rules
+func NewTypeClassifier(rules []TypeClassifierRule, keyPrefixSeparators ...string) *TypeClassifier {
+	return &TypeClassifier{
+		TypeField:           defaultTypeField,
+		Rules:               rules,
+		KeyPrefixSeparators: keyPrefixSeparators,
+	}
+}
+
+// Infer the type of a doc.  Returns false if it could not be classified.
+func (c *TypeClassifier) Classify(docId string, doc map[string]interface{}) (docType string, ok bool) {
+
+	for _, rule := range c.Rules {
+		if rule.matches(docId, doc) {
+			return rule.Type, true
+		}
+	}
+
+	for _, separator := range c.KeyPrefixSeparators {
+		if idx := strings.Index(docId, separator); idx > 0 {
+			return docId[:idx], true
+		}
+	}
+
+	return "", false
+}
+
+// The pre-insert transform, suitable for adding to ExampleApp.Transforms
+func (c *TypeClassifier) Transform(input DocProcessorInput) (output DocProcessorInput, err error) {
+
+	typeField := c.TypeField
+	if typeField == "" {
+		typeField = defaultTypeField
+	}
+
+	output = DocProcessorInput{
+		DocIds: input.DocIds,
+		Docs:   make([]interface{}, len(input.Docs)),
+	}
+
+	for i, docId := range input.DocIds {
+		doc := input.Docs[i]
+		output.Docs[i] = doc
+
+		docMap, ok := doc.(map[string]interface{})
+		if !ok {
+			// Only JSON objects can carry a type field
+			c.recordUnclassified(docId)
+			continue
+		}
+		if _, hasType := docMap[typeField]; hasType && !c.Overwrite {
+			continue
+		}
+
+		docType, ok := c.Classify(docId, docMap)
+		if !ok {
+			c.recordUnclassified(docId)
+			continue
+		}
+
+		typed := make(map[string]interface{}, len(docMap)+1)
+		for k, v := range docMap {
+			typed[k] = v
+		}
+		typed[typeField] = docType
+		output.Docs[i] = typed
+		c.recordClassified(docType)
+	}
+
+	return output, nil
+}
+
+func (c *TypeClassifier) recordClassified(docType string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.report.Classified == nil {
+		c.report.Classified = map[string]int{}
+	}
+	c.report.Classified[docType]++
+}
+
+func (c *TypeClassifier) recordUnclassified(docId string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.report.Unclassified = append(c.report.Unclassified, docId)
+}
+
+// Get a snapshot of the classification report
+func (c *TypeClassifier) Report() TypeClassifierReport {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	report := TypeClassifierReport{
+		Classified:   map[string]int{},
+		Unclassified: append([]string{}, c.report.Unclassified...),
+	}
+	for docType, count := range c.report.Classified {
+		report.Classified[docType] = count
+	}
+	sort.Strings(report.Unclassified)
+	return report
+}
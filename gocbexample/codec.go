@@ -0,0 +1,150 @@
+package gocbexample
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Converts the body of a doc between its generic form (map[string]interface{}, as read from the
+// source) and a typed value, eg a struct
+type BodyCodec interface {
+	Decode(body interface{}) (interface{}, error)
+
+	// Return the value to write.  Anything encoding/json can marshal is fine, eg the struct itself
+	Encode(value interface{}) (interface{}, error)
+}
+
+// Converts doc ids to and from a typed key, eg "airline_10" <-> AirlineKey{Id: 10}
+type IdCodec interface {
+	DecodeId(docId string) (interface{}, error)
+	EncodeId(key interface{}) (string, error)
+}
+
+// The codecs registered per doc type
+type codecRegistry struct {
+	mutex  sync.RWMutex
+	bodies map[string]BodyCodec
+	ids    map[string]IdCodec
+}
+
+// Use codec for the bodies of docs of docType in TypedTransforms
+func (e *ExampleApp) RegisterBodyCodec(docType string, codec BodyCodec) {
+	e.codecs.mutex.Lock()
+	defer e.codecs.mutex.Unlock()
+	if e.codecs.bodies == nil {
+		e.codecs.bodies = map[string]BodyCodec{}
+	}
+	e.codecs.bodies[docType] = codec
+}
+
+// Use codec for the ids of docs of docType in TypedTransforms
+func (e *ExampleApp) RegisterIdCodec(docType string, codec IdCodec) {
+	e.codecs.mutex.Lock()
+	defer e.codecs.mutex.Unlock()
+	if e.codecs.ids == nil {
+		e.codecs.ids = map[string]IdCodec{}
+	}
+	e.codecs.ids[docType] = codec
+}
+
+func (e *ExampleApp) codecsFor(docType string) (BodyCodec, IdCodec) {
+	e.codecs.mutex.RLock()
+	defer e.codecs.mutex.RUnlock()
+	return e.codecs.bodies[docType], e.codecs.ids[docType]
+}
+
+// A body codec that decodes into the struct returned by newValue via encoding/json, and writes the
+// struct as is.  newValue must return a pointer, eg func() interface{} { return &Airline{} }
+func NewJSONStructCodec(newValue func() interface{}) BodyCodec {
+	return jsonStructCodec{newValue: newValue}
+}
+
+type jsonStructCodec struct {
+	newValue func() interface{}
+}
+
+func (c jsonStructCodec) Decode(body interface{}) (interface{}, error) {
+	value := c.newValue()
+	if reflect.ValueOf(value).Kind() != reflect.Ptr {
+		return nil, fmt.Errorf("Struct codec value must be a pointer, got: %T", value)
+	}
+	bodyBytes, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(bodyBytes, value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (c jsonStructCodec) Encode(value interface{}) (interface{}, error) {
+	return value, nil
+}
+
+// A doc decoded with the codecs registered for its type.  Key is the doc id if there's no id codec, and
+// Value the generic body if there's no body codec.
+type TypedDoc struct {
+	DocType string
+	Key     interface{}
+	Value   interface{}
+}
+
+// A transform that decodes the docs of docType with the registered codecs, calls fn on each (which may
+// change the key and value) and encodes them back.  Docs of other types are passed through.  Since the
+// encoded bodies can be typed values rather than maps, add typed transforms after any map based ones.
+func (e *ExampleApp) TypedTransform(docType string, fn func(doc *TypedDoc) error) DocProcessorReturnDocs {
+	return func(input DocProcessorInput) (output DocProcessorInput, err error) {
+
+		bodyCodec, idCodec := e.codecsFor(docType)
+
+		output = DocProcessorInput{
+			DocIds: make([]string, len(input.DocIds)),
+			Docs:   make([]interface{}, len(input.Docs)),
+		}
+		for i, docId := range input.DocIds {
+			output.DocIds[i], output.Docs[i] = docId, input.Docs[i]
+
+			docMap, ok := input.Docs[i].(map[string]interface{})
+			if !ok || docMap[defaultTypeField] != docType {
+				continue
+			}
+
+			typed := &TypedDoc{DocType: docType, Key: docId, Value: input.Docs[i]}
+			if idCodec != nil {
+				if typed.Key, err = idCodec.DecodeId(docId); err != nil {
+					return output, newDocError(PhaseTransform, docId, fmt.Errorf("Error decoding id.  Err: %w", err))
+				}
+			}
+			if bodyCodec != nil {
+				if typed.Value, err = bodyCodec.Decode(input.Docs[i]); err != nil {
+					return output, newDocError(PhaseTransform, docId, fmt.Errorf("Error decoding %v doc.  Err: %w", docType, err))
+				}
+			}
+
+			if err := fn(typed); err != nil {
+				return output, newDocError(PhaseTransform, docId, err)
+			}
+
+			if idCodec != nil {
+				if output.DocIds[i], err = idCodec.EncodeId(typed.Key); err != nil {
+					return output, newDocError(PhaseTransform, docId, fmt.Errorf("Error encoding id.  Err: %w", err))
+				}
+			} else if key, ok := typed.Key.(string); ok {
+				output.DocIds[i] = key
+			} else {
+				return output, newDocError(PhaseTransform, docId, fmt.Errorf("Key of %v docs is a %T, but there's no id codec to encode it", docType, typed.Key))
+			}
+			if bodyCodec != nil {
+				if output.Docs[i], err = bodyCodec.Encode(typed.Value); err != nil {
+					return output, newDocError(PhaseTransform, docId, fmt.Errorf("Error encoding %v doc.  Err: %w", docType, err))
+				}
+			} else {
+				output.Docs[i] = typed.Value
+			}
+		}
+		return output, nil
+	}
+}
@@ -0,0 +1,317 @@
+package gocbexample
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// The scope and collection every bucket has, which holds the docs of buckets that don't use collections
+const (
+	defaultScopeName      = "_default"
+	defaultCollectionName = "_default"
+)
+
+// A scope and collection of a bucket, written "scope.collection".  An empty Collection stands for every
+// collection of the scope.
+type Keyspace struct {
+	Scope      string
+	Collection string
+}
+
+// The default collection, which buckets without collections keep their docs in
+var DefaultKeyspace = Keyspace{Scope: defaultScopeName, Collection: defaultCollectionName}
+
+// Parse "scope.collection", or "scope" for every collection of the scope
+func ParseKeyspace(keyspace string) (Keyspace, error) {
+	parts := strings.Split(keyspace, ".")
+	for _, part := range parts {
+		if part == "" || strings.Contains(part, "`") {
+			return Keyspace{}, fmt.Errorf("Invalid collection: %q.  Expected scope.collection, or scope for every collection of the scope", keyspace)
+		}
+	}
+	switch len(parts) {
+	case 1:
+		return Keyspace{Scope: parts[0]}, nil
+	case 2:
+		return Keyspace{Scope: parts[0], Collection: parts[1]}, nil
+	}
+	return Keyspace{}, fmt.Errorf("Invalid collection: %q.  Expected scope.collection, or scope for every collection of the scope", keyspace)
+}
+
+func (k Keyspace) String() string {
+	if k.Collection == "" {
+		return k.Scope
+	}
+	return k.Scope + "." + k.Collection
+}
+
+func (k Keyspace) isDefault() bool {
+	return k == DefaultKeyspace
+}
+
+// A source collection and the target collection it's copied to
+type CollectionPair struct {
+	Source Keyspace
+	Target Keyspace
+}
+
+func (p CollectionPair) String() string {
+	if p.Source == p.Target {
+		return p.Source.String()
+	}
+	return p.Source.String() + "->" + p.Target.String()
+}
+
+// Copy these scopes ("inventory") or collections ("inventory.hotel") of the source bucket, rather than its default
+// collection.  Each is copied to the collection of the same name in the target, unless collectionMap maps it (or
+// its scope) to another, eg {"inventory.hotel": "staging.hotels", "tenant": "tenant_copy"}.  Target scopes and
+// collections that don't exist are created.
+func WithCollections(collections []string, collectionMap map[string]string) Option {
+	return func(e *ExampleApp) error {
+		for _, collection := range collections {
+			if _, err := ParseKeyspace(collection); err != nil {
+				return err
+			}
+		}
+		for source, target := range collectionMap {
+			sourceKeyspace, err := ParseKeyspace(source)
+			if err != nil {
+				return err
+			}
+			targetKeyspace, err := ParseKeyspace(target)
+			if err != nil {
+				return err
+			}
+			if (sourceKeyspace.Collection == "") != (targetKeyspace.Collection == "") {
+				return fmt.Errorf("Invalid collectionMap entry: %v -> %v.  A scope can only be mapped to a scope, and a collection to a collection", source, target)
+			}
+		}
+		e.Collections = collections
+		e.CollectionMap = collectionMap
+		return nil
+	}
+}
+
+// The collection of the bucket that KV operations and queries run against: the source or target collection being
+// copied, or the default collection of any other bucket
+func (e *ExampleApp) collection(bucket *gocb.Bucket) *gocb.Collection {
+	switch {
+	case bucket == e.SourceBucket && e.SourceCollection != nil:
+		return e.SourceCollection
+	case bucket == e.TargetBucket && e.TargetCollection != nil:
+		return e.TargetCollection
+	}
+	return bucket.DefaultCollection()
+}
+
+func (e *ExampleApp) keyspace(bucket *gocb.Bucket) Keyspace {
+	collection := e.collection(bucket)
+	return Keyspace{Scope: collection.ScopeName(), Collection: collection.Name()}
+}
+
+// Names the collection of the bucket in logs, progress and checkpoints: the bucket name for its default collection,
+// which is all there was before collections, otherwise bucket.scope.collection
+func (e *ExampleApp) keyspaceName(bucket *gocb.Bucket) string {
+	keyspace := e.keyspace(bucket)
+	if keyspace.isDefault() {
+		return bucket.Name()
+	}
+	return bucket.Name() + "." + keyspace.String()
+}
+
+// The N1QL keyspace of the collection of the bucket, eg `travel-sample`.`inventory`.`hotel`, and the alias its
+// docs are projected under when it isn't given one
+func (e *ExampleApp) n1qlKeyspace(bucket *gocb.Bucket) (path, alias string) {
+	keyspace := e.keyspace(bucket)
+	if keyspace.isDefault() {
+		return fmt.Sprintf("`%v`", bucket.Name()), bucket.Name()
+	}
+	return fmt.Sprintf("`%v`.`%v`.`%v`", bucket.Name(), keyspace.Scope, keyspace.Collection), keyspace.Collection
+}
+
+// Replace the {bucket} placeholder of a query with the collection of the bucket.  `{bucket}` becomes the whole
+// keyspace path, so that queries written against buckets carry on working against collections.
+func (e *ExampleApp) replaceQueryBucket(statement string, bucket *gocb.Bucket) string {
+	path, _ := e.n1qlKeyspace(bucket)
+	statement = strings.Replace(statement, "`"+queryBucketPlaceholder+"`", path, -1)
+	return strings.Replace(statement, queryBucketPlaceholder, bucket.Name(), -1)
+}
+
+// Work out which source collections are copied to which target collections, creating the target scopes and
+// collections that don't exist yet
+func (e *ExampleApp) resolveCollections() error {
+
+	if len(e.Collections) == 0 {
+		if len(e.CollectionMap) > 0 {
+			e.Collections = []string{DefaultKeyspace.String()}
+		} else {
+			e.CollectionPairs = []CollectionPair{{Source: DefaultKeyspace, Target: DefaultKeyspace}}
+			return e.useCollectionPair(e.CollectionPairs[0])
+		}
+	}
+
+	scopes, err := e.SourceBucket.CollectionsV2().GetAllScopes(nil)
+	if err != nil {
+		return fmt.Errorf("Error listing the collections of bucket: %v.  Err: %v", e.SourceBucket.Name(), err)
+	}
+	sourceCollections := map[string][]string{}
+	for _, scope := range scopes {
+		for _, collection := range scope.Collections {
+			sourceCollections[scope.Name] = append(sourceCollections[scope.Name], collection.Name)
+		}
+		sort.Strings(sourceCollections[scope.Name])
+	}
+
+	pairs := []CollectionPair{}
+	seen := map[Keyspace]bool{}
+	for _, name := range e.Collections {
+		keyspace, _ := ParseKeyspace(name)
+		collections, ok := sourceCollections[keyspace.Scope]
+		if !ok {
+			return fmt.Errorf("Bucket: %v has no scope: %v", e.SourceBucket.Name(), keyspace.Scope)
+		}
+		if keyspace.Collection != "" {
+			if !containsString(collections, keyspace.Collection) {
+				return fmt.Errorf("Bucket: %v has no collection: %v", e.SourceBucket.Name(), keyspace)
+			}
+			collections = []string{keyspace.Collection}
+		}
+		for _, collection := range collections {
+			source := Keyspace{Scope: keyspace.Scope, Collection: collection}
+			if seen[source] {
+				continue
+			}
+			seen[source] = true
+			pairs = append(pairs, CollectionPair{Source: source, Target: e.mapCollection(source)})
+		}
+	}
+
+	targets := map[Keyspace]Keyspace{}
+	for _, pair := range pairs {
+		if other, ok := targets[pair.Target]; ok {
+			return fmt.Errorf("Collections: %v and %v are both mapped to collection: %v", other, pair.Source, pair.Target)
+		}
+		targets[pair.Target] = pair.Source
+		if e.SourceBucketSpec.Name == e.TargetBucketSpec.Name && pair.Source == pair.Target && !e.InPlace {
+			return fmt.Errorf("Collection: %v of bucket: %v is mapped to itself, so the copy would feed on its own output", pair.Source, e.SourceBucket.Name())
+		}
+	}
+	if !e.InPlace {
+		if err := e.createTargetCollections(pairs); err != nil {
+			return err
+		}
+	}
+
+	e.CollectionPairs = pairs
+	return e.useCollectionPair(pairs[0])
+}
+
+// The target collection of a source collection: the one collectionMap maps it or its scope to, otherwise the
+// one of the same name
+func (e *ExampleApp) mapCollection(source Keyspace) Keyspace {
+	if target, ok := e.CollectionMap[source.String()]; ok {
+		keyspace, _ := ParseKeyspace(target)
+		return keyspace
+	}
+	if target, ok := e.CollectionMap[source.Scope]; ok {
+		return Keyspace{Scope: target, Collection: source.Collection}
+	}
+	return source
+}
+
+// Create the target scopes and collections that don't exist
+func (e *ExampleApp) createTargetCollections(pairs []CollectionPair) error {
+
+	manager := e.TargetBucket.CollectionsV2()
+	scopes, err := manager.GetAllScopes(nil)
+	if err != nil {
+		return fmt.Errorf("Error listing the collections of bucket: %v.  Err: %v", e.TargetBucket.Name(), err)
+	}
+	existing := map[Keyspace]bool{}
+	for _, scope := range scopes {
+		existing[Keyspace{Scope: scope.Name}] = true
+		for _, collection := range scope.Collections {
+			existing[Keyspace{Scope: scope.Name, Collection: collection.Name}] = true
+		}
+	}
+
+	for _, pair := range pairs {
+		scope := Keyspace{Scope: pair.Target.Scope}
+		if !existing[scope] {
+			e.logf("Creating scope: %v in bucket: %v", scope, e.TargetBucket.Name())
+			if err := manager.CreateScope(scope.Scope, nil); err != nil && !errors.Is(err, gocb.ErrScopeExists) {
+				return fmt.Errorf("Error creating scope: %v in bucket: %v.  Err: %v", scope, e.TargetBucket.Name(), err)
+			}
+			existing[scope] = true
+		}
+		if !existing[pair.Target] {
+			e.logf("Creating collection: %v in bucket: %v", pair.Target, e.TargetBucket.Name())
+			err := manager.CreateCollection(pair.Target.Scope, pair.Target.Collection, nil, nil)
+			if err != nil && !errors.Is(err, gocb.ErrCollectionExists) {
+				return fmt.Errorf("Error creating collection: %v in bucket: %v.  Err: %v", pair.Target, e.TargetBucket.Name(), err)
+			}
+			existing[pair.Target] = true
+		}
+	}
+	return nil
+}
+
+// Point the source and target at a pair of collections
+func (e *ExampleApp) useCollectionPair(pair CollectionPair) error {
+	e.SourceCollection = e.SourceBucket.Scope(pair.Source.Scope).Collection(pair.Source.Collection)
+	if e.InPlace {
+		e.TargetCollection = e.SourceCollection
+		return nil
+	}
+	e.TargetCollection = e.TargetBucket.Scope(pair.Target.Scope).Collection(pair.Target.Collection)
+	return nil
+}
+
+// Call fn with the source and target pointed at each pair of collections in turn.  The collections are left
+// pointing at the first pair.
+func (e *ExampleApp) ForEachCollectionPair(fn func(pair CollectionPair) error) error {
+	if len(e.CollectionPairs) == 0 {
+		return fmt.Errorf("The collections to copy are resolved by Connect")
+	}
+	defer e.useCollectionPair(e.CollectionPairs[0])
+	for _, pair := range e.CollectionPairs {
+		if err := e.useCollectionPair(pair); err != nil {
+			return err
+		}
+		if err := fn(pair); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Views only index the default collection
+func (e *ExampleApp) checkViewCollections() error {
+	for _, pair := range e.CollectionPairs {
+		if !pair.Source.isDefault() || !pair.Target.isDefault() {
+			return fmt.Errorf("Views only index the default collection, so can't scan collection: %v.  Use the %v or %v engine to copy collections", pair, EngineN1ql, EngineDCP)
+		}
+	}
+	return nil
+}
+
+// Views only index the default collection, so can't scan the bucket when another collection is being copied
+func (e *ExampleApp) checkViewKeyspace(bucket *gocb.Bucket) error {
+	if !e.keyspace(bucket).isDefault() {
+		return fmt.Errorf("Views only index the default collection, so can't scan: %v.  Use the %v or %v engine", e.keyspaceName(bucket), EngineN1ql, EngineDCP)
+	}
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,549 @@
+package gocbexample
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+)
+
+// Settings for a run, loaded from a JSON config file.  Anything not in the file keeps its default.
+type Config struct {
+
+	// A config file (relative to this one) whose settings this file is applied on top of, eg a job template
+	Extends string `json:"extends,omitempty"`
+
+	// Couchbase connection string
+	ConnSpec string `json:"connSpec"`
+
+	Source BucketSpec `json:"source"`
+	Target BucketSpec `json:"target"`
+
+	// Use N1QL?  If false, use views
+	UseN1ql bool `json:"useN1ql"`
+
+	// How to iterate buckets: "views", "n1ql", or "auto" to pick from the cluster's services.  Overrides useN1ql
+	Engine Engine `json:"engine,omitempty"`
+
+	// Allow the source and target to be the same bucket, transforming it in place
+	InPlace bool `json:"inPlace,omitempty"`
+
+	// Copy these scopes ("inventory") or collections ("inventory.hotel") of the source bucket rather than its
+	// default collection
+	Collections []string `json:"collections,omitempty"`
+
+	// Copy source collections or scopes to differently named ones in the target, eg {"inventory.hotel": "staging.hotels"}.
+	// The others are copied to the collection of the same name, which is created if need be
+	CollectionMap map[string]string `json:"collectionMap,omitempty"`
+
+	// Query nodes (host:port) to send N1QL requests to, round robin.  A single node pins all requests to it
+	QueryNodes []string `json:"queryNodes,omitempty"`
+
+	// Discover the query nodes and spread N1QL requests across them
+	SpreadQueries bool `json:"spreadQueries,omitempty"`
+
+	// Cap on concurrent N1QL requests
+	MaxConcurrentQueries int `json:"maxConcurrentQueries,omitempty"`
+
+	// Read the source docs with this N1QL query rather than a scan of the whole source bucket, eg to join or UNNEST.
+	// It must project the doc id AS id and the doc body AS doc.  `{bucket}` is replaced by the source bucket name
+	SourceQuery string `json:"sourceQuery,omitempty"`
+
+	// Take the source doc ids from this Analytics dataset (eg "Default.travel") and fetch the bodies via KV, for
+	// clusters without the query service.  The dataset is the authoritative list of docs to copy
+	AnalyticsDataset string `json:"analyticsDataset,omitempty"`
+
+	// Scan buckets with N1QL a page of this many docs at a time (keyset paginated on META().id), so large buckets
+	// don't time out a single query.  Failed pages are retried and a failed job resumes from the last page.  0 disables paging
+	N1qlPageSize int `json:"n1qlPageSize,omitempty"`
+
+	// Checkpoint the progress of copies to a doc in the target bucket this often, so that a rerun of the job id
+	// resumes the copy rather than copying every doc again.  0 disables checkpoints
+	CheckpointIntervalSeconds int `json:"checkpointIntervalSeconds,omitempty"`
+
+	// Number of goroutines processing batches of docs.  Defaults to 1
+	Workers int `json:"workers,omitempty"`
+
+	// Docs per view query page / batch.  Defaults to 1000
+	PageSize int `json:"pageSize,omitempty"`
+
+	// Retry reads and writes that fail with temporary errors.  Defaults to no retries
+	Retry *RetryConfig `json:"retry,omitempty"`
+
+	// Subdoc mutations run at once by the post-insert phases (XATTR stamping, type namespacing).  Defaults to 16
+	SubdocConcurrency int `json:"subdocConcurrency,omitempty"`
+
+	// View pages fetched ahead of the page being processed, so reads and writes overlap.  Defaults to 1, 0 disables
+	ReadAheadPages *int `json:"readAheadPages,omitempty"`
+
+	// Batch writes to the target independently of the read page size.  Defaults to writing each page as a batch
+	WriteBatch *WriteBatchConfig `json:"writeBatch,omitempty"`
+
+	// Copy the docs matching each rule (by doc type or key prefix), in order, ahead of the rest
+	Priorities []PriorityRule `json:"priorities,omitempty"`
+
+	// Stamp copied docs with this XATTR key rather than Metadata, eg a system XATTR such as "_migration"
+	MetadataXattrKey string `json:"metadataXattrKey,omitempty"`
+
+	// Add the CAS, seqno and CRC32C of the stamping mutation to the metadata XATTR, expanded by the server
+	MetadataMacros bool `json:"metadataMacros,omitempty"`
+
+	// Read and write the XATTRs of deleted docs' tombstones too
+	XattrAccessDeleted bool `json:"xattrAccessDeleted,omitempty"`
+
+	// Only read the source docs matching this filter, eg {"types": ["airline"], "match": ["$.country == \"France\""]}
+	Filter *DocFilter `json:"filter,omitempty"`
+
+	// Split view iteration into this many key ranges, queried concurrently
+	ViewQueryRanges int `json:"viewQueryRanges,omitempty"`
+
+	// The design doc and view used to iterate buckets via views.  Default to "all_docs"
+	DesignDoc string `json:"designDoc,omitempty"`
+	ViewName  string `json:"viewName,omitempty"`
+
+	// Use a dev_ design doc
+	DevelopmentViews bool `json:"developmentViews,omitempty"`
+
+	// Replace an existing design doc with the same name that wasn't created by this tool
+	OverwriteDesignDoc bool `json:"overwriteDesignDoc,omitempty"`
+
+	// Seconds to wait for indexes/views to build before starting.  Defaults to 600
+	ReadinessTimeoutSeconds int `json:"readinessTimeoutSeconds,omitempty"`
+
+	// Fail the job if a view scan reads a different number of docs than the view's total_rows
+	StrictRowCount bool `json:"strictRowCount,omitempty"`
+
+	// Limit the bytes per second read from buckets / written to the target bucket.  0 means no limit
+	ReadBytesPerSecond  int64 `json:"readBytesPerSecond,omitempty"`
+	WriteBytesPerSecond int64 `json:"writeBytesPerSecond,omitempty"`
+
+	// Copy from this cbbackupmgr backup, restored into the source bucket, rather than the live bucket
+	Backup *BackupSpec `json:"backup,omitempty"`
+
+	// Also write the docs copied to Redis, eg to warm a cache
+	Redis *RedisConfig `json:"redis,omitempty"`
+
+	// How import -format csv maps the columns of a CSV file to docs
+	CsvImport *CsvImportConfig `json:"csvImport,omitempty"`
+
+	// How export -format avro writes docs, and the schemas it writes them with
+	Avro *AvroConfig `json:"avro,omitempty"`
+
+	// A file written by calibrate -save, whose safe write rate is the default of writeBytesPerSecond
+	CalibrationFile string `json:"calibrationFile,omitempty"`
+
+	// Only run inside these daily windows, eg ["22:00-06:00"].  Outside of them the job pauses until a window reopens
+	RunWindows []string `json:"runWindows,omitempty"`
+
+	// IANA time zone of the run windows, eg "America/Los_Angeles".  Defaults to local time
+	RunWindowTimeZone string `json:"runWindowTimeZone,omitempty"`
+
+	// Poll bucket stats this often, slowing the job down while the source or target is under pressure.  0 disables
+	HealthCheckIntervalSeconds int `json:"healthCheckIntervalSeconds,omitempty"`
+
+	// Bucket stat thresholds that count as pressure.  0 disables a check
+	MaxDiskWriteQueue        float64 `json:"maxDiskWriteQueue,omitempty"`
+	MinMemoryHeadroomPercent float64 `json:"minMemoryHeadroomPercent,omitempty"`
+	MaxBackgroundFetchMicros float64 `json:"maxBackgroundFetchMicros,omitempty"`
+
+	// Anonymization rule sets for the anonymize command, scoped by doc type or key pattern
+	Anonymize AnonymizeConfig `json:"anonymize"`
+
+	// Keep/drop fields per doc type when copying, eg [{"types": ["route"], "drop": ["$.schedule"]}]
+	Projections []ProjectionRule `json:"projections,omitempty"`
+
+	// Truncate oversized string and array values when copying
+	Truncation *TruncationConfig `json:"truncation,omitempty"`
+
+	// Perturb lat/lon coordinates within a radius and/or truncate their precision when copying
+	GeoFuzz *GeoFuzzConfig `json:"geoFuzz,omitempty"`
+
+	// Replace fields with locale-aware fake values (names, cities, emails..) when copying
+	Faker *FakerConfig `json:"faker,omitempty"`
+
+	// Generalize quasi-identifiers (zip -> zip3, age -> band, city -> region) when copying, and report the group sizes reached
+	Generalization *GeneralizationConfig `json:"generalization,omitempty"`
+
+	// Encrypt fields with AEAD_AES_256_CBC_HMAC_SHA512 when copying, and decrypt them with the decrypt command
+	Encryption *EncryptionConfig `json:"encryption,omitempty"`
+
+	// Set fields of the docs with CEL expressions, eg [{"when": "doc.type == 'hotel'", "set": {"country": "'France'"}}],
+	// in order, after the built-in transforms
+	CelTransforms []CelTransformConfig `json:"celTransforms,omitempty"`
+
+	// Transform the docs with transforms compiled into the binary with transforms.Register, in order, after the
+	// built-in and CEL transforms
+	CustomTransforms []CustomTransformConfig `json:"customTransforms,omitempty"`
+
+	// Transform the docs with WASM plugins, in order, after the built-in, CEL and custom transforms
+	WasmPlugins []WasmPluginConfig `json:"wasmPlugins,omitempty"`
+
+	// Transform the docs with an external HTTP endpoint, after the other transforms
+	Webhook *WebhookConfig `json:"webhook,omitempty"`
+
+	// What to do with docs that a pre-insert stage fails on, keyed by stage (preInsert or transforms):
+	// abort (the default), skip or dead-letter
+	ErrorPolicies map[string]string `json:"errorPolicies,omitempty"`
+
+	// Fall back to replica reads for source docs whose active read fails
+	ReplicaReadFallback bool `json:"replicaReadFallback,omitempty"`
+
+	// Read back every Nth written doc and compare it with what was written.  0 disables sampling
+	SampleEveryN int `json:"sampleEveryN,omitempty"`
+
+	// Read sampled docs from a replica
+	SampleFromReplica bool `json:"sampleFromReplica,omitempty"`
+
+	// Before declaring success, wait until the target's indexes have caught up with the copied docs, so
+	// queries run right after the job see all of them.  Waits up to readinessTimeoutSeconds
+	WaitForTargetIndexes bool `json:"waitForTargetIndexes,omitempty"`
+
+	// Which copied docs the copy command spot checks.  Defaults to 3 random docs
+	SpotChecks *SpotCheckConfig `json:"spotChecks,omitempty"`
+
+	// After a copy, flag source vs target bucket stats (item count, RAM quota, memory/data/disk used) that differ
+	// by more than this percentage.  Defaults to 5
+	BucketStatsThresholdPercent float64 `json:"bucketStatsThresholdPercent,omitempty"`
+
+	// N1QL assertions checked against the target bucket after a copy, failing the job if one doesn't hold
+	SmokeQueries []SmokeQuery `json:"smokeQueries,omitempty"`
+
+	// Stop the job cleanly once it has run this long or read this many docs.  0 leaves the limit off
+	MaxDurationSeconds int   `json:"maxDurationSeconds,omitempty"`
+	MaxDocs            int64 `json:"maxDocs,omitempty"`
+
+	// Identifies the run.  Generated if empty
+	JobId string `json:"jobId,omitempty"`
+
+	// Jobs run in dependency order by the jobs command, each with the rest of this config as its base
+	Jobs []JobSpec `json:"jobs,omitempty"`
+
+	// Directory that per-job workspace directories are created under
+	WorkspaceRoot string `json:"workspaceRoot"`
+}
+
+// How failed reads and writes are retried
+type RetryConfig struct {
+	MaxAttempts          int `json:"maxAttempts"`
+	InitialBackoffMillis int `json:"initialBackoffMillis"`
+	MaxBackoffMillis     int `json:"maxBackoffMillis,omitempty"`
+}
+
+// When transformed docs are flushed to the target.  A batch is written as soon as it reaches any of the limits
+type WriteBatchConfig struct {
+	MaxDocs       int `json:"maxDocs,omitempty"`
+	MaxBytes      int `json:"maxBytes,omitempty"`
+	MaxWaitMillis int `json:"maxWaitMillis,omitempty"`
+}
+
+// The defaults match the travel-sample setup described in the README
+func DefaultConfig() Config {
+	return Config{
+		ConnSpec: "couchbase://localhost",
+		Source: BucketSpec{
+			Name:          "travel-sample",
+			Password:      "password",
+			AdminPassword: "password",
+		},
+		Target: BucketSpec{
+			Name:          "travel-sample-copy",
+			Password:      "password",
+			AdminPassword: "password",
+		},
+		WorkspaceRoot: "jobs",
+	}
+}
+
+// Load the config file at path on top of the defaults.  An empty path returns the defaults.
+func LoadConfig(path string) (config Config, err error) {
+	return LoadConfigWithVars(path, nil)
+}
+
+// Load the config file at path on top of the defaults, or on top of the template it extends, replacing
+// ${NAME} variables with vars, the built-in variables or environment variables.  An empty path returns
+// the defaults.
+func LoadConfigWithVars(path string, vars map[string]string) (config Config, err error) {
+
+	config = DefaultConfig()
+	if path == "" {
+		return config, nil
+	}
+
+	if err := loadConfigFile(path, vars, time.Now(), &config, 0); err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+// Apply a config file, and before it the templates it extends, to config
+func loadConfigFile(path string, vars map[string]string, now time.Time, config *Config, depth int) error {
+
+	configBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	configBytes, err = substituteConfigVariables(path, configBytes, vars, now)
+	if err != nil {
+		return err
+	}
+
+	var header struct {
+		Extends string `json:"extends"`
+	}
+	if err := json.Unmarshal(configBytes, &header); err != nil {
+		return fmt.Errorf("Error parsing config file: %v.  Err: %v", path, err)
+	}
+	if header.Extends != "" {
+		if depth >= maxConfigExtendsDepth {
+			return fmt.Errorf("Config file %v extends more than %v templates.  Do the templates extend each other?", path, maxConfigExtendsDepth)
+		}
+		templatePath := header.Extends
+		if !filepath.IsAbs(templatePath) {
+			templatePath = filepath.Join(filepath.Dir(path), templatePath)
+		}
+		if err := loadConfigFile(templatePath, vars, now, config, depth+1); err != nil {
+			return err
+		}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(configBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(config); err != nil {
+		return fmt.Errorf("Error parsing config file: %v.  Err: %v", path, err)
+	}
+	return nil
+}
+
+// A copy of the config that is safe to write to disk or logs
+func (c Config) Redacted() Config {
+	redacted := c
+	redacted.Source = c.Source.redacted()
+	redacted.Target = c.Target.redacted()
+	return redacted
+}
+
+func (spec BucketSpec) redacted() BucketSpec {
+	if spec.Password != "" {
+		spec.Password = "<redacted>"
+	}
+	if spec.AdminPassword != "" {
+		spec.AdminPassword = "<redacted>"
+	}
+	return spec
+}
+
+// Apply a config to the app.  Settings left at their zero value keep the app's defaults.
+func WithConfig(config Config) Option {
+	return func(e *ExampleApp) error {
+
+		var opts []Option
+		if config.UseN1ql {
+			opts = append(opts, WithN1QL())
+		}
+		if config.Backup != nil {
+			opts = append(opts, WithBackup(*config.Backup))
+		}
+		if config.Redis != nil {
+			opts = append(opts, WithRedis(*config.Redis))
+		}
+		if config.Engine != EngineConfigured {
+			opts = append(opts, WithEngine(config.Engine))
+		}
+		if config.InPlace {
+			opts = append(opts, WithInPlace())
+		}
+		if len(config.Collections) > 0 || len(config.CollectionMap) > 0 {
+			opts = append(opts, WithCollections(config.Collections, config.CollectionMap))
+		}
+		if config.Workers != 0 {
+			opts = append(opts, WithWorkers(config.Workers))
+		}
+		if config.PageSize != 0 {
+			opts = append(opts, WithPageSize(config.PageSize))
+		}
+		if config.ViewQueryRanges != 0 {
+			opts = append(opts, WithViewQueryRanges(config.ViewQueryRanges))
+		}
+		if config.CheckpointIntervalSeconds != 0 {
+			opts = append(opts, WithCheckpointInterval(time.Duration(config.CheckpointIntervalSeconds)*time.Second))
+		}
+		if config.Retry != nil {
+			opts = append(opts, WithRetryPolicy(RetryPolicy{
+				MaxAttempts:    config.Retry.MaxAttempts,
+				InitialBackoff: time.Duration(config.Retry.InitialBackoffMillis) * time.Millisecond,
+				MaxBackoff:     time.Duration(config.Retry.MaxBackoffMillis) * time.Millisecond,
+			}))
+		}
+		if config.SubdocConcurrency != 0 {
+			opts = append(opts, WithSubdocConcurrency(config.SubdocConcurrency))
+		}
+		if config.ReadAheadPages != nil {
+			opts = append(opts, WithReadAhead(*config.ReadAheadPages))
+		}
+		if config.WriteBatch != nil {
+			opts = append(opts, WithWriteBatching(WriteBatching{
+				MaxDocs:  config.WriteBatch.MaxDocs,
+				MaxBytes: config.WriteBatch.MaxBytes,
+				MaxWait:  time.Duration(config.WriteBatch.MaxWaitMillis) * time.Millisecond,
+			}))
+		}
+		if len(config.Priorities) > 0 {
+			opts = append(opts, WithPriorities(config.Priorities...))
+		}
+		if config.Filter != nil {
+			opts = append(opts, WithFilter(*config.Filter))
+		}
+		if config.MetadataXattrKey != "" {
+			opts = append(opts, WithMetadataXattrKey(config.MetadataXattrKey))
+		}
+		if config.MetadataMacros {
+			opts = append(opts, WithMetadataMacros())
+		}
+		if config.XattrAccessDeleted {
+			opts = append(opts, WithXattrAccessDeleted())
+		}
+		if config.MaxDurationSeconds != 0 || config.MaxDocs != 0 {
+			opts = append(opts, WithLimits(time.Duration(config.MaxDurationSeconds)*time.Second, config.MaxDocs))
+		}
+		for stage, policy := range config.ErrorPolicies {
+			errorPolicy, err := ParseErrorPolicy(policy)
+			if err != nil {
+				return err
+			}
+			opts = append(opts, WithErrorPolicy(stage, errorPolicy))
+		}
+		for _, opt := range opts {
+			if err := opt(e); err != nil {
+				return err
+			}
+		}
+
+		e.JobId = config.JobId
+		e.QueryNodes = config.QueryNodes
+		e.SpreadQueries = config.SpreadQueries
+		e.MaxConcurrentQueries = config.MaxConcurrentQueries
+		e.N1qlPageSize = config.N1qlPageSize
+		if config.SourceQuery != "" {
+			// Otherwise keep the query of the filter's WHERE clause
+			e.SourceQuery = config.SourceQuery
+		}
+		e.AnalyticsDataset = config.AnalyticsDataset
+		e.StrictRowCount = config.StrictRowCount
+		if config.DesignDoc != "" {
+			e.DesignDoc = config.DesignDoc
+		}
+		if config.ViewName != "" {
+			e.ViewName = config.ViewName
+		}
+		e.DevelopmentViews = config.DevelopmentViews
+		e.OverwriteDesignDoc = config.OverwriteDesignDoc
+		e.ReadinessTimeout = time.Duration(config.ReadinessTimeoutSeconds) * time.Second
+		e.ReadBytesPerSecond = config.ReadBytesPerSecond
+		e.WriteBytesPerSecond = config.WriteBytesPerSecond
+		if config.WriteBytesPerSecond == 0 && config.CalibrationFile != "" {
+			calibration, err := ReadCalibration(config.CalibrationFile)
+			if err != nil {
+				return err
+			}
+			if calibration.Bucket != config.Target.Name {
+				e.logf("Warning: calibration file %v is for bucket %v, not the target %v", config.CalibrationFile, calibration.Bucket, config.Target.Name)
+			}
+			e.WriteBytesPerSecond = calibration.SafeWriteBytesPerSecond
+		}
+		e.ReplicaReadFallback = config.ReplicaReadFallback
+		e.SampleEveryN = config.SampleEveryN
+		e.SampleFromReplica = config.SampleFromReplica
+		e.Anonymize = config.Anonymize
+
+		if len(config.Projections) > 0 {
+			projection, err := NewProjection("", config.Projections)
+			if err != nil {
+				return err
+			}
+			e.Projection = projection
+			e.Transforms = append(e.Transforms, projection.Transform)
+		}
+		if config.Truncation != nil {
+			truncator, err := NewTruncator(*config.Truncation)
+			if err != nil {
+				return err
+			}
+			e.Truncator = truncator
+			e.Transforms = append(e.Transforms, truncator.Transform)
+		}
+		if config.GeoFuzz != nil {
+			geoFuzzer, err := NewGeoFuzzer(*config.GeoFuzz)
+			if err != nil {
+				return err
+			}
+			e.GeoFuzzer = geoFuzzer
+			e.Transforms = append(e.Transforms, geoFuzzer.Transform)
+		}
+		if config.Faker != nil {
+			faker, err := NewFaker(*config.Faker)
+			if err != nil {
+				return err
+			}
+			e.Faker = faker
+			e.Transforms = append(e.Transforms, faker.Transform)
+		}
+		if config.Generalization != nil {
+			generalizer, err := NewGeneralizer(*config.Generalization)
+			if err != nil {
+				return err
+			}
+			e.Generalizer = generalizer
+			e.Transforms = append(e.Transforms, generalizer.Transform)
+		}
+		if config.Encryption != nil {
+			encryptor, err := NewFieldEncryptor(*config.Encryption)
+			if err != nil {
+				return err
+			}
+			e.Encryptor = encryptor
+			e.Transforms = append(e.Transforms, encryptor.Transform)
+		}
+		if len(config.CelTransforms) > 0 {
+			celTransform, err := NewCelTransform(config.CelTransforms)
+			if err != nil {
+				return err
+			}
+			e.CelTransform = celTransform
+			e.Transforms = append(e.Transforms, celTransform.Transform)
+		}
+		for _, transformConfig := range config.CustomTransforms {
+			transform, err := NewCustomTransform(transformConfig)
+			if err != nil {
+				return err
+			}
+			e.Transforms = append(e.Transforms, transform)
+		}
+		for _, pluginConfig := range config.WasmPlugins {
+			plugin, err := NewWasmTransform(pluginConfig)
+			if err != nil {
+				return err
+			}
+			e.WasmPlugins = append(e.WasmPlugins, plugin)
+			e.Transforms = append(e.Transforms, plugin.Transform)
+		}
+		if config.Webhook != nil {
+			webhook, err := NewWebhookTransform(*config.Webhook)
+			if err != nil {
+				return err
+			}
+			webhook.logf = e.logf
+			e.Webhook = webhook
+			e.Transforms = append(e.Transforms, webhook.Transform)
+		}
+
+		schedule, err := ParseRunSchedule(config.RunWindows, config.RunWindowTimeZone)
+		if err != nil {
+			return err
+		}
+		e.Schedule = schedule
+
+		return nil
+	}
+}
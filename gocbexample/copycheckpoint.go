@@ -0,0 +1,437 @@
+package gocbexample
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// Prefix of the ids of the docs copies checkpoint their progress to in the target bucket
+const copyCheckpointDocIdPrefix = "_gocb-example::checkpoint::"
+
+// The progress of a copy, saved to a doc in the target bucket so that a copy that died can carry on from where it
+// got to rather than copying every doc again.  Only docs that were processed, along with every doc read before
+// them, are behind the cursors.
+type CopyCheckpoint struct {
+	Source string `json:"source"`
+	JobId  string `json:"jobId,omitempty"`
+	Engine Engine `json:"engine"`
+
+	// The priority lane being copied.  The docs of the lanes before it have all been copied
+	Lane int `json:"lane"`
+
+	// With the views engine, the view key ranges scanned, each with the key of the last doc processed
+	ViewRanges []ViewRangeCursor `json:"viewRanges,omitempty"`
+
+	// With the paged N1QL engine, the id of the last doc processed, which the keyset scan resumes after
+	N1qlCursor string `json:"n1qlCursor,omitempty"`
+
+	// Batches started, which a resumed copy numbers its batches after, and docs read up to the cursors
+	Batches int64 `json:"batches"`
+	Docs    int64 `json:"docs"`
+
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// A view key range of a checkpointed scan, and how far its scan got
+type ViewRangeCursor struct {
+	StartKey string `json:"startKey,omitempty"`
+	EndKey   string `json:"endKey,omitempty"`
+	LastKey  string `json:"lastKey,omitempty"`
+	Done     bool   `json:"done,omitempty"`
+}
+
+// Checkpoint the progress of copies to the target bucket this often, so that Resume can carry on from the last
+// checkpoint.  With a JobId, a copy also resumes from the checkpoint of an earlier run of the same job.
+// Checkpoints need the source bucket to be scanned with views or paged N1QL, and the target bucket as the sink.
+func WithCheckpointInterval(interval time.Duration) Option {
+	return func(e *ExampleApp) error {
+		if interval <= 0 {
+			return fmt.Errorf("Invalid checkpoint interval: %v.  Must be positive", interval)
+		}
+		e.CheckpointInterval = interval
+		return nil
+	}
+}
+
+// Carry on the copy of an earlier run from its checkpoint, or copy from the start if there isn't one
+func (e *ExampleApp) Resume() (err error) {
+	return e.ResumeWithCallback(nil, nil)
+}
+
+// Like Resume, with the callbacks of CopyBucketWithCallback
+func (e *ExampleApp) ResumeWithCallback(preInsertCallback DocProcessorReturnDocs, postInsertCallback DocProcessor) (err error) {
+	e.resume = true
+	defer func() { e.resume = false }()
+	return e.CopyBucketWithCallback(preInsertCallback, postInsertCallback)
+}
+
+// The id of the checkpoint doc of copies of the source collection by the job
+func (e *ExampleApp) copyCheckpointDocId() string {
+	return fmt.Sprintf("%v%v::%v", copyCheckpointDocIdPrefix, e.keyspaceName(e.SourceBucket), e.JobId)
+}
+
+// Read the checkpoint of the copy from the target bucket.  Returns nil if there isn't one
+func (e *ExampleApp) LoadCopyCheckpoint() (*CopyCheckpoint, error) {
+
+	checkpoint := &CopyCheckpoint{}
+	result, err := e.collection(e.TargetBucket).Get(e.copyCheckpointDocId(), nil)
+	if err == nil {
+		err = result.Content(checkpoint)
+	}
+	if err != nil {
+		if errors.Is(wrapGocbError(err), ErrDocNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("Error reading copy checkpoint: %v.  Err: %v", e.copyCheckpointDocId(), err)
+	}
+	return checkpoint, nil
+}
+
+// Can the copy be checkpointed?  Returns the engine its source is scanned with
+func (e *ExampleApp) checkCheckpointable() (Engine, error) {
+
+	if source, ok := e.Source.(*BucketSource); !ok || source.Bucket != e.SourceBucket {
+		return "", fmt.Errorf("Copies can only be checkpointed from the source bucket, not: %v", e.Source.Name())
+	}
+	if !e.sinkIsTargetBucket() {
+		return "", fmt.Errorf("Copy checkpoints are saved to the target bucket, so need it as the sink, not: %v", e.Sink.Name())
+	}
+	engine, err := e.walkEngine(e.SourceBucket, EngineConfigured)
+	if err != nil {
+		return "", err
+	}
+	if engine != EngineViews && engine != EngineN1qlPaged {
+		return "", fmt.Errorf("Copies can only be checkpointed with the %v engine, or %v with n1qlPageSize, not: %v", EngineViews, EngineN1ql, engine)
+	}
+	return engine, nil
+}
+
+// Start checkpointing the copy, if checkpoints are enabled, from the checkpoint of an earlier run if resuming.
+// Returns nil if the copy isn't checkpointed.
+func (e *ExampleApp) startCopyCheckpoints() (*copyCheckpointer, error) {
+
+	if e.CheckpointInterval <= 0 {
+		if e.resume {
+			return nil, fmt.Errorf("Resuming a copy needs checkpoints.  See WithCheckpointInterval")
+		}
+		return nil, nil
+	}
+	engine, err := e.checkCheckpointable()
+	if err != nil {
+		if e.resume {
+			return nil, err
+		}
+		e.logf("Warning: not checkpointing the copy.  %v", err)
+		return nil, nil
+	}
+
+	c := &copyCheckpointer{
+		app:      e,
+		bucket:   e.SourceBucket,
+		docId:    e.copyCheckpointDocId(),
+		interval: e.CheckpointInterval,
+		pages:    map[string]*checkpointPage{},
+		checkpoint: CopyCheckpoint{
+			Source: e.SourceBucketSpec.Name,
+			JobId:  e.JobId,
+			Engine: engine,
+		},
+	}
+
+	if e.resume || e.JobId != "" {
+		checkpoint, err := e.LoadCopyCheckpoint()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case checkpoint == nil && e.resume:
+			e.logf("No checkpoint: %v to resume from, copying from the start", c.docId)
+		case checkpoint == nil:
+		case checkpoint.Engine != engine:
+			return nil, fmt.Errorf("Checkpoint: %v was saved by a copy with the %v engine, so can't be resumed with the %v engine", c.docId, checkpoint.Engine, engine)
+		default:
+			e.logf("Resuming copy from checkpoint: %v of %v, after %v docs", c.docId, checkpoint.UpdatedAt, checkpoint.Docs)
+			c.checkpoint = *checkpoint
+			c.readDone = make([]bool, len(checkpoint.ViewRanges))
+			c.pending = make([][]*checkpointPage, len(checkpoint.ViewRanges))
+			if batches := atomic.LoadInt64(&e.batchCounter); checkpoint.Batches > batches {
+				atomic.StoreInt64(&e.batchCounter, checkpoint.Batches)
+			}
+			if checkpoint.N1qlCursor != "" {
+				e.ResumeN1qlScan(e.keyspaceName(e.SourceBucket), checkpoint.N1qlCursor)
+			}
+		}
+	}
+
+	// Replace the checkpoint of any earlier copy straight away, so it can't be resumed in the middle of this one
+	if err := c.save(true); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Tracks the pages of a checkpointed copy as they're read and processed, and saves the checkpoint every interval
+type copyCheckpointer struct {
+	app      *ExampleApp
+	bucket   *gocb.Bucket
+	docId    string
+	interval time.Duration
+
+	mutex      sync.Mutex
+	checkpoint CopyCheckpoint
+
+	// Write the docs buffered for writing, so that every doc behind the cursors saved has been written
+	flush func() error
+
+	// The pages of each view range read but not yet processed, in the order read, and by their last key.  Pages
+	// can be processed out of order, but the cursor of a range only moves past the pages processed before it
+	pending  [][]*checkpointPage
+	pages    map[string]*checkpointPage
+	readDone []bool
+
+	saveMutex sync.Mutex
+	savedAt   time.Time
+}
+
+type checkpointPage struct {
+	viewRange int
+	lastKey   string
+	docs      int
+	done      bool
+}
+
+// Is the copy checkpointed, and is the bucket the one it scans?
+func (c *copyCheckpointer) tracks(bucket *gocb.Bucket) bool {
+	return c != nil && bucket == c.bucket
+}
+
+// The priority lane to copy first
+func (c *copyCheckpointer) startLane() int {
+	if c == nil {
+		return 0
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.checkpoint.Lane
+}
+
+// Flush the write buffer of the lane being copied before saving each checkpoint.  nil if writes aren't buffered
+func (c *copyCheckpointer) setFlush(flush func() error) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.flush = flush
+}
+
+// The view ranges of the checkpoint, or nil if the scan of the lane hasn't started yet
+func (c *copyCheckpointer) viewRanges() []ViewRangeCursor {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.checkpoint.ViewRanges == nil {
+		return nil
+	}
+	return append([]ViewRangeCursor{}, c.checkpoint.ViewRanges...)
+}
+
+// Start the scan of the lane with these view key ranges
+func (c *copyCheckpointer) setViewRanges(keyRanges []viewKeyRange) []ViewRangeCursor {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.checkpoint.ViewRanges = make([]ViewRangeCursor, len(keyRanges))
+	for i, keyRange := range keyRanges {
+		c.checkpoint.ViewRanges[i] = ViewRangeCursor{StartKey: keyRange.StartKey, EndKey: keyRange.EndKey}
+	}
+	c.pending = make([][]*checkpointPage, len(keyRanges))
+	c.readDone = make([]bool, len(keyRanges))
+	return append([]ViewRangeCursor{}, c.checkpoint.ViewRanges...)
+}
+
+// Record a page of the view range read, before it's processed
+func (c *copyCheckpointer) viewPageRead(viewRange int, lastKey string, docs int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	page := &checkpointPage{viewRange: viewRange, lastKey: lastKey, docs: docs}
+	c.pending[viewRange] = append(c.pending[viewRange], page)
+	c.pages[lastKey] = page
+}
+
+// Record that every page of the view range has been read
+func (c *copyCheckpointer) viewRangeRead(viewRange int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.readDone[viewRange] = true
+	c.advance(viewRange)
+}
+
+// Record the page with this last key processed, and save the checkpoint if it's due
+func (c *copyCheckpointer) viewPageDone(lastKey string) error {
+	c.mutex.Lock()
+	page, ok := c.pages[lastKey]
+	if ok {
+		page.done = true
+		delete(c.pages, lastKey)
+		c.advance(page.viewRange)
+	}
+	c.mutex.Unlock()
+	if !ok {
+		return nil
+	}
+	return c.save(false)
+}
+
+// Move the cursor of the view range past the pages processed in order.  Must be called with the mutex held
+func (c *copyCheckpointer) advance(viewRange int) {
+	pending := c.pending[viewRange]
+	for len(pending) > 0 && pending[0].done {
+		c.checkpoint.ViewRanges[viewRange].LastKey = pending[0].lastKey
+		c.checkpoint.Docs += int64(pending[0].docs)
+		pending = pending[1:]
+	}
+	c.pending[viewRange] = pending
+	if c.readDone[viewRange] && len(pending) == 0 {
+		c.checkpoint.ViewRanges[viewRange].Done = true
+	}
+}
+
+// Record a page of a paged N1QL scan processed, and save the checkpoint if it's due
+func (c *copyCheckpointer) n1qlPageDone(lastDocId string, docs int) error {
+	c.mutex.Lock()
+	c.checkpoint.N1qlCursor = lastDocId
+	c.checkpoint.Docs += int64(docs)
+	c.mutex.Unlock()
+	return c.save(false)
+}
+
+// Record the lane copied, so that a resumed copy starts from the next one
+func (c *copyCheckpointer) laneDone(lane int) error {
+	if c == nil {
+		return nil
+	}
+	c.mutex.Lock()
+	c.checkpoint.Lane = lane + 1
+	c.checkpoint.ViewRanges = nil
+	c.checkpoint.N1qlCursor = ""
+	c.pending = nil
+	c.readDone = nil
+	c.mutex.Unlock()
+	return c.save(true)
+}
+
+// Save the checkpoint to the target bucket, if force is set or it was last saved at least an interval ago
+func (c *copyCheckpointer) save(force bool) error {
+
+	if force {
+		c.saveMutex.Lock()
+	} else if !c.saveMutex.TryLock() {
+		// Being saved already
+		return nil
+	}
+	defer c.saveMutex.Unlock()
+	if !force && time.Since(c.savedAt) < c.interval {
+		return nil
+	}
+
+	c.mutex.Lock()
+	checkpoint := c.checkpoint
+	checkpoint.ViewRanges = append([]ViewRangeCursor(nil), c.checkpoint.ViewRanges...)
+	flush := c.flush
+	c.mutex.Unlock()
+
+	// The docs of the pages behind the cursors may be buffered rather than written yet
+	if flush != nil {
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+
+	checkpoint.Batches = atomic.LoadInt64(&c.app.batchCounter)
+	checkpoint.UpdatedAt = time.Now()
+	collection, err := c.app.writeCollection(c.app.TargetBucket, "Saving the copy checkpoint")
+	if err != nil {
+		return err
+	}
+	if _, err := collection.Upsert(c.docId, checkpoint, nil); err != nil {
+		return fmt.Errorf("Error saving copy checkpoint: %v.  Err: %v", c.docId, err)
+	}
+	c.savedAt = time.Now()
+	return nil
+}
+
+// Remove the checkpoint once the copy has finished, so it isn't left among the copied docs
+func (c *copyCheckpointer) finish() error {
+	if c == nil {
+		return nil
+	}
+	c.saveMutex.Lock()
+	defer c.saveMutex.Unlock()
+	collection, err := c.app.writeCollection(c.app.TargetBucket, "Removing the copy checkpoint")
+	if err != nil {
+		return err
+	}
+	if _, err := collection.Remove(c.docId, nil); err != nil && !errors.Is(wrapGocbError(err), ErrDocNotFound) {
+		return fmt.Errorf("Error removing copy checkpoint: %v.  Err: %v", c.docId, err)
+	}
+	return nil
+}
+
+// Scan the view key ranges of the checkpoint, each from after its last key, recording the pages read.  The
+// ranges are split as for ForEachDocIdBucketViews when the scan of the lane starts, and kept when it's resumed.
+func (e *ExampleApp) forEachDocIdBucketViewsCheckpointed(docProcessor DocProcessor, bucket *gocb.Bucket, c *copyCheckpointer) error {
+
+	ranges := c.viewRanges()
+	if ranges == nil {
+		keyRanges := []viewKeyRange{{}}
+		if e.ViewQueryRanges > 1 {
+			var err error
+			if keyRanges, err = e.viewKeyRanges(bucket, e.ViewQueryRanges); err != nil {
+				return err
+			}
+		}
+		ranges = c.setViewRanges(keyRanges)
+	}
+	for _, cursor := range ranges {
+		if cursor.Done || cursor.LastKey != "" {
+			// The rows before the cursors were read by an earlier run
+			e.ScanProgress(bucket.Name()).setPartial()
+			break
+		}
+	}
+
+	e.logf("Performing checkpointed operation via %v view key ranges over bucket: %v", len(ranges), bucket.Name())
+	defer e.logf("Finished checkpointed operation via view key ranges over bucket: %v", bucket.Name())
+
+	wg := sync.WaitGroup{}
+	errs := make(chan error, len(ranges))
+
+	for i, cursor := range ranges {
+		if cursor.Done {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, cursor ViewRangeCursor) {
+			defer wg.Done()
+			onPage := func(lastKey string, docs int) {
+				c.viewPageRead(i, lastKey, docs)
+			}
+			keyRange := viewKeyRange{StartKey: cursor.StartKey, EndKey: cursor.EndKey}
+			if err := e.forEachDocIdBucketViewRange(docProcessor, bucket, keyRange, cursor.LastKey, onPage); err != nil {
+				errs <- fmt.Errorf("Error processing view key range [%q, %q).  Err: %w", keyRange.StartKey, keyRange.EndKey, err)
+				return
+			}
+			c.viewRangeRead(i)
+		}(i, cursor)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	// Return the first error, if any
+	return <-errs
+}
@@ -0,0 +1,323 @@
+package gocbexample
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How a CSV column's values are converted to JSON
+const (
+	CsvTypeAuto   = "auto"   // numbers and true/false as such, anything else as a string
+	CsvTypeString = "string" // as is
+	CsvTypeInt    = "int"
+	CsvTypeFloat  = "float"
+	CsvTypeBool   = "bool" // true/false, yes/no, y/n or 1/0, in any case
+	CsvTypeJSON   = "json" // a JSON value, eg an array
+	CsvTypeDate   = "date" // parsed with the column's dateFormat, and written as an ISO-8601 string in UTC
+)
+
+// Maps a CSV column to a doc field
+type CsvColumn struct {
+
+	// The column, by its header
+	Column string `json:"column"`
+
+	// The field it's written to, eg "address.city" for a nested field.  Defaults to the column header
+	Field string `json:"field,omitempty"`
+
+	// How values are converted.  Defaults to the csvImport defaultType
+	Type string `json:"type,omitempty"`
+
+	// Go time layout of a date column, eg "02/01/2006".  Defaults to RFC 3339
+	DateFormat string `json:"dateFormat,omitempty"`
+
+	// Leave the column out of the docs, eg when it's only used in the key
+	Skip bool `json:"skip,omitempty"`
+}
+
+// How the import command reads a CSV file.  The first row is the header, naming the columns
+type CsvImportConfig struct {
+
+	// The field separator.  Defaults to ","
+	Delimiter string `json:"delimiter,omitempty"`
+
+	// Mappings of the columns that aren't written as is, to a field named after the header with the default type
+	Columns []CsvColumn `json:"columns,omitempty"`
+
+	// The type of the columns not listed.  Defaults to auto
+	DefaultType string `json:"defaultType,omitempty"`
+
+	// Values written as null, eg ["", "NULL", "N/A"].  Defaults to the empty string
+	NullValues []string `json:"nullValues,omitempty"`
+
+	// Build doc ids from the fields of the docs, eg "user::${id}".  Fields of skipped columns can be used too
+	KeyTemplate string `json:"keyTemplate,omitempty"`
+}
+
+// A Source that reads a CSV file, a doc per row
+type CsvSource struct {
+	Path   string
+	Config CsvImportConfig
+
+	keyTemplate *DocKeyTemplate
+	columns     map[string]CsvColumn
+	nullValues  map[string]bool
+
+	// Number of docs passed to the DocProcessor at once
+	BatchSize int
+}
+
+func NewCsvSource(path string, config CsvImportConfig) (*CsvSource, error) {
+
+	if config.KeyTemplate == "" {
+		return nil, fmt.Errorf("A CSV import needs a key template, eg 'user::${id}'")
+	}
+	keyTemplate, err := NewDocKeyTemplate(config.KeyTemplate)
+	if err != nil {
+		return nil, err
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	if config.DefaultType == "" {
+		config.DefaultType = CsvTypeAuto
+	}
+
+	s := &CsvSource{
+		Path:        path,
+		Config:      config,
+		keyTemplate: keyTemplate,
+		columns:     map[string]CsvColumn{},
+		nullValues:  map[string]bool{},
+		BatchSize:   pageSizeViewResult,
+	}
+	for _, column := range config.Columns {
+		if column.Type == "" {
+			column.Type = config.DefaultType
+		}
+		s.columns[column.Column] = column
+	}
+	if len(config.NullValues) == 0 {
+		config.NullValues = []string{""}
+	}
+	for _, nullValue := range config.NullValues {
+		s.nullValues[nullValue] = true
+	}
+	return s, nil
+}
+
+// Check the delimiter and column mappings.  The key template can be set later, with -key-template
+func (c CsvImportConfig) validate() error {
+	if c.Delimiter != "" && len([]rune(c.Delimiter)) != 1 {
+		return fmt.Errorf("Invalid CSV delimiter: %q.  Must be a single character", c.Delimiter)
+	}
+	if c.DefaultType != "" {
+		if err := validateCsvType(c.DefaultType); err != nil {
+			return err
+		}
+	}
+	for i, column := range c.Columns {
+		if column.Column == "" {
+			return fmt.Errorf("csvImport.columns[%v] has no column", i)
+		}
+		if column.Type == "" {
+			continue
+		}
+		if err := validateCsvType(column.Type); err != nil {
+			return fmt.Errorf("csvImport.columns[%v]: %v", i, err)
+		}
+	}
+	return nil
+}
+
+func validateCsvType(csvType string) error {
+	switch csvType {
+	case CsvTypeAuto, CsvTypeString, CsvTypeInt, CsvTypeFloat, CsvTypeBool, CsvTypeJSON, CsvTypeDate:
+		return nil
+	}
+	return fmt.Errorf("Unknown CSV column type: %v.  Expected one of: auto, string, int, float, bool, json, date", csvType)
+}
+
+func (s *CsvSource) Name() string {
+	return fmt.Sprintf("csv:%v", s.Path)
+}
+
+func (s *CsvSource) ForEachDoc(docProcessor DocProcessor) error {
+
+	file, err := os.Open(s.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	if s.Config.Delimiter != "" {
+		reader.Comma = []rune(s.Config.Delimiter)[0]
+	}
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("Error reading the header of CSV file: %v.  Err: %v", s.Path, err)
+	}
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	}
+	headerColumns := map[string]bool{}
+	for _, column := range header {
+		headerColumns[column] = true
+	}
+	for column := range s.columns {
+		if !headerColumns[column] {
+			return fmt.Errorf("CSV file: %v has no column: %v.  Its columns are: %v", s.Path, column, strings.Join(header, ", "))
+		}
+	}
+
+	batch := DocProcessorInput{}
+	flush := func() error {
+		if len(batch.DocIds) == 0 {
+			return nil
+		}
+		err := docProcessor(batch.DocIds, batch.Docs)
+		batch = DocProcessorInput{}
+		return err
+	}
+
+	for row := 2; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return newDocError(PhaseSourceRead, "", fmt.Errorf("Error reading CSV file: %v.  Err: %w", s.Path, err))
+		}
+
+		doc, keyFields, err := s.rowDoc(header, record)
+		if err != nil {
+			return newDocError(PhaseSourceRead, "", fmt.Errorf("Error converting row %v of CSV file: %v.  Err: %w", row, s.Path, err))
+		}
+		docId, err := s.keyTemplate.key(keyFields)
+		if err != nil {
+			return newDocError(PhaseSourceRead, "", fmt.Errorf("Error building the id of row %v of CSV file: %v.  Err: %w", row, s.Path, err))
+		}
+
+		batch.DocIds = append(batch.DocIds, docId)
+		batch.Docs = append(batch.Docs, doc)
+		if len(batch.DocIds) >= s.BatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return flush()
+}
+
+// The doc of a row, and the fields the key template can use: the doc's, plus those of skipped columns
+func (s *CsvSource) rowDoc(header, record []string) (doc, keyFields map[string]interface{}, err error) {
+
+	doc = map[string]interface{}{}
+	keyFields = map[string]interface{}{}
+	for i, value := range record {
+		column, ok := s.columns[header[i]]
+		if !ok {
+			column = CsvColumn{Column: header[i], Type: s.Config.DefaultType}
+		}
+		field := column.Field
+		if field == "" {
+			field = column.Column
+		}
+
+		var converted interface{}
+		if !s.nullValues[value] {
+			converted, err = convertCsvValue(value, column)
+			if err != nil {
+				return nil, nil, fmt.Errorf("Column %v: %v", column.Column, err)
+			}
+		}
+
+		setNestedField(keyFields, field, converted)
+		if !column.Skip {
+			setNestedField(doc, field, converted)
+		}
+	}
+	return doc, keyFields, nil
+}
+
+func convertCsvValue(value string, column CsvColumn) (interface{}, error) {
+
+	switch column.Type {
+	case CsvTypeString:
+		return value, nil
+	case CsvTypeInt:
+		i, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid int: %q", value)
+		}
+		if i > 1<<53 || i < -(1<<53) {
+			// More than a float64 holds exactly
+			return json.Number(strconv.FormatInt(i, 10)), nil
+		}
+		return float64(i), nil
+	case CsvTypeFloat:
+		f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid float: %q", value)
+		}
+		return f, nil
+	case CsvTypeBool:
+		switch strings.ToLower(strings.TrimSpace(value)) {
+		case "true", "yes", "y", "1":
+			return true, nil
+		case "false", "no", "n", "0":
+			return false, nil
+		}
+		return nil, fmt.Errorf("Invalid bool: %q", value)
+	case CsvTypeJSON:
+		var val interface{}
+		if err := json.Unmarshal([]byte(value), &val); err != nil {
+			return nil, fmt.Errorf("Invalid JSON: %q.  Err: %v", value, err)
+		}
+		return val, nil
+	case CsvTypeDate:
+		layout := column.DateFormat
+		if layout == "" {
+			layout = time.RFC3339
+		}
+		date, err := time.Parse(layout, strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid date: %q.  Expected the format %v", value, layout)
+		}
+		return date.UTC().Format(time.RFC3339Nano), nil
+	}
+
+	// Auto
+	trimmed := strings.TrimSpace(value)
+	if f, err := strconv.ParseFloat(trimmed, 64); err == nil && numberValue(trimmed) == f {
+		return f, nil
+	}
+	switch trimmed {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	return value, nil
+}
+
+// Set a dotted field of a doc, eg "address.city", creating the objects on the way
+func setNestedField(doc map[string]interface{}, field string, val interface{}) {
+	parts := strings.Split(field, ".")
+	for _, part := range parts[:len(parts)-1] {
+		child, ok := doc[part].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			doc[part] = child
+		}
+		doc = child
+	}
+	doc[parts[len(parts)-1]] = val
+}
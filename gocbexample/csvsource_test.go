@@ -0,0 +1,133 @@
+package gocbexample
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeCsvFile(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "users.csv")
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCsvSourceForEachDoc(t *testing.T) {
+
+	// Excel writes a byte order mark before the header
+	path := writeCsvFile(t, "\ufeffid;name;city;active;score;joined\n"+
+		"1;Ann;Paris;yes;N/A;03/10/2017\n"+
+		"2;Bob;;no;3.5;04/10/2017\n"+
+		"3;007;Lyon;Y;10;05/10/2017\n")
+
+	source, err := NewCsvSource(path, CsvImportConfig{
+		Delimiter: ";",
+		Columns: []CsvColumn{
+			{Column: "id", Skip: true},
+			{Column: "name", Type: CsvTypeString},
+			{Column: "city", Field: "address.city"},
+			{Column: "active", Type: CsvTypeBool},
+			{Column: "joined", Type: CsvTypeDate, DateFormat: "02/01/2006"},
+		},
+		NullValues:  []string{"", "N/A"},
+		KeyTemplate: "user::${id}",
+	})
+	if err != nil {
+		t.Fatalf("NewCsvSource failed: %v", err)
+	}
+	source.BatchSize = 2
+
+	var batches [][]string
+	var docs []interface{}
+	err = source.ForEachDoc(func(docIds []string, batchDocs []interface{}) error {
+		batches = append(batches, docIds)
+		docs = append(docs, batchDocs...)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ForEachDoc failed: %v", err)
+	}
+
+	wantBatches := [][]string{{"user::1", "user::2"}, {"user::3"}}
+	wantDocs := []interface{}{
+		map[string]interface{}{"name": "Ann", "address": map[string]interface{}{"city": "Paris"}, "active": true, "score": nil, "joined": "2017-10-03T00:00:00Z"},
+		map[string]interface{}{"name": "Bob", "address": map[string]interface{}{"city": nil}, "active": false, "score": 3.5, "joined": "2017-10-04T00:00:00Z"},
+		map[string]interface{}{"name": "007", "address": map[string]interface{}{"city": "Lyon"}, "active": true, "score": 10.0, "joined": "2017-10-05T00:00:00Z"},
+	}
+	if !reflect.DeepEqual(batches, wantBatches) {
+		t.Errorf("Batches = %v, want %v", batches, wantBatches)
+	}
+	if !reflect.DeepEqual(docs, wantDocs) {
+		t.Errorf("Docs = %v, want %v", docs, wantDocs)
+	}
+}
+
+func TestCsvSourceErrors(t *testing.T) {
+
+	path := writeCsvFile(t, "id,age\n1,30\n2,thirty\n")
+
+	// A mapped column the file doesn't have
+	source, err := NewCsvSource(path, CsvImportConfig{Columns: []CsvColumn{{Column: "email"}}, KeyTemplate: "${id}"})
+	if err != nil {
+		t.Fatalf("NewCsvSource failed: %v", err)
+	}
+	if err := source.ForEachDoc(func([]string, []interface{}) error { return nil }); err == nil || !strings.Contains(err.Error(), "no column: email") {
+		t.Errorf("ForEachDoc with an unknown column = %v, want a no column error", err)
+	}
+
+	// A value that isn't of its column's type fails, naming the row
+	source, err = NewCsvSource(path, CsvImportConfig{Columns: []CsvColumn{{Column: "age", Type: CsvTypeInt}}, KeyTemplate: "${id}"})
+	if err != nil {
+		t.Fatalf("NewCsvSource failed: %v", err)
+	}
+	if err := source.ForEachDoc(func([]string, []interface{}) error { return nil }); err == nil || !strings.Contains(err.Error(), "row 3") {
+		t.Errorf("ForEachDoc with an invalid int = %v, want an error for row 3", err)
+	}
+
+	for _, config := range []CsvImportConfig{
+		{KeyTemplate: ""},
+		{KeyTemplate: "${id}", Delimiter: "::"},
+		{KeyTemplate: "${id}", DefaultType: "decimal"},
+		{KeyTemplate: "${id}", Columns: []CsvColumn{{Field: "age"}}},
+	} {
+		if _, err := NewCsvSource(path, config); err == nil {
+			t.Errorf("NewCsvSource(%+v) succeeded, want an error", config)
+		}
+	}
+}
+
+// Values that would change if coerced, eg by losing leading zeros or precision, are kept as they are
+func TestConvertCsvValue(t *testing.T) {
+
+	tests := []struct {
+		value  string
+		column CsvColumn
+		want   interface{}
+	}{
+		{value: "42", column: CsvColumn{Type: CsvTypeAuto}, want: 42.0},
+		{value: "007", column: CsvColumn{Type: CsvTypeAuto}, want: "007"},
+		{value: "1e3", column: CsvColumn{Type: CsvTypeAuto}, want: "1e3"},
+		{value: "NaN", column: CsvColumn{Type: CsvTypeAuto}, want: "NaN"},
+		{value: " 42 ", column: CsvColumn{Type: CsvTypeInt}, want: 42.0},
+		{value: "9007199254740993", column: CsvColumn{Type: CsvTypeInt}, want: json.Number("9007199254740993")},
+		{value: `["a", 1]`, column: CsvColumn{Type: CsvTypeJSON}, want: []interface{}{"a", 1.0}},
+		{value: "2017-10-03T10:00:00+02:00", column: CsvColumn{Type: CsvTypeDate}, want: "2017-10-03T08:00:00Z"},
+	}
+	for _, test := range tests {
+		got, err := convertCsvValue(test.value, test.column)
+		if err != nil || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("convertCsvValue(%q, %v) = %#v, %v, want %#v", test.value, test.column.Type, got, err, test.want)
+		}
+	}
+
+	for _, column := range []CsvColumn{{Type: CsvTypeInt}, {Type: CsvTypeFloat}, {Type: CsvTypeBool}, {Type: CsvTypeJSON}, {Type: CsvTypeDate}} {
+		if got, err := convertCsvValue("4.2x", column); err == nil {
+			t.Errorf("convertCsvValue(4.2x, %v) = %v, want an error", column.Type, got)
+		}
+	}
+}
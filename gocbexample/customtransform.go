@@ -0,0 +1,57 @@
+package gocbexample
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/couchbaselabs/gocb-example/transforms"
+)
+
+// A transform registered with transforms.Register, compiled into this binary
+type CustomTransformConfig struct {
+
+	// The name it was registered under
+	Name string `json:"name"`
+
+	// Passed to its factory as is
+	Config json.RawMessage `json:"config,omitempty"`
+}
+
+// Look up a registered transform, and check that it's compiled into this binary
+func (c CustomTransformConfig) factory() (transforms.TransformFactory, error) {
+	factory, ok := transforms.Lookup(c.Name)
+	if !ok {
+		registered := "none"
+		if names := transforms.Names(); len(names) > 0 {
+			registered = strings.Join(names, ", ")
+		}
+		return nil, fmt.Errorf("Unknown custom transform: %q.  This binary has: %v.  See go run release.go -with", c.Name, registered)
+	}
+	return factory, nil
+}
+
+// Build the registered transform as a pipeline stage
+func NewCustomTransform(config CustomTransformConfig) (DocProcessorReturnDocs, error) {
+
+	factory, err := config.factory()
+	if err != nil {
+		return nil, err
+	}
+	transform, err := factory(config.Config)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating custom transform: %v.  Err: %v", config.Name, err)
+	}
+
+	return func(input DocProcessorInput) (output DocProcessorInput, err error) {
+
+		docIds, docs, err := transform(input.DocIds, input.Docs)
+		if err != nil {
+			return output, newDocError(PhaseTransform, "", fmt.Errorf("Error in custom transform: %v.  Err: %w", config.Name, err))
+		}
+		if len(docIds) != len(docs) {
+			return output, newDocError(PhaseTransform, "", fmt.Errorf("Custom transform: %v returned %v ids for %v docs", config.Name, len(docIds), len(docs)))
+		}
+		return DocProcessorInput{DocIds: docIds, Docs: docs}, nil
+	}, nil
+}
@@ -0,0 +1,465 @@
+package gocbexample
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+	"github.com/couchbase/gocbcore/v10"
+	"github.com/couchbase/gocbcore/v10/memd"
+)
+
+const (
+	// Times a vbucket's stream is reopened after the server ends it early, eg when the vbucket moves in a
+	// rebalance, before the scan fails
+	maxDcpStreamRestarts = 5
+
+	// Wait between reopening a stream, so that the cluster map has time to catch up with a rebalance
+	dcpStreamRestartDelay = time.Second
+
+	// How long the DCP connection has to come up
+	dcpConnectTimeout = 30 * time.Second
+)
+
+// Loop over each doc in the collection of the bucket by streaming it over DCP straight from the KV engine, which
+// needs neither a view nor an index.  Each vbucket is streamed up to the seqno it had when the scan started,
+// e.Workers vbuckets at a time.  A doc changed during the scan is sent again in a later snapshot, so each vbucket
+// is buffered until its stream ends, and only then are its docs passed on in pages of e.PageSize, each doc once in
+// its latest version.  Docs deleted before the end of the stream are dropped, and values that aren't JSON are
+// skipped.
+func (e *ExampleApp) ForEachDocIdBucketDCP(docProcessor DocProcessor, bucket *gocb.Bucket) (err error) {
+
+	keyspaceName := e.keyspaceName(bucket)
+	e.logf("Performing operation via DCP over bucket: %v", keyspaceName)
+	defer e.logf("Finished operation via DCP over bucket: %v", keyspaceName)
+
+	agent, numVbuckets, err := e.openDcpAgent(bucket)
+	if err != nil {
+		return newDocError(PhaseSourceRead, "", err)
+	}
+	defer agent.Close()
+
+	// Buckets without collections are streamed whole, as before.  Collections are streamed with a filter, so that
+	// the server only sends their docs.
+	var collectionIds []uint32
+	if keyspace := e.keyspace(bucket); !keyspace.isDefault() {
+		collectionId, err := e.dcpCollectionId(bucket.Name(), keyspace)
+		if err != nil {
+			return newDocError(PhaseSourceRead, "", err)
+		}
+		collectionIds = []uint32{collectionId}
+	}
+
+	highSeqnos, err := dcpHighSeqnos(agent, numVbuckets, collectionIds)
+	if err != nil {
+		return newDocError(PhaseSourceRead, "", fmt.Errorf("Error getting the vbucket seqnos of bucket: %v.  Err: %v", keyspaceName, err))
+	}
+
+	progress := e.startScanProgress(keyspaceName)
+	workers := e.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+
+	// Stream the vbuckets with a pool of workers, stopping at the first error
+	vbIds := make(chan uint16, len(highSeqnos))
+	for vbId, highSeqno := range highSeqnos {
+		if highSeqno > 0 {
+			vbIds <- uint16(vbId)
+		}
+	}
+	close(vbIds)
+
+	var errMutex sync.Mutex
+	var firstErr error
+	stop := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for vbId := range vbIds {
+				// Pause before opening the next vbucket's stream, rather than while one is open
+				e.Schedule.Wait(e.logf)
+				stream := &dcpStream{
+					app:          e,
+					agent:        agent,
+					bucket:       bucket,
+					name:         keyspaceName,
+					collections:  collectionIds,
+					vbId:         vbId,
+					endSeqno:     highSeqnos[vbId],
+					docProcessor: docProcessor,
+					progress:     progress,
+					stop:         stop,
+				}
+				if err := stream.run(); err != nil {
+					errMutex.Lock()
+					if firstErr == nil {
+						firstErr = err
+						close(stop)
+					}
+					errMutex.Unlock()
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	return firstErr
+}
+
+// Connect a DCP agent to the bucket, with the credentials it was opened with.  Returns the agent and the number of
+// vbuckets of the bucket.
+func (e *ExampleApp) openDcpAgent(bucket *gocb.Bucket) (*gocbcore.DCPAgent, int, error) {
+
+	spec := e.bucketSpec(bucket)
+	config := &gocbcore.DCPAgentConfig{UserAgent: "gocb-example"}
+	if err := config.FromConnStr(e.ConnSpec); err != nil {
+		return nil, 0, fmt.Errorf("Error parsing connection string for DCP: %v.  Err: %v", e.ConnSpec, err)
+	}
+	config.BucketName = spec.Name
+	config.SecurityConfig.Auth = &gocbcore.PasswordAuthProvider{Username: spec.username(), Password: spec.Password}
+	config.IoConfig.UseCollections = !e.keyspace(bucket).isDefault()
+
+	// The stream name shows up in the server's DCP stats, so that the scan can be told apart from replication
+	streamName := fmt.Sprintf("gocb-example:%v:%v", spec.Name, time.Now().UnixNano())
+	agent, err := gocbcore.CreateDcpAgent(config, streamName, memd.DcpOpenFlagProducer)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Error opening DCP connection to bucket: %v.  Err: %v", spec.Name, err)
+	}
+
+	ready := make(chan error, 1)
+	_, err = agent.WaitUntilReady(time.Now().Add(dcpConnectTimeout), gocbcore.WaitUntilReadyOptions{},
+		func(_ *gocbcore.WaitUntilReadyResult, err error) {
+			ready <- err
+		})
+	if err == nil {
+		err = <-ready
+	}
+	var numVbuckets int
+	if err == nil {
+		var snapshot *gocbcore.ConfigSnapshot
+		if snapshot, err = agent.ConfigSnapshot(); err == nil {
+			numVbuckets, err = snapshot.NumVbuckets()
+		}
+	}
+	if err != nil {
+		agent.Close()
+		return nil, 0, fmt.Errorf("Error opening DCP connection to bucket: %v.  Err: %v", spec.Name, err)
+	}
+	if numVbuckets == 0 {
+		agent.Close()
+		return nil, 0, fmt.Errorf("Bucket: %v has no vbuckets, DCP needs a couchbase bucket", spec.Name)
+	}
+	return agent, numVbuckets, nil
+}
+
+// The id the server knows a collection by in DCP, from the bucket's collection manifest
+func (e *ExampleApp) dcpCollectionId(bucketName string, keyspace Keyspace) (uint32, error) {
+
+	var manifest struct {
+		Scopes []struct {
+			Name        string `json:"name"`
+			Collections []struct {
+				Name string `json:"name"`
+				Uid  string `json:"uid"`
+			} `json:"collections"`
+		} `json:"scopes"`
+	}
+	if err := e.managementGet("/pools/default/buckets/"+url.PathEscape(bucketName)+"/scopes", &manifest); err != nil {
+		return 0, fmt.Errorf("Error getting the collections of bucket: %v.  Err: %v", bucketName, err)
+	}
+	for _, scope := range manifest.Scopes {
+		if scope.Name != keyspace.Scope {
+			continue
+		}
+		for _, collection := range scope.Collections {
+			if collection.Name != keyspace.Collection {
+				continue
+			}
+			uid, err := strconv.ParseUint(collection.Uid, 16, 32)
+			if err != nil {
+				return 0, fmt.Errorf("Invalid uid: %v of collection: %v.  Err: %v", collection.Uid, keyspace, err)
+			}
+			return uint32(uid), nil
+		}
+	}
+	return 0, fmt.Errorf("Bucket: %v has no collection: %v", bucketName, keyspace)
+}
+
+// The seqno of each active vbucket, indexed by vbucket id, asked of each node in turn.  With a collection, the
+// seqno is that of the last change to the collection.
+func dcpHighSeqnos(agent *gocbcore.DCPAgent, numVbuckets int, collectionIds []uint32) ([]gocbcore.SeqNo, error) {
+
+	type seqnosResult struct {
+		entries []gocbcore.VbSeqNoEntry
+		err     error
+	}
+
+	snapshot, err := agent.ConfigSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	numServers, err := snapshot.NumServers()
+	if err != nil {
+		return nil, err
+	}
+	opts := gocbcore.GetVbucketSeqnoOptions{}
+	if len(collectionIds) > 0 {
+		opts.FilterOptions = &gocbcore.GetVbucketSeqnoFilterOptions{CollectionID: collectionIds[0]}
+	}
+
+	highSeqnos := make([]gocbcore.SeqNo, numVbuckets)
+	seen := make([]bool, len(highSeqnos))
+	for serverIdx := 1; serverIdx <= numServers; serverIdx++ {
+		results := make(chan seqnosResult, 1)
+		_, err := agent.GetVbucketSeqnos(serverIdx, memd.VbucketStateActive, opts, func(entries []gocbcore.VbSeqNoEntry, err error) {
+			results <- seqnosResult{entries: entries, err: err}
+		})
+		if err != nil {
+			return nil, err
+		}
+		result := <-results
+		if result.err != nil {
+			return nil, result.err
+		}
+		for _, entry := range result.entries {
+			if int(entry.VbID) < len(highSeqnos) {
+				highSeqnos[entry.VbID] = entry.SeqNo
+				seen[entry.VbID] = true
+			}
+		}
+	}
+
+	for vbId, ok := range seen {
+		if !ok {
+			return nil, fmt.Errorf("No node reported vbucket %v as active, the cluster may be rebalancing or failing over", vbId)
+		}
+	}
+	return highSeqnos, nil
+}
+
+// The stream of one vbucket, from seqno 0 up to endSeqno.  Implements gocbcore.StreamObserver, whose callbacks are
+// made one at a time on the connection's goroutine, and buffers the docs of the vbucket, so that a doc the stream
+// sends again, changed or deleted, replaces the version buffered.
+type dcpStream struct {
+	app          *ExampleApp
+	agent        *gocbcore.DCPAgent
+	bucket       *gocb.Bucket
+	name         string
+	collections  []uint32
+	vbId         uint16
+	endSeqno     gocbcore.SeqNo
+	docProcessor DocProcessor
+	progress     *ScanProgress
+	stop         <-chan struct{}
+
+	// Where the stream is reopened from if it ends early: the vbucket's uuid, the last seqno received and the
+	// snapshot it's in
+	vbUuid     gocbcore.VbUUID
+	lastSeqno  uint64
+	snapStart  uint64
+	snapEnd    uint64
+	snapshots  int
+	backfilled int
+
+	// The docs of the vbucket, and the index of each doc in them.  Kept when the stream is reopened.
+	buffered    DocProcessorInput
+	bufferIndex map[string]int
+	skipped     int
+
+	ended chan error
+}
+
+// Stream the vbucket, reopening the stream from where it got to if the server ends it early, then pass on its docs
+func (s *dcpStream) run() error {
+
+	s.buffered = DocProcessorInput{DocIds: []string{}, Docs: []interface{}{}}
+	s.bufferIndex = map[string]int{}
+	for restarts := 0; ; restarts++ {
+		streamErr := s.stream()
+		switch {
+		case s.stopped():
+			return nil
+		case streamErr == nil:
+			s.app.logf("Streamed vbucket %v of bucket %v up to seqno %v: %v docs, %v snapshots, %v from disk, skipped %v non-JSON docs",
+				s.vbId, s.name, s.endSeqno, len(s.buffered.DocIds), s.snapshots, s.backfilled, s.skipped)
+			return s.processBuffered()
+		case !isRestartableDcpError(streamErr) || restarts >= maxDcpStreamRestarts:
+			return newDocError(PhaseSourceRead, "", fmt.Errorf("Error streaming vbucket %v of bucket: %v.  Err: %v", s.vbId, s.name, streamErr))
+		}
+		s.app.logf("Stream of vbucket %v of bucket %v ended at seqno %v, reopening it.  Err: %v", s.vbId, s.name, s.lastSeqno, streamErr)
+		select {
+		case <-s.stop:
+			return nil
+		case <-time.After(dcpStreamRestartDelay):
+		}
+	}
+}
+
+// Whether another vbucket's stream failed, which stops the scan
+func (s *dcpStream) stopped() bool {
+	select {
+	case <-s.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// Open the stream from where the last one got to, and wait for it to end.  Returns the error the stream ended
+// with, which isn't set if the scan was stopped by another vbucket.
+func (s *dcpStream) stream() error {
+
+	s.ended = make(chan error, 1)
+
+	opts := gocbcore.OpenStreamOptions{}
+	if len(s.collections) > 0 {
+		opts.FilterOptions = &gocbcore.OpenStreamFilterOptions{CollectionIDs: s.collections}
+	}
+	opened := make(chan error, 1)
+	_, err := s.agent.OpenStream(s.vbId, memd.DcpStreamAddFlagActiveOnly, s.vbUuid,
+		gocbcore.SeqNo(s.lastSeqno), s.endSeqno, gocbcore.SeqNo(s.snapStart), gocbcore.SeqNo(s.snapEnd), s, opts,
+		func(failoverLog []gocbcore.FailoverEntry, err error) {
+			if err == nil && s.vbUuid == 0 && len(failoverLog) > 0 {
+				s.vbUuid = failoverLog[0].VbUUID
+			}
+			opened <- err
+		})
+	if err != nil {
+		return err
+	}
+	if err := <-opened; err != nil {
+		return err
+	}
+
+	select {
+	case err := <-s.ended:
+		return err
+	case <-s.stop:
+		// Stop the server sending more of the stream.  The buffer is dropped, so needn't wait for it to end.
+		s.agent.CloseStream(s.vbId, gocbcore.CloseStreamOptions{}, func(error) {})
+		return nil
+	}
+}
+
+// Pass on the buffered docs in pages
+func (s *dcpStream) processBuffered() error {
+
+	pageSize := s.app.PageSize
+	if pageSize <= 0 {
+		pageSize = len(s.buffered.DocIds)
+	}
+	for start := 0; start < len(s.buffered.DocIds); start += pageSize {
+		if s.stopped() {
+			return nil
+		}
+		end := start + pageSize
+		if end > len(s.buffered.DocIds) {
+			end = len(s.buffered.DocIds)
+		}
+		if err := s.docProcessor(s.buffered.DocIds[start:end], s.buffered.Docs[start:end]); err != nil {
+			return err
+		}
+		s.progress.add(end - start)
+	}
+	return nil
+}
+
+// Snapshots on disk are the backfill of docs that have left the server's memory
+func (s *dcpStream) SnapshotMarker(marker gocbcore.DcpSnapshotMarker) {
+	s.snapStart, s.snapEnd = marker.StartSeqNo, marker.EndSeqNo
+	s.snapshots++
+	if marker.SnapshotType.HasOnDisk() {
+		s.backfilled++
+	}
+}
+
+func (s *dcpStream) Mutation(mutation gocbcore.DcpMutation) {
+
+	s.lastSeqno = mutation.SeqNo
+	docId := string(mutation.Key)
+
+	var doc interface{}
+	if err := json.Unmarshal(mutation.Value, &doc); err != nil {
+		s.app.logf("Doc %v isn't JSON, skipping", docId)
+		s.skipped++
+		s.removeBuffered(docId)
+		return
+	}
+	if s.app.readCas != nil && s.bucket == s.app.SourceBucket {
+		s.app.readCas.record(docId, gocb.Cas(mutation.Cas))
+	}
+
+	// The latest mutation of a doc wins
+	if i, ok := s.bufferIndex[docId]; ok {
+		s.buffered.Docs[i] = doc
+		return
+	}
+	s.bufferIndex[docId] = len(s.buffered.DocIds)
+	s.buffered.DocIds = append(s.buffered.DocIds, docId)
+	s.buffered.Docs = append(s.buffered.Docs, doc)
+}
+
+func (s *dcpStream) Deletion(deletion gocbcore.DcpDeletion) {
+	s.lastSeqno = deletion.SeqNo
+	s.removeBuffered(string(deletion.Key))
+}
+
+func (s *dcpStream) Expiration(expiration gocbcore.DcpExpiration) {
+	s.lastSeqno = expiration.SeqNo
+	s.removeBuffered(string(expiration.Key))
+}
+
+// Sent instead of the changes to other collections, so a reopened stream starts after them
+func (s *dcpStream) SeqNoAdvanced(advanced gocbcore.DcpSeqNoAdvanced) {
+	s.lastSeqno = advanced.SeqNo
+}
+
+// Changes to the collections themselves, and out of order snapshots, which aren't asked for
+func (s *dcpStream) CreateCollection(gocbcore.DcpCollectionCreation)     {}
+func (s *dcpStream) DeleteCollection(gocbcore.DcpCollectionDeletion)     {}
+func (s *dcpStream) FlushCollection(gocbcore.DcpCollectionFlush)         {}
+func (s *dcpStream) CreateScope(gocbcore.DcpScopeCreation)               {}
+func (s *dcpStream) DeleteScope(gocbcore.DcpScopeDeletion)               {}
+func (s *dcpStream) ModifyCollection(gocbcore.DcpCollectionModification) {}
+func (s *dcpStream) OSOSnapshot(gocbcore.DcpOSOSnapshot)                 {}
+
+// Drop a buffered doc that was deleted, expired or overwritten with a non-JSON value
+func (s *dcpStream) removeBuffered(docId string) {
+	i, ok := s.bufferIndex[docId]
+	if !ok {
+		return
+	}
+	if s.app.readCas != nil && s.bucket == s.app.SourceBucket {
+		s.app.readCas.forget([]string{docId})
+	}
+	last := len(s.buffered.DocIds) - 1
+	if i != last {
+		s.buffered.DocIds[i], s.buffered.Docs[i] = s.buffered.DocIds[last], s.buffered.Docs[last]
+		s.bufferIndex[s.buffered.DocIds[i]] = i
+	}
+	s.buffered.DocIds, s.buffered.Docs = s.buffered.DocIds[:last], s.buffered.Docs[:last]
+	delete(s.bufferIndex, docId)
+}
+
+// The docs received before the stream ended early stay buffered, since it's reopened after the last of them
+func (s *dcpStream) End(end gocbcore.DcpStreamEnd, err error) {
+	if errors.Is(err, gocbcore.ErrDCPStreamClosed) {
+		err = nil
+	}
+	s.ended <- err
+}
+
+// Errors the server ends or refuses a stream with while the vbucket is moving, which reopening the stream recovers
+func isRestartableDcpError(err error) bool {
+	return errors.Is(err, gocbcore.ErrDCPStreamStateChanged) || errors.Is(err, gocbcore.ErrDCPStreamDisconnected) ||
+		errors.Is(err, gocbcore.ErrDCPStreamTooSlow) || errors.Is(err, gocbcore.ErrNotMyVBucket)
+}
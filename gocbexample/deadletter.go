@@ -0,0 +1,171 @@
+package gocbexample
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// Length of the truncated payload hashes in error contexts
+const payloadHashLength = 16
+
+// Structured context of a failed doc (or batch)
+type ErrorContext struct {
+	Phase       Phase  `json:"phase"`
+	DocId       string `json:"docId,omitempty"`
+	BatchId     string `json:"batchId,omitempty"`
+	Attempts    int    `json:"attempts,omitempty"`
+	PayloadHash string `json:"payloadHash,omitempty"`
+	Error       string `json:"error"`
+}
+
+func (c ErrorContext) String() string {
+	return fmt.Sprintf("phase=%q doc=%q batch=%v attempts=%v payload=%v error=%q", c.Phase, c.DocId, c.BatchId, c.Attempts, c.PayloadHash, c.Error)
+}
+
+// A line in the dead-letter file: the error context plus the doc that failed, so it can be fixed and replayed
+type DeadLetterEntry struct {
+	ErrorContext
+	Doc interface{} `json:"doc,omitempty"`
+}
+
+// Appends dead-letter entries to a JSON lines file.  The file isn't created until the first entry is written.
+type DeadLetterWriter struct {
+	Path string
+
+	mutex   sync.Mutex
+	file    *os.File
+	entries int
+}
+
+func NewDeadLetterWriter(path string) *DeadLetterWriter {
+	return &DeadLetterWriter{Path: path}
+}
+
+// Write the docs that fail to copy, and the docs the dead-letter error policy leaves out, to the file at path
+func WithDeadLetterFile(path string) Option {
+	return func(e *ExampleApp) error {
+		if path == "" {
+			return fmt.Errorf("Invalid dead-letter file: the path is empty")
+		}
+		e.DeadLetters = NewDeadLetterWriter(path)
+		return nil
+	}
+}
+
+func (w *DeadLetterWriter) Write(entry DeadLetterEntry) error {
+
+	entryBytes, err := json.Marshal(entry)
+	if err != nil {
+		// The doc itself may not be encodable, keep the context at least
+		entry.Doc = nil
+		if entryBytes, err = json.Marshal(entry); err != nil {
+			return err
+		}
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.file == nil {
+		file, err := os.OpenFile(w.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		w.file = file
+	}
+	if _, err := w.file.Write(append(entryBytes, '\n')); err != nil {
+		return err
+	}
+	w.entries += 1
+	return nil
+}
+
+// Number of entries written
+func (w *DeadLetterWriter) Entries() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.entries
+}
+
+func (w *DeadLetterWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// Truncated sha256 of a doc body
+func payloadHash(doc interface{}) string {
+	docBytes, err := json.Marshal(doc)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(docBytes)
+	return hex.EncodeToString(sum[:])[:payloadHashLength]
+}
+
+// Generate an id for the next batch of docs, eg "b42"
+func (e *ExampleApp) nextBatchId() string {
+	return fmt.Sprintf("b%v", atomic.AddInt64(&e.batchCounter, 1))
+}
+
+// Fill in the batch and payload context of a copy error, then log it and write the failed docs to the
+// dead-letter file.  If the error isn't specific to one doc, every doc in the batch is dead-lettered.
+// Errors that were already recorded are returned as is.
+func (e *ExampleApp) recordCopyError(err error, batchId string, docIds []string, docs []interface{}) error {
+
+	var docErr *DocError
+	if !errors.As(err, &docErr) {
+		docErr = &DocError{Err: err}
+		err = docErr
+	}
+	if docErr.BatchId != "" {
+		return err
+	}
+	docErr.BatchId = batchId
+
+	var failedIds []string
+	var failedDocs []interface{}
+	for i, docId := range docIds {
+		if docErr.DocId == "" || docErr.DocId == docId {
+			failedIds = append(failedIds, docId)
+			failedDocs = append(failedDocs, docs[i])
+		}
+	}
+	if docErr.DocId != "" && len(failedIds) == 1 && docErr.PayloadHash == "" {
+		docErr.PayloadHash = payloadHash(failedDocs[0])
+	}
+
+	e.logf("Copy error: %v", docErr.Context())
+
+	if e.DeadLetters == nil {
+		return err
+	}
+	if len(failedIds) == 0 {
+		// The failing doc isn't in the batch, eg its id was changed by a transform.  Keep the context at least.
+		if writeErr := e.DeadLetters.Write(DeadLetterEntry{ErrorContext: docErr.Context()}); writeErr != nil {
+			e.logf("Error writing dead-letter entry for doc id: %v.  Err: %v", docErr.DocId, writeErr)
+		}
+		return err
+	}
+	for i, docId := range failedIds {
+		context := docErr.Context()
+		context.DocId = docId
+		context.PayloadHash = payloadHash(failedDocs[i])
+		if writeErr := e.DeadLetters.Write(DeadLetterEntry{ErrorContext: context, Doc: failedDocs[i]}); writeErr != nil {
+			e.logf("Error writing dead-letter entry for doc id: %v.  Err: %v", docId, writeErr)
+		}
+	}
+
+	return err
+}
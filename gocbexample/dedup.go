@@ -0,0 +1,187 @@
+package gocbexample
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+)
+
+// A group of docs whose bodies hash to the same value
+type DuplicateCluster struct {
+	Hash string `json:"hash"`
+
+	// The first doc seen with this hash.  When skipping duplicates, this is the one that gets copied
+	CanonicalId string `json:"canonicalId"`
+
+	DuplicateIds []string `json:"duplicateIds"`
+}
+
+// Result of a duplicate-content analysis
+type DuplicateReport struct {
+	DocsSeen int                `json:"docsSeen"`
+	Clusters []DuplicateCluster `json:"clusters"`
+}
+
+// Hashes document bodies during iteration to find identical docs.  Near-identical docs can be
+// found by listing top-level fields (eg timestamps or embedded ids) in IgnoreFields, which are
+// removed before hashing.
+type DuplicateDetector struct {
+
+	// Top-level fields excluded from the hash
+	IgnoreFields []string
+
+	// When used as a pre-insert transform, drop docs whose body was already seen
+	SkipDuplicates bool
+
+	mutex    sync.Mutex
+	docsSeen int
+	clusters map[string]*DuplicateCluster
+}
+
+// Create a new DuplicateDetector
+func NewDuplicateDetector(skipDuplicates bool, ignoreFields ...string) *DuplicateDetector {
+	return &DuplicateDetector{
+		IgnoreFields:   ignoreFields,
+		SkipDuplicates: skipDuplicates,
+		clusters:       map[string]*DuplicateCluster{},
+	}
+}
+
+// Hash a doc body, minus the ignored fields
+func (d *DuplicateDetector) hashDoc(doc interface{}) (string, error) {
+
+	if docMap, ok := doc.(map[string]interface{}); ok && len(d.IgnoreFields) > 0 {
+		trimmed := make(map[string]interface{}, len(docMap))
+		for k, v := range docMap {
+			trimmed[k] = v
+		}
+		for _, field := range d.IgnoreFields {
+			delete(trimmed, field)
+		}
+		doc = trimmed
+	}
+
+	return hashComparableDoc(doc)
+}
+
+// Record a doc.  Returns true (and the id of the doc it duplicates) if the body was seen before.
+func (d *DuplicateDetector) Observe(docId string, doc interface{}) (canonicalId string, isDuplicate bool, err error) {
+
+	hash, err := d.hashDoc(doc)
+	if err != nil {
+		return "", false, fmt.Errorf("Error hashing doc with id: %v.  Err: %v", docId, err)
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.clusters == nil {
+		d.clusters = map[string]*DuplicateCluster{}
+	}
+	d.docsSeen += 1
+
+	cluster, ok := d.clusters[hash]
+	if !ok {
+		d.clusters[hash] = &DuplicateCluster{
+			Hash:        hash,
+			CanonicalId: docId,
+		}
+		return docId, false, nil
+	}
+	cluster.DuplicateIds = append(cluster.DuplicateIds, docId)
+	return cluster.CanonicalId, true, nil
+
+}
+
+// The pre-insert transform, suitable for adding to ExampleApp.Transforms.  Records every doc, and
+// if SkipDuplicates is set, removes docs whose body has already been copied.
+func (d *DuplicateDetector) Transform(input DocProcessorInput) (output DocProcessorInput, err error) {
+
+	output = DocProcessorInput{
+		DocIds: make([]string, 0, len(input.DocIds)),
+		Docs:   make([]interface{}, 0, len(input.Docs)),
+	}
+
+	for i, docId := range input.DocIds {
+		_, isDuplicate, err := d.Observe(docId, input.Docs[i])
+		if err != nil {
+			return output, err
+		}
+		if isDuplicate && d.SkipDuplicates {
+			continue
+		}
+		output.DocIds = append(output.DocIds, docId)
+		output.Docs = append(output.Docs, input.Docs[i])
+	}
+
+	return output, nil
+}
+
+// Get the clusters of docs that have at least one duplicate, largest first
+func (d *DuplicateDetector) Report() DuplicateReport {
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	report := DuplicateReport{
+		DocsSeen: d.docsSeen,
+		Clusters: []DuplicateCluster{},
+	}
+	for _, cluster := range d.clusters {
+		if len(cluster.DuplicateIds) == 0 {
+			continue
+		}
+		clusterCopy := *cluster
+		clusterCopy.DuplicateIds = append([]string{}, cluster.DuplicateIds...)
+		sort.Strings(clusterCopy.DuplicateIds)
+		report.Clusters = append(report.Clusters, clusterCopy)
+	}
+	sort.Slice(report.Clusters, func(i, j int) bool {
+		if len(report.Clusters[i].DuplicateIds) != len(report.Clusters[j].DuplicateIds) {
+			return len(report.Clusters[i].DuplicateIds) > len(report.Clusters[j].DuplicateIds)
+		}
+		return report.Clusters[i].CanonicalId < report.Clusters[j].CanonicalId
+	})
+	return report
+}
+
+// Write a JSON file mapping each canonical doc id to the ids of its duplicates
+func (d *DuplicateDetector) WriteMappingFile(path string) error {
+
+	mapping := map[string][]string{}
+	for _, cluster := range d.Report().Clusters {
+		mapping[cluster.CanonicalId] = cluster.DuplicateIds
+	}
+
+	mappingBytes, err := json.MarshalIndent(mapping, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, mappingBytes, 0644)
+}
+
+// Iterate over the source bucket hashing every doc body, without copying anything
+func (e *ExampleApp) AnalyzeDuplicates(detector *DuplicateDetector) (report DuplicateReport, err error) {
+
+	observeEachDoc := func(docIds []string, docs []interface{}) error {
+		for i, docId := range docIds {
+			if _, _, err := detector.Observe(docId, docs[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	e.startPhase("dedup")
+	if err := e.Walk(SourceBucketRole, WalkOptions{}, e.countProgress(observeEachDoc)); err != nil {
+		return report, err
+	}
+
+	report = detector.Report()
+	e.logf("Duplicate analysis: %v docs seen, %v clusters of duplicates", report.DocsSeen, len(report.Clusters))
+
+	return report, nil
+
+}
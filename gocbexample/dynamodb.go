@@ -0,0 +1,240 @@
+package gocbexample
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A Source that reads a DynamoDB export to S3 in the DynamoDB JSON format, once downloaded: either a data
+// file, gzipped or not, or a directory, whose *.json.gz and *.json files are read in name order (eg the
+// AWSDynamoDB/<export id>/data directory).  Each line holds an item of typed attribute values, which are
+// converted into plain JSON:
+//
+//	{"S": "a"} -> "a", {"N": "1.5"} -> 1.5, {"BOOL": true} -> true, {"NULL": true} -> null
+//	{"B": "<base64>"} -> "<base64>", {"M": {..}} -> {..}, {"L": [..]} -> [..]
+//	{"SS": [..]}, {"NS": [..]}, {"BS": [..]} -> arrays
+//
+// Doc ids are built from the converted attributes by KeyTemplate, eg "${pk}::${sk}" for a table with a
+// partition and a sort key.
+type DynamoDBSource struct {
+	Path        string
+	KeyTemplate *DocKeyTemplate
+
+	// Number of docs passed to the DocProcessor at once
+	BatchSize int
+}
+
+// The key template of a table's partition key, and its sort key if it has one, eg "${pk}::${sk}"
+func dynamoDBKeyTemplate(partitionKey, sortKey string) string {
+	if sortKey == "" {
+		return fmt.Sprintf("${%v}", partitionKey)
+	}
+	return fmt.Sprintf("${%v}::${%v}", partitionKey, sortKey)
+}
+
+func NewDynamoDBSource(path, keyTemplate string) (*DynamoDBSource, error) {
+	template, err := NewDocKeyTemplate(keyTemplate)
+	if err != nil {
+		return nil, err
+	}
+	return &DynamoDBSource{Path: path, KeyTemplate: template, BatchSize: pageSizeViewResult}, nil
+}
+
+func (s *DynamoDBSource) Name() string {
+	return fmt.Sprintf("dynamodb:%v", s.Path)
+}
+
+// The data files of the export
+func (s *DynamoDBSource) dataFiles() ([]string, error) {
+
+	info, err := os.Stat(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{s.Path}, nil
+	}
+
+	var files []string
+	for _, pattern := range []string{"*.json.gz", "*.json"} {
+		matches, err := filepath.Glob(filepath.Join(s.Path, pattern))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("Directory: %v has no *.json.gz or *.json DynamoDB export files.  Point to the data directory of the export", s.Path)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func (s *DynamoDBSource) ForEachDoc(docProcessor DocProcessor) error {
+
+	files, err := s.dataFiles()
+	if err != nil {
+		return err
+	}
+
+	batch := DocProcessorInput{}
+	flush := func() error {
+		if len(batch.DocIds) == 0 {
+			return nil
+		}
+		err := docProcessor(batch.DocIds, batch.Docs)
+		batch = DocProcessorInput{}
+		return err
+	}
+
+	for _, path := range files {
+		err := s.forEachItem(path, func(docId string, doc interface{}) error {
+			batch.DocIds = append(batch.DocIds, docId)
+			batch.Docs = append(batch.Docs, doc)
+			if len(batch.DocIds) >= s.BatchSize {
+				return flush()
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return flush()
+}
+
+// Call fn with each item of a data file, converted, and its doc id
+func (s *DynamoDBSource) forEachItem(path string, fn func(docId string, doc interface{}) error) error {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var reader io.Reader = bufio.NewReader(file)
+	if strings.HasSuffix(path, ".gz") {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return fmt.Errorf("Error reading gzipped file: %v.  Err: %v", path, err)
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+
+	decoder := json.NewDecoder(reader)
+	decoder.UseNumber()
+	for line := 1; ; line++ {
+		exported := struct {
+			Item map[string]interface{}
+		}{}
+		if err := decoder.Decode(&exported); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return newDocError(PhaseSourceRead, "", fmt.Errorf("Error reading item %v of file: %v.  Err: %w", line, path, err))
+		}
+		if exported.Item == nil {
+			return newDocError(PhaseSourceRead, "", fmt.Errorf("Item %v of file: %v has no Item.  Is it a DynamoDB JSON export?", line, path))
+		}
+
+		doc := make(map[string]interface{}, len(exported.Item))
+		for attribute, typed := range exported.Item {
+			val, err := fromDynamoDBValue(typed)
+			if err != nil {
+				return newDocError(PhaseSourceRead, "", fmt.Errorf("Error converting attribute %v of item %v of file: %v.  Err: %w", attribute, line, path, err))
+			}
+			doc[attribute] = val
+		}
+		docId, err := s.KeyTemplate.key(doc)
+		if err != nil {
+			return newDocError(PhaseSourceRead, "", fmt.Errorf("Error building the id of item %v of file: %v.  Err: %w", line, path, err))
+		}
+		if err := fn(docId, doc); err != nil {
+			return err
+		}
+	}
+}
+
+// Convert a typed DynamoDB attribute value, eg {"N": "1"}, into plain JSON
+func fromDynamoDBValue(typed interface{}) (interface{}, error) {
+
+	wrapper, ok := typed.(map[string]interface{})
+	if !ok || len(wrapper) != 1 {
+		return nil, fmt.Errorf("Not a typed attribute value: %v", typed)
+	}
+
+	for dataType, val := range wrapper {
+		switch dataType {
+		case "S", "B", "BOOL":
+			return val, nil
+
+		case "NULL":
+			return nil, nil
+
+		case "N":
+			number, ok := val.(string)
+			if !ok {
+				return nil, fmt.Errorf("N must be a string, got: %v", val)
+			}
+			return numberValue(number), nil
+
+		case "SS", "BS":
+			return val, nil
+
+		case "NS":
+			numbers, ok := val.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("NS must be an array, got: %v", val)
+			}
+			converted := make([]interface{}, len(numbers))
+			for i, number := range numbers {
+				s, ok := number.(string)
+				if !ok {
+					return nil, fmt.Errorf("NS must hold strings, got: %v", number)
+				}
+				converted[i] = numberValue(s)
+			}
+			return converted, nil
+
+		case "L":
+			list, ok := val.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("L must be an array, got: %v", val)
+			}
+			converted := make([]interface{}, len(list))
+			for i, elem := range list {
+				convertedElem, err := fromDynamoDBValue(elem)
+				if err != nil {
+					return nil, fmt.Errorf("[%v]: %v", i, err)
+				}
+				converted[i] = convertedElem
+			}
+			return converted, nil
+
+		case "M":
+			attributes, ok := val.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("M must be an object, got: %v", val)
+			}
+			converted := make(map[string]interface{}, len(attributes))
+			for attribute, attributeVal := range attributes {
+				convertedVal, err := fromDynamoDBValue(attributeVal)
+				if err != nil {
+					return nil, fmt.Errorf("%v: %v", attribute, err)
+				}
+				converted[attribute] = convertedVal
+			}
+			return converted, nil
+		}
+		return nil, fmt.Errorf("Unknown DynamoDB data type: %v", dataType)
+	}
+	return nil, nil
+}
@@ -0,0 +1,112 @@
+package gocbexample
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Write the data directory of an export, gzipping the files named *.gz
+func writeDynamoDBExport(t *testing.T, files map[string]string) string {
+
+	dir := t.TempDir()
+	for name, items := range files {
+		file, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasSuffix(name, ".gz") {
+			gzipWriter := gzip.NewWriter(file)
+			_, err = gzipWriter.Write([]byte(items))
+			if err == nil {
+				err = gzipWriter.Close()
+			}
+		} else {
+			_, err = file.Write([]byte(items))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := file.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestDynamoDBSourceForEachDoc(t *testing.T) {
+
+	dir := writeDynamoDBExport(t, map[string]string{
+		"b.json.gz": `{"Item": {"pk": {"S": "user#2"}, "sk": {"N": "1"}, "tags": {"SS": ["x", "y"]}}}` + "\n",
+		"a.json.gz": `{"Item": {"pk": {"S": "user#1"}, "sk": {"N": "7"}, "geo": {"M": {"lat": {"N": "1.5"}, "exact": {"BOOL": false}}}}}` + "\n" +
+			`{"Item": {"pk": {"S": "user#1"}, "sk": {"N": "8"}, "scores": {"NS": ["1", "12345678901234567890"]}, "note": {"NULL": true}}}` + "\n",
+		"c.json":               `{"Item": {"pk": {"S": "user#3"}, "sk": {"N": "1"}, "log": {"L": [{"S": "a"}, {"N": "2"}]}}}` + "\n",
+		"manifest-summary.md5": "not an export file",
+	})
+
+	source, err := NewDynamoDBSource(dir, dynamoDBKeyTemplate("pk", "sk"))
+	if err != nil {
+		t.Fatalf("NewDynamoDBSource failed: %v", err)
+	}
+	docs := map[string]interface{}{}
+	var docIds []string
+	err = source.ForEachDoc(func(batchIds []string, batchDocs []interface{}) error {
+		for i, docId := range batchIds {
+			docIds = append(docIds, docId)
+			docs[docId] = batchDocs[i]
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ForEachDoc failed: %v", err)
+	}
+
+	// The data files are read in name order
+	wantIds := []string{"user#1::7", "user#1::8", "user#2::1", "user#3::1"}
+	if !reflect.DeepEqual(docIds, wantIds) {
+		t.Errorf("Doc ids = %v, want %v", docIds, wantIds)
+	}
+	wantDocs := map[string]interface{}{
+		"user#1::7": map[string]interface{}{"pk": "user#1", "sk": 7.0, "geo": map[string]interface{}{"lat": 1.5, "exact": false}},
+		"user#1::8": map[string]interface{}{"pk": "user#1", "sk": 8.0, "scores": []interface{}{1.0, json.Number("12345678901234567890")}, "note": nil},
+		"user#2::1": map[string]interface{}{"pk": "user#2", "sk": 1.0, "tags": []interface{}{"x", "y"}},
+		"user#3::1": map[string]interface{}{"pk": "user#3", "sk": 1.0, "log": []interface{}{"a", 2.0}},
+	}
+	if !reflect.DeepEqual(docs, wantDocs) {
+		t.Errorf("Docs = %v, want %v", docs, wantDocs)
+	}
+}
+
+func TestDynamoDBSourceErrors(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		items string
+		want  string
+	}{
+		{name: "not an export", items: `{"pk": {"S": "a"}}`, want: "has no Item"},
+		{name: "untyped value", items: `{"Item": {"pk": {"S": "a"}, "n": 1}}`, want: "attribute n"},
+		{name: "number not a string", items: `{"Item": {"pk": {"N": 1}}}`, want: "attribute pk"},
+		{name: "unknown type", items: `{"Item": {"pk": {"S": "a"}, "x": {"L": [{"X": "a"}]}}}`, want: "Unknown DynamoDB data type: X"},
+		{name: "no partition key", items: `{"Item": {"id": {"S": "a"}}}`, want: "id of item 1"},
+	}
+	for _, test := range tests {
+		dir := writeDynamoDBExport(t, map[string]string{"data.json": test.items + "\n"})
+		source, err := NewDynamoDBSource(dir, dynamoDBKeyTemplate("pk", ""))
+		if err != nil {
+			t.Fatalf("NewDynamoDBSource failed: %v", err)
+		}
+		err = source.ForEachDoc(func([]string, []interface{}) error { return nil })
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%v: ForEachDoc = %v, want an error containing %q", test.name, err, test.want)
+		}
+	}
+
+	if _, err := (&DynamoDBSource{Path: t.TempDir()}).dataFiles(); err == nil {
+		t.Errorf("dataFiles of a directory without data files succeeded, want an error")
+	}
+}
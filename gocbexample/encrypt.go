@@ -0,0 +1,422 @@
+package gocbexample
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Prefix of encrypted fields, as used by Couchbase field-level encryption: "ssn" becomes "encrypted$ssn"
+const defaultEncryptedFieldPrefix = "encrypted$"
+
+// Environment variable the encryption key is read from, unless configured otherwise
+const defaultEncryptionKeyEnv = "ENCRYPTION_KEY"
+
+// The "alg" recorded in encrypted fields: the algorithm of Couchbase field-level encryption
+const encryptionAlgorithm = "AEAD_AES_256_CBC_HMAC_SHA512"
+
+// Which fields to encrypt and with which key
+type EncryptionConfig struct {
+
+	// JSONPaths of the fields to encrypt, eg ["$.email", "$.reviews[*].author"].  Each must end in a field name
+	Paths []string `json:"paths,omitempty"`
+
+	// Where the key is read from: a file, or else an environment variable (ENCRYPTION_KEY by default).
+	// The key is 64 bytes, base64 encoded, as for Couchbase field-level encryption.  It is never logged or
+	// written to reports.
+	KeyFile string `json:"keyFile,omitempty"`
+	KeyEnv  string `json:"keyEnv,omitempty"`
+
+	// Recorded as the "kid" of encrypted fields, so the right key can be found when decrypting.  Defaults to "default"
+	KeyId string `json:"keyId,omitempty"`
+
+	// Prefix added to the names of encrypted fields.  Defaults to "encrypted$"
+	FieldPrefix string `json:"fieldPrefix,omitempty"`
+
+	// Encrypt without binding each value to its doc id and field, as Couchbase SDKs do, so that they can decrypt
+	// it.  Otherwise a value only decrypts where it was encrypted, not if it's moved to another field or doc.
+	SdkCompatible bool `json:"sdkCompatible,omitempty"`
+}
+
+// An encrypted field value, in the layout used by Couchbase field-level encryption
+type encryptedField struct {
+	Alg        string `json:"alg"`
+	Kid        string `json:"kid"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// A pre-insert stage that encrypts the configured fields rather than destroying them, so whoever holds the
+// key can recover the original values.  The decrypt command reverses it.
+type FieldEncryptor struct {
+	config  EncryptionConfig
+	paths   []JSONPath
+	cipher  *aesCbcHmacSha512
+	decrypt bool
+
+	mutex     sync.Mutex
+	encrypted int
+	decrypted int
+	pathHits  []bool
+}
+
+// Summary of what a FieldEncryptor did
+type EncryptionReport struct {
+	// Number of field values encrypted / decrypted
+	Encrypted int
+	Decrypted int
+}
+
+func NewFieldEncryptor(config EncryptionConfig) (*FieldEncryptor, error) {
+
+	if config.KeyId == "" {
+		config.KeyId = "default"
+	}
+	if config.FieldPrefix == "" {
+		config.FieldPrefix = defaultEncryptedFieldPrefix
+	}
+
+	paths, err := ParseJSONPaths(config.Paths)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		last := path.segments[len(path.segments)-1]
+		if last.isIndex || last.wildcard {
+			return nil, fmt.Errorf("Invalid encryption path: %v.  It must end in a field name", path)
+		}
+	}
+
+	key, err := config.loadKey()
+	if err != nil {
+		return nil, err
+	}
+	fieldCipher, err := newAesCbcHmacSha512(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FieldEncryptor{config: config, paths: paths, cipher: fieldCipher, pathHits: make([]bool, len(paths))}, nil
+}
+
+// Load the key from KeyFile if set, otherwise from the KeyEnv environment variable
+func (c EncryptionConfig) loadKey() (secret, error) {
+
+	var encoded string
+	if c.KeyFile != "" {
+		keyBytes, err := ioutil.ReadFile(c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading encryption key file: %v.  Err: %v", c.KeyFile, err)
+		}
+		encoded = string(keyBytes)
+	} else {
+		env := c.KeyEnv
+		if env == "" {
+			env = defaultEncryptionKeyEnv
+		}
+		encoded = os.Getenv(env)
+		if encoded == "" {
+			return nil, fmt.Errorf("No encryption key.  Set keyFile or the %v environment variable", env)
+		}
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("Encryption key is not valid base64")
+	}
+	if len(key) != aesCbcHmacSha512KeySize {
+		return nil, fmt.Errorf("Encryption key is %v bytes, %v needs %v", len(key), encryptionAlgorithm, aesCbcHmacSha512KeySize)
+	}
+	return secret(key), nil
+}
+
+// Decrypt fields encrypted with the same key rather than encrypting, for the decrypt command
+func (f *FieldEncryptor) SetDecrypt(decrypt bool) {
+	f.decrypt = decrypt
+}
+
+func (f *FieldEncryptor) Transform(input DocProcessorInput) (output DocProcessorInput, err error) {
+
+	output = DocProcessorInput{
+		DocIds: make([]string, 0, len(input.DocIds)),
+		Docs:   make([]interface{}, 0, len(input.Docs)),
+	}
+	count := 0
+	pathHits := make([]bool, len(f.paths))
+	var failed DocErrors
+	for i, doc := range input.Docs {
+		// Counted per doc, so that the fields of a doc that fails aren't counted in the report
+		docCount := 0
+		docPathHits := make([]bool, len(f.paths))
+		var body interface{}
+		if f.decrypt {
+			body, err = f.decryptFields(input.DocIds[i], doc, "$", &docCount)
+		} else {
+			body, err = f.encryptFields(input.DocIds[i], doc, docPathHits, &docCount)
+		}
+		if err != nil {
+			failed = failed.add(input.DocIds[i], doc, fmt.Errorf("Error processing encrypted fields.  Err: %v", err))
+			continue
+		}
+		output.DocIds = append(output.DocIds, input.DocIds[i])
+		output.Docs = append(output.Docs, body)
+		count += docCount
+		for j, hit := range docPathHits {
+			pathHits[j] = pathHits[j] || hit
+		}
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.decrypt {
+		f.decrypted += count
+	} else {
+		f.encrypted += count
+	}
+	for i, hit := range pathHits {
+		f.pathHits[i] = f.pathHits[i] || hit
+	}
+
+	return output, failed.err()
+}
+
+// Replace each configured field with a prefixed field holding its encrypted value
+func (f *FieldEncryptor) encryptFields(docId string, doc interface{}, pathHits []bool, count *int) (interface{}, error) {
+
+	var err error
+	for i, path := range f.paths {
+		field := path.segments[len(path.segments)-1].field
+		parent := JSONPath{raw: path.raw, segments: path.segments[:len(path.segments)-1]}
+		doc = parent.UpdateAt(doc, func(location string, val interface{}) interface{} {
+			obj, ok := val.(map[string]interface{})
+			if !ok {
+				return val
+			}
+			plain, ok := obj[field]
+			if !ok || err != nil {
+				return val
+			}
+			encrypted, encryptErr := f.encryptValue(plain, f.associatedData(docId, fieldLocation(location, field)))
+			if encryptErr != nil {
+				err = encryptErr
+				return val
+			}
+			result := make(map[string]interface{}, len(obj))
+			for key, child := range obj {
+				if key != field {
+					result[key] = child
+				}
+			}
+			result[f.config.FieldPrefix+field] = encrypted
+			pathHits[i] = true
+			*count += 1
+			return result
+		})
+	}
+	return doc, err
+}
+
+// The associated data a field's value is encrypted with: its doc id and location, so that it doesn't decrypt if
+// it's moved.  Empty if SDK compatible, since Couchbase SDKs don't pass any.
+func (f *FieldEncryptor) associatedData(docId, location string) []byte {
+	if f.config.SdkCompatible {
+		return nil
+	}
+	return []byte(docId + "\x00" + location)
+}
+
+func (f *FieldEncryptor) encryptValue(val interface{}, associatedData []byte) (encryptedField, error) {
+
+	plaintext, err := json.Marshal(val)
+	if err != nil {
+		return encryptedField{}, err
+	}
+	ciphertext, err := f.cipher.seal(plaintext, associatedData)
+	if err != nil {
+		return encryptedField{}, err
+	}
+	return encryptedField{
+		Alg:        encryptionAlgorithm,
+		Kid:        f.config.KeyId,
+		Ciphertext: base64.StdEncoding.EncodeToString(ciphertext),
+	}, nil
+}
+
+// Replace every prefixed field anywhere in the doc with a field holding its decrypted value.  location is where
+// val is in the doc.
+func (f *FieldEncryptor) decryptFields(docId string, val interface{}, location string, count *int) (interface{}, error) {
+
+	switch v := val.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			if strings.HasPrefix(key, f.config.FieldPrefix) {
+				if encrypted, ok := asEncryptedField(child); ok {
+					field := strings.TrimPrefix(key, f.config.FieldPrefix)
+					plain, err := f.decryptValue(encrypted, f.associatedData(docId, fieldLocation(location, field)))
+					if err != nil {
+						return nil, fmt.Errorf("Error decrypting field: %v.  Err: %v", key, err)
+					}
+					result[field] = plain
+					*count += 1
+					continue
+				}
+			}
+			decrypted, err := f.decryptFields(docId, child, fieldLocation(location, key), count)
+			if err != nil {
+				return nil, err
+			}
+			result[key] = decrypted
+		}
+		return result, nil
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, child := range v {
+			decrypted, err := f.decryptFields(docId, child, indexLocation(location, i), count)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = decrypted
+		}
+		return result, nil
+	default:
+		return val, nil
+	}
+}
+
+func asEncryptedField(val interface{}) (encryptedField, bool) {
+	obj, ok := val.(map[string]interface{})
+	if !ok || len(obj) != 3 {
+		return encryptedField{}, false
+	}
+	alg, _ := obj["alg"].(string)
+	kid, _ := obj["kid"].(string)
+	ciphertext, ok := obj["ciphertext"].(string)
+	return encryptedField{Alg: alg, Kid: kid, Ciphertext: ciphertext}, ok && alg != ""
+}
+
+func (f *FieldEncryptor) decryptValue(encrypted encryptedField, associatedData []byte) (interface{}, error) {
+
+	if encrypted.Alg != encryptionAlgorithm {
+		return nil, fmt.Errorf("Unsupported algorithm: %v", encrypted.Alg)
+	}
+	if encrypted.Kid != f.config.KeyId {
+		return nil, fmt.Errorf("Encrypted with key: %v, but the configured key is: %v", encrypted.Kid, f.config.KeyId)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(encrypted.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := f.cipher.open(ciphertext, associatedData)
+	if err != nil {
+		return nil, err
+	}
+
+	var val interface{}
+	if err := json.Unmarshal(plaintext, &val); err != nil {
+		return nil, err
+	}
+	return val, nil
+}
+
+func (f *FieldEncryptor) Report() EncryptionReport {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return EncryptionReport{Encrypted: f.encrypted, Decrypted: f.decrypted}
+}
+
+// The paths that never matched a field.  Only meaningful after encrypting.
+func (f *FieldEncryptor) UnusedRules() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.decrypt {
+		return nil
+	}
+	var unused []string
+	for i, hit := range f.pathHits {
+		if !hit {
+			unused = append(unused, fmt.Sprintf("encryption.paths[%v] %v", i, f.paths[i]))
+		}
+	}
+	return unused
+}
+
+// Size of the key of AEAD_AES_256_CBC_HMAC_SHA512: the MAC key followed by the encryption key
+const aesCbcHmacSha512KeySize = 64
+
+// Bytes of the HMAC-SHA512 tag kept
+const aesCbcHmacSha512TagSize = 32
+
+// AEAD_AES_256_CBC_HMAC_SHA512 (draft-mcgrew-aead-aes-cbc-hmac-sha2), as used by Couchbase field-level
+// encryption: AES-256-CBC with PKCS #7 padding and a random IV, followed by an HMAC-SHA512 tag, truncated to 32
+// bytes, over the associated data, the IV and ciphertext, and the length of the associated data in bits
+type aesCbcHmacSha512 struct {
+	macKey []byte
+	block  cipher.Block
+}
+
+func newAesCbcHmacSha512(key []byte) (*aesCbcHmacSha512, error) {
+	if len(key) != aesCbcHmacSha512KeySize {
+		return nil, fmt.Errorf("Invalid encryption key.  Err: %v needs %v bytes, not %v", encryptionAlgorithm, aesCbcHmacSha512KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key[32:])
+	if err != nil {
+		return nil, fmt.Errorf("Invalid encryption key.  Err: %v", err)
+	}
+	return &aesCbcHmacSha512{macKey: append([]byte{}, key[:32]...), block: block}, nil
+}
+
+// Encrypt the plaintext, returning the IV, ciphertext and tag
+func (c *aesCbcHmacSha512) seal(plaintext, associatedData []byte) ([]byte, error) {
+
+	padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+	padded := append(append([]byte{}, plaintext...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+
+	sealed := make([]byte, aes.BlockSize+len(padded), aes.BlockSize+len(padded)+aesCbcHmacSha512TagSize)
+	iv := sealed[:aes.BlockSize]
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	cipher.NewCBCEncrypter(c.block, iv).CryptBlocks(sealed[aes.BlockSize:], padded)
+	return append(sealed, c.tag(associatedData, sealed)...), nil
+}
+
+// Check the tag and decrypt the ciphertext
+func (c *aesCbcHmacSha512) open(sealed, associatedData []byte) ([]byte, error) {
+
+	if len(sealed) < 2*aes.BlockSize+aesCbcHmacSha512TagSize || (len(sealed)-aesCbcHmacSha512TagSize)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("Ciphertext is not a valid length")
+	}
+	ciphertext, tag := sealed[:len(sealed)-aesCbcHmacSha512TagSize], sealed[len(sealed)-aesCbcHmacSha512TagSize:]
+	if !hmac.Equal(tag, c.tag(associatedData, ciphertext)) {
+		return nil, fmt.Errorf("Authentication failed: wrong key, or the value was changed or moved")
+	}
+
+	plaintext := make([]byte, len(ciphertext)-aes.BlockSize)
+	cipher.NewCBCDecrypter(c.block, ciphertext[:aes.BlockSize]).CryptBlocks(plaintext, ciphertext[aes.BlockSize:])
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > aes.BlockSize {
+		return nil, fmt.Errorf("Invalid padding")
+	}
+	return plaintext[:len(plaintext)-padding], nil
+}
+
+func (c *aesCbcHmacSha512) tag(associatedData, ciphertext []byte) []byte {
+	mac := hmac.New(sha512.New, c.macKey)
+	mac.Write(associatedData)
+	mac.Write(ciphertext)
+	associatedDataBits := make([]byte, 8)
+	binary.BigEndian.PutUint64(associatedDataBits, uint64(len(associatedData))*8)
+	mac.Write(associatedDataBits)
+	return mac.Sum(nil)[:aesCbcHmacSha512TagSize]
+}
@@ -0,0 +1,103 @@
+package gocbexample
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newTestEncryptor(t *testing.T, key string, sdkCompatible bool) *FieldEncryptor {
+	t.Setenv("TEST_ENCRYPTION_KEY", base64.StdEncoding.EncodeToString([]byte(key)))
+	encryptor, err := NewFieldEncryptor(EncryptionConfig{
+		Paths:         []string{"$.email", "$.reviews[*].author"},
+		KeyEnv:        "TEST_ENCRYPTION_KEY",
+		SdkCompatible: sdkCompatible,
+	})
+	if err != nil {
+		t.Fatalf("NewFieldEncryptor() = %v", err)
+	}
+	return encryptor
+}
+
+// Encrypt the docs and read them back as JSON, as they would be from the bucket
+func encryptTestDocs(t *testing.T, encryptor *FieldEncryptor, docIds []string, docs []interface{}) DocProcessorInput {
+	encrypted, err := encryptor.Transform(DocProcessorInput{DocIds: docIds, Docs: docs})
+	if err != nil {
+		t.Fatalf("Encrypting = %v", err)
+	}
+	encoded, _ := json.Marshal(encrypted.Docs)
+	var readDocs []interface{}
+	mustUnmarshal(t, string(encoded), &readDocs)
+	return DocProcessorInput{DocIds: encrypted.DocIds, Docs: readDocs}
+}
+
+func TestFieldEncryptorRoundTrip(t *testing.T) {
+
+	key := strings.Repeat("k", aesCbcHmacSha512KeySize)
+	encryptor := newTestEncryptor(t, key, false)
+
+	var doc, want map[string]interface{}
+	mustUnmarshal(t, `{"email": "a@b.com", "reviews": [{"author": "ann"}, {"author": {"first": "bob"}}], "name": "x"}`, &doc)
+	mustUnmarshal(t, `{"email": "a@b.com", "reviews": [{"author": "ann"}, {"author": {"first": "bob"}}], "name": "x"}`, &want)
+
+	encrypted := encryptTestDocs(t, encryptor, []string{"user_1"}, []interface{}{doc})
+	encryptedDoc, _ := json.Marshal(encrypted.Docs[0])
+	if strings.Contains(string(encryptedDoc), "a@b.com") || strings.Contains(string(encryptedDoc), "ann") {
+		t.Errorf("Encrypted doc has plaintext: %s", encryptedDoc)
+	}
+	if !strings.Contains(string(encryptedDoc), `"encrypted$email":{"alg":"AEAD_AES_256_CBC_HMAC_SHA512"`) {
+		t.Errorf("Encrypted doc isn't in the field-level encryption layout: %s", encryptedDoc)
+	}
+
+	decryptor := newTestEncryptor(t, key, false)
+	decryptor.SetDecrypt(true)
+	decrypted, err := decryptor.Transform(encrypted)
+	if err != nil {
+		t.Fatalf("Decrypting = %v", err)
+	}
+	if !reflect.DeepEqual(decrypted.Docs[0], interface{}(want)) {
+		t.Errorf("Decrypted doc = %v, want %v", decrypted.Docs[0], want)
+	}
+	if report := decryptor.Report(); report.Decrypted != 3 {
+		t.Errorf("Decrypted %v fields, want 3", report.Decrypted)
+	}
+}
+
+func TestFieldEncryptorRejects(t *testing.T) {
+
+	key := strings.Repeat("k", aesCbcHmacSha512KeySize)
+	encryptor := newTestEncryptor(t, key, false)
+	var doc map[string]interface{}
+	mustUnmarshal(t, `{"email": "a@b.com"}`, &doc)
+	encrypted := encryptTestDocs(t, encryptor, []string{"user_1"}, []interface{}{doc})
+	encryptedField := encrypted.Docs[0].(map[string]interface{})["encrypted$email"]
+
+	tests := []struct {
+		name  string
+		key   string
+		docId string
+		doc   map[string]interface{}
+	}{
+		{name: "wrong key", key: strings.Repeat("x", aesCbcHmacSha512KeySize), docId: "user_1", doc: map[string]interface{}{"encrypted$email": encryptedField}},
+		{name: "other doc", key: key, docId: "user_2", doc: map[string]interface{}{"encrypted$email": encryptedField}},
+		{name: "other field", key: key, docId: "user_1", doc: map[string]interface{}{"encrypted$phone": encryptedField}},
+	}
+	for _, test := range tests {
+		decryptor := newTestEncryptor(t, test.key, false)
+		decryptor.SetDecrypt(true)
+		if _, err := decryptor.Transform(DocProcessorInput{DocIds: []string{test.docId}, Docs: []interface{}{test.doc}}); err == nil {
+			t.Errorf("%v: decrypting succeeded, want an error", test.name)
+		}
+	}
+
+	// Without the doc id and field bound in, as Couchbase SDKs encrypt, a moved value still decrypts
+	sdkEncryptor := newTestEncryptor(t, key, true)
+	encrypted = encryptTestDocs(t, sdkEncryptor, []string{"user_1"}, []interface{}{doc})
+	sdkDecryptor := newTestEncryptor(t, key, true)
+	sdkDecryptor.SetDecrypt(true)
+	if _, err := sdkDecryptor.Transform(DocProcessorInput{DocIds: []string{"user_2"}, Docs: encrypted.Docs}); err != nil {
+		t.Errorf("Decrypting SDK compatible value in another doc = %v", err)
+	}
+}
@@ -0,0 +1,186 @@
+package gocbexample
+
+import (
+	"fmt"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// Where a copy reads docs from: a bucket, a file, a stream ..
+type Source interface {
+
+	// Describes the source in logs and reports
+	Name() string
+
+	// Call docProcessor with each batch of docs.  docProcessor may be called from multiple goroutines.
+	ForEachDoc(docProcessor DocProcessor) error
+}
+
+// Where a copy writes docs to
+type Sink interface {
+
+	// Describes the sink in logs and reports
+	Name() string
+
+	// Write a batch of docs.  May be called from multiple goroutines.
+	WriteDocs(docIds []string, docs []interface{}) error
+}
+
+// The outcome of writing a doc
+type WriteResult struct {
+	DocId string
+
+	// CAS of the written doc.  0 if the write failed, or the sink doesn't have CAS values
+	Cas gocb.Cas
+
+	// Unix time the doc was written to expire at, which later subdoc mutations of it must pass on to keep.  0 if
+	// the doc doesn't expire
+	Expiry uint32
+
+	// Why the write failed, or nil
+	Err error
+}
+
+// A Sink that reports the outcome of each write
+type ResultSink interface {
+	Sink
+
+	// Write a batch of docs, returning a result per doc (in the order of docIds) along with the error of
+	// the first failed write
+	WriteDocsWithResults(docIds []string, docs []interface{}) ([]WriteResult, error)
+}
+
+// Write docs to the sink, returning a result per doc.  Results of sinks that don't report per doc
+// outcomes share the batch error.
+func writeDocsWithResults(sink Sink, docIds []string, docs []interface{}) ([]WriteResult, error) {
+
+	if resultSink, ok := sink.(ResultSink); ok {
+		return resultSink.WriteDocsWithResults(docIds, docs)
+	}
+
+	err := sink.WriteDocs(docIds, docs)
+	results := make([]WriteResult, len(docIds))
+	for i, docId := range docIds {
+		results[i] = WriteResult{DocId: docId, Err: err}
+	}
+	return results, err
+}
+
+// A Source that iterates a bucket via N1QL or views, depending on how the ExampleApp is configured
+type BucketSource struct {
+	app    *ExampleApp
+	Bucket *gocb.Bucket
+}
+
+func (e *ExampleApp) NewBucketSource(bucket *gocb.Bucket) *BucketSource {
+	return &BucketSource{app: e, Bucket: bucket}
+}
+
+func (s *BucketSource) Name() string {
+	return fmt.Sprintf("bucket:%v", s.app.keyspaceName(s.Bucket))
+}
+
+func (s *BucketSource) ForEachDoc(docProcessor DocProcessor) error {
+	return s.app.forEachDocIdBucket(docProcessor, s.Bucket)
+}
+
+// A Sink that inserts docs into the target collection of a bucket.  Fails if a doc already exists.
+type BucketSink struct {
+	app    *ExampleApp
+	Bucket *gocb.Bucket
+
+	// How inserts that fail with a temporary error are retried
+	RetryPolicy RetryPolicy
+}
+
+func (e *ExampleApp) NewBucketSink(bucket *gocb.Bucket) *BucketSink {
+	return &BucketSink{app: e, Bucket: bucket, RetryPolicy: e.RetryPolicy}
+}
+
+func (s *BucketSink) Name() string {
+	return fmt.Sprintf("bucket:%v", s.app.keyspaceName(s.Bucket))
+}
+
+func (s *BucketSink) WriteDocs(docIds []string, docs []interface{}) error {
+	_, err := s.WriteDocsWithResults(docIds, docs)
+	return err
+}
+
+func (s *BucketSink) WriteDocsWithResults(docIds []string, docs []interface{}) (results []WriteResult, err error) {
+
+	results = make([]WriteResult, len(docIds))
+	collection, err := s.app.writeCollection(s.Bucket, "Writing to "+s.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(docIds) {
+	case 0:
+		return results, nil
+
+	case 1:
+
+		// Insert the doc into the target bucket
+		var cas gocb.Cas
+		attempts, err := s.RetryPolicy.do(s.app.logf, "insert of doc id: "+docIds[0], isRetryableWriteError, func() (err error) {
+			result, err := collection.Insert(docIds[0], docs[0], nil)
+			if err == nil {
+				cas = result.Cas()
+			}
+			return err
+		})
+		if err != nil {
+			err = withAttempts(newDocError(PhaseTargetWrite, docIds[0], err), attempts)
+		}
+		results[0] = WriteResult{DocId: docIds[0], Cas: cas, Err: err}
+		return results, err
+
+	default:
+
+		// copy docs via bulk ops
+		var items []gocb.BulkOp
+
+		for i, docId := range docIds {
+			item := &gocb.InsertOp{
+				ID:    docId,
+				Value: docs[i],
+			}
+			items = append(items, item)
+		}
+
+		// Do the underlying bulk operation
+		attempts, err := s.RetryPolicy.doBulk(s.app.logf, collection, s.app.bulkOpOptions(s.Bucket), items, isRetryableWriteError)
+		if err != nil {
+			err = newDocError(PhaseTargetWrite, "", err)
+			for i, docId := range docIds {
+				results[i] = WriteResult{DocId: docId, Err: err}
+			}
+			return results, err
+		}
+
+		// Collect the outcome of each op, failing with the first error
+		var firstErr error
+		for i, item := range items {
+			insertItem := item.(*gocb.InsertOp)
+			results[i] = WriteResult{DocId: insertItem.ID}
+			if insertItem.Result != nil {
+				results[i].Cas = insertItem.Result.Cas()
+			}
+			if insertItem.Err != nil {
+				results[i].Err = withAttempts(newDocError(PhaseTargetWrite, insertItem.ID, insertItem.Err), attempts[item])
+				if firstErr == nil {
+					firstErr = results[i].Err
+				}
+			}
+		}
+		return results, firstErr
+
+	}
+
+}
+
+// Is the sink the target bucket?  Checks that read back from the target (eg write sampling) only make sense then.
+func (e *ExampleApp) sinkIsTargetBucket() bool {
+	bucketSink, ok := e.Sink.(*BucketSink)
+	return ok && bucketSink.Bucket == e.TargetBucket
+}
@@ -0,0 +1,75 @@
+package gocbexample
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Pick the engine from the services the cluster runs, when connecting
+const EngineAuto Engine = "auto"
+
+// Iterate buckets with the given engine: EngineViews, EngineN1ql, EngineDCP, or EngineAuto to pick one from the
+// cluster's services once connected
+func WithEngine(engine Engine) Option {
+	return func(e *ExampleApp) error {
+		switch engine {
+		case EngineViews, EngineN1ql, EngineDCP, EngineAuto:
+		default:
+			return fmt.Errorf("Unknown engine: %v.  Expected one of: %v, %v, %v, %v", engine, EngineAuto, EngineViews, EngineN1ql, EngineDCP)
+		}
+		e.Engine = engine
+		e.UseN1ql = engine == EngineN1ql
+		return nil
+	}
+}
+
+// The nodes running a service, eg "n1ql"
+func (info *ClusterInfo) nodesWithService(service string) []string {
+	var nodes []string
+	for _, node := range info.Nodes {
+		for _, nodeService := range node.Services {
+			if nodeService == service {
+				nodes = append(nodes, node.Hostname)
+				break
+			}
+		}
+	}
+	return nodes
+}
+
+// Pick the engine for EngineAuto, once the cluster's services are known, and log why.  Settings that only apply to
+// one engine decide it; otherwise N1QL is picked if the cluster runs the query and index services, since views are
+// deprecated and building a view of a large bucket is slow, and views if it doesn't.  Analytics is only used when
+// analyticsDataset is set, and DCP only when asked for, since it reads every doc of the bucket from the data
+// service, competing with the application's own reads.
+func (e *ExampleApp) selectEngine() {
+
+	if e.Engine != EngineAuto {
+		return
+	}
+
+	engine, reason := EngineViews, ""
+	switch {
+	case len(e.QueryNodes) > 0 || e.SpreadQueries || e.MaxConcurrentQueries > 0 || e.N1qlPageSize > 0:
+		engine, reason = EngineN1ql, "query node or N1QL paging options are set"
+	case len(e.Collections) > 0 || len(e.CollectionMap) > 0:
+		engine, reason = EngineN1ql, "collections are copied, which views don't index"
+	case e.ViewQueryRanges > 1 || e.DevelopmentViews || e.OverwriteDesignDoc:
+		reason = "view options are set"
+	case e.Capabilities == nil:
+		reason = "the cluster's services couldn't be detected"
+	default:
+		queryNodes := e.Capabilities.nodesWithService("n1ql")
+		indexNodes := e.Capabilities.nodesWithService("index")
+		if len(queryNodes) > 0 && len(indexNodes) > 0 {
+			engine = EngineN1ql
+			reason = fmt.Sprintf("the cluster runs the query service (on %v) and index service (on %v)",
+				strings.Join(queryNodes, ", "), strings.Join(indexNodes, ", "))
+		} else {
+			reason = "the cluster doesn't run both the query and index services"
+		}
+	}
+
+	e.UseN1ql = engine == EngineN1ql
+	e.logf("Iterating buckets with %v, since %v.  Set -engine to override", engine, reason)
+}
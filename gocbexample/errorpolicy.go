@@ -0,0 +1,165 @@
+package gocbexample
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// What happens to a doc that a pre-insert stage fails on
+type ErrorPolicy string
+
+const (
+	// Fail the copy.  The default
+	ErrorPolicyAbort ErrorPolicy = "abort"
+
+	// Leave the doc out of the copy and list it in the report
+	ErrorPolicySkip ErrorPolicy = "skip"
+
+	// Leave the doc out of the copy, list it in the report and write it to the dead-letter file
+	ErrorPolicyDeadLetter ErrorPolicy = "dead-letter"
+)
+
+// Names of the pre-insert stages that error policies can be set for
+const (
+	StagePreInsert  = "preInsert"
+	StageTransforms = "transforms"
+)
+
+func ParseErrorPolicy(policy string) (ErrorPolicy, error) {
+	switch ErrorPolicy(policy) {
+	case ErrorPolicyAbort, ErrorPolicySkip, ErrorPolicyDeadLetter:
+		return ErrorPolicy(policy), nil
+	case "":
+		return ErrorPolicyAbort, nil
+	}
+	return "", fmt.Errorf("Unknown error policy: %v.  Expected abort, skip or dead-letter", policy)
+}
+
+// Set the error policy of a pre-insert stage: StagePreInsert (the preInsertCallback, eg anonymization)
+// or StageTransforms (the built-in Transforms)
+func WithErrorPolicy(stage string, policy ErrorPolicy) Option {
+	return func(e *ExampleApp) error {
+		if stage != StagePreInsert && stage != StageTransforms {
+			return fmt.Errorf("Unknown stage: %v.  Expected %v or %v", stage, StagePreInsert, StageTransforms)
+		}
+		if _, err := ParseErrorPolicy(string(policy)); err != nil {
+			return err
+		}
+		if e.ErrorPolicies == nil {
+			e.ErrorPolicies = map[string]ErrorPolicy{}
+		}
+		e.ErrorPolicies[stage] = policy
+		return nil
+	}
+}
+
+func (e *ExampleApp) errorPolicy(stage string) ErrorPolicy {
+	if policy, ok := e.ErrorPolicies[stage]; ok {
+		return policy
+	}
+	return ErrorPolicyAbort
+}
+
+// Docs left out of the copy by the skip and dead-letter error policies
+type skippedDocs struct {
+	mutex sync.Mutex
+	docs  []ErrorContext
+}
+
+// The docs that were skipped because a stage failed on them
+func (e *ExampleApp) SkippedDocs() []ErrorContext {
+	e.skipped.mutex.Lock()
+	defer e.skipped.mutex.Unlock()
+	return append([]ErrorContext{}, e.skipped.docs...)
+}
+
+// A doc a stage failed on, as it was passed to the stage
+type FailedDoc struct {
+	DocId string
+	Doc   interface{}
+	Err   error
+}
+
+// Returned by a stage that failed on some docs of a batch, along with the output of the rest, so that the docs
+// can be left out of the copy without running the stage again
+type DocErrors []FailedDoc
+
+func (d DocErrors) Error() string {
+	if len(d) == 1 {
+		return fmt.Sprintf("Error processing doc id: %v.  Err: %v", d[0].DocId, d[0].Err)
+	}
+	return fmt.Sprintf("Error processing %v docs, the first being doc id: %v.  Err: %v", len(d), d[0].DocId, d[0].Err)
+}
+
+// Record that the stage failed on the doc, and return the updated failures, which are nil until the first
+func (d DocErrors) add(docId string, doc interface{}, err error) DocErrors {
+	return append(d, FailedDoc{DocId: docId, Doc: doc, Err: err})
+}
+
+// The failures as an error, or nil if there weren't any
+func (d DocErrors) err() error {
+	if len(d) == 0 {
+		return nil
+	}
+	return d
+}
+
+// Run a pre-insert stage on a batch according to its error policy.  A stage that returns DocErrors has just the
+// docs it failed on left out of the output.  Any other error means the stage failed on the batch as a whole, eg a
+// webhook request, so every doc of the batch is left out: the stage isn't run again one doc at a time, since that
+// would repeat its side effects, eg counting the docs in its report or calling the webhook once per doc.
+func (e *ExampleApp) runStage(stage string, stageFunc DocProcessorReturnDocs, batchId string, input DocProcessorInput) (DocProcessorInput, error) {
+
+	output, err := stageFunc(input)
+	if err == nil {
+		return output, nil
+	}
+
+	var docErrs DocErrors
+	isDocErrs := errors.As(err, &docErrs)
+	policy := e.errorPolicy(stage)
+	if policy == ErrorPolicyAbort {
+		if isDocErrs {
+			return output, newDocError(PhaseTransform, docErrs[0].DocId, docErrs[0].Err)
+		}
+		return output, err
+	}
+
+	if isDocErrs {
+		for _, failed := range docErrs {
+			e.skipDoc(policy, stage, batchId, failed.DocId, failed.Doc, failed.Err)
+		}
+		return output, nil
+	}
+
+	e.logf("Stage %v failed on the whole of batch %v, skipping its %v docs.  Err: %v", stage, batchId, len(input.DocIds), err)
+	for i, docId := range input.DocIds {
+		e.skipDoc(policy, stage, batchId, docId, input.Docs[i], err)
+	}
+	return DocProcessorInput{DocIds: []string{}, Docs: []interface{}{}}, nil
+}
+
+// Record a doc that was left out of the copy, and dead-letter it if that's the policy
+func (e *ExampleApp) skipDoc(policy ErrorPolicy, stage string, batchId string, docId string, doc interface{}, err error) {
+
+	docErr := &DocError{
+		Phase:       PhaseTransform,
+		DocId:       docId,
+		Err:         err,
+		BatchId:     batchId,
+		PayloadHash: payloadHash(doc),
+	}
+	context := docErr.Context()
+	e.logf("Skipping doc in stage %v: %v", stage, context)
+
+	e.skipped.mutex.Lock()
+	e.skipped.docs = append(e.skipped.docs, context)
+	e.skipped.mutex.Unlock()
+
+	if policy == ErrorPolicyDeadLetter {
+		if writeErr := e.DeadLetters.Write(DeadLetterEntry{ErrorContext: context, Doc: doc}); writeErr != nil {
+			e.logf("Error writing dead-letter entry for doc id: %v.  Err: %v", docId, writeErr)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package gocbexample
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRunStage(t *testing.T) {
+
+	input := DocProcessorInput{
+		DocIds: []string{"a", "b", "c"},
+		Docs:   []interface{}{"doc a", "doc b", "doc c"},
+	}
+
+	// Fails on doc b with DocErrors, passing on the rest
+	failOnB := func(input DocProcessorInput) (DocProcessorInput, error) {
+		output := DocProcessorInput{}
+		var failed DocErrors
+		for i, docId := range input.DocIds {
+			if docId == "b" {
+				failed = failed.add(docId, input.Docs[i], errors.New("bad doc"))
+				continue
+			}
+			output.DocIds = append(output.DocIds, docId)
+			output.Docs = append(output.Docs, input.Docs[i])
+		}
+		return output, failed.err()
+	}
+	failBatch := func(input DocProcessorInput) (DocProcessorInput, error) {
+		return DocProcessorInput{}, errors.New("webhook down")
+	}
+
+	tests := []struct {
+		name        string
+		policy      ErrorPolicy
+		stage       DocProcessorReturnDocs
+		wantIds     []string
+		wantSkipped []string
+		wantErr     bool
+	}{
+		{name: "doc errors skipped", policy: ErrorPolicySkip, stage: failOnB, wantIds: []string{"a", "c"}, wantSkipped: []string{"b"}},
+		{name: "doc errors abort", policy: ErrorPolicyAbort, stage: failOnB, wantErr: true},
+		{name: "batch error skipped", policy: ErrorPolicySkip, stage: failBatch, wantIds: []string{}, wantSkipped: []string{"a", "b", "c"}},
+		{name: "batch error abort", policy: ErrorPolicyAbort, stage: failBatch, wantErr: true},
+		{name: "chained doc errors", policy: ErrorPolicySkip, stage: ChainDocProcessors(failOnB, failOnB), wantIds: []string{"a", "c"}, wantSkipped: []string{"b"}},
+	}
+
+	for _, test := range tests {
+		e := &ExampleApp{ErrorPolicies: map[string]ErrorPolicy{StageTransforms: test.policy}}
+
+		calls := 0
+		stage := func(input DocProcessorInput) (DocProcessorInput, error) {
+			calls++
+			return test.stage(input)
+		}
+		output, err := e.runStage(StageTransforms, stage, "batch-1", input)
+
+		if calls != 1 {
+			t.Errorf("%v: stage was run %v times, want once", test.name, calls)
+		}
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%v: want an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: failed: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(output.DocIds, test.wantIds) {
+			t.Errorf("%v: output ids = %v, want %v", test.name, output.DocIds, test.wantIds)
+		}
+		var skipped []string
+		for _, context := range e.SkippedDocs() {
+			skipped = append(skipped, context.DocId)
+		}
+		if !reflect.DeepEqual(skipped, test.wantSkipped) {
+			t.Errorf("%v: skipped = %v, want %v", test.name, skipped, test.wantSkipped)
+		}
+	}
+}
+
+func TestDeadLetterPolicyNeedsFile(t *testing.T) {
+
+	e := &ExampleApp{ErrorPolicies: map[string]ErrorPolicy{StagePreInsert: ErrorPolicyDeadLetter}}
+	if err := e.validate(); err == nil {
+		t.Errorf("validate() of the dead-letter policy without a dead-letter file succeeded, want an error")
+	}
+	if err := WithDeadLetterFile("dead-letter.jsonl")(e); err != nil {
+		t.Fatalf("WithDeadLetterFile failed: %v", err)
+	}
+	if err := e.validate(); err != nil {
+		t.Errorf("validate() with a dead-letter file failed: %v", err)
+	}
+}
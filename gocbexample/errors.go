@@ -0,0 +1,144 @@
+package gocbexample
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// Errors that callers can check for with errors.Is, whatever the underlying SDK or transform error was
+var (
+	// The doc already exists in the target
+	ErrDocExists = errors.New("document already exists")
+
+	// The doc doesn't exist
+	ErrDocNotFound = errors.New("document not found")
+
+	// The cluster asked the client to back off (tmpfail) or the operation timed out.  Retrying may succeed.
+	ErrTemporary = errors.New("temporary failure")
+
+	// A failure in each phase of the copy.  A *DocError matches the sentinel for its phase.
+	ErrSourceRead  = errors.New("source read failed")
+	ErrTransform   = errors.New("transform failed")
+	ErrTargetWrite = errors.New("target write failed")
+	ErrPostInsert  = errors.New("post-insert callback failed")
+)
+
+// The phase of a copy that an error happened in
+type Phase string
+
+const (
+	PhaseSourceRead  Phase = "source read"
+	PhaseTransform   Phase = "transform"
+	PhaseTargetWrite Phase = "target write"
+	PhasePostInsert  Phase = "post-insert callback"
+)
+
+var phaseErrors = map[Phase]error{
+	PhaseSourceRead:  ErrSourceRead,
+	PhaseTransform:   ErrTransform,
+	PhaseTargetWrite: ErrTargetWrite,
+	PhasePostInsert:  ErrPostInsert,
+}
+
+// An error processing a doc (or, if DocId is empty, a whole batch) in one phase of a copy.  Get it
+// with errors.As to find out which doc and phase failed.
+type DocError struct {
+	Phase Phase
+	DocId string
+	Err   error
+
+	// The batch the doc was in, how many times the failing operation was tried, and a hash of the doc
+	// body, so that failures can be correlated without logging the (possibly sensitive) doc itself
+	BatchId     string
+	Attempts    int
+	PayloadHash string
+}
+
+// Wrap err with the phase and doc id it happened in.  Errors that already carry a phase keep it, and
+// gocb errors are wrapped so that they match ErrDocExists, ErrDocNotFound and ErrTemporary.
+func newDocError(phase Phase, docId string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var docErr *DocError
+	if errors.As(err, &docErr) {
+		return err
+	}
+	return &DocError{Phase: phase, DocId: docId, Err: wrapGocbError(err)}
+}
+
+func (e *DocError) Error() string {
+	if e.DocId == "" {
+		return fmt.Sprintf("Error during %v.  Err: %v", e.Phase, e.Err)
+	}
+	return fmt.Sprintf("Error during %v of doc id: %v.  Err: %v", e.Phase, e.DocId, e.Err)
+}
+
+// The structured context of the error, as written to logs, dead-letter entries and the report
+func (e *DocError) Context() ErrorContext {
+	return ErrorContext{
+		Phase:       e.Phase,
+		DocId:       e.DocId,
+		BatchId:     e.BatchId,
+		Attempts:    e.Attempts,
+		PayloadHash: e.PayloadHash,
+		Error:       e.Err.Error(),
+	}
+}
+
+func (e *DocError) Unwrap() error {
+	return e.Err
+}
+
+// Match the sentinel error for the phase
+func (e *DocError) Is(target error) bool {
+	return phaseErrors[e.Phase] == target
+}
+
+// Record the number of attempts on a *DocError
+func withAttempts(err error, attempts int) error {
+	var docErr *DocError
+	if errors.As(err, &docErr) {
+		docErr.Attempts = attempts
+	}
+	return err
+}
+
+// A gocb error, matched against the sentinels with gocb's own errors.  A CAS mismatch counts as the doc existing,
+// as gocb v1 reported it, since either way the doc was written by someone else.
+type gocbError struct {
+	err error
+}
+
+func wrapGocbError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var wrapped *gocbError
+	if errors.As(err, &wrapped) {
+		return err
+	}
+	return &gocbError{err: err}
+}
+
+func (e *gocbError) Error() string {
+	return e.err.Error()
+}
+
+func (e *gocbError) Unwrap() error {
+	return e.err
+}
+
+func (e *gocbError) Is(target error) bool {
+	switch target {
+	case ErrDocExists:
+		return errors.Is(e.err, gocb.ErrDocumentExists) || errors.Is(e.err, gocb.ErrCasMismatch)
+	case ErrDocNotFound:
+		return errors.Is(e.err, gocb.ErrDocumentNotFound)
+	case ErrTemporary:
+		return errors.Is(e.err, gocb.ErrTemporaryFailure) || errors.Is(e.err, gocb.ErrTimeout)
+	}
+	return false
+}
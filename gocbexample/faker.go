@@ -0,0 +1,327 @@
+package gocbexample
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Locale used when none is configured
+const defaultFakerLocale = "en_US"
+
+// The kinds of fake values a field can be replaced with
+const (
+	FakeFirstName = "firstName"
+	FakeLastName  = "lastName"
+	FakeName      = "name"
+	FakeCity      = "city"
+	FakeDomain    = "domain"
+	FakeEmail     = "email"
+)
+
+// Dictionaries (lists of values to pick from) that fake values are built from
+const (
+	dictFirstNames = "firstNames"
+	dictLastNames  = "lastNames"
+	dictCities     = "cities"
+	dictDomains    = "domains"
+)
+
+// A field to replace with a fake value of the given kind
+type FakeField struct {
+	Path string `json:"path"`
+	Kind string `json:"kind"`
+}
+
+// Which fields to fake and how
+type FakerConfig struct {
+	Fields []FakeField `json:"fields"`
+
+	// Picks the built-in dictionaries and the name order, eg "de_DE" or "ja_JP".  Defaults to "en_US"
+	Locale string `json:"locale,omitempty"`
+
+	// Files that replace the built-in dictionaries (firstNames, lastNames, cities, domains), one value per line,
+	// eg {"cities": "cities.txt"}
+	Dictionaries map[string]string `json:"dictionaries,omitempty"`
+
+	// Seed of the fake values.  The values for a doc depend only on the seed and the doc id.  Generated if 0
+	Seed int64 `json:"seed,omitempty"`
+}
+
+// The conventions of a locale, along with its built-in dictionaries
+type fakerLocale struct {
+	dictionaries map[string][]string
+
+	// Write the family name first, as in "Yamada Taro"
+	familyNameFirst bool
+}
+
+var fakerLocales = map[string]fakerLocale{
+	"en_US": {
+		dictionaries: map[string][]string{
+			dictFirstNames: {"James", "Mary", "Robert", "Patricia", "John", "Jennifer", "Michael", "Linda", "David", "Elizabeth"},
+			dictLastNames:  {"Smith", "Johnson", "Williams", "Brown", "Jones", "Garcia", "Miller", "Davis", "Wilson", "Moore"},
+			dictCities:     {"Springfield", "Riverside", "Fairview", "Franklin", "Greenville", "Clinton", "Salem", "Madison"},
+			dictDomains:    {"example.com", "example.net", "example.org"},
+		},
+	},
+	"de_DE": {
+		dictionaries: map[string][]string{
+			dictFirstNames: {"Lukas", "Anna", "Jürgen", "Sophie", "Maximilian", "Lea", "Jörg", "Mia", "Stefan", "Käthe"},
+			dictLastNames:  {"Müller", "Schmidt", "Schneider", "Fischer", "Weber", "Meyer", "Wagner", "Becker", "Schäfer", "Koch"},
+			dictCities:     {"Neustadt", "Bad Homburg", "Lüneburg", "Würzburg", "Friedrichshafen", "Görlitz", "Oberhausen"},
+			dictDomains:    {"example.de", "beispiel.de", "example.com"},
+		},
+	},
+	"fr_FR": {
+		dictionaries: map[string][]string{
+			dictFirstNames: {"Léa", "Hugo", "Chloé", "Louis", "Manon", "Gabriel", "Inès", "Raphaël", "Jade", "Noé"},
+			dictLastNames:  {"Martin", "Bernard", "Dubois", "Thomas", "Robert", "Richard", "Petit", "Durand", "Lefèvre", "Moreau"},
+			dictCities:     {"Villeneuve", "Saint-Étienne", "Montreuil", "Fontainebleau", "Châteauroux", "Besançon"},
+			dictDomains:    {"exemple.fr", "example.fr", "example.com"},
+		},
+	},
+	"ja_JP": {
+		dictionaries: map[string][]string{
+			dictFirstNames: {"太郎", "花子", "翔", "陽菜", "蓮", "結衣", "大翔", "葵", "悠真", "さくら"},
+			dictLastNames:  {"佐藤", "鈴木", "高橋", "田中", "伊藤", "渡辺", "山本", "中村", "小林", "加藤"},
+			dictCities:     {"横浜市", "札幌市", "仙台市", "金沢市", "松山市", "熊本市", "那覇市"},
+			dictDomains:    {"example.jp", "example.co.jp", "example.com"},
+		},
+		familyNameFirst: true,
+	},
+}
+
+// The dictionaries each kind of fake value is built from
+var fakeKindDictionaries = map[string][]string{
+	FakeFirstName: {dictFirstNames},
+	FakeLastName:  {dictLastNames},
+	FakeName:      {dictFirstNames, dictLastNames},
+	FakeCity:      {dictCities},
+	FakeDomain:    {dictDomains},
+	FakeEmail:     {dictFirstNames, dictLastNames, dictDomains},
+}
+
+type compiledFakeField struct {
+	path JSONPath
+	kind string
+}
+
+// A pre-insert stage that replaces fields with realistic fake values (names, cities, emails..) in the
+// character set and conventions of the original dataset's locale
+type Faker struct {
+	config       FakerConfig
+	locale       fakerLocale
+	fields       []compiledFakeField
+	dictionaries map[string][]string
+
+	mutex    sync.Mutex
+	faked    int
+	pathHits []bool
+}
+
+// Summary of what a Faker did
+type FakerReport struct {
+	// Number of values replaced
+	Values int
+}
+
+func NewFaker(config FakerConfig) (*Faker, error) {
+
+	if len(config.Fields) == 0 {
+		return nil, fmt.Errorf("Faker has no fields")
+	}
+	if config.Locale == "" {
+		config.Locale = defaultFakerLocale
+	}
+	locale, ok := fakerLocales[config.Locale]
+	if !ok {
+		return nil, fmt.Errorf("Unknown faker locale: %v.  Supported locales: %v", config.Locale, fakerLocaleNames())
+	}
+	if config.Seed == 0 {
+		config.Seed = time.Now().UnixNano()
+	}
+
+	f := &Faker{
+		config:       config,
+		locale:       locale,
+		dictionaries: map[string][]string{},
+		pathHits:     make([]bool, len(config.Fields)),
+	}
+	for name, values := range locale.dictionaries {
+		f.dictionaries[name] = values
+	}
+	for name, path := range config.Dictionaries {
+		if _, ok := locale.dictionaries[name]; !ok {
+			return nil, fmt.Errorf("Unknown faker dictionary: %v.  Must be one of %v, %v, %v or %v", name, dictFirstNames, dictLastNames, dictCities, dictDomains)
+		}
+		values, err := loadDictionary(path)
+		if err != nil {
+			return nil, err
+		}
+		f.dictionaries[name] = values
+	}
+
+	for i, field := range config.Fields {
+		if _, ok := fakeKindDictionaries[field.Kind]; !ok {
+			return nil, fmt.Errorf("Unknown fake value kind: %v in faker field %v", field.Kind, i)
+		}
+		path, err := ParseJSONPath(field.Path)
+		if err != nil {
+			return nil, err
+		}
+		f.fields = append(f.fields, compiledFakeField{path: path, kind: field.Kind})
+	}
+
+	return f, nil
+}
+
+func fakerLocaleNames() []string {
+	names := []string{}
+	for name := range fakerLocales {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Load a dictionary file: one value per line, ignoring blank lines
+func loadDictionary(path string) ([]string, error) {
+
+	dictFile, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening dictionary: %v.  Err: %v", path, err)
+	}
+	defer dictFile.Close()
+
+	var values []string
+	scanner := bufio.NewScanner(dictFile)
+	for scanner.Scan() {
+		if value := strings.TrimSpace(scanner.Text()); value != "" {
+			values = append(values, value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Error reading dictionary: %v.  Err: %v", path, err)
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("Dictionary: %v is empty", path)
+	}
+	return values, nil
+}
+
+func (f *Faker) Transform(input DocProcessorInput) (output DocProcessorInput, err error) {
+
+	output = DocProcessorInput{
+		DocIds: input.DocIds,
+		Docs:   make([]interface{}, len(input.Docs)),
+	}
+	faked := 0
+	pathHits := make([]bool, len(f.fields))
+	for i, doc := range input.Docs {
+		rng := rand.New(rand.NewSource(docRandSeed(f.config.Seed, input.DocIds[i])))
+		for j, field := range f.fields {
+			doc = field.path.Update(doc, func(val interface{}) interface{} {
+				if val == nil {
+					return val
+				}
+				faked += 1
+				pathHits[j] = true
+				return f.fake(field.kind, rng)
+			})
+		}
+		output.Docs[i] = doc
+	}
+
+	f.mutex.Lock()
+	f.faked += faked
+	for i, hit := range pathHits {
+		f.pathHits[i] = f.pathHits[i] || hit
+	}
+	f.mutex.Unlock()
+
+	return output, nil
+}
+
+func (f *Faker) pick(dictionary string, rng *rand.Rand) string {
+	values := f.dictionaries[dictionary]
+	return values[rng.Intn(len(values))]
+}
+
+// Generate a fake value of the given kind
+func (f *Faker) fake(kind string, rng *rand.Rand) string {
+
+	switch kind {
+	case FakeFirstName:
+		return f.pick(dictFirstNames, rng)
+	case FakeLastName:
+		return f.pick(dictLastNames, rng)
+	case FakeName:
+		first, last := f.pick(dictFirstNames, rng), f.pick(dictLastNames, rng)
+		if f.locale.familyNameFirst {
+			return last + " " + first
+		}
+		return first + " " + last
+	case FakeCity:
+		return f.pick(dictCities, rng)
+	case FakeDomain:
+		return f.pick(dictDomains, rng)
+	default:
+		first, last := f.pick(dictFirstNames, rng), f.pick(dictLastNames, rng)
+		localPart := emailLocalPart(first + "." + last)
+		if localPart == "" {
+			// Names in scripts that can't appear in the local part of an address
+			localPart = fmt.Sprintf("user%d", rng.Intn(100000))
+		}
+		return localPart + "@" + f.pick(dictDomains, rng)
+	}
+}
+
+// Lowercase ASCII letters, digits and dots of s, eg "Jürgen.Müller" -> "jrgen.mller".  Empty if s has no letters.
+func emailLocalPart(s string) string {
+	var localPart strings.Builder
+	letters := 0
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case r < unicode.MaxASCII && unicode.IsLetter(r):
+			letters += 1
+			localPart.WriteRune(r)
+		case r < unicode.MaxASCII && (unicode.IsDigit(r) || r == '.'):
+			localPart.WriteRune(r)
+		}
+	}
+	if letters == 0 {
+		return ""
+	}
+	return localPart.String()
+}
+
+// The seed of the fake values, which regenerates them exactly if configured on another run
+func (f *Faker) Seed() int64 {
+	return f.config.Seed
+}
+
+func (f *Faker) Report() FakerReport {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return FakerReport{Values: f.faked}
+}
+
+// The fields that never matched a value, which usually means they have a typo
+func (f *Faker) UnusedRules() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	var unused []string
+	for i, hit := range f.pathHits {
+		if !hit {
+			unused = append(unused, fmt.Sprintf("faker.fields[%v] %v", i, f.fields[i].path))
+		}
+	}
+	return unused
+}
@@ -0,0 +1,103 @@
+package gocbexample
+
+import (
+	"sync"
+)
+
+// What the scrub command did, or in a dry run would have done
+type ScrubReport struct {
+	Path   string `json:"path"`
+	Action string `json:"action"`
+	DryRun bool   `json:"dryRun,omitempty"`
+
+	// Docs read, docs matching the filter, and matching docs that had the field
+	DocsScanned  int `json:"docsScanned"`
+	DocsMatched  int `json:"docsMatched"`
+	DocsScrubbed int `json:"docsScrubbed"`
+}
+
+// Removes or overwrites a field in the docs matching a filter.  Docs without the field, or that don't match,
+// are dropped from the batch so that they aren't written back.
+type FieldScrubber struct {
+	path      JSONPath
+	filter    *docFilter
+	overwrite bool
+	value     interface{}
+	dryRun    bool
+
+	mutex  sync.Mutex
+	report ScrubReport
+}
+
+// Create a scrubber that removes the field at path, or if overwrite is set replaces its value with value
+func NewFieldScrubber(path string, filter DocFilter, overwrite bool, value interface{}, dryRun bool) (*FieldScrubber, error) {
+
+	jsonPath, err := ParseJSONPath(path)
+	if err != nil {
+		return nil, err
+	}
+	compiledFilter, err := newDocFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	action := "remove"
+	if overwrite {
+		action = "overwrite"
+	}
+	return &FieldScrubber{
+		path:      jsonPath,
+		filter:    compiledFilter,
+		overwrite: overwrite,
+		value:     value,
+		dryRun:    dryRun,
+		report:    ScrubReport{Path: path, Action: action, DryRun: dryRun},
+	}, nil
+}
+
+// A DocProcessorReturnDocs that scrubs the field, passing on only the docs it changed
+func (s *FieldScrubber) Transform(input DocProcessorInput) (output DocProcessorInput, err error) {
+
+	scanned, matched, scrubbed := 0, 0, 0
+	for i, docId := range input.DocIds {
+
+		scanned++
+		doc := input.Docs[i]
+		if !s.filter.matches(docId, doc) {
+			continue
+		}
+		matched++
+
+		found := false
+		updated := s.path.Update(doc, func(val interface{}) interface{} {
+			found = true
+			return s.value
+		})
+		if !found {
+			continue
+		}
+		if !s.overwrite {
+			updated = s.path.Remove(doc)
+		}
+		scrubbed++
+
+		if !s.dryRun {
+			output.DocIds = append(output.DocIds, docId)
+			output.Docs = append(output.Docs, updated)
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.report.DocsScanned += scanned
+	s.report.DocsMatched += matched
+	s.report.DocsScrubbed += scrubbed
+
+	return output, nil
+}
+
+func (s *FieldScrubber) Report() ScrubReport {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.report
+}
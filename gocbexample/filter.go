@@ -0,0 +1,299 @@
+package gocbexample
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// Selects docs by id, type, content or N1QL condition.  The filter of the config (or -filter) selects the source docs
+// that every command reads, and the maintenance commands (eg scrub) narrow that down with their own.  A doc must
+// match every filter that is set, and an empty filter matches every doc.
+type DocFilter struct {
+
+	// Regex the doc id must match, eg "^test::"
+	KeyPattern string `json:"keyPattern,omitempty"`
+
+	// The doc type must be one of these
+	Types []string `json:"types,omitempty"`
+
+	// JSONPath predicates the doc must satisfy: "$.env == \"test\"", "$.env != \"prod\"", or "$.owner" for a
+	// field that is present.  A predicate holds if any value the path matches satisfies it
+	Match []string `json:"match,omitempty"`
+
+	// A CEL expression over the doc, as doc, and its id, as id, that must be true, eg
+	// "doc.type == 'hotel' && doc.country == 'FR'".  A doc it fails on, eg for a field the doc doesn't have,
+	// doesn't match, so has(doc.field) guards optional fields
+	Expr string `json:"expr,omitempty"`
+
+	// A N1QL WHERE clause over the bucket aliased as d, eg "d.createdBy = 'loadtest'".  The bucket is then
+	// scanned with that query rather than the configured scan
+	Where string `json:"where,omitempty"`
+}
+
+type docFilter struct {
+	keyPattern *regexp.Regexp
+	types      map[string]bool
+	predicates []docPredicate
+	expr       *celExpr
+}
+
+// A parsed DocFilter.Match predicate
+type docPredicate struct {
+	path JSONPath
+
+	// "==", "!=", or "" for a field that is present
+	op    string
+	value []byte
+}
+
+func parseDocPredicate(expr string) (docPredicate, error) {
+
+	predicate := docPredicate{}
+	path := expr
+	for _, op := range []string{"==", "!="} {
+		if i := strings.Index(expr, op); i >= 0 {
+			predicate.op = op
+			path = expr[:i]
+			var value interface{}
+			if err := json.Unmarshal([]byte(strings.TrimSpace(expr[i+len(op):])), &value); err != nil {
+				return predicate, fmt.Errorf("Invalid match predicate: %v.  The value must be JSON, eg \"test\".  Err: %v", expr, err)
+			}
+			predicate.value, _ = json.Marshal(value)
+			break
+		}
+	}
+
+	jsonPath, err := ParseJSONPath(strings.TrimSpace(path))
+	if err != nil {
+		return predicate, fmt.Errorf("Invalid match predicate: %v.  Err: %v", expr, err)
+	}
+	predicate.path = jsonPath
+	return predicate, nil
+}
+
+// Values are compared as JSON, so eg 1 and 1.0 are equal
+func (p docPredicate) matches(doc interface{}) bool {
+	values := p.path.Values(doc)
+	if p.op == "" {
+		return len(values) > 0
+	}
+	for _, val := range values {
+		valBytes, err := json.Marshal(val)
+		if err != nil {
+			continue
+		}
+		if (string(valBytes) == string(p.value)) == (p.op == "==") {
+			return true
+		}
+	}
+	return false
+}
+
+func newDocFilter(filter DocFilter) (*docFilter, error) {
+	compiled := &docFilter{}
+	if filter.KeyPattern != "" {
+		keyPattern, err := regexp.Compile(filter.KeyPattern)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid key pattern: %v.  Err: %v", filter.KeyPattern, err)
+		}
+		compiled.keyPattern = keyPattern
+	}
+	if len(filter.Types) > 0 {
+		compiled.types = map[string]bool{}
+		for _, docType := range filter.Types {
+			compiled.types[docType] = true
+		}
+	}
+	for _, expr := range filter.Match {
+		predicate, err := parseDocPredicate(expr)
+		if err != nil {
+			return nil, err
+		}
+		compiled.predicates = append(compiled.predicates, predicate)
+	}
+	if filter.Expr != "" {
+		env, err := newCelEnv()
+		if err != nil {
+			return nil, err
+		}
+		if compiled.expr, err = compileCelExpr(env, filter.Expr, true); err != nil {
+			return nil, err
+		}
+	}
+	return compiled, nil
+}
+
+func (f *docFilter) matches(docId string, doc interface{}) bool {
+	if f.keyPattern != nil && !f.keyPattern.MatchString(docId) {
+		return false
+	}
+	if f.types != nil {
+		body, ok := doc.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		docType, _ := body[defaultTypeField].(string)
+		if !f.types[docType] {
+			return false
+		}
+	}
+	for _, predicate := range f.predicates {
+		if !predicate.matches(doc) {
+			return false
+		}
+	}
+	if f.expr != nil && !f.expr.matches(docId, doc) {
+		return false
+	}
+	return true
+}
+
+// Wrap a doc processor to only pass on the docs matching the filter.  Pages without any are skipped.
+func (f *docFilter) docProcessor(docProcessor DocProcessor) DocProcessor {
+	return func(docIds []string, docs []interface{}) error {
+		var matchedIds []string
+		var matchedDocs []interface{}
+		for i, docId := range docIds {
+			if f.matches(docId, docs[i]) {
+				matchedIds = append(matchedIds, docId)
+				matchedDocs = append(matchedDocs, docs[i])
+			}
+		}
+		if len(matchedIds) == 0 {
+			return nil
+		}
+		return docProcessor(matchedIds, matchedDocs)
+	}
+}
+
+// Only read the source docs matching the filter, with every command.  A WHERE clause replaces the configured
+// scan of the source with the query selecting the docs matching it.
+func WithFilter(filter DocFilter) Option {
+	return func(e *ExampleApp) error {
+		compiled, err := newDocFilter(filter)
+		if err != nil {
+			return err
+		}
+		e.Filter = &filter
+		e.sourceFilter = compiled
+		if filter.Where != "" {
+			e.SourceQuery = whereSourceQuery(filter.Where)
+		}
+		return nil
+	}
+}
+
+// Wrap a doc processor to only pass on the source docs matching the Filter.  Pages without any are skipped.
+func (e *ExampleApp) filterSourceDocs(bucket *gocb.Bucket, docProcessor DocProcessor) DocProcessor {
+	if e.sourceFilter == nil || bucket != e.SourceBucket {
+		return docProcessor
+	}
+	return e.sourceFilter.docProcessor(docProcessor)
+}
+
+// The source query that scans the docs matching a WHERE clause
+func whereSourceQuery(where string) string {
+	return fmt.Sprintf("SELECT META(d).id AS id, d AS doc FROM `%v` d WHERE %v", queryBucketPlaceholder, where)
+}
+
+// Scan only the docs matching the filter's WHERE clause, if it has one, as well as the WHERE clause of the Filter
+func (e *ExampleApp) applyWhereFilter(filter DocFilter) {
+	if filter.Where == "" {
+		return
+	}
+	where := filter.Where
+	if e.Filter != nil && e.Filter.Where != "" {
+		where = fmt.Sprintf("(%v) AND (%v)", e.Filter.Where, filter.Where)
+	}
+	e.SourceQuery = whereSourceQuery(where)
+	e.logf("Scanning the docs matching: %v", e.SourceQuery)
+}
+
+// Called with a page of the docs matching a filter, and the CAS each was read with
+type matchedDocsProcessor func(docIds []string, docs []interface{}, cas []gocb.Cas) error
+
+// Scan the source bucket as a phase of the job, calling matchedProcessor with the docs matching the filter.  Pages
+// without any are skipped.  Used by the commands that change the source in place, so the CAS of each doc is
+// recorded as it's read, or the doc read again if the scan doesn't return it.  Returns the number of docs
+// scanned and matched.
+func (e *ExampleApp) forEachMatchingDoc(phase string, filter DocFilter, matchedProcessor matchedDocsProcessor) (scanned, matched int64, err error) {
+
+	compiledFilter, err := newDocFilter(filter)
+	if err != nil {
+		return 0, 0, err
+	}
+	e.applyWhereFilter(filter)
+
+	e.readCas = newReadCasTracker()
+	defer func() { e.readCas = nil }()
+
+	docProcessor := func(docIds []string, docs []interface{}) error {
+
+		var matchedIds []string
+		var matchedDocs []interface{}
+		var matchedCas []gocb.Cas
+		for i, docId := range docIds {
+			cas, _ := e.readCas.take(docId)
+			if compiledFilter.matches(docId, docs[i]) {
+				matchedIds = append(matchedIds, docId)
+				matchedDocs = append(matchedDocs, docs[i])
+				matchedCas = append(matchedCas, cas)
+			}
+		}
+		atomic.AddInt64(&scanned, int64(len(docIds)))
+		atomic.AddInt64(&matched, int64(len(matchedIds)))
+		if len(matchedIds) == 0 {
+			return nil
+		}
+		return matchedProcessor(matchedIds, matchedDocs, matchedCas)
+	}
+
+	e.startPhase(phase)
+	e.logf("Running %v over bucket: %v", phase, e.SourceBucket.Name())
+	err = e.forEachDocIdBucket(e.countProgress(e.inPlaceReads(docProcessor)), e.SourceBucket)
+	return atomic.LoadInt64(&scanned), atomic.LoadInt64(&matched), err
+}
+
+// Flags shared by the maintenance commands: the doc filter and dry run
+type docFilterFlags struct {
+	keyPattern *string
+	types      *string
+	match      *stringListFlag
+	expr       *string
+	where      *string
+	dryRun     *bool
+}
+
+func addDocFilterFlags(flags *flag.FlagSet) *docFilterFlags {
+	match := &stringListFlag{}
+	flags.Var(match, "match", "Only act on docs satisfying this JSONPath predicate, eg '$.env == \"test\"' or '$.owner'.  Can be repeated")
+	return &docFilterFlags{
+		keyPattern: flags.String("key-pattern", "", "Only act on docs whose id matches this regex, eg '^test::'"),
+		types:      flags.String("types", "", "Comma separated doc types to act on"),
+		match:      match,
+		expr:       flags.String("expr", "", "Only act on docs this CEL expression is true for, eg \"doc.env == 'test' && has(doc.owner)\""),
+		where:      flags.String("where", "", "Only act on docs matching this N1QL WHERE clause, with the bucket aliased as d, eg \"d.env = 'test'\""),
+		dryRun:     flags.Bool("dry-run", false, "Count the docs that would be changed, without changing them"),
+	}
+}
+
+func (f *docFilterFlags) filter() DocFilter {
+	return DocFilter{
+		KeyPattern: *f.keyPattern,
+		Types:      splitCommaList(*f.types),
+		Match:      *f.match,
+		Expr:       *f.expr,
+		Where:      *f.where,
+	}
+}
+
+// Whether the filter selects every doc
+func (f DocFilter) isEmpty() bool {
+	return f.KeyPattern == "" && len(f.Types) == 0 && len(f.Match) == 0 && f.Expr == "" && f.Where == ""
+}
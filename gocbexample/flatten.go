@@ -0,0 +1,205 @@
+package gocbexample
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Default separator used when flattening nested objects into dotted keys
+const defaultFlattenSeparator = "."
+
+// Returns a pre-insert transform that flattens nested objects into dotted keys, eg:
+// {"geo": {"lat": 1.5}} -> {"geo.lat": 1.5}
+//
+// maxDepth limits how many levels of nesting are collapsed (0 means no limit).  Arrays are left as-is.
+func FlattenDocsTransform(separator string, maxDepth int) DocProcessorReturnDocs {
+
+	if separator == "" {
+		separator = defaultFlattenSeparator
+	}
+
+	return func(input DocProcessorInput) (output DocProcessorInput, err error) {
+		output = DocProcessorInput{
+			DocIds: make([]string, 0, len(input.DocIds)),
+			Docs:   make([]interface{}, 0, len(input.Docs)),
+		}
+		var failed DocErrors
+		for i, doc := range input.Docs {
+			docMap, ok := doc.(map[string]interface{})
+			if !ok {
+				// Only JSON objects can be flattened, pass anything else through untouched
+				output.DocIds = append(output.DocIds, input.DocIds[i])
+				output.Docs = append(output.Docs, doc)
+				continue
+			}
+			flattened, err := FlattenDoc(docMap, separator, maxDepth)
+			if err != nil {
+				failed = failed.add(input.DocIds[i], doc, fmt.Errorf("Error flattening doc.  Err: %v", err))
+				continue
+			}
+			output.DocIds = append(output.DocIds, input.DocIds[i])
+			output.Docs = append(output.Docs, flattened)
+		}
+		return output, failed.err()
+	}
+
+}
+
+// Returns a pre-insert transform that nests dotted keys back into objects, eg:
+// {"geo.lat": 1.5} -> {"geo": {"lat": 1.5}}
+//
+// maxDepth limits how many levels of nesting are created (0 means no limit).
+func NestDocsTransform(separator string, maxDepth int) DocProcessorReturnDocs {
+
+	if separator == "" {
+		separator = defaultFlattenSeparator
+	}
+
+	return func(input DocProcessorInput) (output DocProcessorInput, err error) {
+		output = DocProcessorInput{
+			DocIds: make([]string, 0, len(input.DocIds)),
+			Docs:   make([]interface{}, 0, len(input.Docs)),
+		}
+		var failed DocErrors
+		for i, doc := range input.Docs {
+			docMap, ok := doc.(map[string]interface{})
+			if !ok {
+				output.DocIds = append(output.DocIds, input.DocIds[i])
+				output.Docs = append(output.Docs, doc)
+				continue
+			}
+			nested, err := NestDoc(docMap, separator, maxDepth)
+			if err != nil {
+				failed = failed.add(input.DocIds[i], doc, fmt.Errorf("Error nesting doc.  Err: %v", err))
+				continue
+			}
+			output.DocIds = append(output.DocIds, input.DocIds[i])
+			output.Docs = append(output.Docs, nested)
+		}
+		return output, failed.err()
+	}
+
+}
+
+// Flatten nested objects in doc into keys joined by separator.  Returns an error if two keys flatten to the same
+// key, eg {"geo": {"lat": 1}, "geo.lat": 1.5}
+func FlattenDoc(doc map[string]interface{}, separator string, maxDepth int) (map[string]interface{}, error) {
+	flattened := map[string]interface{}{}
+	if err := flattenInto(flattened, "", doc, separator, maxDepth, 0); err != nil {
+		return nil, err
+	}
+	return flattened, nil
+}
+
+func flattenInto(dest map[string]interface{}, prefix string, obj map[string]interface{}, separator string, maxDepth, depth int) error {
+	for _, key := range sortedKeys(obj) {
+		val := obj[key]
+		flatKey := key
+		if prefix != "" {
+			flatKey = prefix + separator + key
+		}
+		child, isObject := val.(map[string]interface{})
+		if isObject && len(child) > 0 && (maxDepth == 0 || depth < maxDepth) {
+			if err := flattenInto(dest, flatKey, child, separator, maxDepth, depth+1); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, ok := dest[flatKey]; ok {
+			return fmt.Errorf("More than one key flattens to %q", flatKey)
+		}
+		dest[flatKey] = val
+	}
+	return nil
+}
+
+// Nest keys containing separator back into objects.  The values are copied, so the doc is left as it was.
+// Returns an error if a key conflicts with a non-object value, eg {"geo": 1, "geo.lat": 1.5}, or two keys
+// nest to the same path, eg {"geo": {"lat": 1}, "geo.lat": 1.5}.  Keys are nested in sorted order, so the
+// same doc always gets the same result.
+func NestDoc(doc map[string]interface{}, separator string, maxDepth int) (map[string]interface{}, error) {
+
+	nested := map[string]interface{}{}
+
+	for _, key := range sortedKeys(doc) {
+
+		parts := strings.Split(key, separator)
+		if maxDepth > 0 && len(parts) > maxDepth+1 {
+			// Collapse anything beyond maxDepth back into the final key
+			parts = append(parts[:maxDepth], strings.Join(parts[maxDepth:], separator))
+		}
+
+		current := nested
+		for _, part := range parts[:len(parts)-1] {
+			existing, ok := current[part]
+			if !ok {
+				child := map[string]interface{}{}
+				current[part] = child
+				current = child
+				continue
+			}
+			child, ok := existing.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("Key %q conflicts with non-object value at %q", key, part)
+			}
+			current = child
+		}
+
+		// Every object in nested is a copy, so merging into it leaves the doc's own objects alone
+		if err := mergeNested(current, parts[len(parts)-1], copyValue(doc[key])); err != nil {
+			return nil, fmt.Errorf("Key %q conflicts with an existing value.  Err: %v", key, err)
+		}
+
+	}
+
+	return nested, nil
+}
+
+// Set dest[key] to val, merging objects into an existing object.  Returns an error if a value is set twice
+func mergeNested(dest map[string]interface{}, key string, val interface{}) error {
+	existing, ok := dest[key]
+	if !ok {
+		dest[key] = val
+		return nil
+	}
+	existingMap, existingIsObject := existing.(map[string]interface{})
+	valMap, valIsObject := val.(map[string]interface{})
+	if !existingIsObject || !valIsObject {
+		return fmt.Errorf("%q is set more than once", key)
+	}
+	for _, k := range sortedKeys(valMap) {
+		if err := mergeNested(existingMap, k, valMap[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Copy the objects and arrays of a JSON value, so that the copy can be changed without changing val
+func copyValue(val interface{}) interface{} {
+	switch typed := val.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(typed))
+		for k, v := range typed {
+			copied[k] = copyValue(v)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(typed))
+		for i, v := range typed {
+			copied[i] = copyValue(v)
+		}
+		return copied
+	}
+	return val
+}
+
+func sortedKeys(obj map[string]interface{}) []string {
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,86 @@
+package gocbexample
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestNestDoc(t *testing.T) {
+
+	tests := []struct {
+		doc      string
+		maxDepth int
+		want     string
+		wantErr  bool
+	}{
+		{doc: `{"geo.lat": 1.5, "geo.lon": 2.5, "name": "x"}`, want: `{"geo": {"lat": 1.5, "lon": 2.5}, "name": "x"}`},
+		{doc: `{"a.b.c": 1}`, maxDepth: 1, want: `{"a": {"b.c": 1}}`},
+		{doc: `{"geo": {"lat": 1.5}, "geo.lon": 2.5}`, want: `{"geo": {"lat": 1.5, "lon": 2.5}}`},
+		{doc: `{"geo": {"lat": {"deg": 1}}, "geo.lat.min": 2}`, want: `{"geo": {"lat": {"deg": 1, "min": 2}}}`},
+		{doc: `{"geo": 1, "geo.lat": 1.5}`, wantErr: true},
+		{doc: `{"geo": {"lat": 1}, "geo.lat": 1.5}`, wantErr: true},
+		{doc: `{"geo": {"lat": {"deg": 1}}, "geo.lat.deg": 2}`, wantErr: true},
+	}
+
+	for _, test := range tests {
+		var doc, want map[string]interface{}
+		mustUnmarshal(t, test.doc, &doc)
+		original, _ := json.Marshal(doc)
+
+		got, err := NestDoc(doc, ".", test.maxDepth)
+		if after, _ := json.Marshal(doc); string(after) != string(original) {
+			t.Errorf("NestDoc(%v) modified the doc: %s", test.doc, after)
+		}
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("NestDoc(%v) = %v, want an error", test.doc, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("NestDoc(%v) failed: %v", test.doc, err)
+			continue
+		}
+		mustUnmarshal(t, test.want, &want)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("NestDoc(%v) = %v, want %v", test.doc, got, want)
+		}
+	}
+}
+
+func TestFlattenDoc(t *testing.T) {
+
+	tests := []struct {
+		doc      string
+		maxDepth int
+		want     string
+		wantErr  bool
+	}{
+		{doc: `{"geo": {"lat": 1.5, "lon": 2.5}, "tags": ["a"]}`, want: `{"geo.lat": 1.5, "geo.lon": 2.5, "tags": ["a"]}`},
+		{doc: `{"a": {"b": {"c": 1}}}`, maxDepth: 1, want: `{"a.b": {"c": 1}}`},
+		{doc: `{"a": {}}`, want: `{"a": {}}`},
+		{doc: `{"geo": {"lat": 1}, "geo.lat": 1.5}`, wantErr: true},
+	}
+
+	for _, test := range tests {
+		var doc, want map[string]interface{}
+		mustUnmarshal(t, test.doc, &doc)
+
+		got, err := FlattenDoc(doc, ".", test.maxDepth)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("FlattenDoc(%v) = %v, want an error", test.doc, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("FlattenDoc(%v) failed: %v", test.doc, err)
+			continue
+		}
+		mustUnmarshal(t, test.want, &want)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("FlattenDoc(%v) = %v, want %v", test.doc, got, want)
+		}
+	}
+}
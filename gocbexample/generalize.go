@@ -0,0 +1,280 @@
+package gocbexample
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Value that mapped quasi-identifiers without an entry in the mapping are generalized to, unless configured otherwise
+const defaultUnmappedValue = "other"
+
+// Generalizes the values of one quasi-identifier field.  Exactly one of PrefixLength, BandWidth and
+// MappingFile must be set.
+type GeneralizationRule struct {
+
+	// JSONPath of the field, eg "$.zip"
+	Path string `json:"path"`
+
+	// Keep only the first PrefixLength characters, eg 3 turns the zip code "94105" into "941"
+	PrefixLength int `json:"prefixLength,omitempty"`
+
+	// Replace numbers with the band of this width they fall in, eg 10 turns the age 37 into "30-39"
+	BandWidth int `json:"bandWidth,omitempty"`
+
+	// JSON file mapping values to generalized values, eg {"San Francisco": "California"}
+	MappingFile string `json:"mappingFile,omitempty"`
+
+	// What values missing from the mapping file become.  Defaults to "other"
+	Unmapped string `json:"unmapped,omitempty"`
+}
+
+// Which quasi-identifiers to generalize, and the group size the dataset should reach
+type GeneralizationConfig struct {
+	Rules []GeneralizationRule `json:"rules"`
+
+	// The k of k-anonymity: every combination of generalized quasi-identifiers should be shared by at
+	// least this many docs.  Smaller groups are reported (and logged as a warning).  0 disables the check
+	MinGroupSize int `json:"minGroupSize,omitempty"`
+}
+
+type compiledGeneralizationRule struct {
+	GeneralizationRule
+	path    JSONPath
+	mapping map[string]interface{}
+}
+
+// A pre-insert stage that generalizes quasi-identifier fields (zip codes, ages, cities..) so that docs
+// can't be singled out by combining them, and measures the resulting group sizes
+type Generalizer struct {
+	config GeneralizationConfig
+	rules  []compiledGeneralizationRule
+
+	mutex       sync.Mutex
+	generalized int
+	ruleHits    []bool
+
+	// Number of docs per combination of generalized values
+	groups map[string]int
+}
+
+// Summary of what a Generalizer did.  A group is the set of docs sharing the same generalized
+// quasi-identifiers, and the smallest group is the k the dataset actually achieves.
+type GeneralizationReport struct {
+
+	// Number of values generalized
+	Values int
+
+	Groups       int
+	MinGroupSize int
+
+	// Groups (and the docs in them) smaller than the configured minGroupSize
+	SmallGroups     int
+	DocsSmallGroups int
+}
+
+func NewGeneralizer(config GeneralizationConfig) (*Generalizer, error) {
+
+	if len(config.Rules) == 0 {
+		return nil, fmt.Errorf("Generalization has no rules")
+	}
+	if config.MinGroupSize < 0 {
+		return nil, fmt.Errorf("Invalid generalization minGroupSize: %v", config.MinGroupSize)
+	}
+
+	g := &Generalizer{
+		config:   config,
+		ruleHits: make([]bool, len(config.Rules)),
+		groups:   map[string]int{},
+	}
+	for i, rule := range config.Rules {
+		compiled, err := compileGeneralizationRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid generalization rule %v.  Err: %v", i, err)
+		}
+		g.rules = append(g.rules, compiled)
+	}
+	return g, nil
+}
+
+func compileGeneralizationRule(rule GeneralizationRule) (compiledGeneralizationRule, error) {
+
+	compiled := compiledGeneralizationRule{GeneralizationRule: rule}
+
+	generalizations := 0
+	for _, set := range []bool{rule.PrefixLength != 0, rule.BandWidth != 0, rule.MappingFile != ""} {
+		if set {
+			generalizations += 1
+		}
+	}
+	if generalizations != 1 {
+		return compiled, fmt.Errorf("Exactly one of prefixLength, bandWidth and mappingFile must be set")
+	}
+	if rule.PrefixLength < 0 || rule.BandWidth < 0 {
+		return compiled, fmt.Errorf("prefixLength and bandWidth must be positive")
+	}
+
+	path, err := ParseJSONPath(rule.Path)
+	if err != nil {
+		return compiled, err
+	}
+	compiled.path = path
+
+	if rule.MappingFile != "" {
+		mappingBytes, err := ioutil.ReadFile(rule.MappingFile)
+		if err != nil {
+			return compiled, err
+		}
+		if err := json.Unmarshal(mappingBytes, &compiled.mapping); err != nil {
+			return compiled, fmt.Errorf("Error parsing mapping file: %v.  Err: %v", rule.MappingFile, err)
+		}
+		if compiled.Unmapped == "" {
+			compiled.Unmapped = defaultUnmappedValue
+		}
+	}
+
+	return compiled, nil
+}
+
+func (g *Generalizer) Transform(input DocProcessorInput) (output DocProcessorInput, err error) {
+
+	output = DocProcessorInput{
+		DocIds: input.DocIds,
+		Docs:   make([]interface{}, len(input.Docs)),
+	}
+	generalized := 0
+	ruleHits := make([]bool, len(g.rules))
+	groups := map[string]int{}
+	for i, doc := range input.Docs {
+
+		// The generalized values of each rule make up the doc's group
+		quasiIdentifiers := make([][]interface{}, len(g.rules))
+		for j, rule := range g.rules {
+			doc = rule.path.Update(doc, func(val interface{}) interface{} {
+				generalizedVal, ok := rule.generalize(val)
+				if ok {
+					generalized += 1
+					ruleHits[j] = true
+				}
+				quasiIdentifiers[j] = append(quasiIdentifiers[j], generalizedVal)
+				return generalizedVal
+			})
+		}
+		output.Docs[i] = doc
+
+		group, err := json.Marshal(quasiIdentifiers)
+		if err != nil {
+			return output, err
+		}
+		groups[string(group)] += 1
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.generalized += generalized
+	for i, hit := range ruleHits {
+		g.ruleHits[i] = g.ruleHits[i] || hit
+	}
+	for group, docs := range groups {
+		g.groups[group] += docs
+	}
+
+	return output, nil
+}
+
+// Generalize a single value.  Returns false if it isn't a value the rule applies to (eg null), in which
+// case it's left as is.
+func (r compiledGeneralizationRule) generalize(val interface{}) (interface{}, bool) {
+
+	switch {
+	case r.PrefixLength > 0:
+		str, ok := scalarString(val)
+		if !ok {
+			return val, false
+		}
+		runes := []rune(str)
+		if len(runes) > r.PrefixLength {
+			runes = runes[:r.PrefixLength]
+		}
+		return string(runes), true
+	case r.BandWidth > 0:
+		var number float64
+		switch v := val.(type) {
+		case float64:
+			number = v
+		case string:
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				return val, false
+			}
+			number = parsed
+		default:
+			return val, false
+		}
+		lower := int(math.Floor(number/float64(r.BandWidth))) * r.BandWidth
+		return fmt.Sprintf("%d-%d", lower, lower+r.BandWidth-1), true
+	default:
+		str, ok := scalarString(val)
+		if !ok {
+			return val, false
+		}
+		if mapped, ok := r.mapping[str]; ok {
+			return mapped, true
+		}
+		return r.Unmapped, true
+	}
+}
+
+// The string form of a string or number
+func scalarString(val interface{}) (string, bool) {
+	switch v := val.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	default:
+		return "", false
+	}
+}
+
+func (g *Generalizer) Report() GeneralizationReport {
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	report := GeneralizationReport{Values: g.generalized, Groups: len(g.groups)}
+	sizes := make([]int, 0, len(g.groups))
+	for _, docs := range g.groups {
+		sizes = append(sizes, docs)
+	}
+	sort.Ints(sizes)
+	if len(sizes) > 0 {
+		report.MinGroupSize = sizes[0]
+	}
+	for _, size := range sizes {
+		if size >= g.config.MinGroupSize {
+			break
+		}
+		report.SmallGroups += 1
+		report.DocsSmallGroups += size
+	}
+	return report
+}
+
+// The rules that never matched a value, which usually means they have a typo
+func (g *Generalizer) UnusedRules() []string {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	var unused []string
+	for i, hit := range g.ruleHits {
+		if !hit {
+			unused = append(unused, fmt.Sprintf("generalization.rules[%v] %v", i, g.rules[i].path))
+		}
+	}
+	return unused
+}
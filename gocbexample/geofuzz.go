@@ -0,0 +1,181 @@
+package gocbexample
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Meters per degree of latitude (and of longitude at the equator)
+const metersPerDegree = 111320.0
+
+// How to fuzz coordinates
+type GeoFuzzConfig struct {
+
+	// JSONPaths of the objects holding the coordinates.  Defaults to ["$.geo"], as in travel-sample
+	Paths []string `json:"paths,omitempty"`
+
+	// Coordinate field names within those objects.  Default to "lat" and "lon"
+	LatField string `json:"latField,omitempty"`
+	LonField string `json:"lonField,omitempty"`
+
+	// Move each point to a random point up to this far away
+	RadiusMeters float64 `json:"radiusMeters,omitempty"`
+
+	// Round coordinates to this many decimal places (2 is ~1km, 3 is ~100m).  Negative disables rounding
+	Precision *int `json:"precision,omitempty"`
+
+	// Seed of the random offsets.  The offset for a doc depends only on the seed and the doc id, so
+	// rerunning with the same seed gives the same result.  Generated if 0
+	Seed int64 `json:"seed,omitempty"`
+}
+
+// A pre-insert stage that perturbs lat/lon coordinates within a radius and/or truncates their precision,
+// so anonymized datasets can't pinpoint real addresses while remaining usable for geo queries
+type GeoFuzzer struct {
+	config GeoFuzzConfig
+	paths  []JSONPath
+
+	mutex    sync.Mutex
+	points   int
+	pathHits []bool
+}
+
+// Summary of what a GeoFuzzer did
+type GeoFuzzReport struct {
+	// Number of coordinate pairs fuzzed
+	Points int
+}
+
+func NewGeoFuzzer(config GeoFuzzConfig) (*GeoFuzzer, error) {
+
+	if len(config.Paths) == 0 {
+		config.Paths = []string{"$.geo"}
+	}
+	if config.LatField == "" {
+		config.LatField = "lat"
+	}
+	if config.LonField == "" {
+		config.LonField = "lon"
+	}
+	if config.RadiusMeters < 0 {
+		return nil, fmt.Errorf("Invalid geo fuzz radius: %v", config.RadiusMeters)
+	}
+	if config.RadiusMeters == 0 && (config.Precision == nil || *config.Precision < 0) {
+		return nil, fmt.Errorf("Geo fuzzing needs a radiusMeters and/or a precision")
+	}
+	if config.Seed == 0 {
+		config.Seed = time.Now().UnixNano()
+	}
+
+	paths, err := ParseJSONPaths(config.Paths)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GeoFuzzer{config: config, paths: paths, pathHits: make([]bool, len(paths))}, nil
+}
+
+func (g *GeoFuzzer) Transform(input DocProcessorInput) (output DocProcessorInput, err error) {
+
+	output = DocProcessorInput{
+		DocIds: input.DocIds,
+		Docs:   make([]interface{}, len(input.Docs)),
+	}
+	points := 0
+	pathHits := make([]bool, len(g.paths))
+	for i, doc := range input.Docs {
+		rng := rand.New(rand.NewSource(docRandSeed(g.config.Seed, input.DocIds[i])))
+		for j, path := range g.paths {
+			doc = path.Update(doc, func(val interface{}) interface{} {
+				fuzzed, ok := g.fuzzPoint(val, rng)
+				if ok {
+					points += 1
+					pathHits[j] = true
+				}
+				return fuzzed
+			})
+		}
+		output.Docs[i] = doc
+	}
+
+	g.mutex.Lock()
+	g.points += points
+	for i, hit := range pathHits {
+		g.pathHits[i] = g.pathHits[i] || hit
+	}
+	g.mutex.Unlock()
+
+	return output, nil
+}
+
+// Fuzz the coordinates of a geo object.  Returns false if it isn't an object with numeric coordinates.
+func (g *GeoFuzzer) fuzzPoint(val interface{}, rng *rand.Rand) (interface{}, bool) {
+
+	point, ok := val.(map[string]interface{})
+	if !ok {
+		return val, false
+	}
+	lat, ok := point[g.config.LatField].(float64)
+	if !ok {
+		return val, false
+	}
+	lon, ok := point[g.config.LonField].(float64)
+	if !ok {
+		return val, false
+	}
+
+	if g.config.RadiusMeters > 0 {
+		// Uniformly distributed over the disc, hence the square root
+		distance := g.config.RadiusMeters * math.Sqrt(rng.Float64())
+		bearing := 2 * math.Pi * rng.Float64()
+		lat += distance * math.Cos(bearing) / metersPerDegree
+		lon += distance * math.Sin(bearing) / (metersPerDegree * math.Max(math.Cos(lat*math.Pi/180), 0.01))
+		lat = math.Max(-90, math.Min(90, lat))
+		if lon > 180 {
+			lon -= 360
+		} else if lon < -180 {
+			lon += 360
+		}
+	}
+
+	if g.config.Precision != nil && *g.config.Precision >= 0 {
+		scale := math.Pow(10, float64(*g.config.Precision))
+		lat = math.Round(lat*scale) / scale
+		lon = math.Round(lon*scale) / scale
+	}
+
+	fuzzed := make(map[string]interface{}, len(point))
+	for k, v := range point {
+		fuzzed[k] = v
+	}
+	fuzzed[g.config.LatField] = lat
+	fuzzed[g.config.LonField] = lon
+	return fuzzed, true
+}
+
+// The seed of the random offsets, which regenerates them exactly if configured on another run
+func (g *GeoFuzzer) Seed() int64 {
+	return g.config.Seed
+}
+
+func (g *GeoFuzzer) Report() GeoFuzzReport {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return GeoFuzzReport{Points: g.points}
+}
+
+// The paths that never matched a geo object, which usually means they have a typo
+func (g *GeoFuzzer) UnusedRules() []string {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	var unused []string
+	for i, hit := range g.pathHits {
+		if !hit {
+			unused = append(unused, fmt.Sprintf("geoFuzz.paths[%v] %v", i, g.paths[i]))
+		}
+	}
+	return unused
+}
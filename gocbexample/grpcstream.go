@@ -0,0 +1,176 @@
+package gocbexample
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/couchbaselabs/gocb-example/streamdocs"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Connect to a StreamDocs server, over TLS with the system's root CAs if useTLS is set, otherwise in plaintext
+func dialStreamDocs(address string, useTLS bool) (*grpc.ClientConn, error) {
+	creds := insecure.NewCredentials()
+	if useTLS {
+		creds = credentials.NewTLS(&tls.Config{})
+	}
+	conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("Error connecting to StreamDocs server: %v.  Err: %v", address, err)
+	}
+	return conn, nil
+}
+
+// A Source that reads docs from the ReadDocs call of a StreamDocs gRPC server, see streamdocs/streamdocs.proto.
+// Each batch the server streams is passed to the DocProcessor as is.
+type GRPCSource struct {
+	Address string
+	TLS     bool
+
+	// Passed to the server, eg for it to track what each job has read
+	JobId string
+}
+
+func NewGRPCSource(address string, useTLS bool, jobId string) *GRPCSource {
+	return &GRPCSource{Address: address, TLS: useTLS, JobId: jobId}
+}
+
+func (s *GRPCSource) Name() string {
+	return fmt.Sprintf("grpc:%v", s.Address)
+}
+
+func (s *GRPCSource) ForEachDoc(docProcessor DocProcessor) error {
+
+	conn, err := dialStreamDocs(s.Address, s.TLS)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Cancelling the call when the DocProcessor fails tells the server to stop streaming
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := streamdocs.NewStreamDocsClient(conn).ReadDocs(ctx, &streamdocs.ReadDocsRequest{JobId: s.JobId})
+	if err != nil {
+		return fmt.Errorf("Error calling ReadDocs on StreamDocs server: %v.  Err: %v", s.Address, err)
+	}
+
+	for {
+		batch, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return newDocError(PhaseSourceRead, "", fmt.Errorf("Error reading docs from StreamDocs server: %v.  Err: %w", s.Address, err))
+		}
+
+		input := DocProcessorInput{
+			DocIds: make([]string, len(batch.Docs)),
+			Docs:   make([]interface{}, len(batch.Docs)),
+		}
+		for i, doc := range batch.Docs {
+			if doc.Id == "" {
+				return fmt.Errorf("StreamDocs server: %v sent a doc with no id", s.Address)
+			}
+			input.DocIds[i] = doc.Id
+			if err := json.Unmarshal(doc.Json, &input.Docs[i]); err != nil {
+				return newDocError(PhaseSourceRead, doc.Id, fmt.Errorf("StreamDocs server: %v sent a doc that isn't valid JSON.  Err: %w", s.Address, err))
+			}
+		}
+		if len(input.DocIds) == 0 {
+			continue
+		}
+		if err := docProcessor(input.DocIds, input.Docs); err != nil {
+			return err
+		}
+	}
+}
+
+// A Sink that writes docs to the WriteDocs call of a StreamDocs gRPC server, see streamdocs/streamdocs.proto.
+// Each WriteDocs sends a batch and waits for the server to ack it, so the copy runs no faster than the server writes.
+type GRPCSink struct {
+	Address string
+
+	mutex  sync.Mutex
+	conn   *grpc.ClientConn
+	cancel context.CancelFunc
+	stream streamdocs.StreamDocs_WriteDocsClient
+}
+
+// Connect to the server and start the WriteDocs call
+func NewGRPCSink(address string, useTLS bool) (*GRPCSink, error) {
+
+	conn, err := dialStreamDocs(address, useTLS)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := streamdocs.NewStreamDocsClient(conn).WriteDocs(ctx)
+	if err != nil {
+		cancel()
+		conn.Close()
+		return nil, fmt.Errorf("Error calling WriteDocs on StreamDocs server: %v.  Err: %v", address, err)
+	}
+	return &GRPCSink{Address: address, conn: conn, cancel: cancel, stream: stream}, nil
+}
+
+func (s *GRPCSink) Name() string {
+	return fmt.Sprintf("grpc:%v", s.Address)
+}
+
+func (s *GRPCSink) WriteDocs(docIds []string, docs []interface{}) error {
+
+	batch := &streamdocs.DocBatch{Docs: make([]*streamdocs.Doc, len(docIds))}
+	for i, docId := range docIds {
+		docJSON, err := json.Marshal(docs[i])
+		if err != nil {
+			return newDocError(PhaseTargetWrite, docId, err)
+		}
+		batch.Docs[i] = &streamdocs.Doc{Id: docId, Json: docJSON}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.stream.Send(batch); err != nil {
+		// The server ended the call, and Recv has its status
+		if _, recvErr := s.stream.Recv(); recvErr != nil {
+			err = recvErr
+		}
+		return newDocError(PhaseTargetWrite, "", fmt.Errorf("Error sending docs to StreamDocs server: %v.  Err: %w", s.Address, err))
+	}
+	ack, err := s.stream.Recv()
+	if err != nil {
+		return newDocError(PhaseTargetWrite, "", fmt.Errorf("Error writing docs to StreamDocs server: %v.  Err: %w", s.Address, err))
+	}
+	if ack.Docs != int64(len(docIds)) {
+		return newDocError(PhaseTargetWrite, "", fmt.Errorf("StreamDocs server: %v acked %v docs of a batch of %v", s.Address, ack.Docs, len(docIds)))
+	}
+	return nil
+}
+
+// End the WriteDocs call, waiting for the server to finish it, and disconnect
+func (s *GRPCSink) Close() error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	defer s.conn.Close()
+	defer s.cancel()
+
+	if err := s.stream.CloseSend(); err != nil {
+		return err
+	}
+	if _, err := s.stream.Recv(); err != io.EOF {
+		if err == nil {
+			err = fmt.Errorf("an ack of no batch")
+		}
+		return fmt.Errorf("Error ending WriteDocs on StreamDocs server: %v.  Err: %v", s.Address, err)
+	}
+	return nil
+}
@@ -0,0 +1,294 @@
+package gocbexample
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// How far the monitor may slow a job down: concurrency is halved and byte rates are divided by two
+// per level, down to a minimum, and each batch is delayed by healthBatchDelay times 2^level - 1
+const (
+	maxHealthSlowdownLevel = 4
+
+	// The delay before each batch at level 1.  Unlike the concurrency and byte rates, which are no-ops with
+	// one worker or no rate limit, the delay slows every job down
+	healthBatchDelay = 250 * time.Millisecond
+
+	// Number of consecutive healthy polls before speeding back up a level
+	healthyPollsToRecover = 3
+)
+
+// Limits on the source/target bucket stats.  Exceeding any of them counts as pressure.  Zero disables a check.
+type HealthThresholds struct {
+
+	// Items waiting to be written to disk (ep_queue_size + ep_flusher_todo)
+	MaxDiskWriteQueue float64
+
+	// Free memory below the high water mark, as a percent of the high water mark
+	MinMemoryHeadroomPercent float64
+
+	// Average wait for background fetches from disk, in microseconds (avg_bg_wait_time)
+	MaxBackgroundFetchMicros float64
+}
+
+// Polls cluster bucket stats while a job runs, and slows the job down (fewer concurrent batches,
+// lower byte rates, a delay before each batch) while the source or target bucket is under pressure.  Speed is restored
+// a level at a time once the stats recover.
+type HealthMonitor struct {
+	app        *ExampleApp
+	interval   time.Duration
+	thresholds HealthThresholds
+	gate       *concurrencyGate
+	stop       chan struct{}
+	done       chan struct{}
+
+	mutex        sync.Mutex
+	level        int
+	batchDelay   time.Duration
+	healthyPolls int
+	slowdowns    int
+	lastPressure []string
+}
+
+// Summary of what the health monitor did, for the job report
+type HealthReport struct {
+	Level        int
+	Slowdowns    int
+	BatchDelay   string   `json:",omitempty"`
+	LastPressure []string `json:",omitempty"`
+}
+
+// A semaphore whose limit can be changed while goroutines are waiting on it
+type concurrencyGate struct {
+	mutex  sync.Mutex
+	cond   *sync.Cond
+	limit  int
+	active int
+}
+
+func newConcurrencyGate(limit int) *concurrencyGate {
+	gate := &concurrencyGate{limit: limit}
+	gate.cond = sync.NewCond(&gate.mutex)
+	return gate
+}
+
+func (g *concurrencyGate) acquire() {
+	g.mutex.Lock()
+	for g.active >= g.limit {
+		g.cond.Wait()
+	}
+	g.active += 1
+	g.mutex.Unlock()
+}
+
+func (g *concurrencyGate) release() {
+	g.mutex.Lock()
+	g.active -= 1
+	g.mutex.Unlock()
+	g.cond.Broadcast()
+}
+
+func (g *concurrencyGate) setLimit(limit int) {
+	g.mutex.Lock()
+	g.limit = limit
+	g.mutex.Unlock()
+	g.cond.Broadcast()
+}
+
+// Start polling bucket stats every interval.  Does nothing if interval is 0.
+func (e *ExampleApp) StartHealthMonitor(interval time.Duration, thresholds HealthThresholds) {
+
+	if interval <= 0 {
+		return
+	}
+
+	e.healthMonitor = &HealthMonitor{
+		app:        e,
+		interval:   interval,
+		thresholds: thresholds,
+		gate:       newConcurrencyGate(e.Workers),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go e.healthMonitor.run()
+
+	e.logf("Monitoring cluster health every %v", interval)
+}
+
+// Stop polling and return what the monitor did.  Returns nil if the monitor wasn't started.
+func (e *ExampleApp) StopHealthMonitor() *HealthReport {
+
+	if e.healthMonitor == nil {
+		return nil
+	}
+	close(e.healthMonitor.stop)
+	<-e.healthMonitor.done
+
+	m := e.healthMonitor
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	report := &HealthReport{
+		Level:        m.level,
+		Slowdowns:    m.slowdowns,
+		LastPressure: m.lastPressure,
+	}
+	if m.batchDelay > 0 {
+		report.BatchDelay = m.batchDelay.String()
+	}
+	return report
+}
+
+func (m *HealthMonitor) run() {
+
+	defer close(m.done)
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			m.poll()
+		}
+	}
+}
+
+// Check the stats of both buckets and move the slowdown level up or down
+func (m *HealthMonitor) poll() {
+
+	var pressure []string
+	for _, bucketName := range []string{m.app.SourceBucketSpec.Name, m.app.TargetBucketSpec.Name} {
+		bucketPressure, err := m.bucketPressure(bucketName)
+		if err != nil {
+			// Don't slow down (or speed up) on a failed poll, the cluster may just be busy
+			m.app.logf("Error polling stats for bucket: %v.  Err: %v", bucketName, err)
+			return
+		}
+		pressure = append(pressure, bucketPressure...)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	level := m.level
+	if len(pressure) > 0 {
+		m.lastPressure = pressure
+		m.healthyPolls = 0
+		if level < maxHealthSlowdownLevel {
+			level += 1
+			m.slowdowns += 1
+		}
+	} else {
+		m.healthyPolls += 1
+		if m.healthyPolls >= healthyPollsToRecover && level > 0 {
+			level -= 1
+			m.healthyPolls = 0
+		}
+	}
+
+	if level != m.level {
+		if level > m.level {
+			m.app.logf("Cluster under pressure (%v), slowing down to level %v", pressure, level)
+		} else {
+			m.app.logf("Cluster recovered, speeding up to level %v", level)
+		}
+		m.level = level
+		m.apply()
+	}
+}
+
+// Set the concurrency, byte rates and batch delay for the current level.  Must be called with the mutex held
+func (m *HealthMonitor) apply() {
+
+	divisor := 1 << uint(m.level)
+	m.batchDelay = healthBatchDelay * time.Duration(divisor-1)
+
+	limit := m.app.Workers / divisor
+	if limit < 1 {
+		limit = 1
+	}
+	m.gate.setLimit(limit)
+
+	m.app.readLimiter.SetBytesPerSecond(m.app.ReadBytesPerSecond / int64(divisor))
+	m.app.writeLimiter.SetBytesPerSecond(m.app.WriteBytesPerSecond / int64(divisor))
+}
+
+// Bucket stats response.  Each stat is a series of samples, the last being the most recent.
+type bucketStats struct {
+	Op struct {
+		Samples map[string][]float64 `json:"samples"`
+	} `json:"op"`
+}
+
+func (s bucketStats) latest(name string) (float64, bool) {
+	samples := s.Op.Samples[name]
+	if len(samples) == 0 {
+		return 0, false
+	}
+	return samples[len(samples)-1], true
+}
+
+// Get the thresholds a bucket is currently exceeding
+func (m *HealthMonitor) bucketPressure(bucketName string) (pressure []string, err error) {
+
+	stats := bucketStats{}
+	if err := m.app.managementGet(fmt.Sprintf("/pools/default/buckets/%v/stats?zoom=minute", url.PathEscape(bucketName)), &stats); err != nil {
+		return nil, err
+	}
+
+	if m.thresholds.MaxDiskWriteQueue > 0 {
+		queueSize, _ := stats.latest("ep_queue_size")
+		flusherTodo, _ := stats.latest("ep_flusher_todo")
+		if diskWriteQueue := queueSize + flusherTodo; diskWriteQueue > m.thresholds.MaxDiskWriteQueue {
+			pressure = append(pressure, fmt.Sprintf("%v disk write queue: %v", bucketName, diskWriteQueue))
+		}
+	}
+
+	if m.thresholds.MinMemoryHeadroomPercent > 0 {
+		memUsed, ok1 := stats.latest("mem_used")
+		highWater, ok2 := stats.latest("ep_mem_high_wat")
+		if ok1 && ok2 && highWater > 0 {
+			if headroom := 100 * (highWater - memUsed) / highWater; headroom < m.thresholds.MinMemoryHeadroomPercent {
+				pressure = append(pressure, fmt.Sprintf("%v memory headroom: %.1f%%", bucketName, headroom))
+			}
+		}
+	}
+
+	if m.thresholds.MaxBackgroundFetchMicros > 0 {
+		if bgWait, ok := stats.latest("avg_bg_wait_time"); ok && bgWait > m.thresholds.MaxBackgroundFetchMicros {
+			pressure = append(pressure, fmt.Sprintf("%v background fetch wait: %vus", bucketName, bgWait))
+		}
+	}
+
+	return pressure, nil
+}
+
+// Wrap a DocProcessor so that the number of batches processed at once, and how soon each starts, are limited by
+// the health monitor
+func (e *ExampleApp) healthGate(docProcessor DocProcessor) DocProcessor {
+	if e.healthMonitor == nil || docProcessor == nil {
+		return docProcessor
+	}
+	m := e.healthMonitor
+	return func(docIds []string, docs []interface{}) error {
+		m.gate.acquire()
+		defer m.gate.release()
+
+		// Delay while holding the slot, so that fewer slots also means fewer delayed batches at once
+		if delay := m.delay(); delay > 0 {
+			time.Sleep(delay)
+		}
+		return docProcessor(docIds, docs)
+	}
+}
+
+// The delay before each batch at the current level
+func (m *HealthMonitor) delay() time.Duration {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.batchDelay
+}
@@ -0,0 +1,150 @@
+package gocbexample
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// Module path of the Couchbase Go SDK, used to look up its version in the build info
+const gocbModulePath = "github.com/couchbase/gocb/v2"
+
+// An entry from /pools/default
+type poolNodes struct {
+	Nodes []ClusterNode `json:"nodes"`
+}
+
+// A node of the cluster and the Couchbase Server version it runs
+type ClusterNode struct {
+	Hostname string   `json:"hostname"`
+	Version  string   `json:"version"`
+	Services []string `json:"services"`
+}
+
+// The bucket capabilities that features of this tool depend on
+type BucketInfo struct {
+	Name         string   `json:"name"`
+	Capabilities []string `json:"bucketCapabilities"`
+	Xattrs       bool     `json:"xattrs"`
+	Collections  bool     `json:"collections"`
+}
+
+// The server version of the cluster and the capabilities of the source and target buckets
+type ClusterInfo struct {
+
+	// The lowest version of any node, which is the version the cluster behaves as during an upgrade
+	Version string `json:"version"`
+
+	Nodes   []ClusterNode `json:"nodes"`
+	Buckets []BucketInfo  `json:"buckets"`
+}
+
+// The version of gocb this binary was built with, or "unknown" if it wasn't built as a module
+func sdkVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range info.Deps {
+			if dep.Path == gocbModulePath {
+				if dep.Replace != nil {
+					return dep.Replace.Version
+				}
+				return dep.Version
+			}
+		}
+	}
+	return "unknown"
+}
+
+// The numeric major.minor.patch of a server version like "6.5.1-6299-enterprise"
+func parseServerVersion(version string) [3]int {
+	parsed := [3]int{}
+	numbers := strings.SplitN(strings.SplitN(version, "-", 2)[0], ".", 3)
+	for i, number := range numbers {
+		parsed[i], _ = strconv.Atoi(number)
+	}
+	return parsed
+}
+
+// Is server version a older than b?
+func serverVersionLess(a, b string) bool {
+	parsedA, parsedB := parseServerVersion(a), parseServerVersion(b)
+	for i := range parsedA {
+		if parsedA[i] != parsedB[i] {
+			return parsedA[i] < parsedB[i]
+		}
+	}
+	return false
+}
+
+// Get the cluster version and the source and target bucket capabilities from the management REST API
+func (e *ExampleApp) ClusterInfo() (*ClusterInfo, error) {
+
+	pool := poolNodes{}
+	if err := e.managementGet("/pools/default", &pool); err != nil {
+		return nil, fmt.Errorf("Error getting cluster nodes.  Err: %v", err)
+	}
+
+	info := &ClusterInfo{
+		Nodes: pool.Nodes,
+	}
+	for _, node := range pool.Nodes {
+		if info.Version == "" || serverVersionLess(node.Version, info.Version) {
+			info.Version = node.Version
+		}
+	}
+
+	for _, spec := range []BucketSpec{e.SourceBucketSpec, e.TargetBucketSpec} {
+		bucket := BucketInfo{}
+		if err := e.managementGet("/pools/default/buckets/"+url.PathEscape(spec.Name), &bucket); err != nil {
+			return nil, fmt.Errorf("Error getting bucket: %v.  Err: %v", spec.Name, err)
+		}
+		for _, capability := range bucket.Capabilities {
+			switch capability {
+			case "xattr":
+				bucket.Xattrs = true
+			case "collections":
+				bucket.Collections = true
+			}
+		}
+		info.Buckets = append(info.Buckets, bucket)
+	}
+
+	return info, nil
+}
+
+// Print the tool and SDK versions, then the cluster version and bucket capabilities if the cluster is reachable
+func setupInfo(flags *flag.FlagSet) func(job *Job) error {
+
+	configPath := flags.String("config", "", "Path to a JSON config file with the cluster and buckets to describe")
+
+	return func(job *Job) error {
+
+		if err := setupVersion(flags)(nil); err != nil {
+			return err
+		}
+
+		config, err := LoadConfig(*configPath)
+		if err != nil {
+			return err
+		}
+		e := NewExample(config.Source, config.Target)
+		e.ConnSpec = config.ConnSpec
+
+		info, err := e.ClusterInfo()
+		if err != nil {
+			fmt.Printf("cluster:    unavailable (%v)\n", err)
+			return nil
+		}
+		fmt.Printf("cluster:    %v\n", info.Version)
+		for _, node := range info.Nodes {
+			fmt.Printf("  node %v: %v [%v]\n", node.Hostname, node.Version, strings.Join(node.Services, ","))
+		}
+		for _, bucket := range info.Buckets {
+			fmt.Printf("bucket %v: xattrs=%v collections=%v\n", bucket.Name, bucket.Xattrs, bucket.Collections)
+		}
+		return nil
+	}
+
+}
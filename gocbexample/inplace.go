@@ -0,0 +1,282 @@
+package gocbexample
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// Returned (wrapped) for a doc that changed between being read and being written back in place.  The doc is
+// left as it is, and listed in the sink's conflicts, so a rerun can pick it up.
+var ErrInPlaceConflict = errors.New("doc changed since it was read")
+
+// The CAS of each doc when it was read, so that writing it back in place fails if it has changed since
+type readCasTracker struct {
+	mutex sync.Mutex
+	cas   map[string]gocb.Cas
+
+	// Docs buffered for writing, whose CAS is kept after the page they were read in has been processed
+	held map[string]bool
+}
+
+func newReadCasTracker() *readCasTracker {
+	return &readCasTracker{cas: map[string]gocb.Cas{}, held: map[string]bool{}}
+}
+
+func (t *readCasTracker) record(docId string, cas gocb.Cas) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.cas[docId] = cas
+}
+
+func (t *readCasTracker) has(docId string) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	_, ok := t.cas[docId]
+	return ok
+}
+
+// Get and forget the CAS a doc was read with
+func (t *readCasTracker) take(docId string) (gocb.Cas, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	cas, ok := t.cas[docId]
+	delete(t.cas, docId)
+	delete(t.held, docId)
+	return cas, ok
+}
+
+// Keep the CAS of docs buffered for writing until they're written.  Safe to call on a nil tracker
+func (t *readCasTracker) hold(docIds []string) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for _, docId := range docIds {
+		t.held[docId] = true
+	}
+}
+
+// Forget the CAS of docs that won't be written, unless they're buffered for writing.  Safe to call on a nil
+// tracker
+func (t *readCasTracker) forget(docIds []string) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for _, docId := range docIds {
+		if !t.held[docId] {
+			delete(t.cas, docId)
+		}
+	}
+}
+
+// Wrap the doc processor of a scan of the source bucket so that, once each page has been processed, the CAS of
+// its docs that were neither written nor buffered, eg because a filter or transform dropped them, is forgotten.
+// Otherwise the tracker would grow with every doc dropped over the scan.
+func (e *ExampleApp) forgetDroppedCas(bucket *gocb.Bucket, docProcessor DocProcessor) DocProcessor {
+	readCas := e.readCas
+	if readCas == nil || bucket != e.SourceBucket {
+		return docProcessor
+	}
+	return func(docIds []string, docs []interface{}) error {
+		defer readCas.forget(docIds)
+		return docProcessor(docIds, docs)
+	}
+}
+
+// Wrap a doc processor so that, in place, every doc it's passed has a known CAS.  Docs read via KV (views
+// scans, Analytics) had their CAS recorded by the read, whereas N1QL scans don't return an exact CAS, so
+// those docs are read again and passed on as read, along with the CAS that goes with the body.  Only used
+// while copying, when e.readCas is set.
+func (e *ExampleApp) inPlaceReads(docProcessor DocProcessor) DocProcessor {
+	if !e.InPlace {
+		return docProcessor
+	}
+	return func(docIds []string, docs []interface{}) error {
+
+		var rereadIds []string
+		for _, docId := range docIds {
+			if !e.readCas.has(docId) {
+				rereadIds = append(rereadIds, docId)
+			}
+		}
+		if len(rereadIds) == 0 {
+			return docProcessor(docIds, docs)
+		}
+
+		foundDocIds, foundDocs, err := e.fetchDocs(e.SourceBucket, rereadIds)
+		if err != nil {
+			return err
+		}
+		reread := make(map[string]interface{}, len(foundDocIds))
+		for i, docId := range foundDocIds {
+			reread[docId] = foundDocs[i]
+		}
+
+		var readDocIds []string
+		var readDocs []interface{}
+		for i, docId := range docIds {
+			doc := docs[i]
+			if e.readCas.has(docId) {
+				readDocIds = append(readDocIds, docId)
+				readDocs = append(readDocs, doc)
+				continue
+			}
+			if doc, ok := reread[docId]; ok {
+				// Otherwise the doc was deleted since the scan read it
+				readDocIds = append(readDocIds, docId)
+				readDocs = append(readDocs, doc)
+			}
+		}
+		return docProcessor(readDocIds, readDocs)
+	}
+}
+
+// A Sink that writes docs back to the bucket they were read from, replacing each only if its CAS is still the
+// one it was read with.  Docs that changed in the meantime, or were deleted, are left as they are and listed
+// in Conflicts.  Each doc keeps its expiry, read along with its CAS just before the replace.
+type InPlaceSink struct {
+	app    *ExampleApp
+	Bucket *gocb.Bucket
+
+	conflictsMutex sync.Mutex
+	conflicts      []string
+}
+
+func (e *ExampleApp) NewInPlaceSink(bucket *gocb.Bucket) *InPlaceSink {
+	return &InPlaceSink{app: e, Bucket: bucket}
+}
+
+func (s *InPlaceSink) Name() string {
+	return fmt.Sprintf("in place:%v", s.app.keyspaceName(s.Bucket))
+}
+
+func (s *InPlaceSink) WriteDocs(docIds []string, docs []interface{}) error {
+	_, err := s.WriteDocsWithResults(docIds, docs)
+	return err
+}
+
+// Replace the docs via bulk ops, keeping their expiry.  Conflicts are reported in the results, but aren't an
+// error of the batch.
+func (s *InPlaceSink) WriteDocsWithResults(docIds []string, docs []interface{}) (results []WriteResult, err error) {
+
+	results = make([]WriteResult, len(docIds))
+	if len(docIds) == 0 {
+		return results, nil
+	}
+
+	readCas := make([]gocb.Cas, len(docIds))
+	for i, docId := range docIds {
+		cas, ok := gocb.Cas(0), false
+		if s.app.readCas != nil {
+			cas, ok = s.app.readCas.take(docId)
+		}
+		if !ok {
+			return nil, newDocError(PhaseTargetWrite, docId, fmt.Errorf("Doc id: %v wasn't read from bucket: %v, so can't be written back in place.  "+
+				"Transforms that change doc ids can't run in place", docId, s.app.keyspaceName(s.Bucket)))
+		}
+		readCas[i] = cas
+	}
+
+	expiries, changed, err := s.readExpiries(docIds, readCas)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []gocb.BulkOp
+	itemIndexes := map[gocb.BulkOp]int{}
+	for i, docId := range docIds {
+		if changed[i] {
+			results[i] = WriteResult{DocId: docId, Err: newDocError(PhaseTargetWrite, docId, ErrInPlaceConflict)}
+			s.addConflict(docId)
+			continue
+		}
+		item := &gocb.ReplaceOp{ID: docId, Value: docs[i], Cas: readCas[i], Expiry: expiryDuration(expiries[i])}
+		itemIndexes[item] = i
+		items = append(items, item)
+	}
+	if len(items) == 0 {
+		return results, nil
+	}
+	collection, err := s.app.writeCollection(s.Bucket, "Writing back in place")
+	if err != nil {
+		return nil, err
+	}
+	if err := collection.Do(items, s.app.bulkOpOptions(s.Bucket)); err != nil {
+		return nil, newDocError(PhaseTargetWrite, "", err)
+	}
+
+	var firstErr error
+	for _, item := range items {
+		replaceItem := item.(*gocb.ReplaceOp)
+		i := itemIndexes[item]
+		results[i] = WriteResult{DocId: replaceItem.ID}
+		if replaceItem.Err == nil {
+			results[i].Cas = replaceItem.Result.Cas()
+			continue
+		}
+		wrappedErr := wrapGocbError(replaceItem.Err)
+		if errors.Is(wrappedErr, ErrDocExists) || errors.Is(wrappedErr, ErrDocNotFound) {
+			// A CAS mismatch matches ErrDocExists
+			results[i] = WriteResult{DocId: replaceItem.ID, Err: newDocError(PhaseTargetWrite, replaceItem.ID, fmt.Errorf("%w: %v", ErrInPlaceConflict, replaceItem.Err))}
+			s.addConflict(replaceItem.ID)
+			continue
+		}
+		results[i].Err = newDocError(PhaseTargetWrite, replaceItem.ID, replaceItem.Err)
+		if firstErr == nil {
+			firstErr = results[i].Err
+		}
+	}
+	return results, firstErr
+}
+
+// Read the expiry of each doc from the $document virtual XATTR, since a replace sets the expiry it's passed and
+// would otherwise make the doc permanent.  A doc whose CAS is no longer the one it was read with has changed, or
+// been deleted, so is flagged as changed rather than replaced.  On servers without XATTRs the expiry can't be
+// read, so is 0.
+func (s *InPlaceSink) readExpiries(docIds []string, readCas []gocb.Cas) (expiries []uint32, changed []bool, err error) {
+
+	expiries = make([]uint32, len(docIds))
+	changed = make([]bool, len(docIds))
+	if !s.app.SupportsXattrs(s.Bucket.Name()) {
+		return expiries, changed, nil
+	}
+
+	collection := s.app.collection(s.Bucket)
+	err = forEachConcurrently(s.app.SubdocConcurrency, len(docIds), func(i int) error {
+		meta, err := lookupDocMeta(collection, docIds[i], nil)
+		if err != nil {
+			if errors.Is(wrapGocbError(err), ErrDocNotFound) {
+				changed[i] = true
+				return nil
+			}
+			return newDocError(PhaseTargetWrite, docIds[i], err)
+		}
+		changed[i] = meta.Cas != readCas[i]
+		expiries[i] = meta.Expiry
+		return nil
+	})
+	return expiries, changed, err
+}
+
+func (s *InPlaceSink) addConflict(docId string) {
+	s.conflictsMutex.Lock()
+	defer s.conflictsMutex.Unlock()
+	s.conflicts = append(s.conflicts, docId)
+}
+
+// The ids of the docs that changed or were deleted between being read and being written back, so were left
+// as they are
+func (s *InPlaceSink) Conflicts() []string {
+	s.conflictsMutex.Lock()
+	defer s.conflictsMutex.Unlock()
+	conflicts := append([]string{}, s.conflicts...)
+	sort.Strings(conflicts)
+	return conflicts
+}
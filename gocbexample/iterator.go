@@ -0,0 +1,117 @@
+package gocbexample
+
+import (
+	"errors"
+	"sync"
+)
+
+// Returned by the DocProcessor of a DocIterator to stop the scan once the iterator is closed
+var errIteratorClosed = errors.New("Iterator closed")
+
+type docIteratorBatch struct {
+	docIds []string
+	docs   []interface{}
+}
+
+// Pulls docs from a Source one at a time, so callers can consume a bucket at their own pace rather than
+// through DocProcessor callbacks:
+//
+//	it := e.NewDocIterator(e.Source)
+//	defer it.Close()
+//	for it.Next() {
+//		docId, doc := it.Doc()
+//		..
+//	}
+//	if err := it.Err(); err != nil {
+//		..
+//	}
+//
+// The source is read ahead by a batch, and the iteration order depends on the source (with several
+// workers, batches arrive in no particular order).
+type DocIterator struct {
+	batches  chan docIteratorBatch
+	closed   chan struct{}
+	finished chan struct{}
+
+	batch docIteratorBatch
+	index int
+
+	closeOnce sync.Once
+	err       error
+}
+
+// Start iterating over the docs of source
+func (e *ExampleApp) NewDocIterator(source Source) *DocIterator {
+
+	it := &DocIterator{
+		batches:  make(chan docIteratorBatch),
+		closed:   make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+
+	go func() {
+		defer close(it.finished)
+		defer close(it.batches)
+		it.err = source.ForEachDoc(func(docIds []string, docs []interface{}) error {
+			select {
+			case it.batches <- docIteratorBatch{docIds: docIds, docs: docs}:
+				return nil
+			case <-it.closed:
+				return errIteratorClosed
+			}
+		})
+	}()
+
+	return it
+}
+
+// Iterate over the docs of the source bucket
+func (e *ExampleApp) IterateSourceBucket() *DocIterator {
+	return e.NewDocIterator(e.NewBucketSource(e.SourceBucket))
+}
+
+// Advance to the next doc.  Returns false once there are no more docs, or the scan failed (see Err).
+func (it *DocIterator) Next() bool {
+	it.index += 1
+	for it.index >= len(it.batch.docIds) {
+		batch, ok := <-it.batches
+		if !ok {
+			return false
+		}
+		it.batch, it.index = batch, 0
+	}
+	return true
+}
+
+// The id and body of the current doc
+func (it *DocIterator) Doc() (docId string, doc interface{}) {
+	if it.index >= len(it.batch.docIds) {
+		return "", nil
+	}
+	return it.batch.docIds[it.index], it.batch.docs[it.index]
+}
+
+// The error the scan failed with, if any.  Only valid once Next has returned false.
+func (it *DocIterator) Err() error {
+	select {
+	case <-it.finished:
+	default:
+		return nil
+	}
+	if errors.Is(it.err, errIteratorClosed) {
+		return nil
+	}
+	return it.err
+}
+
+// Stop the scan and wait for it to wind down.  Safe to call more than once, and after the last doc.
+func (it *DocIterator) Close() error {
+	it.closeOnce.Do(func() {
+		close(it.closed)
+	})
+	for range it.batches {
+		// Drain batches sent before the scan noticed the close
+	}
+	<-it.finished
+	return it.Err()
+}
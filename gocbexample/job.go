@@ -0,0 +1,375 @@
+package gocbexample
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+)
+
+// The report written to the job workspace when a command finishes
+type JobReport struct {
+	JobId      string    `json:"jobId"`
+	Command    string    `json:"command"`
+	Version    string    `json:"version"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	Error      string    `json:"error,omitempty"`
+
+	// Which doc, batch and phase the job failed on, if the error was from copying a doc
+	ErrorContext *ErrorContext `json:"errorContext,omitempty"`
+
+	// The limit that stopped the job before it finished, eg "max duration (2h0m0s)"
+	Stopped string `json:"stopped,omitempty"`
+
+	// Seeds of the random values generated by the run, keyed by config section.  Setting them as the
+	// "seed" of those sections regenerates the same dataset
+	Seeds map[string]int64 `json:"seeds,omitempty"`
+
+	// Command specific results, keyed by section name
+	Results map[string]interface{} `json:"results,omitempty"`
+}
+
+// A single run of a command: the effective config, the connected app, the workspace and the report
+type Job struct {
+	Id        string
+	Command   string
+	Config    Config
+	App       *ExampleApp
+	Workspace *Workspace
+	Report    *JobReport
+
+	// The collection pair being run, whose results are reported under it when the job runs more than one
+	collectionPair string
+}
+
+// Flags shared by every command
+type jobFlags struct {
+	configPath    *string
+	vars          *stringListFlag
+	jobId         *string
+	workspaceRoot *string
+	useN1ql       *bool
+	inPlace       *bool
+	maxDuration   *time.Duration
+	maxDocs       *int64
+	filter        *string
+	engine        *string
+}
+
+func addJobFlags(flags *flag.FlagSet) *jobFlags {
+	vars := &stringListFlag{}
+	flags.Var(vars, "var", "Set a ${NAME} variable used in the config file, eg -var SUFFIX=daily.  Can be repeated")
+	return &jobFlags{
+		configPath:    flags.String("config", "", "Path to a JSON config file"),
+		vars:          vars,
+		jobId:         flags.String("job-id", "", "Job id, used to name the workspace directory.  Generated if not set"),
+		workspaceRoot: flags.String("workspace-root", "", "Directory to create the job workspace under"),
+		useN1ql:       flags.Bool("n1ql", false, "Use N1QL rather than views to iterate buckets"),
+		inPlace:       flags.Bool("in-place", false, "Allow the source and target to be the same bucket, transforming it in place"),
+		maxDuration:   flags.Duration("max-duration", 0, "Stop the job cleanly once it has run this long, eg 2h.  0 leaves it unlimited"),
+		maxDocs:       flags.Int64("max-docs", 0, "Stop the job cleanly once it has read this many docs.  0 leaves it unlimited"),
+		engine:        flags.String("engine", "", "How to iterate buckets: views, n1ql, dcp, or auto to pick from the cluster's services.  Overrides -n1ql"),
+		filter:        flags.String("filter", "", "Only read the source docs matching this JSON filter, eg '{\"types\": [\"airline\"]}'.  Replaces the filter of the config file"),
+	}
+}
+
+// Resolve the effective config from the config file and flags
+func (f *jobFlags) config() (Config, error) {
+	vars, err := parseConfigVariables(*f.vars)
+	if err != nil {
+		return Config{}, err
+	}
+	config, err := LoadConfigWithVars(*f.configPath, vars)
+	if err != nil {
+		return config, err
+	}
+	if *f.jobId != "" {
+		config.JobId = *f.jobId
+	}
+	if *f.workspaceRoot != "" {
+		config.WorkspaceRoot = *f.workspaceRoot
+	}
+	if *f.useN1ql {
+		config.UseN1ql = true
+	}
+	if *f.engine != "" {
+		config.Engine = Engine(*f.engine)
+	}
+	if *f.inPlace {
+		config.InPlace = true
+	}
+	if *f.maxDuration > 0 {
+		config.MaxDurationSeconds = int(math.Ceil(f.maxDuration.Seconds()))
+	}
+	if *f.maxDocs > 0 {
+		config.MaxDocs = *f.maxDocs
+	}
+	if *f.filter != "" {
+		filter := &DocFilter{}
+		if err := json.Unmarshal([]byte(*f.filter), filter); err != nil {
+			return config, fmt.Errorf("Error parsing -filter: %v.  Err: %v", *f.filter, err)
+		}
+		config.Filter = filter
+	}
+	return config, nil
+}
+
+// Generate a unique job id from the command name, start time and a random suffix, eg copy-20171003-142501-9f3c2a1b
+func newJobId(command string, startedAt time.Time) string {
+	command = strings.Replace(command, " ", "-", -1)
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		// Fall back to the nanosecond clock, which is unique enough for a single host
+		return fmt.Sprintf("%v-%v-%x", command, startedAt.Format("20060102-150405"), startedAt.Nanosecond())
+	}
+	return fmt.Sprintf("%v-%v-%x", command, startedAt.Format("20060102-150405"), suffix)
+}
+
+// Refuse job ids that aren't a single directory name, eg "../other-job" or ".", whose workspace would be
+// outside the workspace root, or the root itself, and trample the state of other jobs
+func checkJobId(jobId string) error {
+	if jobId == "." || strings.Contains(jobId, "..") || strings.ContainsAny(jobId, `/\`) {
+		return fmt.Errorf("Invalid job id: %q.  It names the job's workspace directory, so can't be . or contain .. or path separators", jobId)
+	}
+	return nil
+}
+
+// Set up the workspace and connect to the buckets
+func StartJob(command string, config Config) (*Job, error) {
+
+	startedAt := time.Now()
+
+	if config.JobId == "" {
+		config.JobId = newJobId(command, startedAt)
+	}
+	if err := checkJobId(config.JobId); err != nil {
+		return nil, err
+	}
+
+	workspace, err := NewWorkspace(config.WorkspaceRoot, config.JobId)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating workspace for job: %v.  Err: %v", config.JobId, err)
+	}
+
+	// Tag every log line with the job id so that logs from concurrent runs can be told apart
+	log.SetPrefix(fmt.Sprintf("[job=%v] ", config.JobId))
+
+	if err := workspace.StartLogging(); err != nil {
+		return nil, err
+	}
+	if err := workspace.WriteConfig(config); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Starting job %v (%v), gocb-example %v, workspace: %v", config.JobId, command, toolVersion(), workspace.Dir)
+
+	job := &Job{
+		Id:        config.JobId,
+		Command:   command,
+		Config:    config,
+		Workspace: workspace,
+		Report: &JobReport{
+			JobId:     config.JobId,
+			Command:   command,
+			Version:   toolVersion(),
+			StartedAt: startedAt,
+			Results:   map[string]interface{}{},
+		},
+	}
+
+	// Catch typos in the rules before spending time connecting and copying
+	warnings, err := config.Lint()
+	for _, warning := range warnings {
+		log.Printf("Warning: %v", warning)
+	}
+	if err != nil {
+		return job, err
+	}
+
+	app, err := NewExampleWithOptions(config.Source, config.Target, WithConfig(config), WithDeadLetterFile(workspace.DeadLetterPath()))
+	if err != nil {
+		return job, err
+	}
+	job.App = app
+	if err := job.App.Connect(config.ConnSpec); err != nil {
+		return job, err
+	}
+
+	job.App.StartHealthMonitor(time.Duration(config.HealthCheckIntervalSeconds)*time.Second, HealthThresholds{
+		MaxDiskWriteQueue:        config.MaxDiskWriteQueue,
+		MinMemoryHeadroomPercent: config.MinMemoryHeadroomPercent,
+		MaxBackgroundFetchMicros: config.MaxBackgroundFetchMicros,
+	})
+
+	return job, nil
+}
+
+// Start a job for the command, run it, check the target if the command wrote to it, then finish the job.
+// Returns the error the job finished with.
+func RunJob(commandName string, cmd command, config Config, run func(job *Job) error) error {
+
+	if cmd.inPlace {
+		config.InPlace = true
+		config.Target = config.Source
+	}
+	job, err := StartJob(commandName, config)
+	if err == nil && cmd.writesTarget && job.App.Backup != nil {
+		err = job.App.RestoreBackup()
+	}
+	if err == nil && cmd.writesTarget {
+		err = job.checkpointN1qlCursors()
+	}
+	if err == nil {
+		// Run the command and check the target once per collection pair
+		err = job.App.ForEachCollectionPair(func(pair CollectionPair) error {
+			if len(job.App.CollectionPairs) > 1 {
+				log.Printf("Running %v on collection: %v", commandName, pair)
+				job.collectionPair = pair.String()
+				defer func() { job.collectionPair = "" }()
+			}
+			if err := run(job); err != nil {
+				return err
+			}
+			if cmd.writesTarget {
+				return job.CheckTarget()
+			}
+			return nil
+		})
+	}
+	if job != nil {
+		err = job.Finish(err)
+	}
+	return err
+}
+
+// Add a section to the job report.  While a job of more than one collection pair runs, sections are added under
+// "collections" and the pair, eg {"collections": {"inventory.hotel": {"verify": ..}}}
+func (j *Job) AddResult(name string, result interface{}) {
+	if j.collectionPair == "" {
+		j.Report.Results[name] = result
+		return
+	}
+	collections, ok := j.Report.Results["collections"].(map[string]map[string]interface{})
+	if !ok {
+		collections = map[string]map[string]interface{}{}
+		j.Report.Results["collections"] = collections
+	}
+	if collections[j.collectionPair] == nil {
+		collections[j.collectionPair] = map[string]interface{}{}
+	}
+	collections[j.collectionPair][name] = result
+}
+
+// Write the report to the workspace and close it.  Returns the error the job finished with.
+func (j *Job) Finish(jobErr error) error {
+
+	j.Report.FinishedAt = time.Now()
+	if j.App != nil {
+		if seeds := j.App.Seeds(); len(seeds) > 0 {
+			j.Report.Seeds = seeds
+			log.Printf("Random seeds: %v", seeds)
+		}
+		if j.App.Capabilities != nil {
+			j.AddResult("cluster", j.App.Capabilities)
+		}
+		if j.App.Schedule != nil {
+			j.AddResult("schedule", j.App.Schedule.Report())
+		}
+		if j.App.Projection != nil {
+			j.AddResult("projection", j.App.Projection.Report())
+		}
+		if j.App.Truncator != nil {
+			j.AddResult("truncation", j.App.Truncator.Report())
+		}
+		if j.App.GeoFuzzer != nil {
+			j.AddResult("geoFuzz", j.App.GeoFuzzer.Report())
+		}
+		if j.App.Faker != nil {
+			j.AddResult("faker", j.App.Faker.Report())
+		}
+		if j.App.Generalizer != nil {
+			report := j.App.Generalizer.Report()
+			j.AddResult("generalization", report)
+			if report.SmallGroups > 0 {
+				log.Printf("Warning: %v groups (%v docs) are smaller than the generalization minGroupSize, the smallest has %v docs",
+					report.SmallGroups, report.DocsSmallGroups, report.MinGroupSize)
+			}
+		}
+		if j.App.Encryptor != nil {
+			j.AddResult("encryption", j.App.Encryptor.Report())
+		}
+		if j.App.CelTransform != nil {
+			j.AddResult("celTransforms", j.App.CelTransform.Report())
+		}
+		if len(j.App.WasmPlugins) > 0 {
+			reports := []WasmPluginReport{}
+			for _, plugin := range j.App.WasmPlugins {
+				reports = append(reports, plugin.Report())
+			}
+			j.AddResult("wasmPlugins", reports)
+		}
+		if j.App.Webhook != nil {
+			j.AddResult("webhook", j.App.Webhook.Report())
+		}
+		if inPlaceSink, ok := j.App.Sink.(*InPlaceSink); ok {
+			if conflicts := inPlaceSink.Conflicts(); len(conflicts) > 0 {
+				j.AddResult("inPlaceConflicts", conflicts)
+				log.Printf("Warning: %v docs changed while being transformed in place, so were left as they were.  Rerun to transform them", len(conflicts))
+			}
+		}
+		if advice := j.App.TuningAdvice(); advice != nil {
+			j.AddResult("tuning", advice)
+			advice.log()
+		}
+		if healthReport := j.App.StopHealthMonitor(); healthReport != nil {
+			j.AddResult("health", healthReport)
+		}
+		if j.App.DeadLetters != nil {
+			if entries := j.App.DeadLetters.Entries(); entries > 0 {
+				j.AddResult("deadLetters", entries)
+				log.Printf("%v dead-letter entries written to %v", entries, j.App.DeadLetters.Path)
+			}
+			j.App.DeadLetters.Close()
+		}
+		j.App.Close()
+	}
+	if jobErr != nil {
+		j.Report.Error = jobErr.Error()
+		var docErr *DocError
+		if errors.As(jobErr, &docErr) {
+			context := docErr.Context()
+			j.Report.ErrorContext = &context
+		}
+		var limitErr *LimitError
+		if errors.As(jobErr, &limitErr) {
+			// A clean stop: the report has the results so far, and paged N1QL scans resume from the checkpoint
+			j.Report.Stopped = limitErr.Limit
+			log.Printf("Job %v stopped: %v.  Rerun with -job-id %v to carry on", j.Id, jobErr, j.Id)
+		} else {
+			log.Printf("Job %v failed: %v", j.Id, jobErr)
+		}
+	} else {
+		log.Printf("Job %v finished in %v", j.Id, j.Report.FinishedAt.Sub(j.Report.StartedAt))
+		if j.App != nil {
+			if unused := j.App.UnusedRules(); len(unused) > 0 {
+				for _, rule := range unused {
+					log.Printf("Warning: rule %v never matched a doc.  Is there a typo?", rule)
+				}
+				j.AddResult("unusedRules", unused)
+			}
+		}
+	}
+
+	if err := j.Workspace.WriteReport(j.Report); err != nil {
+		log.Printf("Error writing report for job %v: %v", j.Id, err)
+	}
+	j.Workspace.Close()
+
+	return jobErr
+}
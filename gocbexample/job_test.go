@@ -0,0 +1,26 @@
+package gocbexample
+
+import "testing"
+
+func TestCheckJobId(t *testing.T) {
+
+	tests := []struct {
+		jobId   string
+		wantErr bool
+	}{
+		{jobId: "copy-20171003-142501-9f3c2a1b"},
+		{jobId: "nightly.v2"},
+		{jobId: ".", wantErr: true},
+		{jobId: "..", wantErr: true},
+		{jobId: "../other-job", wantErr: true},
+		{jobId: "jobs/nightly", wantErr: true},
+		{jobId: `jobs\nightly`, wantErr: true},
+		{jobId: "/tmp/job", wantErr: true},
+	}
+
+	for _, test := range tests {
+		if err := checkJobId(test.jobId); (err != nil) != test.wantErr {
+			t.Errorf("checkJobId(%q) = %v, want error: %v", test.jobId, err, test.wantErr)
+		}
+	}
+}
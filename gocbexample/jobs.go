@@ -0,0 +1,243 @@
+package gocbexample
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// One of the jobs of a config run by the jobs command, eg copying beer-sample, then verifying the copy
+type JobSpec struct {
+
+	// Names the job in dependsOn and the summary, and suffixes its job id
+	Name string `json:"name"`
+
+	// The command to run, eg "copy", and its flags, eg ["-skip-duplicates"]
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+
+	// Jobs that must succeed before this one runs.  Otherwise jobs run in the order they're listed
+	DependsOn []string `json:"dependsOn,omitempty"`
+
+	// Settings applied on top of the rest of the config for this job only, eg {"source": {"name": "beer-sample"}}
+	Config json.RawMessage `json:"config,omitempty"`
+}
+
+const (
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobSkipped   = "skipped"
+)
+
+// The outcome of one of the jobs run by the jobs command
+type JobSummary struct {
+	Name     string
+	JobId    string
+	Status   string
+	Duration time.Duration
+	Error    string `json:",omitempty"`
+}
+
+// Registered here rather than in the commands map, since running the jobs looks up their commands in it
+func init() {
+	commands["jobs"] = command{setup: setupJobs, noJob: true}
+}
+
+// Run the jobs defined by the config, in dependency order
+func setupJobs(flags *flag.FlagSet) func(job *Job) error {
+
+	jobFlags := addJobFlags(flags)
+
+	return func(_ *Job) error {
+
+		config, err := jobFlags.config()
+		if err != nil {
+			return err
+		}
+		summaries, err := RunJobs(config)
+		for _, summary := range summaries {
+			if summary.Error != "" {
+				log.Printf("Job %v (%v): %v in %v: %v", summary.Name, summary.JobId, summary.Status, summary.Duration, summary.Error)
+			} else {
+				log.Printf("Job %v (%v): %v in %v", summary.Name, summary.JobId, summary.Status, summary.Duration)
+			}
+		}
+		return err
+	}
+
+}
+
+// Run each of the config's jobs with its own job id and workspace.  A job whose dependencies didn't succeed is
+// skipped, while the jobs that don't depend on it still run.  Returns an error if any job failed or was skipped.
+func RunJobs(config Config) (summaries []JobSummary, err error) {
+
+	if len(config.Jobs) == 0 {
+		return nil, fmt.Errorf("The config has no jobs to run")
+	}
+	ordered, err := orderJobs(config.Jobs)
+	if err != nil {
+		return nil, err
+	}
+
+	startedAt := time.Now()
+	status := map[string]string{}
+	failed := 0
+	for _, spec := range ordered {
+
+		summary := JobSummary{Name: spec.Name, Status: JobSkipped}
+		for _, dependency := range spec.DependsOn {
+			if status[dependency] != JobSucceeded {
+				summary.Error = fmt.Sprintf("Dependency %v %v", dependency, status[dependency])
+				break
+			}
+		}
+
+		if summary.Error == "" {
+			jobConfig, jobErr := config.forJob(spec, startedAt)
+			summary.JobId = jobConfig.JobId
+			if jobErr == nil {
+				log.Printf("Running job %v: %v %v", spec.Name, spec.Command, strings.Join(spec.Args, " "))
+				jobStartedAt := time.Now()
+				jobErr = runJobSpec(spec, jobConfig)
+				summary.Duration = time.Since(jobStartedAt).Round(time.Millisecond)
+
+				// The next job sets its own prefix once its workspace is created
+				log.SetPrefix("")
+			}
+			summary.Status = JobSucceeded
+			if jobErr != nil {
+				summary.Status = JobFailed
+				summary.Error = jobErr.Error()
+			}
+		}
+
+		if summary.Status != JobSucceeded {
+			failed++
+		}
+		status[spec.Name] = summary.Status
+		summaries = append(summaries, summary)
+	}
+
+	if failed > 0 {
+		return summaries, fmt.Errorf("%v of %v jobs failed or were skipped", failed, len(summaries))
+	}
+	return summaries, nil
+}
+
+// Parse the job's flags and run its command
+func runJobSpec(spec JobSpec, config Config) error {
+
+	cmd := commands[spec.Command]
+	flags := flag.NewFlagSet(spec.Name, flag.ContinueOnError)
+	run := cmd.setup(flags)
+	if err := flags.Parse(spec.Args); err != nil {
+		return fmt.Errorf("Error parsing args of job: %v.  Err: %v", spec.Name, err)
+	}
+	return RunJob(spec.Command, cmd, config, run)
+}
+
+// The config of a job: this config with the job's settings applied on top.  The job id is the job name
+// appended to the configured job id, or generated from the job name.
+func (c Config) forJob(spec JobSpec, startedAt time.Time) (Config, error) {
+
+	// Copy the config via JSON, so that applying the job's settings doesn't change the nested sections
+	// of the config that the other jobs share
+	base := c
+	base.Jobs = nil
+	baseBytes, err := json.Marshal(base)
+	if err != nil {
+		return Config{}, err
+	}
+	config := Config{}
+	if err := json.Unmarshal(baseBytes, &config); err != nil {
+		return Config{}, err
+	}
+	if len(spec.Config) > 0 {
+		if err := json.Unmarshal(spec.Config, &config); err != nil {
+			return Config{}, fmt.Errorf("Error parsing the config of job: %v.  Err: %v", spec.Name, err)
+		}
+	}
+
+	if c.JobId != "" {
+		config.JobId = c.JobId + "-" + spec.Name
+	} else {
+		config.JobId = newJobId(spec.Name, startedAt)
+	}
+	return config, nil
+}
+
+// Order the jobs so that each runs after the jobs it depends on, otherwise keeping the order they're listed in.
+// Returns an error if a job is invalid, depends on an unknown job, or the dependencies have a cycle.
+func orderJobs(specs []JobSpec) ([]JobSpec, error) {
+
+	names := map[string]bool{}
+	for i, spec := range specs {
+		if spec.Name == "" {
+			return nil, fmt.Errorf("jobs[%v] has no name", i)
+		}
+		if names[spec.Name] {
+			return nil, fmt.Errorf("Job name %v is used more than once", spec.Name)
+		}
+		names[spec.Name] = true
+
+		cmd, ok := commands[spec.Command]
+		if !ok || cmd.noJob {
+			return nil, fmt.Errorf("Job %v has an unknown command: %v.  Expected one of: %v", spec.Name, spec.Command, strings.Join(jobCommandNames(), ", "))
+		}
+	}
+	for _, spec := range specs {
+		for _, dependency := range spec.DependsOn {
+			if !names[dependency] {
+				return nil, fmt.Errorf("Job %v depends on unknown job: %v", spec.Name, dependency)
+			}
+		}
+	}
+
+	ordered := []JobSpec{}
+	placed := map[string]bool{}
+	for len(ordered) < len(specs) {
+		progress := false
+		for _, spec := range specs {
+			if placed[spec.Name] || !dependenciesPlaced(spec, placed) {
+				continue
+			}
+			ordered = append(ordered, spec)
+			placed[spec.Name] = true
+			progress = true
+			break
+		}
+		if !progress {
+			var cycle []string
+			for _, spec := range specs {
+				if !placed[spec.Name] {
+					cycle = append(cycle, spec.Name)
+				}
+			}
+			return nil, fmt.Errorf("The dependencies of jobs %v form a cycle", strings.Join(cycle, ", "))
+		}
+	}
+	return ordered, nil
+}
+
+func dependenciesPlaced(spec JobSpec, placed map[string]bool) bool {
+	for _, dependency := range spec.DependsOn {
+		if !placed[dependency] {
+			return false
+		}
+	}
+	return true
+}
+
+// The commands that can be run as one of the jobs of a config
+func jobCommandNames() []string {
+	names := []string{}
+	for _, name := range commandNames() {
+		if !commands[name].noJob {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,124 @@
+package gocbexample
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// A doc as stored in a JSON lines file, one per line
+type jsonLinesDoc struct {
+	Id  string      `json:"id"`
+	Doc interface{} `json:"doc"`
+}
+
+// A Source that reads docs from a JSON lines file of {"id": .., "doc": ..} objects
+type JSONLinesSource struct {
+	Path string
+
+	// Number of docs passed to the DocProcessor at once
+	BatchSize int
+}
+
+func NewJSONLinesSource(path string) *JSONLinesSource {
+	return &JSONLinesSource{Path: path, BatchSize: pageSizeViewResult}
+}
+
+func (s *JSONLinesSource) Name() string {
+	return fmt.Sprintf("file:%v", s.Path)
+}
+
+func (s *JSONLinesSource) ForEachDoc(docProcessor DocProcessor) error {
+
+	file, err := os.Open(s.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	batch := DocProcessorInput{}
+	flush := func() error {
+		if len(batch.DocIds) == 0 {
+			return nil
+		}
+		err := docProcessor(batch.DocIds, batch.Docs)
+		batch = DocProcessorInput{}
+		return err
+	}
+
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	for line := 1; ; line++ {
+		doc := jsonLinesDoc{}
+		if err := decoder.Decode(&doc); err == io.EOF {
+			break
+		} else if err != nil {
+			return newDocError(PhaseSourceRead, "", fmt.Errorf("Error reading doc %v of file: %v.  Err: %w", line, s.Path, err))
+		}
+		if doc.Id == "" {
+			return fmt.Errorf("Doc %v of file: %v has no id", line, s.Path)
+		}
+		batch.DocIds = append(batch.DocIds, doc.Id)
+		batch.Docs = append(batch.Docs, doc.Doc)
+		if len(batch.DocIds) >= s.BatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return flush()
+}
+
+// A Sink that writes docs to a JSON lines file of {"id": .., "doc": ..} objects
+type JSONLinesSink struct {
+	Path string
+
+	mutex   sync.Mutex
+	file    *os.File
+	writer  *bufio.Writer
+	encoder *json.Encoder
+}
+
+// Create (or truncate) the file at path
+func NewJSONLinesSink(path string) (*JSONLinesSink, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	writer := bufio.NewWriter(file)
+	return &JSONLinesSink{
+		Path:    path,
+		file:    file,
+		writer:  writer,
+		encoder: json.NewEncoder(writer),
+	}, nil
+}
+
+func (s *JSONLinesSink) Name() string {
+	return fmt.Sprintf("file:%v", s.Path)
+}
+
+func (s *JSONLinesSink) WriteDocs(docIds []string, docs []interface{}) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i, docId := range docIds {
+		if err := s.encoder.Encode(jsonLinesDoc{Id: docId, Doc: docs[i]}); err != nil {
+			return newDocError(PhaseTargetWrite, docId, fmt.Errorf("Error writing to file: %v.  Err: %w", s.Path, err))
+		}
+	}
+	return nil
+}
+
+// Flush buffered docs and close the file
+func (s *JSONLinesSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.writer.Flush(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
@@ -0,0 +1,364 @@
+package gocbexample
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A parsed JSONPath expression.  Only the subset needed to address fields in docs is supported:
+//
+//	$.geo.lat         object fields
+//	$.reviews[0]      array index
+//	$.reviews[*].date every element of an array
+//	$.*.ratings       every field of an object
+//	$['odd.name']     quoted field names
+//	$xattrs.Metadata  the XATTRs verify and checksum add to docs, under the $xattrs field
+//
+// The leading "$." is optional, so "geo.lat" is equivalent to "$.geo.lat".
+type JSONPath struct {
+	raw      string
+	segments []jsonPathSegment
+}
+
+type jsonPathSegment struct {
+	field    string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// Parse a JSONPath expression
+func ParseJSONPath(path string) (JSONPath, error) {
+
+	jsonPath := JSONPath{raw: path}
+
+	rest := strings.TrimSpace(path)
+	if strings.HasPrefix(rest, xattrsField) && (len(rest) == len(xattrsField) || strings.ContainsRune(".[", rune(rest[len(xattrsField)]))) {
+		// The $ of the XATTRs field is part of its name, not the root
+		jsonPath.segments = append(jsonPath.segments, jsonPathSegment{field: xattrsField})
+		rest = rest[len(xattrsField):]
+	} else {
+		rest = strings.TrimPrefix(rest, "$")
+	}
+	if len(jsonPath.segments) == 0 && rest != "" && rest[0] != '.' && rest[0] != '[' {
+		// Allow "geo.lat" as shorthand for "$.geo.lat"
+		rest = "." + rest
+	}
+
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end == -1 {
+				end = len(rest)
+			}
+			name := rest[:end]
+			if name == "" {
+				return jsonPath, fmt.Errorf("Invalid JSONPath %q: empty field name", path)
+			}
+			if name == "*" {
+				jsonPath.segments = append(jsonPath.segments, jsonPathSegment{wildcard: true})
+			} else {
+				jsonPath.segments = append(jsonPath.segments, jsonPathSegment{field: name})
+			}
+			rest = rest[end:]
+		case '[':
+			end := strings.Index(rest, "]")
+			if end == -1 {
+				return jsonPath, fmt.Errorf("Invalid JSONPath %q: unterminated [", path)
+			}
+			inner := strings.TrimSpace(rest[1:end])
+			rest = rest[end+1:]
+			switch {
+			case inner == "*":
+				jsonPath.segments = append(jsonPath.segments, jsonPathSegment{wildcard: true})
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				jsonPath.segments = append(jsonPath.segments, jsonPathSegment{field: inner[1 : len(inner)-1]})
+			default:
+				index, err := strconv.Atoi(inner)
+				if err != nil || index < 0 {
+					return jsonPath, fmt.Errorf("Invalid JSONPath %q: bad index %q", path, inner)
+				}
+				jsonPath.segments = append(jsonPath.segments, jsonPathSegment{index: index, isIndex: true})
+			}
+		default:
+			return jsonPath, fmt.Errorf("Invalid JSONPath %q: unexpected %q", path, rest[0])
+		}
+	}
+
+	if len(jsonPath.segments) == 0 {
+		return jsonPath, fmt.Errorf("Invalid JSONPath %q: path selects the whole doc", path)
+	}
+
+	return jsonPath, nil
+}
+
+// Parse a list of JSONPath expressions
+func ParseJSONPaths(paths []string) ([]JSONPath, error) {
+	parsed := make([]JSONPath, 0, len(paths))
+	for _, path := range paths {
+		jsonPath, err := ParseJSONPath(path)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, jsonPath)
+	}
+	return parsed, nil
+}
+
+func (p JSONPath) String() string {
+	return p.raw
+}
+
+// The values matched by the path in doc, if any
+func (p JSONPath) Values(doc interface{}) []interface{} {
+	return pathValues(doc, p.segments, nil)
+}
+
+func pathValues(val interface{}, segments []jsonPathSegment, values []interface{}) []interface{} {
+
+	if len(segments) == 0 {
+		return append(values, val)
+	}
+	segment := segments[0]
+
+	switch v := val.(type) {
+	case map[string]interface{}:
+		if segment.isIndex {
+			return values
+		}
+		if !segment.wildcard {
+			if child, ok := v[segment.field]; ok {
+				values = pathValues(child, segments[1:], values)
+			}
+			return values
+		}
+		for _, child := range v {
+			values = pathValues(child, segments[1:], values)
+		}
+		return values
+	case []interface{}:
+		if !segment.isIndex && !segment.wildcard {
+			return values
+		}
+		for i, child := range v {
+			if segment.wildcard || i == segment.index {
+				values = pathValues(child, segments[1:], values)
+			}
+		}
+		return values
+	default:
+		return values
+	}
+}
+
+// Return a copy of doc with every value matched by the path removed.  The original doc is not modified.
+func (p JSONPath) Remove(doc interface{}) interface{} {
+	return removePath(doc, p.segments)
+}
+
+func removePath(val interface{}, segments []jsonPathSegment) interface{} {
+
+	segment := segments[0]
+	last := len(segments) == 1
+
+	switch v := val.(type) {
+	case map[string]interface{}:
+		if segment.isIndex {
+			return val
+		}
+		result := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			matches := segment.wildcard || key == segment.field
+			switch {
+			case matches && last:
+				continue
+			case matches:
+				result[key] = removePath(child, segments[1:])
+			default:
+				result[key] = child
+			}
+		}
+		return result
+	case []interface{}:
+		if !segment.isIndex && !segment.wildcard {
+			return val
+		}
+		result := make([]interface{}, 0, len(v))
+		for i, child := range v {
+			matches := segment.wildcard || i == segment.index
+			switch {
+			case matches && last:
+				continue
+			case matches:
+				result = append(result, removePath(child, segments[1:]))
+			default:
+				result = append(result, child)
+			}
+		}
+		return result
+	default:
+		return val
+	}
+}
+
+// Return a copy of doc with only the values matched by at least one of the paths.  Objects and arrays
+// on the way to a matched value are kept, with everything else in them dropped.  The original doc is
+// not modified.
+func RetainPaths(doc interface{}, paths []JSONPath) interface{} {
+	segments := make([][]jsonPathSegment, len(paths))
+	for i, path := range paths {
+		segments[i] = path.segments
+	}
+	retained, ok := retainPaths(doc, segments)
+	if !ok {
+		// Keep the doc an object (or array) if nothing matched, rather than replacing it with null
+		switch doc.(type) {
+		case map[string]interface{}:
+			return map[string]interface{}{}
+		case []interface{}:
+			return []interface{}{}
+		}
+	}
+	return retained
+}
+
+func retainPaths(val interface{}, paths [][]jsonPathSegment) (interface{}, bool) {
+
+	for _, segments := range paths {
+		if len(segments) == 0 {
+			return val, true
+		}
+	}
+
+	// The remaining segments of the paths whose first segment matches a key/index
+	childPaths := func(matches func(segment jsonPathSegment) bool) [][]jsonPathSegment {
+		var result [][]jsonPathSegment
+		for _, segments := range paths {
+			if matches(segments[0]) {
+				result = append(result, segments[1:])
+			}
+		}
+		return result
+	}
+
+	switch v := val.(type) {
+	case map[string]interface{}:
+		result := map[string]interface{}{}
+		for key, child := range v {
+			matching := childPaths(func(segment jsonPathSegment) bool {
+				return !segment.isIndex && (segment.wildcard || segment.field == key)
+			})
+			if len(matching) == 0 {
+				continue
+			}
+			if retained, ok := retainPaths(child, matching); ok {
+				result[key] = retained
+			}
+		}
+		return result, len(result) > 0
+	case []interface{}:
+		result := []interface{}{}
+		for i, child := range v {
+			matching := childPaths(func(segment jsonPathSegment) bool {
+				return segment.wildcard || (segment.isIndex && segment.index == i)
+			})
+			if len(matching) == 0 {
+				continue
+			}
+			if retained, ok := retainPaths(child, matching); ok {
+				result = append(result, retained)
+			}
+		}
+		return result, len(result) > 0
+	default:
+		return nil, false
+	}
+}
+
+// Does the path match the value at location, a concrete list of field names and array indexes?
+func (p JSONPath) matches(location []jsonPathSegment) bool {
+	if len(location) != len(p.segments) {
+		return false
+	}
+	for i, segment := range p.segments {
+		switch {
+		case segment.wildcard:
+			continue
+		case segment.isIndex:
+			if !location[i].isIndex || location[i].index != segment.index {
+				return false
+			}
+		default:
+			if location[i].isIndex || location[i].field != segment.field {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Return a copy of doc with every value matched by the path replaced by update(value).  The original
+// doc is not modified.
+func (p JSONPath) Update(doc interface{}, update func(val interface{}) interface{}) interface{} {
+	return updatePath(doc, p.segments, "$", func(location string, val interface{}) interface{} {
+		return update(val)
+	})
+}
+
+// Like Update, passing update the location of each value matched, with the indexes and field names of any
+// wildcards, eg "$.reviews[2].author"
+func (p JSONPath) UpdateAt(doc interface{}, update func(location string, val interface{}) interface{}) interface{} {
+	return updatePath(doc, p.segments, "$", update)
+}
+
+func updatePath(val interface{}, segments []jsonPathSegment, location string, update func(location string, val interface{}) interface{}) interface{} {
+
+	if len(segments) == 0 {
+		return update(location, val)
+	}
+	segment := segments[0]
+
+	switch v := val.(type) {
+	case map[string]interface{}:
+		if segment.isIndex {
+			return val
+		}
+		result := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			if segment.wildcard || key == segment.field {
+				result[key] = updatePath(child, segments[1:], fieldLocation(location, key), update)
+			} else {
+				result[key] = child
+			}
+		}
+		return result
+	case []interface{}:
+		if !segment.isIndex && !segment.wildcard {
+			return val
+		}
+		result := make([]interface{}, len(v))
+		for i, child := range v {
+			if segment.wildcard || i == segment.index {
+				result[i] = updatePath(child, segments[1:], indexLocation(location, i), update)
+			} else {
+				result[i] = child
+			}
+		}
+		return result
+	default:
+		return val
+	}
+}
+
+// The location of a field of the object at location
+func fieldLocation(location, field string) string {
+	return location + "." + field
+}
+
+// The location of an element of the array at location
+func indexLocation(location string, index int) string {
+	return fmt.Sprintf("%v[%v]", location, index)
+}
@@ -0,0 +1,116 @@
+package gocbexample
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParseJSONPath(t *testing.T) {
+
+	tests := []struct {
+		path     string
+		segments []jsonPathSegment
+		wantErr  bool
+	}{
+		{path: "$.geo.lat", segments: []jsonPathSegment{{field: "geo"}, {field: "lat"}}},
+		{path: "geo.lat", segments: []jsonPathSegment{{field: "geo"}, {field: "lat"}}},
+		{path: "$.reviews[0]", segments: []jsonPathSegment{{field: "reviews"}, {index: 0, isIndex: true}}},
+		{path: "$.reviews[*].date", segments: []jsonPathSegment{{field: "reviews"}, {wildcard: true}, {field: "date"}}},
+		{path: "$.*.ratings", segments: []jsonPathSegment{{wildcard: true}, {field: "ratings"}}},
+		{path: "$['odd.name']", segments: []jsonPathSegment{{field: "odd.name"}}},
+		{path: "$xattrs.Metadata", segments: []jsonPathSegment{{field: "$xattrs"}, {field: "Metadata"}}},
+		{path: "$xattrs['Metadata']", segments: []jsonPathSegment{{field: "$xattrs"}, {field: "Metadata"}}},
+		{path: "$xattrs", segments: []jsonPathSegment{{field: "$xattrs"}}},
+		{path: "$.$xattrs.Metadata", segments: []jsonPathSegment{{field: "$xattrs"}, {field: "Metadata"}}},
+		{path: "$xattrsMetadata", segments: []jsonPathSegment{{field: "xattrsMetadata"}}},
+		{path: "$", wantErr: true},
+		{path: "$.geo..lat", wantErr: true},
+		{path: "$.reviews[0", wantErr: true},
+		{path: "$.reviews[-1]", wantErr: true},
+		{path: "$.reviews[x]", wantErr: true},
+	}
+
+	for _, test := range tests {
+		jsonPath, err := ParseJSONPath(test.path)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("ParseJSONPath(%q) = %+v, want an error", test.path, jsonPath.segments)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseJSONPath(%q) failed: %v", test.path, err)
+			continue
+		}
+		if !reflect.DeepEqual(jsonPath.segments, test.segments) {
+			t.Errorf("ParseJSONPath(%q) = %+v, want %+v", test.path, jsonPath.segments, test.segments)
+		}
+	}
+}
+
+func TestJSONPathRemove(t *testing.T) {
+
+	tests := []struct {
+		path string
+		doc  string
+		want string
+	}{
+		{path: "$xattrs.Metadata", doc: `{"$xattrs": {"Metadata": "x", "Owner": "y"}, "a": 1}`, want: `{"$xattrs": {"Owner": "y"}, "a": 1}`},
+		{path: "$.updated", doc: `{"updated": "2017-10-03", "a": 1}`, want: `{"a": 1}`},
+		{path: "$.reviews[*].author", doc: `{"reviews": [{"author": "a", "stars": 4}, {"author": "b"}]}`, want: `{"reviews": [{"stars": 4}, {}]}`},
+		{path: "$.reviews[1]", doc: `{"reviews": ["a", "b", "c"]}`, want: `{"reviews": ["a", "c"]}`},
+		{path: "$.missing.field", doc: `{"a": 1}`, want: `{"a": 1}`},
+	}
+
+	for _, test := range tests {
+		jsonPath, err := ParseJSONPath(test.path)
+		if err != nil {
+			t.Fatalf("ParseJSONPath(%q) failed: %v", test.path, err)
+		}
+		var doc, want interface{}
+		mustUnmarshal(t, test.doc, &doc)
+		mustUnmarshal(t, test.want, &want)
+		original, _ := json.Marshal(doc)
+
+		got := jsonPath.Remove(doc)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Remove(%q) of %v = %v, want %v", test.path, test.doc, got, want)
+		}
+		if after, _ := json.Marshal(doc); string(after) != string(original) {
+			t.Errorf("Remove(%q) modified the original doc: %s", test.path, after)
+		}
+	}
+}
+
+func TestJSONPathValues(t *testing.T) {
+
+	var doc interface{}
+	mustUnmarshal(t, `{"reviews": [{"stars": 4}, {"stars": 2}, {}], "$xattrs": {"Metadata": {"JobId": "j"}}}`, &doc)
+
+	tests := []struct {
+		path string
+		want []interface{}
+	}{
+		{path: "$.reviews[*].stars", want: []interface{}{4.0, 2.0}},
+		{path: "$.reviews[1].stars", want: []interface{}{2.0}},
+		{path: "$xattrs.Metadata.JobId", want: []interface{}{"j"}},
+		{path: "$.missing", want: nil},
+	}
+	for _, test := range tests {
+		jsonPath, err := ParseJSONPath(test.path)
+		if err != nil {
+			t.Fatalf("ParseJSONPath(%q) failed: %v", test.path, err)
+		}
+		if got := jsonPath.Values(doc); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Values(%q) = %v, want %v", test.path, got, test.want)
+		}
+	}
+}
+
+func mustUnmarshal(t *testing.T, data string, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal([]byte(data), v); err != nil {
+		t.Fatalf("Invalid JSON %v: %v", data, err)
+	}
+}
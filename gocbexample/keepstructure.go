@@ -0,0 +1,78 @@
+package gocbexample
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"unicode"
+)
+
+// Numbers are moved by up to this percentage by keep-structure rule sets, unless configured otherwise
+const defaultJitterPercent = 10.0
+
+// Replace every leaf value with random content, keeping the structure and types: strings become random
+// strings of the same length (and character classes, so "SFO-123" might become "KQB-804"), numbers are
+// jittered by up to jitterPercent, and booleans and nulls are kept.  Object fields matching skipFields
+// are left as is.
+func scrubLeaves(val interface{}, rng *rand.Rand, jitterPercent float64, skipFields func(key string) bool) interface{} {
+
+	switch v := val.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			if skipFields(key) {
+				result[key] = child
+			} else {
+				result[key] = scrubLeaves(child, rng, jitterPercent, skipFields)
+			}
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, child := range v {
+			result[i] = scrubLeaves(child, rng, jitterPercent, skipFields)
+		}
+		return result
+	case string:
+		return scrubString(v, rng)
+	case float64:
+		return jitterNumber(v, rng, jitterPercent)
+	default:
+		return val
+	}
+}
+
+// A random string with the same length and character classes as s.  Punctuation and spaces are kept.
+func scrubString(s string, rng *rand.Rand) string {
+	runes := []rune(s)
+	for i, r := range runes {
+		switch {
+		case unicode.IsUpper(r):
+			runes[i] = rune('A' + rng.Intn(26))
+		case unicode.IsLetter(r):
+			runes[i] = rune('a' + rng.Intn(26))
+		case unicode.IsDigit(r):
+			runes[i] = rune('0' + rng.Intn(10))
+		}
+	}
+	return string(runes)
+}
+
+// Move a number by up to jitterPercent of its value.  Integers stay integers.
+func jitterNumber(val float64, rng *rand.Rand, jitterPercent float64) float64 {
+	jittered := val * (1 + (2*rng.Float64()-1)*jitterPercent/100)
+	if val == math.Trunc(val) {
+		return math.Round(jittered)
+	}
+	return jittered
+}
+
+// Seed for a doc's random values, derived from a run seed and the doc id, so that rerunning with the same
+// seed regenerates the same dataset
+func docRandSeed(seed int64, docId string) int64 {
+	hash := fnv.New64a()
+	binary.Write(hash, binary.LittleEndian, seed)
+	hash.Write([]byte(docId))
+	return int64(hash.Sum64())
+}
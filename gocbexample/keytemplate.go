@@ -0,0 +1,72 @@
+package gocbexample
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A ${path} reference to a field in a doc key template
+var keyTemplateFieldPattern = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// Builds the ids of imported docs from their fields, eg "user::${email}" or "order::${customer.id}::${_id}".
+// References are JSONPaths, without the leading "$.", to string, number or boolean fields.
+type DocKeyTemplate struct {
+	template string
+	paths    map[string]JSONPath
+}
+
+func NewDocKeyTemplate(template string) (*DocKeyTemplate, error) {
+
+	refs := keyTemplateFieldPattern.FindAllStringSubmatch(template, -1)
+	if len(refs) == 0 {
+		return nil, fmt.Errorf("Invalid key template: %v.  Must refer to at least one field, eg user::${id}", template)
+	}
+	t := &DocKeyTemplate{template: template, paths: map[string]JSONPath{}}
+	for _, ref := range refs {
+		path, err := ParseJSONPath(ref[1])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid field: %v in key template: %v.  Err: %v", ref[1], template, err)
+		}
+		t.paths[ref[1]] = path
+	}
+	return t, nil
+}
+
+// The id of a doc.  Fails if a field is missing, or isn't a string, number or boolean, or the key is too long
+func (t *DocKeyTemplate) key(doc interface{}) (string, error) {
+
+	var keyErr error
+	key := keyTemplateFieldPattern.ReplaceAllStringFunc(t.template, func(ref string) string {
+		field := ref[2 : len(ref)-1]
+		values := t.paths[field].Values(doc)
+		if len(values) != 1 {
+			keyErr = fmt.Errorf("Doc has %v values of key field: %v", len(values), field)
+			return ""
+		}
+		switch val := values[0].(type) {
+		case string:
+			return val
+		case float64:
+			return strconv.FormatFloat(val, 'f', -1, 64)
+		case json.Number:
+			return val.String()
+		case bool:
+			return strconv.FormatBool(val)
+		}
+		keyErr = fmt.Errorf("Key field: %v isn't a string, number or boolean", field)
+		return ""
+	})
+	if keyErr != nil {
+		return "", keyErr
+	}
+	if strings.TrimSpace(key) == "" {
+		return "", fmt.Errorf("Key template: %v gives an empty key", t.template)
+	}
+	if len(key) > maxDocKeyLength {
+		return "", fmt.Errorf("Key: %v is longer than the %v byte limit", key, maxDocKeyLength)
+	}
+	return key, nil
+}
@@ -0,0 +1,58 @@
+package gocbexample
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Returned (wrapped in a *LimitError) once the app has run for its MaxDuration or read its MaxDocs
+var ErrLimitReached = errors.New("job limit reached")
+
+// The limit that stopped the app, and how far it had got
+type LimitError struct {
+	Limit    string
+	Docs     int64
+	Duration time.Duration
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("Stopped after reaching the %v limit, having read %v docs in %v", e.Limit, e.Docs, e.Duration.Round(time.Second))
+}
+
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimitReached
+}
+
+// Stop the app once it has run for maxDuration or read maxDocs docs, whichever comes first, eg to fit a
+// maintenance window or cap the cost of a test refresh.  The current page is finished, and later pages are
+// stopped with an ErrLimitReached error.  Zero leaves that limit off.
+func WithLimits(maxDuration time.Duration, maxDocs int64) Option {
+	return func(e *ExampleApp) error {
+		if maxDuration < 0 {
+			return fmt.Errorf("Invalid max duration: %v.  Must not be negative", maxDuration)
+		}
+		if maxDocs < 0 {
+			return fmt.Errorf("Invalid max docs: %v.  Must not be negative", maxDocs)
+		}
+		e.MaxDuration = maxDuration
+		e.MaxDocs = maxDocs
+		e.limitsStartedAt = time.Now()
+		return nil
+	}
+}
+
+// Return a *LimitError if a limit has been reached, before another page of docs is processed
+func (e *ExampleApp) checkLimits() error {
+
+	docsRead := atomic.LoadInt64(&e.docsRead)
+	elapsed := time.Since(e.limitsStartedAt)
+	switch {
+	case e.MaxDocs > 0 && docsRead >= e.MaxDocs:
+		return &LimitError{Limit: fmt.Sprintf("max docs (%v)", e.MaxDocs), Docs: docsRead, Duration: elapsed}
+	case e.MaxDuration > 0 && elapsed >= e.MaxDuration:
+		return &LimitError{Limit: fmt.Sprintf("max duration (%v)", e.MaxDuration), Docs: docsRead, Duration: elapsed}
+	}
+	return nil
+}
@@ -0,0 +1,276 @@
+package gocbexample
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Check the rules in the config (anonymization rule sets, projections, truncation, geo fuzzing, faker, generalization,
+// encryption, CEL transforms, custom transforms, WASM plugins, webhook, bucket tuning, collections, error policies, priorities, filter, run windows, spot checks, source and smoke queries) before a job starts.  Returns an error listing every invalid rule, and warnings
+// for rules that are valid but probably not what was meant.
+func (c Config) Lint() (warnings []string, err error) {
+
+	var problems []string
+	check := func(err error) {
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	// Building each stage validates its regexes and JSONPaths
+	_, err = NewAnonymizer(c.Anonymize)
+	check(err)
+	if len(c.Projections) > 0 {
+		_, err = NewProjection("", c.Projections)
+		check(err)
+	}
+	if c.Truncation != nil {
+		_, err = NewTruncator(*c.Truncation)
+		check(err)
+		if c.Truncation.MaxStringLength == 0 && c.Truncation.MaxArrayLength == 0 {
+			warnings = append(warnings, "truncation has neither maxStringLength nor maxArrayLength, so won't truncate anything")
+		}
+	}
+	if c.GeoFuzz != nil {
+		_, err = NewGeoFuzzer(*c.GeoFuzz)
+		check(err)
+	}
+	if c.Faker != nil {
+		_, err = NewFaker(*c.Faker)
+		check(err)
+	}
+	if c.Generalization != nil {
+		_, err = NewGeneralizer(*c.Generalization)
+		check(err)
+	}
+	if c.Encryption != nil {
+		_, err = NewFieldEncryptor(*c.Encryption)
+		check(err)
+	}
+	if len(c.CelTransforms) > 0 {
+		_, err = NewCelTransform(c.CelTransforms)
+		check(err)
+	}
+	for _, transformConfig := range c.CustomTransforms {
+		_, err = transformConfig.factory()
+		check(err)
+	}
+	for _, pluginConfig := range c.WasmPlugins {
+		plugin, err := NewWasmTransform(pluginConfig)
+		check(err)
+		if err == nil {
+			plugin.Close()
+		}
+	}
+	if c.Webhook != nil {
+		_, err = NewWebhookTransform(*c.Webhook)
+		check(err)
+	}
+	for _, spec := range []BucketSpec{c.Source, c.Target} {
+		if spec.Tuning != nil {
+			check(spec.Tuning.validate(spec.Name))
+		}
+	}
+	for stage, policy := range c.ErrorPolicies {
+		errorPolicy, err := ParseErrorPolicy(policy)
+		check(err)
+		if err == nil {
+			check(WithErrorPolicy(stage, errorPolicy)(&ExampleApp{}))
+		}
+	}
+	if len(c.Priorities) > 0 {
+		check(WithPriorities(c.Priorities...)(&ExampleApp{}))
+	}
+	_, err = ParseRunSchedule(c.RunWindows, c.RunWindowTimeZone)
+	check(err)
+	if len(c.RunWindows) > 0 && (c.SourceQuery != "" || c.AnalyticsDataset != "" || (c.UseN1ql && c.N1qlPageSize == 0 && c.Engine != EngineDCP)) {
+		check(fmt.Errorf("runWindows need a scan that can pause between pages: views, engine %v, or useN1ql with n1qlPageSize", EngineDCP))
+	}
+	for i, query := range c.SmokeQueries {
+		if query.Query == "" {
+			check(fmt.Errorf("smokeQueries[%v] has no query", i))
+		}
+		if query.ExpectedRows == nil && query.ExpectedValue == nil && query.SourceQuery == "" {
+			check(fmt.Errorf("smokeQueries[%v] has no expectedRows, expectedValue or sourceQuery", i))
+		}
+	}
+
+	if c.SpotChecks != nil {
+		_, err = newDocIdSampler(*c.SpotChecks)
+		check(err)
+	}
+	if c.SourceQuery != "" && c.AnalyticsDataset != "" {
+		check(fmt.Errorf("sourceQuery and analyticsDataset can't both be set"))
+	}
+	if c.Engine != EngineConfigured {
+		check(WithEngine(c.Engine)(&ExampleApp{}))
+		if c.UseN1ql && c.Engine != EngineN1ql {
+			warnings = append(warnings, fmt.Sprintf("engine %v overrides useN1ql", c.Engine))
+		}
+	}
+	if c.Backup != nil {
+		check(WithBackup(*c.Backup)(&ExampleApp{}))
+		if c.Backup.Bucket == c.Source.Name {
+			check(fmt.Errorf("backup.bucket %v is the source bucket, which the backup is restored into.  Set the source to an empty staging bucket", c.Backup.Bucket))
+		}
+		if c.InPlace {
+			check(fmt.Errorf("backup can't be combined with inPlace"))
+		}
+	}
+	if c.CsvImport != nil {
+		check(c.CsvImport.validate())
+		if c.CsvImport.KeyTemplate != "" {
+			_, err := NewDocKeyTemplate(c.CsvImport.KeyTemplate)
+			check(err)
+		}
+	}
+	if c.Redis != nil {
+		check(WithRedis(*c.Redis)(&ExampleApp{}))
+	}
+	if c.Avro != nil {
+		check(c.Avro.validate())
+		_, err := c.Avro.readSchemaFiles()
+		check(err)
+	}
+	if c.CalibrationFile != "" && c.WriteBytesPerSecond > 0 {
+		warnings = append(warnings, "writeBytesPerSecond overrides the rate of calibrationFile")
+	}
+	if c.MetadataXattrKey != "" {
+		check(validateXattrKey(c.MetadataXattrKey))
+	}
+	if c.Filter != nil {
+		check(WithFilter(*c.Filter)(&ExampleApp{}))
+		if c.Filter.Where != "" && (c.SourceQuery != "" || c.AnalyticsDataset != "") {
+			check(fmt.Errorf("filter.where can't be combined with sourceQuery or analyticsDataset, which also select the source docs"))
+		}
+	}
+	if c.SourceQuery != "" {
+		if !strings.HasPrefix(strings.ToUpper(strings.TrimSpace(c.SourceQuery)), "SELECT") {
+			check(fmt.Errorf("sourceQuery must be a SELECT"))
+		}
+		if c.N1qlPageSize > 0 {
+			warnings = append(warnings, "n1qlPageSize doesn't apply to sourceQuery, which is run as a single query")
+		}
+	}
+
+	if c.CheckpointIntervalSeconds != 0 {
+		check(WithCheckpointInterval(time.Duration(c.CheckpointIntervalSeconds) * time.Second)(&ExampleApp{}))
+		switch {
+		case c.InPlace:
+			warnings = append(warnings, "checkpointIntervalSeconds doesn't apply to inPlace, whose copies aren't checkpointed")
+		case c.SourceQuery != "" || c.AnalyticsDataset != "" || (c.UseN1ql && c.N1qlPageSize == 0):
+			warnings = append(warnings, "checkpointIntervalSeconds only applies to copies scanning the source bucket with views, or N1QL with n1qlPageSize")
+		}
+	}
+
+	if len(c.Collections) > 0 || len(c.CollectionMap) > 0 {
+		check(WithCollections(c.Collections, c.CollectionMap)(&ExampleApp{}))
+		if c.InPlace && len(c.CollectionMap) > 0 {
+			check(fmt.Errorf("collectionMap can't be combined with inPlace, which writes each doc back to the collection it was read from"))
+		}
+		if !c.UseN1ql && (c.Engine == EngineConfigured || c.Engine == EngineViews) {
+			check(fmt.Errorf("views only index the default collection, so copying collections needs engine %v, %v or %v", EngineN1ql, EngineDCP, EngineAuto))
+		}
+	}
+
+	if c.InPlace && c.Source.Name != c.Target.Name {
+		warnings = append(warnings, fmt.Sprintf("inPlace is set, so the target %v is ignored and the source %v is transformed in place", c.Target.Name, c.Source.Name))
+	}
+
+	warnings = append(warnings, c.Anonymize.lint()...)
+	warnings = append(warnings, lintProjections(c.Projections)...)
+
+	if len(problems) > 0 {
+		return warnings, fmt.Errorf("Invalid rules in config: %v", strings.Join(problems, "; "))
+	}
+	return warnings, nil
+}
+
+func (c AnonymizeConfig) lint() (warnings []string) {
+
+	names := map[string]bool{}
+	catchAll := ""
+	for i, ruleSet := range c.RuleSets {
+		name := ruleSet.Name
+		if name == "" {
+			name = fmt.Sprintf("ruleSet%v", i)
+		}
+		switch {
+		case name == defaultAnonymizeRuleSet:
+			warnings = append(warnings, fmt.Sprintf("anonymize rule set %q has the same name as the built-in default rule set, so they can't be told apart in reports", name))
+		case names[name]:
+			warnings = append(warnings, fmt.Sprintf("anonymize rule set name %q is used more than once", name))
+		}
+		names[name] = true
+
+		if catchAll != "" {
+			warnings = append(warnings, fmt.Sprintf("anonymize rule set %q can never match, since rule set %q before it matches every doc", name, catchAll))
+		}
+		if len(ruleSet.Types) == 0 && ruleSet.KeyPattern == "" && catchAll == "" {
+			catchAll = name
+		}
+		if ruleSet.PassThrough && (ruleSet.AnonymizeKeys || len(ruleSet.SkipFields) > 0) {
+			warnings = append(warnings, fmt.Sprintf("anonymize rule set %q is passThrough, so its anonymizeKeys and skipFields are ignored", name))
+		}
+	}
+	return warnings
+}
+
+func lintProjections(rules []ProjectionRule) (warnings []string) {
+	catchAll := -1
+	for i, rule := range rules {
+		if catchAll >= 0 {
+			warnings = append(warnings, fmt.Sprintf("projections[%v] can never match, since projections[%v] before it matches every doc", i, catchAll))
+		}
+		if len(rule.Types) == 0 && catchAll < 0 {
+			catchAll = i
+		}
+	}
+	return warnings
+}
+
+// The seeds of the configured stages that generate random values, keyed by the config section to set
+// them in to regenerate the same dataset, eg {"geoFuzz": 1507045501123456789}
+func (e *ExampleApp) Seeds() map[string]int64 {
+	seeds := map[string]int64{}
+	if e.anonymizer != nil {
+		if seed, ok := e.anonymizer.Seed(); ok {
+			seeds["anonymize"] = seed
+		}
+	}
+	if e.GeoFuzzer != nil {
+		seeds["geoFuzz"] = e.GeoFuzzer.Seed()
+	}
+	if e.Faker != nil {
+		seeds["faker"] = e.Faker.Seed()
+	}
+	return seeds
+}
+
+// The rules of the configured stages that never matched a doc.  Only meaningful once a copy has finished.
+func (e *ExampleApp) UnusedRules() []string {
+	var unused []string
+	if e.anonymizer != nil {
+		unused = append(unused, e.anonymizer.UnusedRules()...)
+	}
+	if e.Projection != nil {
+		unused = append(unused, e.Projection.UnusedRules()...)
+	}
+	if e.Truncator != nil {
+		unused = append(unused, e.Truncator.UnusedRules()...)
+	}
+	if e.GeoFuzzer != nil {
+		unused = append(unused, e.GeoFuzzer.UnusedRules()...)
+	}
+	if e.Faker != nil {
+		unused = append(unused, e.Faker.UnusedRules()...)
+	}
+	if e.Generalizer != nil {
+		unused = append(unused, e.Generalizer.UnusedRules()...)
+	}
+	if e.Encryptor != nil {
+		unused = append(unused, e.Encryptor.UnusedRules()...)
+	}
+	return unused
+}
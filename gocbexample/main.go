@@ -0,0 +1,1298 @@
+// Package gocbexample copies, transforms and anonymizes Couchbase buckets.  ExampleApp and its options are the
+// library; Main is the gocb-example command line built on it.  See README.md for more info.
+package gocbexample
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"sync"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+const (
+
+	// XATTRS will be stored under this key
+	xattrKey = "Metadata"
+
+	// Default view and design doc name
+	designDoc = "all_docs"
+	viewName  = designDoc
+
+	// How many goroutines to use when processing view result pages
+	numGoRoutinesConcurrentViewResult = 1
+
+	// View result page size
+	// TODO: if this page size too large, it will return "panic: Error: queue overflowed" when doing bulk inserts.  Should handle that case.
+	// See https://issues.couchbase.com/browse/GOCBC-231
+	pageSizeViewResult = 1000
+
+	// The largest page size that WithPageSize accepts
+	maxPageSize = 10000
+
+	// How many subdoc mutations the post-insert phases run at once
+	defaultSubdocConcurrency = 16
+)
+
+type DocProcessorInput struct {
+	DocIds []string
+	Docs   []interface{}
+}
+
+// A custom function type that takes a slice of doc ids and a slice of doc bodies and returns an error
+type DocProcessor func(docIds []string, docs []interface{}) (err error)
+
+type DocProcessorReturnDocs func(input DocProcessorInput) (output DocProcessorInput, err error)
+
+// Combine several DocProcessorReturnDocs into one that runs them in order, feeding the output of each into the next.
+// Docs that a processor fails on with DocErrors are left out of the rest of the chain, and returned as its DocErrors.
+func ChainDocProcessors(processors ...DocProcessorReturnDocs) DocProcessorReturnDocs {
+	return func(input DocProcessorInput) (output DocProcessorInput, err error) {
+		output = input
+		var failed DocErrors
+		for _, processor := range processors {
+			if processor == nil {
+				continue
+			}
+			output, err = processor(output)
+			var docErrs DocErrors
+			if errors.As(err, &docErrs) {
+				failed = append(failed, docErrs...)
+				continue
+			}
+			if err != nil {
+				return output, err
+			}
+		}
+		return output, failed.err()
+	}
+}
+
+type BucketSpec struct {
+	Name string `json:"name"`
+
+	// The RBAC user the bucket is opened as.  Defaults to a user named after the bucket
+	Username string `json:"username,omitempty"`
+	Password string `json:"password"`
+
+	AdminPassword string `json:"adminPassword"` // Used to create bucket manager for adding views
+
+	// Timeouts and KV pool sizing for this bucket.  Nil keeps the gocb defaults
+	Tuning *BucketTuning `json:"tuning,omitempty"`
+}
+
+// A struct to keep references to the cluster connection and open buckets
+type ExampleApp struct {
+
+	// Use N1QL?  If false, use views
+	UseN1ql bool
+
+	// The engine setting, which EngineAuto resolves to UseN1ql once connected.  See WithEngine
+	Engine Engine
+
+	// The source and target are the same bucket, whose docs are transformed in place.  See WithInPlace
+	InPlace bool
+
+	// The CAS of each doc read from the source while copying in place
+	readCas *readCasTracker
+
+	// Identifies the current run in the Metadata XATTR and provenance fields
+	JobId string
+
+	// Split the view keyspace into this many ranges and query them concurrently.  0 or 1 means a single sequential query
+	ViewQueryRanges int
+
+	// The design doc and view used to iterate buckets.  Default to "all_docs"
+	DesignDoc string
+	ViewName  string
+
+	// Use a development (dev_ prefixed) design doc, eg for testing changes to the view
+	DevelopmentViews bool
+
+	// Replace an existing design doc with the same name that wasn't created by this tool
+	OverwriteDesignDoc bool
+
+	// Fail a view scan if the number of rows read doesn't match the view's total_rows.  If false, just log a warning
+	StrictRowCount bool
+
+	progressMutex sync.Mutex
+	progress      map[string]*ScanProgress
+
+	// What the app is doing, for embedding applications.  See Progress
+	phaseProgress phaseProgress
+
+	// Built-in transforms applied (in order) to every doc after the preInsertCallback
+	Transforms []DocProcessorReturnDocs
+
+	// Anonymization rule sets used by CopyBucketAnonymizeDoc
+	Anonymize AnonymizeConfig
+
+	anonymizer *Anonymizer
+
+	// If set, keep/drop fields per doc type.  Applied with the other Transforms
+	Projection *Projection
+
+	// If set, truncate oversized string and array values.  Applied with the other Transforms
+	Truncator *Truncator
+
+	// If set, perturb/round lat/lon coordinates.  Applied with the other Transforms
+	GeoFuzzer *GeoFuzzer
+
+	// If set, replace fields with fake values.  Applied with the other Transforms
+	Faker *Faker
+
+	// If set, generalize quasi-identifier fields.  Applied with the other Transforms
+	Generalizer *Generalizer
+
+	// Body and id codecs per doc type, used by TypedTransforms
+	codecs codecRegistry
+
+	// XATTR keys read into the metadata of the batches passed to BatchCallbacks
+	BatchXattrs []string
+
+	// If set, encrypt (or for the decrypt command, decrypt) fields.  Applied with the other Transforms
+	Encryptor *FieldEncryptor
+
+	// If set, set fields with CEL expressions.  Applied with the other Transforms
+	CelTransform *CelTransform
+
+	// Transform the docs with WASM plugins.  Applied with the other Transforms, before the Webhook
+	WasmPlugins []*WasmTransform
+
+	// If set, send the docs to an external HTTP endpoint to transform.  Applied after the other Transforms
+	Webhook *WebhookTransform
+
+	// If set, stamp provenance fields into the body of each copied doc
+	Provenance *ProvenanceSpec
+
+	// Send N1QL requests to these query nodes (host:port) round robin.  A single node pins all requests to it
+	QueryNodes []string
+
+	// Discover the query nodes and spread N1QL requests across them
+	SpreadQueries bool
+
+	// Cap on concurrent N1QL requests.  0 means no limit
+	MaxConcurrentQueries int
+
+	queryRouter *queryRouter
+
+	// A N1QL query used in place of the table scan of the source bucket.  It must project the doc id as id and
+	// the doc body as doc, and `{bucket}` is replaced by the source bucket name
+	SourceQuery string
+
+	// List the source doc ids with a query of this Analytics dataset rather than scanning the source bucket,
+	// and get the bodies from KV
+	AnalyticsDataset string
+
+	// Scan buckets with N1QL a page of this many docs at a time, keyed on META().id, rather than with a single
+	// query streaming the whole bucket.  0 disables paging
+	N1qlPageSize int
+
+	// Called once each page of a paged N1QL scan has been processed, and written if writes are batched, with
+	// the id of its last doc, eg to checkpoint the cursor.  Called with an empty id once the scan finishes
+	OnN1qlPage func(bucketName, lastDocId string) error
+
+	n1qlCursorsMutex sync.Mutex
+	n1qlCursors      map[string]string
+
+	// Checkpoint the progress of copies to the target bucket this often.  See WithCheckpointInterval
+	CheckpointInterval time.Duration
+
+	// Set by Resume, for the copy to carry on from its checkpoint
+	resume          bool
+	copyCheckpoints *copyCheckpointer
+
+	// Writes the docs of the copy in progress that are buffered for writing.  nil if writes aren't buffered
+	flushWrites func() error
+
+	// If reading a source doc from the active node fails, fall back to reading it from a replica
+	ReplicaReadFallback bool
+
+	replicaReadsMutex sync.Mutex
+	replicaReads      []string
+
+	// Read back every Nth written doc from the target and compare it with what was written.  0 disables sampling
+	SampleEveryN int
+
+	// Read sampled docs from a replica rather than the active
+	SampleFromReplica bool
+
+	sampleStats WriteSampleStats
+
+	// Limit the bytes per second read from buckets / written to the target bucket.  0 means no limit
+	ReadBytesPerSecond  int64
+	WriteBytesPerSecond int64
+
+	readLimiter  *ByteRateLimiter
+	writeLimiter *ByteRateLimiter
+
+	healthMonitor *HealthMonitor
+
+	// The latencies and errors of the KV reads and writes, for TuningAdvice
+	throughput throughputStats
+
+	// The server version and bucket capabilities detected by Connect.  Nil if they couldn't be detected
+	Capabilities *ClusterInfo
+
+	// What to do with docs that a pre-insert stage fails on, keyed by stage.  Defaults to ErrorPolicyAbort
+	ErrorPolicies map[string]ErrorPolicy
+
+	skipped skippedDocs
+
+	// Docs that fail to copy are written here, along with the error context.  Nil disables dead-lettering
+	DeadLetters *DeadLetterWriter
+
+	batchCounter int64
+
+	// Only process docs inside these run windows.  Nil means always run
+	Schedule *RunSchedule
+
+	// How long Connect waits for indexes/views to build.  Defaults to 10 minutes
+	ReadinessTimeout time.Duration
+
+	// Number of goroutines processing batches of docs, and the number of docs per view query page
+	Workers  int
+	PageSize int
+
+	// How failed reads and writes are retried
+	RetryPolicy RetryPolicy
+
+	// How a scan is retried if it fails because the bucket's views or indexes aren't ready yet
+	StartupRetryPolicy RetryPolicy
+
+	// Random sample of the docs written by a copy, for spot checks.  Nil if not sampling
+	copiedDocSampler *docIdSampler
+	spotCheckDocIds  []string
+
+	// Number of subdoc mutations the post-insert phases (XATTR stamping, type namespacing) run at once
+	SubdocConcurrency int
+
+	// Number of view pages fetched ahead of the page being processed.  Defaults to 1
+	ReadAheadPages int
+
+	// Batch writes independently of the read pages.  The zero value writes each read page as a batch
+	WriteBatching WriteBatching
+
+	// Copy the docs matching each of these rules, in order, before the rest.  See WithPriorities
+	Priorities []PriorityRule
+
+	// The XATTR copied docs are stamped with, Metadata by default.  See WithMetadataXattrKey
+	MetadataXattrKey string
+
+	// Add the mutation's CAS, seqno and CRC32C to the metadata XATTR.  See WithMetadataMacros
+	MetadataMacros bool
+
+	// Copy from this backup, restored into the source bucket.  See WithBackup
+	Backup *BackupSpec
+
+	// Sinks also written each batch the sink writes, eg a cache to warm as the target bucket is filled
+	Mirrors []Sink
+
+	// Also write the docs copied to Redis.  See WithRedis
+	Redis     *RedisConfig
+	redisSink *RedisSink
+
+	// Read and write the XATTRs of tombstones too.  See WithXattrAccessDeleted
+	XattrAccessDeleted bool
+
+	// Only read the source docs matching this filter.  See WithFilter
+	Filter       *DocFilter
+	sourceFilter *docFilter
+
+	// Stop once the app has run this long or read this many docs.  Zero leaves the limit off.  See WithLimits
+	MaxDuration     time.Duration
+	MaxDocs         int64
+	limitsStartedAt time.Time
+	docsRead        int64
+
+	// Logger for the app's log output.  Nil means the standard logger
+	Logger *log.Logger
+
+	// Where CopyBucketWithCallback reads docs from and writes them to.  Default to the source and target buckets
+	Source Source
+	Sink   Sink
+
+	// Copy these scopes or collections of the source bucket, rather than its default collection, to the target
+	// collections that CollectionMap maps them to.  See WithCollections
+	Collections   []string
+	CollectionMap map[string]string
+
+	// The pairs of collections Connect resolved Collections to, and the pair being copied
+	CollectionPairs  []CollectionPair
+	SourceCollection *gocb.Collection
+	TargetCollection *gocb.Collection
+
+	// The cluster connection and bulk operation timeout each bucket was opened with
+	bucketConnections map[*gocb.Bucket]bucketConnection
+
+	ConnSpec          string
+	ClusterConnection *gocb.Cluster
+	SourceBucketSpec  BucketSpec
+	TargetBucketSpec  BucketSpec
+	SourceBucket      *gocb.Bucket
+	TargetBucket      *gocb.Bucket
+}
+
+// Create a new ExampleApp
+func NewExample(sourceBucketSpec, targetBucketSpec BucketSpec) *ExampleApp {
+	return &ExampleApp{
+		UseN1ql:            false,
+		Workers:            numGoRoutinesConcurrentViewResult,
+		PageSize:           pageSizeViewResult,
+		SubdocConcurrency:  defaultSubdocConcurrency,
+		ReadAheadPages:     1,
+		RetryPolicy:        NoRetries,
+		StartupRetryPolicy: defaultStartupRetryPolicy,
+		MetadataXattrKey:   xattrKey,
+		DesignDoc:          designDoc,
+		ViewName:           viewName,
+		SourceBucketSpec:   sourceBucketSpec,
+		TargetBucketSpec:   targetBucketSpec,
+	}
+}
+
+// Close the cluster connections, so that the next job in the same process starts afresh
+func (e *ExampleApp) Close() {
+	clusters := []*gocb.Cluster{e.ClusterConnection}
+	for _, connection := range e.bucketConnections {
+		if connection.cluster != e.ClusterConnection {
+			clusters = append(clusters, connection.cluster)
+		}
+	}
+	for _, cluster := range clusters {
+		if cluster != nil {
+			if err := cluster.Close(nil); err != nil {
+				e.logf("Error closing cluster connection.  Err: %v", err)
+			}
+		}
+	}
+	e.bucketConnections = nil
+	if e.redisSink != nil {
+		if err := e.redisSink.Close(); err != nil {
+			e.logf("Error closing Redis connection.  Err: %v", err)
+		}
+	}
+	for _, plugin := range e.WasmPlugins {
+		if err := plugin.Close(); err != nil {
+			e.logf("Error closing WASM plugin: %v.  Err: %v", plugin.config.Path, err)
+		}
+	}
+}
+
+// Connect to the cluster and buckets, create primary indexes
+func (e *ExampleApp) Connect(connSpecStr string) (err error) {
+
+	if err := e.checkSameBucket(); err != nil {
+		return err
+	}
+
+	e.setupThrottles()
+
+	// Connect to the cluster as the user of the source bucket
+	e.ConnSpec = connSpecStr
+	e.ClusterConnection, err = e.connectCluster(e.SourceBucketSpec)
+	if err != nil {
+		return err
+	}
+
+	// Connect to Source Bucket
+	e.SourceBucket, err = e.openBucket(e.SourceBucketSpec)
+	if err != nil {
+		return err
+	}
+
+	// Connect to Target Bucket, which in place is the source
+	if e.InPlace {
+		e.TargetBucketSpec = e.SourceBucketSpec
+		e.TargetBucket = e.SourceBucket
+	} else {
+		e.TargetBucket, err = e.openBucket(e.TargetBucketSpec)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Turn off (or fail fast on) features that the server doesn't support
+	if err := e.probeCapabilities(); err != nil {
+		return err
+	}
+	e.selectEngine()
+	if e.UseN1ql && e.Engine == EngineAuto {
+		if err := e.validate(); err != nil {
+			return err
+		}
+	}
+
+	// Point the source and target at the first of the collections to copy
+	if err := e.resolveCollections(); err != nil {
+		return err
+	}
+	if !e.UseN1ql && e.Engine != EngineDCP {
+		if err := e.checkViewCollections(); err != nil {
+			return err
+		}
+	}
+
+	// Copy bucket to bucket unless other endpoints were set
+	if e.Source == nil && e.Backup != nil {
+		e.Source = e.NewBackupSource(*e.Backup, e.NewBucketSource(e.SourceBucket))
+	} else if e.Source == nil {
+		e.Source = e.NewBucketSource(e.SourceBucket)
+	}
+	if e.Sink == nil && e.InPlace {
+		e.Sink = e.NewInPlaceSink(e.SourceBucket)
+	} else if e.Sink == nil {
+		e.Sink = e.NewBucketSink(e.TargetBucket)
+	}
+
+	// DCP streams the docs from the KV engine, without an index or view
+	if e.Engine == EngineDCP {
+		return nil
+	}
+
+	switch e.UseN1ql {
+	case true:
+		if err := e.setupQueryRouter(); err != nil {
+			return err
+		}
+
+		// Create primary indexes on the source and target collections
+		err = e.ForEachCollectionPair(func(CollectionPair) error {
+			for _, bucket := range []*gocb.Bucket{e.SourceBucket, e.TargetBucket} {
+				err := e.collection(bucket).QueryIndexes().CreatePrimaryIndex(&gocb.CreatePrimaryQueryIndexOptions{IgnoreIfExists: true})
+				if err != nil {
+					return fmt.Errorf("Error creating primary index on: %v.  Err: %v", e.keyspaceName(bucket), err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+	case false: // use views
+
+		// Add design doc + view to source bucket
+		if err := e.upsertScanDesignDoc(e.SourceBucket, e.SourceBucketSpec); err != nil {
+			return err
+		}
+
+		// Add design doc + view to target bucket
+		if err := e.upsertScanDesignDoc(e.TargetBucket, e.TargetBucketSpec); err != nil {
+			return err
+		}
+
+	}
+
+	// Don't start iterating until the indexes/views are built
+	return e.WaitForScanIndexes()
+}
+
+// Copies source bucket to target bucket, anonymizing docs with the rule sets in e.Anonymize
+func (e *ExampleApp) CopyBucketAnonymizeDoc() (err error) {
+
+	anonymizer, err := NewAnonymizer(e.Anonymize)
+	if err != nil {
+		return err
+	}
+
+	return e.CopyBucketWithAnonymizer(anonymizer)
+
+}
+
+// Copies source bucket to target bucket, anonymizing docs with the given anonymizer
+func (e *ExampleApp) CopyBucketWithAnonymizer(anonymizer *Anonymizer) (err error) {
+
+	e.anonymizer = anonymizer
+
+	// Copy the bucket and pass the anonymizer as the pre-insert callback function
+	if err := e.CopyBucketWithCallback(anonymizer.Transform, nil); err != nil {
+		return err
+	}
+
+	return nil
+
+}
+
+// Copies source bucket to target bucket, inserting XATTRS in target docs
+func (e *ExampleApp) CopyBucketAddXATTRS() (err error) {
+
+	if !e.SupportsXattrs(e.TargetBucketSpec.Name) {
+		// Connect already warned that the XATTRs are left out
+		return e.CopyBucket()
+	}
+
+	// Create a post-insert callback function that will be invoked on
+	// every document that is copied from the source bucket and inserted into the target bucket.
+	// It adds the "DateCopied" XATTR to the doc.
+	postInsertCallback := func(results []WriteResult) error {
+
+		// Stamp the docs of the batch concurrently, rather than one round trip after another
+		return forEachConcurrently(e.SubdocConcurrency, len(results), func(i int) error {
+
+			result := results[i]
+			if result.Err != nil {
+				// Not written, the copy fails with this error once the callback returns
+				return nil
+			}
+
+			// The XATTR value contains metadata about the document: the bucket it was originally copied from
+			// as well as the date it was copied.
+			xattrVal := map[string]interface{}{
+				"DateCopied":     time.Now(),
+				"UpstreamSource": e.keyspaceName(e.SourceBucket),
+			}
+			if e.JobId != "" {
+				xattrVal["JobId"] = e.JobId
+			}
+			if e.MetadataMacros {
+				xattrVal["MutationCas"] = macroMutationCas
+				xattrVal["MutationSeqno"] = macroMutationSeqno
+				xattrVal["ValueCrc32c"] = macroMutationCrc32c
+			}
+
+			// Create CAS-safe XATTR mutation, using the CAS returned by the insert rather than re-reading the doc, and
+			// the expiry the doc was written with, since a mutation with expiry 0 clears the TTL on some servers
+			specs := upsertXattrWithMacros(nil, e.MetadataXattrKey, xattrVal)
+
+			// Execute mutation
+			collection, err := e.writeCollection(e.TargetBucket, "Stamping XATTRs")
+			if err != nil {
+				return err
+			}
+			_, err = collection.MutateIn(result.DocId, specs, mutateInOptions(gocb.SubdocDocFlagNone, result.Cas, result.Expiry))
+			return err
+
+		})
+	}
+
+	// Copy the bucket and pass the post-insert callback function
+	if err := e.CopyBucketWithWriteResults(nil, postInsertCallback); err != nil {
+		return err
+	}
+
+	return nil
+
+}
+
+func (e *ExampleApp) CopyBucket() (err error) {
+	if err := e.CopyBucketWithCallback(nil, nil); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// The keyspace is a bucket or collection path, eg `travel-sample`.`inventory`.`hotel`, and the docs are projected
+// under the alias
+func TableScanN1qlQuery(keyspace, alias string) string {
+	// Get the doc ID and the doc body in a single query -- eg:
+	// "SELECT META(`travel-sample`).id,* FROM `travel-sample` AS `travel-sample`"
+	//         ^^^^^^^^^^^^ doc id      ^ doc body
+	return fmt.Sprintf(
+		"SELECT META(`%[2]s`).id,* FROM %[1]s AS `%[2]s`",
+		keyspace,
+		alias,
+	)
+}
+
+func (e *ExampleApp) CopyBucketWithCallback(preInsertCallback DocProcessorReturnDocs, postInsertCallback DocProcessor) (err error) {
+	return e.copyBucket(copyCallbacks{preInsert: preInsertCallback, postInsert: postInsertCallback})
+}
+
+// The callbacks of a copy.  Any of them may be nil.
+type copyCallbacks struct {
+	preInsert  DocProcessorReturnDocs
+	postInsert DocProcessor
+
+	// The same, with the doc metadata
+	preInsertBatch  BatchCallback
+	postInsertBatch BatchCallback
+
+	// Called with the outcome of each write, even if some of them failed
+	postInsertResults WriteResultsCallback
+
+	// Called with the outcome of each batch
+	onBatch func(result BatchResult)
+}
+
+// Copy the source to the sink, invoking the callbacks for each batch
+func (e *ExampleApp) copyBucket(callbacks copyCallbacks) (err error) {
+
+	// Connect to Redis only once there are docs to copy, so that commands that don't copy don't need it
+	if e.Redis != nil && e.redisSink == nil {
+		if e.redisSink, err = NewRedisSink(*e.Redis); err != nil {
+			return err
+		}
+		e.Mirrors = append(e.Mirrors, e.redisSink)
+	}
+
+	// The built-in transforms, followed by the provenance stamp if enabled
+	transforms := append([]DocProcessorReturnDocs{}, e.Transforms...)
+	if e.Provenance != nil {
+		transforms = append(transforms, e.ProvenanceTransform(*e.Provenance))
+	}
+
+	// A docprocesser callback that *wraps* the postInsertCallback to do the following:
+	// - Write the docs to the sink (the target bucket by default)
+	// - Invoke the postInsertCallback
+	// - Record the context of any failure in the logs and dead-letter file
+	copyEachDoc := func(docIds []string, docs []interface{}) error {
+
+		batchId := e.nextBatchId()
+		writtenIds, err := e.copyBatch(batchId, docIds, docs, transforms, callbacks)
+		if callbacks.onBatch != nil {
+			callbacks.onBatch(BatchResult{BatchId: batchId, DocIds: writtenIds, Err: err})
+		}
+		return err
+	}
+
+	// Fail before scanning, rather than at the first write
+	if err := e.checkWritable(e.sinkBucket(), "Copying to "+e.Sink.Name()); err != nil {
+		return err
+	}
+	if e.InPlace {
+		// Record the CAS of each doc read, which the in-place sink writes it back with
+		e.readCas = newReadCasTracker()
+		defer func() { e.readCas = nil }()
+	}
+	e.logf("Copying from %v to %v", e.Source.Name(), e.Sink.Name())
+
+	checkpointer, err := e.startCopyCheckpoints()
+	if err != nil {
+		return err
+	}
+	e.copyCheckpoints = checkpointer
+	defer func() { e.copyCheckpoints = nil }()
+
+	if len(e.Priorities) == 0 {
+		e.startPhase("copy")
+		if err := e.copyLane(0, copyEachDoc, transforms, callbacks); err != nil {
+			return err
+		}
+		return checkpointer.finish()
+	}
+
+	// Copy the docs of each priority lane, then the rest, so that a lane has landed before the next one starts.  A
+	// resumed copy starts from the lane it was copying
+	for lane := checkpointer.startLane(); lane <= len(e.Priorities); lane++ {
+		laneName := e.priorityLaneName(lane)
+		e.logf("Copying priority lane: %v", laneName)
+		e.startPhase("copy " + laneName)
+		if err := e.copyLane(lane, copyEachDoc, transforms, callbacks); err != nil {
+			return err
+		}
+		if err := checkpointer.laneDone(lane); err != nil {
+			return err
+		}
+	}
+	return checkpointer.finish()
+
+}
+
+// Copy the docs of a priority lane from the source to the sink
+func (e *ExampleApp) copyLane(lane int, copyEachDoc DocProcessor, transforms []DocProcessorReturnDocs, callbacks copyCallbacks) (err error) {
+
+	if !e.WriteBatching.enabled() {
+		// Each read page is written as a batch
+		return e.Source.ForEachDoc(e.countProgress(e.priorityLaneFilter(lane, e.inPlaceReads(copyEachDoc))))
+	}
+
+	// Transform each read page, then buffer the docs until a write batch is full
+	buffer := newWriteBuffer(e.WriteBatching, func(docIds []string, docs []interface{}) error {
+		batchId := e.nextBatchId()
+		writtenIds, err := e.writeBatch(batchId, docIds, docs, callbacks)
+		if callbacks.onBatch != nil {
+			callbacks.onBatch(BatchResult{BatchId: batchId, DocIds: writtenIds, Err: err})
+		}
+		return err
+	})
+	e.copyCheckpoints.setFlush(buffer.flushBuffered)
+	defer e.copyCheckpoints.setFlush(nil)
+	e.flushWrites = buffer.flushBuffered
+	defer func() { e.flushWrites = nil }()
+	transformEachDoc := func(docIds []string, docs []interface{}) error {
+		batchId := e.nextBatchId()
+		docIds, docs, err := e.transformBatch(batchId, docIds, docs, transforms, callbacks)
+		if err != nil {
+			if callbacks.onBatch != nil {
+				callbacks.onBatch(BatchResult{BatchId: batchId, Err: err})
+			}
+			return err
+		}
+		// In place, the CAS the docs were read with is needed once they're written
+		e.readCas.hold(docIds)
+		return buffer.add(docIds, docs)
+	}
+
+	err = e.Source.ForEachDoc(e.countProgress(e.priorityLaneFilter(lane, e.inPlaceReads(transformEachDoc))))
+
+	// Write the docs that were transformed before any failure, so they aren't lost.  Closing also flushes the
+	// lane, so that its docs have all landed before the next lane starts
+	if closeErr := buffer.close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	return err
+
+}
+
+// Run a batch of docs through the transforms, write them to the sink and invoke the post-insert callbacks.
+// Returns the ids of the docs written.
+func (e *ExampleApp) copyBatch(batchId string, docIds []string, docs []interface{}, transforms []DocProcessorReturnDocs, callbacks copyCallbacks) (writtenIds []string, err error) {
+
+	docIds, docs, err = e.transformBatch(batchId, docIds, docs, transforms, callbacks)
+	if err != nil {
+		return nil, err
+	}
+	return e.writeBatch(batchId, docIds, docs, callbacks)
+}
+
+// Run a batch of docs through the preInsertCallback and transforms.  Failures are recorded against the docs
+// as they were at the failing phase.
+func (e *ExampleApp) transformBatch(batchId string, docIds []string, docs []interface{}, transforms []DocProcessorReturnDocs, callbacks copyCallbacks) ([]string, []interface{}, error) {
+
+	e.logf("Batch %v: call preInsertCallback on %v docs", batchId, len(docIds))
+
+	preInsertCallback := callbacks.preInsert
+	if callbacks.preInsertBatch != nil {
+		preInsertCallback = ChainDocProcessors(preInsertCallback, e.batchCallbackProcessor(e.sourceBucket(), batchId, callbacks.preInsertBatch))
+	}
+	if preInsertCallback != nil {
+		params := DocProcessorInput{
+			DocIds: docIds,
+			Docs:   docs,
+		}
+		returnVal, err := e.runStage(StagePreInsert, preInsertCallback, batchId, params)
+		if err != nil {
+			return nil, nil, e.recordCopyError(newDocError(PhaseTransform, "", err), batchId, docIds, docs)
+		}
+		docs = returnVal.Docs
+		docIds = returnVal.DocIds
+	}
+
+	if len(transforms) > 0 {
+		returnVal, err := e.runStage(StageTransforms, ChainDocProcessors(transforms...), batchId, DocProcessorInput{
+			DocIds: docIds,
+			Docs:   docs,
+		})
+		if err != nil {
+			return nil, nil, e.recordCopyError(newDocError(PhaseTransform, "", err), batchId, docIds, docs)
+		}
+		docs = returnVal.Docs
+		docIds = returnVal.DocIds
+	}
+
+	return docIds, docs, nil
+}
+
+// Write a batch of transformed docs to the sink and invoke the post-insert callbacks.  Failures are recorded
+// against the docs of the batch.  Returns the ids of the docs written.
+func (e *ExampleApp) writeBatch(batchId string, docIds []string, docs []interface{}, callbacks copyCallbacks) (writtenIds []string, err error) {
+
+	batchSize := docsSize(docIds, docs)
+	e.writeLimiter.Wait(batchSize)
+
+	e.logf("Writing %v docs to %v", len(docIds), e.Sink.Name())
+
+	if len(docIds) == 0 {
+		// Every doc was filtered out by the transforms
+		return nil, nil
+	}
+
+	startedAt := time.Now()
+	writeResults, err := writeDocsWithResults(e.Sink, docIds, docs)
+	e.throughput.recordWrites(len(docIds), batchSize, time.Since(startedAt), temporaryWriteFailures(writeResults, err))
+	if callbacks.postInsertResults != nil {
+		// Called even if some writes failed, so the callback can act on the docs that were written
+		if resultsErr := callbacks.postInsertResults(writeResults); resultsErr != nil && err == nil {
+			return docIds, e.recordCopyError(newDocError(PhasePostInsert, "", resultsErr), batchId, docIds, docs)
+		}
+	}
+	if err != nil {
+		return nil, e.recordCopyError(newDocError(PhaseTargetWrite, "", err), batchId, docIds, docs)
+	}
+
+	if e.sinkIsTargetBucket() {
+		if err := e.sampleWrittenDocs(docIds, docs); err != nil {
+			return nil, e.recordCopyError(newDocError(PhaseTargetWrite, "", err), batchId, docIds, docs)
+		}
+		if e.copiedDocSampler != nil {
+			e.copiedDocSampler.add(docIds, docs)
+		}
+	}
+
+	for _, mirror := range e.Mirrors {
+		if err := mirror.WriteDocs(docIds, docs); err != nil {
+			return docIds, e.recordCopyError(newDocError(PhaseTargetWrite, "", err), batchId, docIds, docs)
+		}
+	}
+
+	e.logf("Wrote %v docs, calling postInsertCallback", len(docIds))
+
+	if callbacks.postInsert != nil {
+		if err := callbacks.postInsert(docIds, docs); err != nil {
+			return docIds, e.recordCopyError(newDocError(PhasePostInsert, "", err), batchId, docIds, docs)
+		}
+	}
+	if callbacks.postInsertBatch != nil {
+		postInsertBatch := e.batchCallbackProcessor(e.sinkBucket(), batchId, callbacks.postInsertBatch)
+		if _, err := postInsertBatch(DocProcessorInput{DocIds: docIds, Docs: docs}); err != nil {
+			return docIds, e.recordCopyError(newDocError(PhasePostInsert, "", err), batchId, docIds, docs)
+		}
+	}
+
+	e.logf("Called postInsertCallback")
+
+	return docIds, nil
+
+}
+
+func (e *ExampleApp) GetXattrs(docId, xattrKey string) (xattrVal interface{}, err error) {
+
+	res, err := e.collection(e.TargetBucket).LookupIn(docId, []gocb.LookupInSpec{
+		gocb.GetSpec(xattrKey, &gocb.GetSpecOptions{IsXattr: true}),
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res.ContentAt(0, &xattrVal)
+
+	return xattrVal, nil
+
+}
+
+func (e *ExampleApp) GetSubdocField(docId, subdocKey string) (retValue interface{}, err error) {
+
+	frag, err := e.collection(e.TargetBucket).LookupIn(docId, []gocb.LookupInSpec{gocb.GetSpec(subdocKey, nil)}, nil)
+	if err != nil {
+		return nil, err
+	}
+	frag.ContentAt(0, &retValue)
+
+	return retValue, nil
+
+}
+
+func (e *ExampleApp) SetSubdocField(docId, subdocKey string, subdocVal interface{}) (err error) {
+
+	err = e.mutateInKeepingExpiry(e.TargetBucket, e.TargetBucketSpec.Name, docId, gocb.SubdocDocFlagNone, 0, []gocb.MutateInSpec{
+		gocb.UpsertSpec(subdocKey, subdocVal, nil),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+
+}
+
+// Loop over each doc in the given bucket with whichever query engine is configured, subject to the read byte rate
+// limit and run windows.  Only the source docs matching the Filter are passed on.
+func (e *ExampleApp) forEachDocIdBucket(docProcessor DocProcessor, bucket *gocb.Bucket) (err error) {
+	return e.walkBucket(bucket, WalkOptions{}, docProcessor)
+}
+
+// Loop over each doc in the bucket and callback the doc id processor with the doc id
+func (e *ExampleApp) ForEachDocIdBucketN1ql(docProcessor DocProcessor, bucket *gocb.Bucket) (err error) {
+
+	e.logf("Performing operation over bucket: %v", e.keyspaceName(bucket))
+	defer e.logf("Finished operation over bucket: %v", e.keyspaceName(bucket))
+
+	// Get the doc ID and the doc body in a single query
+	keyspace, alias := e.n1qlKeyspace(bucket)
+	rows, err := e.executeN1qlQuery(bucket, TableScanN1qlQuery(keyspace, alias), nil)
+	if err != nil {
+		return newDocError(PhaseSourceRead, "", err)
+	}
+	defer rows.Close()
+
+	row := map[string]interface{}{}
+	for rows.Next(&row) {
+
+		// Get row ID and document
+		rowIdStr, docRaw, err := n1qlRowDoc(row, alias)
+		if err != nil {
+			return err
+		}
+
+		if docProcessor != nil {
+			// Invoke the doc processor callback
+			if err := docProcessor([]string{rowIdStr}, []interface{}{docRaw}); err != nil {
+				return err
+			}
+		}
+
+	}
+
+	// Surface any errors that were reported after the results
+	return newDocError(PhaseSourceRead, "", rows.Close())
+}
+
+func (e *ExampleApp) ForEachDocIdBucketViewsConcurrent(docProcessor DocProcessor, bucket *gocb.Bucket) (err error) {
+	return e.forEachDocIdBucketViewsConcurrent(docProcessor, bucket, e.Workers)
+}
+
+// Loop over each doc in the bucket via views, processing the pages with a pool of workers
+func (e *ExampleApp) forEachDocIdBucketViewsConcurrent(docProcessor DocProcessor, bucket *gocb.Bucket, workers int) (err error) {
+
+	pendingWorkWaitGroup := sync.WaitGroup{}
+
+	// Create a channel to pass docs to the goroutines
+	viewResultsChanBufferSize := 5 * workers
+	viewResultsChan := make(chan DocProcessorInput, viewResultsChanBufferSize)
+
+	// The first error returned by the docProcessor, which stops the scan at the next page
+	var errMutex sync.Mutex
+	var firstErr error
+	processorErr := func() error {
+		errMutex.Lock()
+		defer errMutex.Unlock()
+		return firstErr
+	}
+
+	// Create a pool of goroutines that will process docs, until the channel is closed once the scan is done
+	for i := 0; i < workers; i++ {
+		go func(goroutineId int) {
+
+			e.logf("Goroutine %v waiting for item in viewResults", goroutineId)
+			for viewResults := range viewResultsChan {
+				if docProcessor != nil && processorErr() == nil {
+					e.logf("Goroutine %v read viewResults and is invoking docProcessor", goroutineId)
+					err := docProcessor(viewResults.DocIds, viewResults.Docs)
+					if err == nil && len(viewResults.DocIds) > 0 && e.copyCheckpoints.tracks(bucket) {
+						err = e.copyCheckpoints.viewPageDone(viewResults.DocIds[len(viewResults.DocIds)-1])
+					}
+					if err != nil {
+						errMutex.Lock()
+						if firstErr == nil {
+							firstErr = err
+						}
+						errMutex.Unlock()
+					}
+				}
+
+				pendingWorkWaitGroup.Done()
+				e.logf("Goroutine %v waiting for item in viewResults", goroutineId)
+			}
+		}(i)
+	}
+
+	viewResultsProcessor := func(docIds []string, docs []interface{}) error {
+
+		if err := processorErr(); err != nil {
+			return err
+		}
+
+		docProcessorInput := DocProcessorInput{
+			DocIds: docIds,
+			Docs:   docs,
+		}
+
+		// Add to the wait group
+		pendingWorkWaitGroup.Add(1)
+
+		// Loop over view results
+		// Send result down the channel  (blocks if all goroutines are busy).  Increment workPending wait group
+		now := time.Now()
+		e.logf("Adding view results to chan")
+		viewResultsChan <- docProcessorInput
+		e.logf("Added view results to chan, took: %v", time.Since(now))
+
+		return nil
+
+	}
+
+	err = e.ForEachDocIdBucketViews(viewResultsProcessor, bucket)
+
+	// No more pages will be sent, so the workers exit once they've processed the ones queued
+	close(viewResultsChan)
+
+	// Wait until all work is done, so that no page is still being written once this returns
+	pendingWorkWaitGroup.Wait()
+
+	if err != nil {
+		return err
+	}
+	return processorErr()
+
+}
+
+// Loop over each doc in the bucket and callback the doc id processor with the doc id
+// TODO: make sure this works if the view is in the process of being indexed
+func (e *ExampleApp) ForEachDocIdBucketViews(docProcessor DocProcessor, bucket *gocb.Bucket) (err error) {
+
+	if err := e.checkViewKeyspace(bucket); err != nil {
+		return err
+	}
+
+	// Count the rows read so that they can be checked against the view's total_rows
+	progress := e.startScanProgress(bucket.Name())
+	countingDocProcessor := func(docIds []string, docs []interface{}) error {
+		if err := docProcessor(docIds, docs); err != nil {
+			return err
+		}
+		progress.add(len(docIds))
+		return nil
+	}
+
+	if checkpointer := e.copyCheckpoints; checkpointer.tracks(bucket) {
+		err = e.forEachDocIdBucketViewsCheckpointed(countingDocProcessor, bucket, checkpointer)
+	} else if e.ViewQueryRanges > 1 {
+		err = e.ForEachDocIdBucketViewsParallel(countingDocProcessor, bucket, e.ViewQueryRanges)
+	} else {
+		e.logf("Performing operation via views over bucket: %v", bucket.Name())
+		defer e.logf("Finished operation via views over bucket: %v", bucket.Name())
+
+		err = e.ForEachDocIdBucketViewRange(countingDocProcessor, bucket, "", "")
+	}
+	if err != nil {
+		return err
+	}
+
+	return e.checkScanComplete(progress)
+}
+
+// Loop over each doc in the bucket whose id is in [startKey, endKey) and callback the doc id processor
+// with the doc id.  An empty startKey or endKey leaves that end of the range open.
+func (e *ExampleApp) ForEachDocIdBucketViewRange(docProcessor DocProcessor, bucket *gocb.Bucket, startKey, endKey string) (err error) {
+	return e.forEachDocIdBucketViewRange(docProcessor, bucket, viewKeyRange{StartKey: startKey, EndKey: endKey}, "", nil)
+}
+
+// Loop over each doc in the key range, starting after afterKey if it's set, eg to resume a scan.  onPage, if
+// non-nil, is called with the last key and number of docs of each page before it's processed.
+func (e *ExampleApp) forEachDocIdBucketViewRange(docProcessor DocProcessor, bucket *gocb.Bucket, keyRange viewKeyRange, afterKey string, onPage func(lastKey string, docs int)) (err error) {
+
+	startKey, endKey := keyRange.StartKey, keyRange.EndKey
+
+	if e.ReadAheadPages > 0 {
+		// Query the view and fetch the bodies of the next page while the current one is processed
+		queue, finish := readAhead(docProcessor, e.ReadAheadPages)
+		defer func() {
+			if finishErr := finish(); err == nil {
+				err = finishErr
+			}
+		}()
+		docProcessor = queue
+	}
+
+	viewOpts := e.newScanViewOptions()
+
+	// The last key of the previous page, which the next page starts from
+	lastKey := afterKey
+
+	for {
+
+		// Between pages, the results of the last view query have all been read
+		if err := e.waitForRunWindow(bucket); err != nil {
+			return err
+		}
+
+		var rangeStart, rangeEnd interface{}
+		if startKey != "" {
+			rangeStart = startKey
+		}
+		if lastKey != "" {
+			rangeStart = lastKey
+		}
+		if endKey != "" {
+			rangeEnd = endKey
+		}
+		if rangeStart != nil || rangeEnd != nil {
+			viewOpts.StartKey, viewOpts.EndKey, viewOpts.InclusiveEnd = rangeStart, rangeEnd, false
+		}
+		viewOpts.Limit = uint32(e.PageSize)
+
+		e.logf("Calling ViewQuery: %v with start key: %v, end key: %v, limit: %v", e.scanViewName(), rangeStart, rangeEnd, viewOpts.Limit)
+		var viewResults *gocb.ViewResult
+		_, err := e.RetryPolicy.do(e.logf, "view query", isTemporaryError, func() (err error) {
+			viewResults, err = e.executeScanViewQuery(bucket, viewOpts)
+			return err
+		})
+		if err != nil {
+			// TODO: Sometimes getting this error, should handle better
+			// TODO: .. Error: Error executing viewQuery: &{all_docs all_docs map[limit:[15000] skip:[1365000]] {[]}}.
+			// TODO: .. Err: Get http://host:8092/bucket/_design/all_docs/_view/all_docs?limit=15000&skip=1365000: net/http: request canceled
+			return newDocError(PhaseSourceRead, "", fmt.Errorf("Error executing view query: %v from key: %v.  Err: %w", e.scanViewName(), rangeStart, wrapGocbError(err)))
+		}
+
+		numResultsProcessed := 0
+		isFirstPage := lastKey == ""
+
+		docIds := []string{}
+
+		for {
+
+			if gotRow := viewResults.Next(); gotRow == false {
+				e.logf("No more rows in view result.")
+				if err := viewResults.Err(); err != nil {
+					return newDocError(PhaseSourceRead, "", fmt.Errorf("Error reading view query: %v.  Err: %w", e.scanViewName(), wrapGocbError(err)))
+				}
+				if isFirstPage {
+					// total_rows is the same on every page, so only record it from the first one
+					if metaData, err := viewResults.MetaData(); err == nil {
+						e.ScanProgress(bucket.Name()).setTotal(int(metaData.TotalRows))
+						e.phaseProgress.setTotal(int(metaData.TotalRows))
+					}
+				}
+				if numResultsProcessed == 0 {
+					// No point in going to the next page, since this page had 0 results
+					return nil
+				}
+				// We've processed all results in this page, break out of inner for loop to process another page of results
+				break
+			}
+
+			// Get row ID
+			rowIdStr := viewResults.Row().ID
+
+			if rowIdStr == lastKey {
+				// Don't add the lastKey, since it was already added in previous iteration and
+				// we'll get a duplicate key error trying to insert.  The other way to solve
+				// this would be to change the insert -> upsert
+				continue
+			}
+
+			lastKey = rowIdStr
+			e.logf("rowIdStr: %v", rowIdStr)
+
+			docIds = append(docIds, rowIdStr)
+
+			numResultsProcessed += 1
+
+		}
+
+		// The view only emits ids, so fetch the doc bodies for this page via bulk ops
+		numRows := len(docIds)
+		docIds, docs, err := e.fetchDocs(bucket, docIds)
+		if err != nil {
+			return err
+		}
+		if skipped := numRows - len(docIds); skipped > 0 {
+			// Deleted since they were indexed, but still rows of the view's total_rows
+			e.ScanProgress(bucket.Name()).skip(skipped)
+		}
+
+		if onPage != nil && len(docIds) > 0 {
+			onPage(docIds[len(docIds)-1], len(docIds))
+		}
+
+		// Invoke the doc processor callback
+		if err := docProcessor(docIds, docs); err != nil {
+			return err
+		}
+
+	}
+
+}
+
+// The XATTR recording the namespace added to a doc's type field and the type it replaced
+const namespaceXattrKey = "Namespace"
+
+// Add a namespace to the type field of every doc in the target bucket, eg "airline" -> "foo-component:airline".
+// The current type is taken from the doc bodies read by the scan, and each doc is updated with a single
+// MutateIn that replaces the type and inserts the Namespace XATTR with the original type.  The XATTR insert
+// fails if an earlier run already namespaced the doc, which fails the whole (atomic) MutateIn, so a rerun
+// never adds the namespace twice.
+func (e *ExampleApp) AddNameSpaceToTypeFieldViaSubdoc(namespacePrefix string) (err error) {
+
+	recordOriginal := e.SupportsXattrs(e.TargetBucketSpec.Name)
+
+	appendNamespaceToTypeField := func(docIds []string, docs []interface{}) error {
+
+		return forEachConcurrently(e.SubdocConcurrency, len(docIds), func(i int) error {
+
+			docId := docIds[i]
+			body, ok := docs[i].(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			currentValueOfTypeField, ok := body["type"]
+			if !ok {
+				return nil
+			}
+			if typeStr, ok := currentValueOfTypeField.(string); ok && strings.HasPrefix(typeStr, namespacePrefix+":") {
+				// Already namespaced
+				return nil
+			}
+
+			newValueOfTypeField := fmt.Sprintf("%v:%v", namespacePrefix, currentValueOfTypeField)
+
+			specs := []gocb.MutateInSpec{gocb.ReplaceSpec("type", newValueOfTypeField, nil)}
+			if recordOriginal {
+				specs = append(specs, gocb.InsertSpec(namespaceXattrKey, map[string]interface{}{
+					"prefix":       namespacePrefix,
+					"originalType": currentValueOfTypeField,
+				}, &gocb.InsertSpecOptions{IsXattr: true}))
+			}
+			err := e.mutateInKeepingExpiry(e.TargetBucket, e.TargetBucketSpec.Name, docId, gocb.SubdocDocFlagNone, 0, specs)
+			if err != nil {
+				if errors.Is(err, gocb.ErrPathExists) {
+					e.logf("Doc %v was already namespaced, skipping", docId)
+					return nil
+				}
+				return fmt.Errorf("Error setting subdoc field: %v.  Doc: %v", err, docId)
+			}
+			return nil
+
+		})
+	}
+
+	e.startPhase("namespace")
+	if err := e.Walk(TargetBucketRole, WalkOptions{}, e.countProgress(appendNamespaceToTypeField)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Run the command named by the first argument of os.Args, defaulting to copy, and exit on an error
+func Main() {
+
+	// The first argument selects the command, defaulting to "copy"
+	commandName := "copy"
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		commandName, args = args[0], args[1:]
+	}
+	if len(args) > 0 {
+		// Commands with a subcommand, eg "xattr set"
+		if _, ok := commands[commandName+" "+args[0]]; ok {
+			commandName, args = commandName+" "+args[0], args[1:]
+		}
+	}
+
+	cmd, ok := commands[commandName]
+	if !ok {
+		log.Fatalf("Unknown command: %v.  Expected one of: %v", commandName, strings.Join(commandNames(), ", "))
+	}
+
+	flags := flag.NewFlagSet(commandName, flag.ExitOnError)
+	if cmd.noJob {
+		run := cmd.setup(flags)
+		flags.Parse(args)
+		if err := run(nil); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		return
+	}
+	jobFlags := addJobFlags(flags)
+	run := cmd.setup(flags)
+	flags.Parse(args)
+
+	config, err := jobFlags.config()
+	if err == nil {
+		err = RunJob(commandName, cmd, config, run)
+	}
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+}
@@ -0,0 +1,256 @@
+package gocbexample
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"time"
+)
+
+// The key template of imported MongoDB docs, unless set
+const defaultMongoKeyTemplate = "${_id}"
+
+// A Source that reads a mongoexport dump, either one doc per line (the default) or a JSON array (--jsonArray),
+// converting MongoDB extended JSON, canonical or relaxed, into plain JSON:
+//
+//	{"$oid": "5a9..."}                      -> "5a9..."
+//	{"$date": "2017-10-03T14:25:01Z"}       -> "2017-10-03T14:25:01Z", as are {"$date": {"$numberLong": ms}}
+//	{"$numberLong": "42"}, {"$numberInt"}   -> 42, and the same for $numberDouble and $numberDecimal
+//	{"$binary": {"base64": "..."}}          -> "..." (the base64)
+//	{"$regularExpression": {"pattern": "^a", "options": "i"}} -> "/^a/i"
+//	{"$timestamp": {"t": 1, "i": 2}}        -> {"t": 1, "i": 2}
+//	{"$minKey": 1}, {"$maxKey": 1}, {"$undefined": true} -> null
+//
+// Doc ids are built from the converted fields by KeyTemplate, "${_id}" by default.
+type MongoSource struct {
+	Path        string
+	KeyTemplate *DocKeyTemplate
+
+	// Drop the _id field from the docs once their ids are built, eg when it's only the ObjectId
+	DropId bool
+
+	// Number of docs passed to the DocProcessor at once
+	BatchSize int
+}
+
+func NewMongoSource(path, keyTemplate string, dropId bool) (*MongoSource, error) {
+	if keyTemplate == "" {
+		keyTemplate = defaultMongoKeyTemplate
+	}
+	template, err := NewDocKeyTemplate(keyTemplate)
+	if err != nil {
+		return nil, err
+	}
+	return &MongoSource{Path: path, KeyTemplate: template, DropId: dropId, BatchSize: pageSizeViewResult}, nil
+}
+
+func (s *MongoSource) Name() string {
+	return fmt.Sprintf("mongoexport:%v", s.Path)
+}
+
+func (s *MongoSource) ForEachDoc(docProcessor DocProcessor) error {
+
+	file, err := os.Open(s.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	batch := DocProcessorInput{}
+	flush := func() error {
+		if len(batch.DocIds) == 0 {
+			return nil
+		}
+		err := docProcessor(batch.DocIds, batch.Docs)
+		batch = DocProcessorInput{}
+		return err
+	}
+
+	reader := bufio.NewReader(file)
+	decoder := json.NewDecoder(reader)
+	decoder.UseNumber()
+	if isArray, err := startsJSONArray(reader); err != nil {
+		return err
+	} else if isArray {
+		if _, err := decoder.Token(); err != nil {
+			return fmt.Errorf("Error reading file: %v.  Err: %v", s.Path, err)
+		}
+	}
+
+	for docNum := 1; decoder.More(); docNum++ {
+		var raw interface{}
+		if err := decoder.Decode(&raw); err == io.EOF {
+			break
+		} else if err != nil {
+			return newDocError(PhaseSourceRead, "", fmt.Errorf("Error reading doc %v of file: %v.  Err: %w", docNum, s.Path, err))
+		}
+		doc, err := fromExtendedJSON(raw)
+		if err != nil {
+			return newDocError(PhaseSourceRead, "", fmt.Errorf("Error converting doc %v of file: %v.  Err: %w", docNum, s.Path, err))
+		}
+		docId, err := s.KeyTemplate.key(doc)
+		if err != nil {
+			return newDocError(PhaseSourceRead, "", fmt.Errorf("Error building the id of doc %v of file: %v.  Err: %w", docNum, s.Path, err))
+		}
+		if body, ok := doc.(map[string]interface{}); ok && s.DropId {
+			delete(body, "_id")
+		}
+		batch.DocIds = append(batch.DocIds, docId)
+		batch.Docs = append(batch.Docs, doc)
+		if len(batch.DocIds) >= s.BatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return flush()
+}
+
+// Does the file hold a JSON array, rather than a doc per line?  Leaves the reader where it was
+func startsJSONArray(reader *bufio.Reader) (bool, error) {
+	for skip := 1; ; skip++ {
+		peeked, err := reader.Peek(skip)
+		if err == io.EOF {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		switch peeked[skip-1] {
+		case ' ', '\t', '\r', '\n', 0xEF, 0xBB, 0xBF:
+			// Whitespace, or a UTF-8 byte order mark
+			continue
+		}
+		return peeked[skip-1] == '[', nil
+	}
+}
+
+// Convert MongoDB extended JSON, as decoded with UseNumber, into plain JSON values
+func fromExtendedJSON(val interface{}) (interface{}, error) {
+
+	switch typed := val.(type) {
+	case []interface{}:
+		for i, elem := range typed {
+			converted, err := fromExtendedJSON(elem)
+			if err != nil {
+				return nil, err
+			}
+			typed[i] = converted
+		}
+		return typed, nil
+
+	case map[string]interface{}:
+		if converted, ok, err := fromExtendedJSONWrapper(typed); ok || err != nil {
+			return converted, err
+		}
+		for field, fieldVal := range typed {
+			converted, err := fromExtendedJSON(fieldVal)
+			if err != nil {
+				return nil, fmt.Errorf("%v: %v", field, err)
+			}
+			typed[field] = converted
+		}
+		return typed, nil
+
+	case json.Number:
+		return numberValue(typed.String()), nil
+	}
+	return val, nil
+}
+
+// Convert an extended JSON type wrapper, eg {"$oid": ".."}.  Returns false if obj isn't one
+func fromExtendedJSONWrapper(obj map[string]interface{}) (converted interface{}, ok bool, err error) {
+
+	switch {
+	case len(obj) == 1:
+	case len(obj) == 2 && obj["$binary"] != nil && obj["$type"] != nil:
+		// Legacy binary: {"$binary": "<base64>", "$type": "00"}
+		return obj["$binary"], true, nil
+	default:
+		return nil, false, nil
+	}
+
+	for wrapper, wrapped := range obj {
+		switch wrapper {
+		case "$oid", "$symbol", "$code":
+			return wrapped, true, nil
+
+		case "$numberLong", "$numberInt", "$numberDouble", "$numberDecimal":
+			s, isString := wrapped.(string)
+			if !isString {
+				return nil, true, fmt.Errorf("%v must be a string, got: %v", wrapper, wrapped)
+			}
+			return numberValue(s), true, nil
+
+		case "$date":
+			return mongoDate(wrapped)
+
+		case "$binary":
+			if binary, isObj := wrapped.(map[string]interface{}); isObj {
+				return binary["base64"], true, nil
+			}
+			return wrapped, true, nil
+
+		case "$regularExpression":
+			regex, isObj := wrapped.(map[string]interface{})
+			if !isObj {
+				return nil, true, fmt.Errorf("$regularExpression must be an object, got: %v", wrapped)
+			}
+			return fmt.Sprintf("/%v/%v", regex["pattern"], regex["options"]), true, nil
+
+		case "$timestamp":
+			converted, err := fromExtendedJSON(wrapped)
+			return converted, true, err
+
+		case "$minKey", "$maxKey", "$undefined":
+			return nil, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// A $date as an ISO-8601 string in UTC.  Relaxed dates are already strings; canonical ones, and dates outside
+// 1970-9999 in relaxed mode, are milliseconds since the epoch
+func mongoDate(wrapped interface{}) (interface{}, bool, error) {
+
+	var millisString string
+	switch date := wrapped.(type) {
+	case string:
+		if _, err := time.Parse(time.RFC3339Nano, date); err != nil {
+			return nil, true, fmt.Errorf("Invalid $date: %v.  Err: %v", date, err)
+		}
+		return date, true, nil
+	case json.Number:
+		millisString = date.String()
+	case map[string]interface{}:
+		millisString, _ = date["$numberLong"].(string)
+	}
+
+	millis, err := strconv.ParseInt(millisString, 10, 64)
+	if err != nil {
+		return nil, true, fmt.Errorf("Invalid $date: %v", wrapped)
+	}
+	date := time.Unix(millis/1000, millis%1000*int64(time.Millisecond)).UTC()
+	return date.Format("2006-01-02T15:04:05.000Z07:00"), true, nil
+}
+
+// A number as the float64 the rest of the pipeline expects, unless that would lose precision, eg for a
+// 64-bit id or a decimal, in which case it's kept as the exact json.Number.  NaN and Infinity, which JSON can't
+// hold, are kept as strings.
+func numberValue(s string) interface{} {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return s
+	}
+	if strconv.FormatFloat(f, 'f', -1, 64) == s || strconv.FormatFloat(f, 'g', -1, 64) == s {
+		return f
+	}
+	if _, err := json.Marshal(json.Number(s)); err == nil {
+		return json.Number(s)
+	}
+	return s
+}
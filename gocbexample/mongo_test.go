@@ -0,0 +1,131 @@
+package gocbexample
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The same docs as mongoexport writes them in canonical and in relaxed mode convert to the same plain JSON
+func TestFromExtendedJSONModes(t *testing.T) {
+
+	canonical := `{
+		"_id": {"$oid": "5a934e000102030405000000"},
+		"created": {"$date": {"$numberLong": "1507040701123"}},
+		"visits": {"$numberLong": "42"},
+		"rating": {"$numberDouble": "4.5"},
+		"big": {"$numberLong": "9007199254740993"},
+		"tags": [{"$numberInt": "1"}, "x"],
+		"avatar": {"$binary": {"base64": "AQI=", "subType": "00"}},
+		"pattern": {"$regularExpression": {"pattern": "^a", "options": "i"}},
+		"updated": {"$timestamp": {"t": 1, "i": 2}},
+		"lowest": {"$minKey": 1}
+	}`
+	relaxed := `{
+		"_id": {"$oid": "5a934e000102030405000000"},
+		"created": {"$date": "2017-10-03T14:25:01.123Z"},
+		"visits": 42,
+		"rating": 4.5,
+		"big": 9007199254740993,
+		"tags": [1, "x"],
+		"avatar": {"$binary": "AQI=", "$type": "00"},
+		"pattern": {"$regularExpression": {"pattern": "^a", "options": "i"}},
+		"updated": {"$timestamp": {"t": 1, "i": 2}},
+		"lowest": {"$minKey": 1}
+	}`
+	want := map[string]interface{}{
+		"_id":     "5a934e000102030405000000",
+		"created": "2017-10-03T14:25:01.123Z",
+		"visits":  42.0,
+		"rating":  4.5,
+		"big":     json.Number("9007199254740993"),
+		"tags":    []interface{}{1.0, "x"},
+		"avatar":  "AQI=",
+		"pattern": "/^a/i",
+		"updated": map[string]interface{}{"t": 1.0, "i": 2.0},
+		"lowest":  nil,
+	}
+
+	for _, extended := range []string{canonical, relaxed} {
+		decoder := json.NewDecoder(strings.NewReader(extended))
+		decoder.UseNumber()
+		var doc interface{}
+		if err := decoder.Decode(&doc); err != nil {
+			t.Fatal(err)
+		}
+		got, err := fromExtendedJSON(doc)
+		if err != nil {
+			t.Fatalf("fromExtendedJSON(%v) failed: %v", extended, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("fromExtendedJSON(%v) = %#v, want %#v", extended, got, want)
+		}
+	}
+
+	for _, invalid := range []string{`{"at": {"$date": "yesterday"}}`, `{"n": {"$numberLong": 42}}`, `{"r": {"$regularExpression": "^a"}}`} {
+		var doc interface{}
+		if err := json.Unmarshal([]byte(invalid), &doc); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fromExtendedJSON(doc); err == nil {
+			t.Errorf("fromExtendedJSON(%v) succeeded, want an error", invalid)
+		}
+	}
+}
+
+func TestMongoSourceForEachDoc(t *testing.T) {
+
+	dir := t.TempDir()
+	files := map[string]string{
+		// mongoexport's default, a doc per line
+		"lines.json": `{"_id": {"$oid": "a1"}, "name": "Ann"}
+{"_id": {"$oid": "b2"}, "name": "Bob"}
+{"_id": {"$oid": "c3"}, "name": "Cy"}
+`,
+		// mongoexport --jsonArray
+		"array.json": `[
+{"_id": {"$oid": "a1"}, "name": "Ann"},
+{"_id": {"$oid": "b2"}, "name": "Bob"},
+{"_id": {"$oid": "c3"}, "name": "Cy"}
+]`,
+	}
+
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		source, err := NewMongoSource(path, "user::${_id}", true)
+		if err != nil {
+			t.Fatalf("NewMongoSource failed: %v", err)
+		}
+		source.BatchSize = 2
+
+		var batches [][]string
+		var docs []interface{}
+		err = source.ForEachDoc(func(docIds []string, batchDocs []interface{}) error {
+			batches = append(batches, docIds)
+			docs = append(docs, batchDocs...)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%v: ForEachDoc failed: %v", name, err)
+		}
+
+		wantBatches := [][]string{{"user::a1", "user::b2"}, {"user::c3"}}
+		if !reflect.DeepEqual(batches, wantBatches) {
+			t.Errorf("%v: batches = %v, want %v", name, batches, wantBatches)
+		}
+		wantDocs := []interface{}{
+			map[string]interface{}{"name": "Ann"},
+			map[string]interface{}{"name": "Bob"},
+			map[string]interface{}{"name": "Cy"},
+		}
+		if !reflect.DeepEqual(docs, wantDocs) {
+			t.Errorf("%v: docs = %v, want %v, with _id dropped", name, docs, wantDocs)
+		}
+	}
+}
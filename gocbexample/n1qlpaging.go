@@ -0,0 +1,190 @@
+package gocbexample
+
+import (
+	"fmt"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// A page of a keyset-paginated scan of the keyspace: the docs with ids after $last, in id order, projected under
+// the alias
+func TableScanN1qlPageQuery(keyspace, alias string) string {
+	// eg "SELECT META(`travel-sample`).id,* FROM `travel-sample` AS `travel-sample` WHERE META(`travel-sample`).id > $last
+	//     ORDER BY META(`travel-sample`).id LIMIT $limit"
+	return fmt.Sprintf(
+		"SELECT META(`%[2]s`).id,* FROM %[1]s AS `%[2]s` WHERE META(`%[2]s`).id > $last ORDER BY META(`%[2]s`).id LIMIT $limit",
+		keyspace,
+		alias,
+	)
+}
+
+// Get the doc id and body from a row of a table scan query
+func n1qlRowDoc(row map[string]interface{}, alias string) (docId string, doc interface{}, err error) {
+
+	rowIdRaw, ok := row["id"]
+	if !ok {
+		return "", nil, fmt.Errorf("Row does not have id field")
+	}
+	docId, ok = rowIdRaw.(string)
+	if !ok {
+		return "", nil, fmt.Errorf("Row id field not of expected type")
+	}
+
+	doc, ok = row[alias]
+	if !ok {
+		return "", nil, fmt.Errorf("Row does not have doc field: %+v.  Row: %+v", alias, row)
+	}
+	return docId, doc, nil
+}
+
+// Make a paged N1QL scan of the bucket start after the given doc id, eg to resume from a checkpoint
+func (e *ExampleApp) ResumeN1qlScan(bucketName, afterDocId string) {
+	e.n1qlCursorsMutex.Lock()
+	defer e.n1qlCursorsMutex.Unlock()
+	if e.n1qlCursors == nil {
+		e.n1qlCursors = map[string]string{}
+	}
+	e.n1qlCursors[bucketName] = afterDocId
+}
+
+// The id of the last doc processed by a paged N1QL scan of the bucket.  Empty if no scan is in progress
+func (e *ExampleApp) N1qlCursor(bucketName string) string {
+	e.n1qlCursorsMutex.Lock()
+	defer e.n1qlCursorsMutex.Unlock()
+	return e.n1qlCursors[bucketName]
+}
+
+// Record the progress of a paged scan.  An empty lastDocId means the scan finished
+func (e *ExampleApp) setN1qlCursor(bucketName, lastDocId string) error {
+
+	e.n1qlCursorsMutex.Lock()
+	if e.n1qlCursors == nil {
+		e.n1qlCursors = map[string]string{}
+	}
+	if lastDocId == "" {
+		delete(e.n1qlCursors, bucketName)
+	} else {
+		e.n1qlCursors[bucketName] = lastDocId
+	}
+	e.n1qlCursorsMutex.Unlock()
+
+	if e.OnN1qlPage != nil {
+		return e.OnN1qlPage(bucketName, lastDocId)
+	}
+	return nil
+}
+
+// Query a page of up to N1qlPageSize docs with ids after lastDocId
+func (e *ExampleApp) queryN1qlPage(bucket *gocb.Bucket, lastDocId string) (docIds []string, docs []interface{}, err error) {
+
+	params := map[string]interface{}{
+		"last":  lastDocId,
+		"limit": e.N1qlPageSize,
+	}
+	keyspace, alias := e.n1qlKeyspace(bucket)
+	rows, err := e.executeN1qlQuery(bucket, TableScanN1qlPageQuery(keyspace, alias), params)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	for {
+		row := map[string]interface{}{}
+		if !rows.Next(&row) {
+			break
+		}
+		docId, doc, err := n1qlRowDoc(row, alias)
+		if err != nil {
+			return nil, nil, err
+		}
+		docIds = append(docIds, docId)
+		docs = append(docs, doc)
+	}
+
+	// Surface any errors that were reported after the results
+	return docIds, docs, rows.Close()
+}
+
+// Loop over the docs in the bucket a page at a time, using META().id as the keyset, so that no single query
+// has to stream the whole bucket.  Each page is retried according to the retry policy, and the id of the
+// last doc of each processed page is recorded as the cursor to resume from.
+func (e *ExampleApp) ForEachDocIdBucketN1qlPaged(docProcessor DocProcessor, bucket *gocb.Bucket) (err error) {
+	return e.forEachDocIdBucketN1qlPaged(docProcessor, bucket, true)
+}
+
+// Loop over the docs in the bucket a page at a time.  Without checkpoint, the scan starts from the first doc and
+// doesn't record its cursor.
+func (e *ExampleApp) forEachDocIdBucketN1qlPaged(docProcessor DocProcessor, bucket *gocb.Bucket, checkpoint bool) (err error) {
+
+	// The bucket name for its default collection, so that cursors checkpointed before collections still resume
+	bucketName := e.keyspaceName(bucket)
+	e.logf("Performing paged operation over bucket: %v", bucketName)
+	defer e.logf("Finished paged operation over bucket: %v", bucketName)
+
+	setCursor := func(lastDocId string) error {
+		if !checkpoint {
+			return nil
+		}
+		// With write batching a page may only have been buffered, so write it before the cursor moves past it
+		if lastDocId != "" && e.flushWrites != nil {
+			if err := e.flushWrites(); err != nil {
+				return err
+			}
+		}
+		return e.setN1qlCursor(bucketName, lastDocId)
+	}
+
+	lastDocId := ""
+	if checkpoint {
+		lastDocId = e.N1qlCursor(bucketName)
+	}
+	if lastDocId != "" {
+		e.logf("Resuming scan of bucket %v after doc id %v", bucketName, lastDocId)
+	}
+
+	// Reads are idempotent, so a failed page is retried whatever the error
+	retryAnyError := func(error) bool { return true }
+
+	for {
+		// Each page is its own query, so pausing between them leaves no stream open.  The cursor of the last
+		// page has already been recorded.
+		if err := e.waitForRunWindow(bucket); err != nil {
+			return err
+		}
+
+		var docIds []string
+		var docs []interface{}
+		attempts, err := e.RetryPolicy.do(e.logf, fmt.Sprintf("N1QL page of bucket %v after doc id %q", bucketName, lastDocId), retryAnyError, func() error {
+			var err error
+			docIds, docs, err = e.queryN1qlPage(bucket, lastDocId)
+			return err
+		})
+		if err != nil {
+			return withAttempts(newDocError(PhaseSourceRead, "", err), attempts)
+		}
+		if len(docIds) == 0 {
+			break
+		}
+
+		if docProcessor != nil {
+			if err := docProcessor(docIds, docs); err != nil {
+				return err
+			}
+		}
+
+		lastDocId = docIds[len(docIds)-1]
+		if err := setCursor(lastDocId); err != nil {
+			return err
+		}
+		if checkpoint && e.copyCheckpoints.tracks(bucket) {
+			if err := e.copyCheckpoints.n1qlPageDone(lastDocId, len(docIds)); err != nil {
+				return err
+			}
+		}
+		if len(docIds) < e.N1qlPageSize {
+			break
+		}
+	}
+
+	return setCursor("")
+}
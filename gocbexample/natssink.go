@@ -0,0 +1,176 @@
+package gocbexample
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	// Header of each message holding the id of its doc
+	natsDocIdHeader = "Couchbase-Doc-Id"
+
+	// How long a batch waits for its JetStream acks
+	natsAckTimeout = 30 * time.Second
+)
+
+// A Sink that publishes each doc to a NATS subject, as its JSON body with its id in the Couchbase-Doc-Id header.
+// With JetStream, each batch waits for the stream to ack every message, and messages carry a Nats-Msg-Id of the
+// job id and doc id, so that the stream drops the duplicates a retried batch or resumed job publishes again, within
+// its duplicate window, while a new job replays every doc.  Without it, docs are published at most once.
+type NATSSink struct {
+	URL     string
+	Subject string
+	JobId   string
+
+	// Build subjects from the fields of the docs, if Subject has ${field} references, eg "travel.${type}"
+	subjectTemplate *DocKeyTemplate
+
+	mutex sync.Mutex
+	conn  *nats.Conn
+	js    jetstream.JetStream
+
+	// Messages the stream acked, and acked as duplicates
+	published  int64
+	duplicates int64
+}
+
+// Connect to the NATS server at url.  credsFile is a NATS credentials file, if the server needs one
+func NewNATSSink(url, subject, credsFile string, useJetStream bool, jobId string) (*NATSSink, error) {
+
+	if subject == "" {
+		return nil, fmt.Errorf("A NATS subject is required, eg travel.docs or travel.${type}")
+	}
+	s := &NATSSink{URL: url, Subject: subject, JobId: jobId}
+	if strings.Contains(subject, "${") {
+		template, err := NewDocKeyTemplate(subject)
+		if err != nil {
+			return nil, err
+		}
+		s.subjectTemplate = template
+	}
+
+	opts := []nats.Option{nats.Name(fmt.Sprintf("gocb-example %v", jobId))}
+	if credsFile != "" {
+		opts = append(opts, nats.UserCredentials(credsFile))
+	}
+	conn, err := nats.Connect(url, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("Error connecting to NATS server: %v.  Err: %v", url, err)
+	}
+	s.conn = conn
+
+	if useJetStream {
+		if s.js, err = jetstream.New(conn, jetstream.WithPublishAsyncMaxPending(pageSizeViewResult)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *NATSSink) Name() string {
+	return fmt.Sprintf("nats:%v", s.Subject)
+}
+
+func (s *NATSSink) subject(doc interface{}) (string, error) {
+	if s.subjectTemplate == nil {
+		return s.Subject, nil
+	}
+	subject, err := s.subjectTemplate.key(doc)
+	if err != nil {
+		return "", err
+	}
+	if strings.ContainsAny(subject, " \t\r\n") {
+		return "", fmt.Errorf("Subject: %q has whitespace, which NATS subjects can't", subject)
+	}
+	return subject, nil
+}
+
+func (s *NATSSink) WriteDocs(docIds []string, docs []interface{}) error {
+
+	msgs := make([]*nats.Msg, len(docIds))
+	for i, docId := range docIds {
+		subject, err := s.subject(docs[i])
+		if err != nil {
+			return newDocError(PhaseTargetWrite, docId, err)
+		}
+		docJSON, err := json.Marshal(docs[i])
+		if err != nil {
+			return newDocError(PhaseTargetWrite, docId, err)
+		}
+		msgs[i] = &nats.Msg{Subject: subject, Data: docJSON, Header: nats.Header{}}
+		msgs[i].Header.Set(natsDocIdHeader, docId)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.js == nil {
+		for i, msg := range msgs {
+			if err := s.conn.PublishMsg(msg); err != nil {
+				return newDocError(PhaseTargetWrite, docIds[i], fmt.Errorf("Error publishing to NATS subject: %v.  Err: %w", msg.Subject, err))
+			}
+		}
+		// Surfaces errors the server sent back, eg for a permissions violation
+		if err := s.conn.Flush(); err != nil {
+			return newDocError(PhaseTargetWrite, "", fmt.Errorf("Error publishing to NATS server: %v.  Err: %w", s.URL, err))
+		}
+		s.published += int64(len(msgs))
+		return nil
+	}
+
+	futures := make([]jetstream.PubAckFuture, len(msgs))
+	for i, msg := range msgs {
+		future, err := s.js.PublishMsgAsync(msg, jetstream.WithMsgID(s.JobId+":"+docIds[i]))
+		if err != nil {
+			return newDocError(PhaseTargetWrite, docIds[i], fmt.Errorf("Error publishing to JetStream subject: %v.  Err: %w", msg.Subject, err))
+		}
+		futures[i] = future
+	}
+
+	timeout := time.NewTimer(natsAckTimeout)
+	defer timeout.Stop()
+	for i, future := range futures {
+		select {
+		case ack := <-future.Ok():
+			s.published++
+			if ack.Duplicate {
+				s.duplicates++
+			}
+		case err := <-future.Err():
+			return newDocError(PhaseTargetWrite, docIds[i], fmt.Errorf("JetStream didn't store the message on subject: %v.  Err: %w", msgs[i].Subject, err))
+		case <-timeout.C:
+			return newDocError(PhaseTargetWrite, docIds[i], fmt.Errorf("Timed out after %v waiting for JetStream to ack the message on subject: %v.  Does a stream capture it?", natsAckTimeout, msgs[i].Subject))
+		}
+	}
+	return nil
+}
+
+// Messages published (acked by the stream, with JetStream), and how many of those the stream dropped as duplicates
+func (s *NATSSink) Published() (published, duplicates int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.published, s.duplicates
+}
+
+// Wait for the messages in flight, and disconnect
+func (s *NATSSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	defer s.conn.Close()
+	if s.js == nil {
+		return s.conn.Flush()
+	}
+	select {
+	case <-s.js.PublishAsyncComplete():
+		return nil
+	case <-time.After(natsAckTimeout):
+		return fmt.Errorf("Timed out waiting for JetStream to ack the last messages")
+	}
+}
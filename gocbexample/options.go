@@ -0,0 +1,264 @@
+package gocbexample
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// Configures an ExampleApp created by NewExampleWithOptions
+type Option func(e *ExampleApp) error
+
+// How failed operations are retried.  Only errors the cluster reports as temporary (eg tmpfail,
+// timeouts) are retried.
+type RetryPolicy struct {
+
+	// Total attempts, including the first.  1 (or less) disables retries
+	MaxAttempts int
+
+	// Backoff before the first retry, doubling on each retry up to MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// Don't retry -- an error fails the copy
+var NoRetries = RetryPolicy{MaxAttempts: 1}
+
+// Create a new ExampleApp with the given options.  Returns an error if an option is invalid
+// or the options conflict.
+func NewExampleWithOptions(sourceBucketSpec, targetBucketSpec BucketSpec, opts ...Option) (*ExampleApp, error) {
+
+	e := NewExample(sourceBucketSpec, targetBucketSpec)
+	for _, opt := range opts {
+		if err := opt(e); err != nil {
+			return nil, err
+		}
+	}
+	if err := e.validate(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Iterate buckets with N1QL rather than views
+func WithN1QL() Option {
+	return func(e *ExampleApp) error {
+		e.UseN1ql = true
+		return nil
+	}
+}
+
+// Number of goroutines processing batches of docs concurrently
+func WithWorkers(workers int) Option {
+	return func(e *ExampleApp) error {
+		if workers < 1 {
+			return fmt.Errorf("Invalid number of workers: %v.  Must be at least 1", workers)
+		}
+		e.Workers = workers
+		return nil
+	}
+}
+
+// Number of docs read per view query page, which is also the size of the batches passed to DocProcessors
+func WithPageSize(pageSize int) Option {
+	return func(e *ExampleApp) error {
+		if pageSize < 1 {
+			return fmt.Errorf("Invalid page size: %v.  Must be at least 1", pageSize)
+		}
+		e.PageSize = pageSize
+		return nil
+	}
+}
+
+// Split view iteration into this many key ranges, queried concurrently
+func WithViewQueryRanges(ranges int) Option {
+	return func(e *ExampleApp) error {
+		if ranges < 1 {
+			return fmt.Errorf("Invalid number of view query ranges: %v.  Must be at least 1", ranges)
+		}
+		e.ViewQueryRanges = ranges
+		return nil
+	}
+}
+
+// Send the app's log output to logger rather than the standard logger
+func WithLogger(logger *log.Logger) Option {
+	return func(e *ExampleApp) error {
+		e.Logger = logger
+		return nil
+	}
+}
+
+// Retry reads and writes that fail with temporary errors
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(e *ExampleApp) error {
+		if policy.MaxAttempts > 1 && policy.InitialBackoff <= 0 {
+			return fmt.Errorf("Invalid retry policy: %+v.  InitialBackoff must be set when retrying", policy)
+		}
+		if policy.MaxBackoff > 0 && policy.MaxBackoff < policy.InitialBackoff {
+			return fmt.Errorf("Invalid retry policy: %+v.  MaxBackoff is less than InitialBackoff", policy)
+		}
+		e.RetryPolicy = policy
+		return nil
+	}
+}
+
+// Copy from source rather than the source bucket
+func WithSource(source Source) Option {
+	return func(e *ExampleApp) error {
+		e.Source = source
+		return nil
+	}
+}
+
+// Copy to sink rather than the target bucket
+func WithSink(sink Sink) Option {
+	return func(e *ExampleApp) error {
+		e.Sink = sink
+		return nil
+	}
+}
+
+// Check for settings that don't make sense together
+func (e *ExampleApp) validate() error {
+
+	if e.UseN1ql && e.ViewQueryRanges > 1 {
+		return fmt.Errorf("View query ranges (%v) can't be used with N1QL", e.ViewQueryRanges)
+	}
+	if e.UseN1ql && (e.DevelopmentViews || e.OverwriteDesignDoc) {
+		return fmt.Errorf("Design doc options can't be used with N1QL")
+	}
+	if e.Engine == EngineDCP && (e.ViewQueryRanges > 1 || e.DevelopmentViews || e.OverwriteDesignDoc) {
+		return fmt.Errorf("View options can't be used with DCP")
+	}
+	if !e.UseN1ql && e.Engine != EngineAuto && (len(e.QueryNodes) > 0 || e.SpreadQueries || e.MaxConcurrentQueries > 0) {
+		return fmt.Errorf("Query node options require N1QL")
+	}
+	if (len(e.Collections) > 0 || len(e.CollectionMap) > 0) && !e.UseN1ql && e.Engine != EngineDCP && e.Engine != EngineAuto {
+		// Otherwise the view's ids of the default collection would be looked up in the collection being copied
+		return fmt.Errorf("Views only index the default collection, so copying collections needs the %v or %v engine", EngineN1ql, EngineDCP)
+	}
+	if e.Schedule != nil && (e.SourceQuery != "" || e.AnalyticsDataset != "" || (e.UseN1ql && e.N1qlPageSize <= 0 && e.Engine != EngineDCP)) {
+		// Only scans made of many queries can pause between them
+		return fmt.Errorf("Run windows need a scan that can pause between pages: views, DCP or N1QL with n1qlPageSize")
+	}
+	if e.InPlace && len(e.CollectionMap) > 0 {
+		return fmt.Errorf("A collection map can't be used in place, where each collection is written back to itself")
+	}
+	for stage, policy := range e.ErrorPolicies {
+		if policy == ErrorPolicyDeadLetter && e.DeadLetters == nil {
+			return fmt.Errorf("The %v error policy of stage %v needs a dead-letter file.  See WithDeadLetterFile", policy, stage)
+		}
+	}
+	if e.PageSize > maxPageSize {
+		// Bigger pages overflow the bulk op queue, see pageSizeViewResult
+		return fmt.Errorf("Page size: %v is bigger than the maximum: %v", e.PageSize, maxPageSize)
+	}
+
+	return nil
+}
+
+// Log via the app's logger, if set, otherwise the standard logger
+func (e *ExampleApp) logf(format string, args ...interface{}) {
+	if e.Logger != nil {
+		e.Logger.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	backoff := p.InitialBackoff
+	for i := 1; i < retry; i++ {
+		backoff *= 2
+		if p.MaxBackoff > 0 && backoff >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
+	}
+	return backoff
+}
+
+// Is err one the cluster expects the client to retry?
+func isTemporaryError(err error) bool {
+	return errors.Is(wrapGocbError(err), ErrTemporary)
+}
+
+// Is err one that guarantees a write didn't happen?  A timed out write may or may not have been applied,
+// so retrying an insert after a timeout could fail with "key exists".
+func isRetryableWriteError(err error) bool {
+	return errors.Is(err, gocb.ErrTemporaryFailure)
+}
+
+// Call fn until it succeeds, it fails with an error that isn't retryable, or the attempts run out, logging each
+// retry with logf.  Returns the number of attempts made.
+func (p RetryPolicy) do(logf func(format string, args ...interface{}), description string, retryable func(error) bool, fn func() error) (attempts int, err error) {
+	for attempts = 1; ; attempts++ {
+		err = fn()
+		if err == nil || !retryable(err) || attempts >= p.MaxAttempts {
+			return attempts, err
+		}
+		backoff := p.backoff(attempts)
+		logf("Retrying %v in %v after attempt %v failed: %v", description, backoff, attempts, err)
+		time.Sleep(backoff)
+	}
+}
+
+// Run bulk ops on a collection, re-running the ones that fail with a retryable error and logging each retry with
+// logf.  Returns the number of attempts made for each op.
+func (p RetryPolicy) doBulk(logf func(format string, args ...interface{}), collection *gocb.Collection, opts *gocb.BulkOpOptions, items []gocb.BulkOp, retryable func(error) bool) (attempts map[gocb.BulkOp]int, err error) {
+	attempts = make(map[gocb.BulkOp]int, len(items))
+	pending := items
+	for attempt := 1; ; attempt++ {
+		for _, item := range pending {
+			attempts[item] = attempt
+		}
+		if err := collection.Do(pending, opts); err != nil {
+			return attempts, err
+		}
+		var failed []gocb.BulkOp
+		for _, item := range pending {
+			if retryable(bulkOpErr(item)) {
+				failed = append(failed, item)
+			}
+		}
+		if len(failed) == 0 || attempt >= p.MaxAttempts {
+			return attempts, nil
+		}
+		backoff := p.backoff(attempt)
+		logf("Retrying %v of %v bulk ops in %v", len(failed), len(items), backoff)
+		time.Sleep(backoff)
+		pending = failed
+	}
+}
+
+// Run bulk ops on the collection of the bucket being copied, with the retry policy and the bucket's bulk operation
+// timeout.  Ops that write fail if the bucket is the source, unless it's being transformed in place.
+func (e *ExampleApp) doBulk(bucket *gocb.Bucket, items []gocb.BulkOp, retryable func(error) bool) (attempts map[gocb.BulkOp]int, err error) {
+	if bulkOpsWrite(items) {
+		if err := e.checkWritable(bucket, "Writing docs"); err != nil {
+			return nil, err
+		}
+	}
+	return e.RetryPolicy.doBulk(e.logf, e.collection(bucket), e.bulkOpOptions(bucket), items, retryable)
+}
+
+func bulkOpErr(item gocb.BulkOp) error {
+	switch op := item.(type) {
+	case *gocb.GetOp:
+		return op.Err
+	case *gocb.InsertOp:
+		return op.Err
+	case *gocb.UpsertOp:
+		return op.Err
+	case *gocb.ReplaceOp:
+		return op.Err
+	case *gocb.RemoveOp:
+		return op.Err
+	case *gocb.TouchOp:
+		return op.Err
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package gocbexample
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Docs copied in a lane of their own ahead of the rest, eg reference and config docs that apps need to boot.
+// A doc matches if its type is one of Types or its id starts with one of KeyPrefixes.
+type PriorityRule struct {
+
+	// Names the lane in the logs and progress phase.  Defaults to priorities[i]
+	Name string `json:"name,omitempty"`
+
+	// Match docs whose type field is one of these, eg ["config", "reference"]
+	Types []string `json:"types,omitempty"`
+
+	// Match docs whose id starts with one of these, eg ["config::"]
+	KeyPrefixes []string `json:"keyPrefixes,omitempty"`
+}
+
+func (r PriorityRule) matches(docId string, doc interface{}) bool {
+	for _, prefix := range r.KeyPrefixes {
+		if strings.HasPrefix(docId, prefix) {
+			return true
+		}
+	}
+	if len(r.Types) == 0 {
+		return false
+	}
+	body, ok := doc.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	docType, ok := body[defaultTypeField].(string)
+	if !ok {
+		return false
+	}
+	for _, priorityType := range r.Types {
+		if docType == priorityType {
+			return true
+		}
+	}
+	return false
+}
+
+// Copy the docs matching each of the rules, in order, before the rest of the docs.  Each lane is a scan of the
+// source, so the source is read once per rule, plus once for the rest.
+func WithPriorities(rules ...PriorityRule) Option {
+	return func(e *ExampleApp) error {
+		for i, rule := range rules {
+			if len(rule.Types) == 0 && len(rule.KeyPrefixes) == 0 {
+				return fmt.Errorf("priorities[%v] has no types or keyPrefixes, so would match no docs", i)
+			}
+			for _, prefix := range rule.KeyPrefixes {
+				if prefix == "" {
+					return fmt.Errorf("priorities[%v] has an empty key prefix, which would match every doc", i)
+				}
+			}
+		}
+		e.Priorities = rules
+		return nil
+	}
+}
+
+// The name of a copy lane: the name of its rule, or "rest" for the docs matching no rule
+func (e *ExampleApp) priorityLaneName(lane int) string {
+	if lane >= len(e.Priorities) {
+		return "rest"
+	}
+	if name := e.Priorities[lane].Name; name != "" {
+		return name
+	}
+	return fmt.Sprintf("priorities[%v]", lane)
+}
+
+// The lane a doc is copied in: the index of the first rule it matches, or len(e.Priorities) if none
+func (e *ExampleApp) priorityLane(docId string, doc interface{}) int {
+	for i, rule := range e.Priorities {
+		if rule.matches(docId, doc) {
+			return i
+		}
+	}
+	return len(e.Priorities)
+}
+
+// Wrap a doc processor to only pass on the docs of a lane.  Pages without any are skipped.
+func (e *ExampleApp) priorityLaneFilter(lane int, docProcessor DocProcessor) DocProcessor {
+	if len(e.Priorities) == 0 {
+		// Every doc is in the one lane
+		return docProcessor
+	}
+	return func(docIds []string, docs []interface{}) error {
+		var laneDocIds []string
+		var laneDocs []interface{}
+		for i, docId := range docIds {
+			if e.priorityLane(docId, docs[i]) == lane {
+				laneDocIds = append(laneDocIds, docId)
+				laneDocs = append(laneDocs, docs[i])
+			}
+		}
+		if len(laneDocIds) == 0 {
+			return nil
+		}
+		return docProcessor(laneDocIds, laneDocs)
+	}
+}
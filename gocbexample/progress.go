@@ -0,0 +1,253 @@
+package gocbexample
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Tracks how far a scan over a bucket has got.  The total is preallocated from the view's
+// total_rows (when known) so that the final count can be checked for completeness.
+type ScanProgress struct {
+	Bucket string
+
+	total   int64 // -1 until known
+	done    int64
+	skipped int64
+	partial int32
+}
+
+// The expected number of docs, or -1 if not known yet
+func (p *ScanProgress) Total() int64 {
+	return atomic.LoadInt64(&p.total)
+}
+
+// The number of docs read so far
+func (p *ScanProgress) Done() int64 {
+	return atomic.LoadInt64(&p.done)
+}
+
+// The number of rows the view returned whose docs were deleted before they could be read
+func (p *ScanProgress) Skipped() int64 {
+	return atomic.LoadInt64(&p.skipped)
+}
+
+func (p *ScanProgress) setTotal(total int) {
+	atomic.StoreInt64(&p.total, int64(total))
+}
+
+func (p *ScanProgress) add(numDocs int) {
+	atomic.AddInt64(&p.done, int64(numDocs))
+}
+
+func (p *ScanProgress) skip(numRows int) {
+	atomic.AddInt64(&p.skipped, int64(numRows))
+}
+
+// The scan didn't start from the first row, eg it was resumed from a checkpoint, so can't be checked against the total
+func (p *ScanProgress) setPartial() {
+	atomic.StoreInt32(&p.partial, 1)
+}
+
+// Get the progress of the current (or last) scan over a bucket
+func (e *ExampleApp) ScanProgress(bucketName string) *ScanProgress {
+	e.progressMutex.Lock()
+	defer e.progressMutex.Unlock()
+	if e.progress == nil {
+		e.progress = map[string]*ScanProgress{}
+	}
+	progress, ok := e.progress[bucketName]
+	if !ok {
+		progress = &ScanProgress{Bucket: bucketName, total: -1}
+		e.progress[bucketName] = progress
+	}
+	return progress
+}
+
+// Reset the progress for a new scan over a bucket
+func (e *ExampleApp) startScanProgress(bucketName string) *ScanProgress {
+	e.progressMutex.Lock()
+	defer e.progressMutex.Unlock()
+	if e.progress == nil {
+		e.progress = map[string]*ScanProgress{}
+	}
+	progress := &ScanProgress{Bucket: bucketName, total: -1}
+	e.progress[bucketName] = progress
+	return progress
+}
+
+// Compare the number of rows read, whether their docs were read or had since been deleted, against the expected
+// total, so that truncated result sets don't go unnoticed.  Returns an error if StrictRowCount is set, otherwise
+// logs a warning.
+func (e *ExampleApp) checkScanComplete(progress *ScanProgress) error {
+
+	total, done, skipped := progress.Total(), progress.Done(), progress.Skipped()
+	if atomic.LoadInt32(&progress.partial) != 0 {
+		e.logf("Not checking the %v docs read against total_rows, since the scan of bucket %v started partway through", done, progress.Bucket)
+		return nil
+	}
+	if skipped > 0 {
+		e.logf("Scan of bucket %v skipped %v docs that were deleted since they were indexed", progress.Bucket, skipped)
+	}
+	if total < 0 || total == done+skipped {
+		return nil
+	}
+
+	err := fmt.Errorf("Scan of bucket %v read %v docs and skipped %v deleted docs, but the view reported total_rows: %v", progress.Bucket, done, skipped, total)
+	if e.StrictRowCount {
+		return err
+	}
+	e.logf("Warning: %v", err)
+	return nil
+}
+
+// The live progress of the app, for applications embedding it (eg a web dashboard) to poll or subscribe to.
+// Independent of the logging.  Safe to call from any goroutine.
+type Progress interface {
+
+	// The number of docs the current phase is expected to process, or -1 if not known
+	Total() int64
+
+	// The number of docs the current phase has processed so far
+	Done() int64
+
+	// Docs processed per second since the current phase started
+	Rate() float64
+
+	// What the app is doing, eg "copy" or "verify".  Empty until the first phase starts
+	Phase() string
+}
+
+// The progress at a point in time, as sent to subscribers
+type ProgressSnapshot struct {
+	Phase string    `json:"phase"`
+	Total int64     `json:"total"`
+	Done  int64     `json:"done"`
+	Rate  float64   `json:"rate"`
+	At    time.Time `json:"at"`
+}
+
+// Tracks the docs processed by the current phase
+type phaseProgress struct {
+	mutex     sync.Mutex
+	phase     string
+	startedAt time.Time
+	total     int64
+	done      int64
+}
+
+func (p *phaseProgress) Total() int64 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.phase == "" {
+		return -1
+	}
+	return p.total
+}
+
+func (p *phaseProgress) Done() int64 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.done
+}
+
+func (p *phaseProgress) Rate() float64 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	elapsed := time.Since(p.startedAt).Seconds()
+	if p.phase == "" || elapsed <= 0 {
+		return 0
+	}
+	return float64(p.done) / elapsed
+}
+
+func (p *phaseProgress) Phase() string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.phase
+}
+
+func (p *phaseProgress) start(phase string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.phase = phase
+	p.startedAt = time.Now()
+	p.total = -1
+	p.done = 0
+}
+
+func (p *phaseProgress) setTotal(total int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.total = int64(total)
+}
+
+func (p *phaseProgress) add(numDocs int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.done += int64(numDocs)
+}
+
+// Get the live progress of the app
+func (e *ExampleApp) Progress() Progress {
+	return &e.phaseProgress
+}
+
+// Start a new phase, resetting the progress
+func (e *ExampleApp) startPhase(phase string) {
+	e.phaseProgress.start(phase)
+}
+
+// Wrap a doc processor to count the docs it processes towards the progress of the current phase, and to stop
+// before the next page once a limit is reached
+func (e *ExampleApp) countProgress(docProcessor DocProcessor) DocProcessor {
+	return func(docIds []string, docs []interface{}) error {
+		if err := e.checkLimits(); err != nil {
+			return err
+		}
+		atomic.AddInt64(&e.docsRead, int64(len(docIds)))
+		if err := docProcessor(docIds, docs); err != nil {
+			return err
+		}
+		e.phaseProgress.add(len(docIds))
+		return nil
+	}
+}
+
+// Send a snapshot of the progress every interval until unsubscribe is called, which closes the channel.
+// A snapshot is dropped rather than blocking if the subscriber hasn't received the previous one.
+func (e *ExampleApp) SubscribeProgress(interval time.Duration) (snapshots <-chan ProgressSnapshot, unsubscribe func()) {
+
+	updates := make(chan ProgressSnapshot, 1)
+	stop := make(chan struct{})
+	go func() {
+		defer close(updates)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case at := <-ticker.C:
+				progress := e.Progress()
+				snapshot := ProgressSnapshot{
+					Phase: progress.Phase(),
+					Total: progress.Total(),
+					Done:  progress.Done(),
+					Rate:  progress.Rate(),
+					At:    at,
+				}
+				select {
+				case updates <- snapshot:
+				default:
+				}
+			}
+		}
+	}()
+
+	once := sync.Once{}
+	return updates, func() {
+		once.Do(func() { close(stop) })
+	}
+}
@@ -0,0 +1,150 @@
+package gocbexample
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Keeps or drops fields of the docs it matches.  A rule with no Types matches every doc.
+type ProjectionRule struct {
+
+	// Match docs whose type field is one of these, eg ["route"]
+	Types []string `json:"types,omitempty"`
+
+	// Keep only the values matched by these JSONPaths, eg ["$.airline", "$.sourceairport"]
+	Keep []string `json:"keep,omitempty"`
+
+	// Drop the values matched by these JSONPaths, eg ["$.schedule"].  Applied after Keep
+	Drop []string `json:"drop,omitempty"`
+}
+
+type compiledProjectionRule struct {
+	types map[string]bool
+	keep  []JSONPath
+	drop  []JSONPath
+}
+
+// A pre-insert stage that keeps or drops fields per doc type, eg to create slimmed down datasets.
+// Rules are evaluated in order and the first rule matching a doc is applied.  Docs that no rule
+// matches, or that aren't JSON objects, are passed through untouched.
+type Projection struct {
+	typeField string
+	rules     []compiledProjectionRule
+
+	mutex     sync.Mutex
+	projected map[string]int
+	ruleHits  []int
+}
+
+// Summary of what a Projection did
+type ProjectionReport struct {
+	// Number of docs projected, keyed by doc type
+	Projected map[string]int
+}
+
+// Parse the JSONPaths in the rules.  typeField defaults to "type".
+func NewProjection(typeField string, rules []ProjectionRule) (*Projection, error) {
+
+	p := &Projection{
+		typeField: typeField,
+		projected: map[string]int{},
+	}
+	if p.typeField == "" {
+		p.typeField = defaultTypeField
+	}
+
+	for i, rule := range rules {
+		if len(rule.Keep) == 0 && len(rule.Drop) == 0 {
+			return nil, fmt.Errorf("Projection rule %v has neither keep nor drop paths", i)
+		}
+		compiled := compiledProjectionRule{}
+		if len(rule.Types) > 0 {
+			compiled.types = map[string]bool{}
+			for _, docType := range rule.Types {
+				compiled.types[docType] = true
+			}
+		}
+		var err error
+		if compiled.keep, err = ParseJSONPaths(rule.Keep); err != nil {
+			return nil, fmt.Errorf("Error parsing keep paths of projection rule %v.  Err: %v", i, err)
+		}
+		if compiled.drop, err = ParseJSONPaths(rule.Drop); err != nil {
+			return nil, fmt.Errorf("Error parsing drop paths of projection rule %v.  Err: %v", i, err)
+		}
+		p.rules = append(p.rules, compiled)
+	}
+	p.ruleHits = make([]int, len(p.rules))
+
+	return p, nil
+}
+
+func (p *Projection) Transform(input DocProcessorInput) (output DocProcessorInput, err error) {
+
+	output = DocProcessorInput{
+		DocIds: input.DocIds,
+		Docs:   make([]interface{}, len(input.Docs)),
+	}
+	projected := map[string]int{}
+	ruleHits := make([]int, len(p.rules))
+
+	for i, doc := range input.Docs {
+		output.Docs[i] = doc
+
+		docMap, ok := doc.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		docType, _ := docMap[p.typeField].(string)
+
+		for j, rule := range p.rules {
+			if rule.types != nil && !rule.types[docType] {
+				continue
+			}
+			ruleHits[j] += 1
+			var projectedDoc interface{} = docMap
+			if len(rule.keep) > 0 {
+				projectedDoc = RetainPaths(projectedDoc, rule.keep)
+			}
+			for _, dropPath := range rule.drop {
+				projectedDoc = dropPath.Remove(projectedDoc)
+			}
+			output.Docs[i] = projectedDoc
+			projected[docType] += 1
+			break
+		}
+	}
+
+	p.mutex.Lock()
+	for docType, count := range projected {
+		p.projected[docType] += count
+	}
+	for i, hits := range ruleHits {
+		p.ruleHits[i] += hits
+	}
+	p.mutex.Unlock()
+
+	return output, nil
+}
+
+func (p *Projection) Report() ProjectionReport {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	report := ProjectionReport{Projected: map[string]int{}}
+	for docType, count := range p.projected {
+		report.Projected[docType] = count
+	}
+	return report
+}
+
+// The rules that never matched a doc, which usually means a typo'd type
+func (p *Projection) UnusedRules() []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	var unused []string
+	for i, hits := range p.ruleHits {
+		if hits == 0 {
+			unused = append(unused, fmt.Sprintf("projections[%v]", i))
+		}
+	}
+	return unused
+}
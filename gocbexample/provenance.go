@@ -0,0 +1,86 @@
+package gocbexample
+
+import (
+	"time"
+)
+
+// Default doc field that provenance info is stamped under.  Starts with an underscore so
+// that the anonymizer (which skips fields matching "_(.)*") leaves it alone.
+const defaultProvenanceField = "_provenance"
+
+// Describes the provenance fields that get stamped into the body of each copied doc.  This is
+// an alternative to the Metadata XATTR for downstream tooling that can't read XATTRs.
+type ProvenanceSpec struct {
+
+	// The doc field to store the provenance object under.  Defaults to "_provenance"
+	Field string
+
+	// Optional identifier of the copy job.  Defaults to ExampleApp.JobId
+	JobId string
+
+	// Optional schema version of the copied docs
+	SchemaVersion string
+
+	// Skip the sourceBucket / copiedAt fields
+	OmitSourceBucket bool
+	OmitCopiedAt     bool
+}
+
+// Build the provenance object that gets stamped into each doc
+func (spec ProvenanceSpec) fields(sourceBucket string, copiedAt time.Time) map[string]interface{} {
+
+	fields := map[string]interface{}{}
+	if !spec.OmitSourceBucket {
+		fields["sourceBucket"] = sourceBucket
+	}
+	if !spec.OmitCopiedAt {
+		fields["copiedAt"] = copiedAt
+	}
+	if spec.JobId != "" {
+		fields["jobId"] = spec.JobId
+	}
+	if spec.SchemaVersion != "" {
+		fields["schemaVersion"] = spec.SchemaVersion
+	}
+	return fields
+
+}
+
+// Returns a pre-insert transform that stamps the provenance fields into the body of each doc.
+// Docs that aren't JSON objects are passed through untouched.
+func (e *ExampleApp) ProvenanceTransform(spec ProvenanceSpec) DocProcessorReturnDocs {
+
+	fieldName := spec.Field
+	if fieldName == "" {
+		fieldName = defaultProvenanceField
+	}
+	if spec.JobId == "" {
+		spec.JobId = e.JobId
+	}
+
+	return func(input DocProcessorInput) (output DocProcessorInput, err error) {
+
+		// All docs in a batch share the same copiedAt timestamp
+		provenance := spec.fields(e.SourceBucketSpec.Name, time.Now())
+
+		output = DocProcessorInput{
+			DocIds: input.DocIds,
+			Docs:   make([]interface{}, len(input.Docs)),
+		}
+		for i, doc := range input.Docs {
+			docMap, ok := doc.(map[string]interface{})
+			if !ok {
+				output.Docs[i] = doc
+				continue
+			}
+			stamped := make(map[string]interface{}, len(docMap)+1)
+			for k, v := range docMap {
+				stamped[k] = v
+			}
+			stamped[fieldName] = provenance
+			output.Docs[i] = stamped
+		}
+		return output, nil
+	}
+
+}
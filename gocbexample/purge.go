@@ -0,0 +1,83 @@
+package gocbexample
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// What the purge command deleted, or in a dry run would have deleted
+type PurgeReport struct {
+	DryRun bool `json:"dryRun,omitempty"`
+
+	// Docs read, docs matching the filter, and matching docs deleted
+	DocsScanned int64 `json:"docsScanned"`
+	DocsMatched int64 `json:"docsMatched"`
+	DocsDeleted int64 `json:"docsDeleted"`
+
+	// Matching docs that changed since they were read, so were left as they are
+	Conflicts []string `json:"conflicts,omitempty"`
+}
+
+// Delete the docs of the source bucket matching the filter, eg the docs of a test namespace.  Each doc is
+// removed only if its CAS is still the one it was read with, so docs changed since the scan are left as they are
+// and reported as conflicts.  The deletes are paced by the write byte rate limit, counting the size of the deleted
+// docs, and a dry run only counts the docs that would be deleted.
+func (e *ExampleApp) PurgeDocs(filter DocFilter, dryRun bool) (report PurgeReport, err error) {
+
+	if filter.isEmpty() && (e.Filter == nil || e.Filter.isEmpty()) {
+		return report, fmt.Errorf("Refusing to purge every doc of bucket: %v.  Set a key pattern, types, match predicate or where clause", e.SourceBucket.Name())
+	}
+
+	report.DryRun = dryRun
+	var reportMutex sync.Mutex
+
+	purgeDocs := func(docIds []string, docs []interface{}, cas []gocb.Cas) error {
+
+		if dryRun {
+			return nil
+		}
+
+		items := make([]gocb.BulkOp, len(docIds))
+		for i, docId := range docIds {
+			items[i] = &gocb.RemoveOp{ID: docId, Cas: cas[i]}
+		}
+		e.writeLimiter.Wait(docsSize(docIds, docs))
+		if _, err := e.doBulk(e.SourceBucket, items, isTemporaryError); err != nil {
+			return newDocError(PhaseTargetWrite, "", err)
+		}
+
+		var deleted int64
+		var conflicts []string
+		for _, item := range items {
+			removeItem := item.(*gocb.RemoveOp)
+			if removeItem.Err == nil {
+				deleted++
+				continue
+			}
+			wrappedErr := wrapGocbError(removeItem.Err)
+			switch {
+			case errors.Is(wrappedErr, ErrDocNotFound):
+				e.logf("Doc %v was deleted since it was read, skipping", removeItem.ID)
+			case errors.Is(wrappedErr, ErrDocExists):
+				// A CAS mismatch matches ErrDocExists
+				conflicts = append(conflicts, removeItem.ID)
+			default:
+				return newDocError(PhaseTargetWrite, removeItem.ID, removeItem.Err)
+			}
+		}
+
+		reportMutex.Lock()
+		defer reportMutex.Unlock()
+		report.DocsDeleted += deleted
+		report.Conflicts = append(report.Conflicts, conflicts...)
+		return nil
+	}
+
+	report.DocsScanned, report.DocsMatched, err = e.forEachMatchingDoc("purge", filter, purgeDocs)
+	sort.Strings(report.Conflicts)
+	return report, err
+}
@@ -0,0 +1,381 @@
+package gocbexample
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// Iterates over query results, one row at a time
+type n1qlRows interface {
+	Next(valuePtr interface{}) bool
+	Close() error
+}
+
+// The row iteration shared by gocb.QueryResult and gocb.AnalyticsResult
+type gocbResult interface {
+	Next() bool
+	Row(valuePtr interface{}) error
+	Err() error
+	Close() error
+}
+
+// Adapts gocb query (or Analytics) results to n1qlRows
+type gocbRows struct {
+	result gocbResult
+	err    error
+}
+
+func (r *gocbRows) Next(valuePtr interface{}) bool {
+	if r.err != nil || !r.result.Next() {
+		return false
+	}
+	if err := r.result.Row(valuePtr); err != nil {
+		r.err = err
+		return false
+	}
+	return true
+}
+
+// Close the results, returning the error of decoding a row or any error reported after the rows
+func (r *gocbRows) Close() error {
+	closeErr := r.result.Close()
+	switch {
+	case r.err != nil:
+		return r.err
+	case r.result.Err() != nil:
+		return r.result.Err()
+	}
+	return closeErr
+}
+
+// Query options with the positional ($1, $2...) or named ($name) parameters
+func queryOptions(params interface{}) (*gocb.QueryOptions, error) {
+	switch p := params.(type) {
+	case nil:
+		return &gocb.QueryOptions{}, nil
+	case []interface{}:
+		return &gocb.QueryOptions{PositionalParameters: p}, nil
+	case map[string]interface{}:
+		named := make(map[string]interface{}, len(p))
+		for name, val := range p {
+			named[strings.TrimPrefix(name, "$")] = val
+		}
+		return &gocb.QueryOptions{NamedParameters: named}, nil
+	}
+	return nil, fmt.Errorf("Unsupported N1QL params type: %T", params)
+}
+
+// Spreads N1QL requests across query nodes (or pins them to one) and caps the number of
+// concurrent requests, to reduce the impact of big scans on production workloads
+type queryRouter struct {
+	nodes     []string
+	next      uint32
+	semaphore chan struct{}
+}
+
+// An entry from /pools/default/nodeServices
+type nodeServices struct {
+	NodesExt []struct {
+		Hostname string         `json:"hostname"`
+		Services map[string]int `json:"services"`
+	} `json:"nodesExt"`
+}
+
+// Set up query routing based on the QueryNodes / SpreadQueries / MaxConcurrentQueries settings
+func (e *ExampleApp) setupQueryRouter() error {
+
+	router := &queryRouter{
+		nodes: e.QueryNodes,
+	}
+	if e.MaxConcurrentQueries > 0 {
+		router.semaphore = make(chan struct{}, e.MaxConcurrentQueries)
+	}
+
+	if len(router.nodes) == 0 && e.SpreadQueries {
+		nodes, err := e.discoverQueryNodes()
+		if err != nil {
+			return fmt.Errorf("Error discovering query nodes.  Err: %v", err)
+		}
+		router.nodes = nodes
+	}
+	if len(router.nodes) > 0 {
+		e.logf("Sending N1QL requests to query nodes: %v", router.nodes)
+	}
+
+	e.queryRouter = router
+	return nil
+}
+
+// Find every node running the query service
+func (e *ExampleApp) discoverQueryNodes() ([]string, error) {
+
+	services := nodeServices{}
+	if err := e.managementGet("/pools/default/nodeServices", &services); err != nil {
+		return nil, err
+	}
+
+	baseURL, err := managementURL(e.ConnSpec)
+	if err != nil {
+		return nil, err
+	}
+	defaultHost := strings.Split(strings.TrimPrefix(strings.TrimPrefix(baseURL, "https://"), "http://"), ":")[0]
+	secure := strings.HasPrefix(baseURL, "https://")
+
+	nodes := []string{}
+	for _, node := range services.NodesExt {
+		port, ok := node.Services["n1ql"]
+		if secure {
+			port, ok = node.Services["n1qlSSL"]
+		}
+		if !ok {
+			continue
+		}
+		hostname := node.Hostname
+		if hostname == "" {
+			// The node the request was sent to leaves its hostname out
+			hostname = defaultHost
+		}
+		nodes = append(nodes, fmt.Sprintf("%v:%v", hostname, port))
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("No nodes are running the query service")
+	}
+	return nodes, nil
+}
+
+// Pick the node for the next request, round robin
+func (r *queryRouter) nextNode() string {
+	idx := atomic.AddUint32(&r.next, 1) - 1
+	return r.nodes[int(idx)%len(r.nodes)]
+}
+
+func (r *queryRouter) acquire() {
+	if r.semaphore != nil {
+		r.semaphore <- struct{}{}
+	}
+}
+
+func (r *queryRouter) release() {
+	if r.semaphore != nil {
+		<-r.semaphore
+	}
+}
+
+// The spec (and therefore the RBAC credentials) of an open bucket
+func (e *ExampleApp) bucketSpec(bucket *gocb.Bucket) BucketSpec {
+	if bucket == e.TargetBucket {
+		return e.TargetBucketSpec
+	}
+	return e.SourceBucketSpec
+}
+
+// Execute a N1QL statement against the bucket, routed according to the query node settings.
+// params are positional ($1, $2...) or named ($name) parameters, or nil.
+func (e *ExampleApp) executeN1qlQuery(bucket *gocb.Bucket, statement string, params interface{}) (n1qlRows, error) {
+
+	router := e.queryRouter
+	if router == nil {
+		router = &queryRouter{}
+	}
+
+	router.acquire()
+
+	var rows n1qlRows
+	var err error
+	if len(router.nodes) > 0 {
+		rows, err = e.executeN1qlQueryOnNode(router.nextNode(), bucket, statement, params)
+	} else {
+		rows, err = e.executeN1qlQueryOnCluster(bucket, statement, params)
+	}
+	if err != nil {
+		router.release()
+		return nil, err
+	}
+
+	return &releasingRows{n1qlRows: rows, release: router.release}, nil
+}
+
+// Execute a N1QL statement via the cluster connection the bucket was opened through
+func (e *ExampleApp) executeN1qlQueryOnCluster(bucket *gocb.Bucket, statement string, params interface{}) (n1qlRows, error) {
+	opts, err := queryOptions(params)
+	if err != nil {
+		return nil, err
+	}
+	result, err := e.cluster(bucket).Query(statement, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &gocbRows{result: result}, nil
+}
+
+// Releases the concurrency slot held by a query once its results are closed
+type releasingRows struct {
+	n1qlRows
+	release func()
+	once    sync.Once
+}
+
+func (r *releasingRows) Close() error {
+	err := r.n1qlRows.Close()
+	r.once.Do(r.release)
+	return err
+}
+
+// Execute a N1QL statement via the REST API of a specific query node
+func (e *ExampleApp) executeN1qlQueryOnNode(node string, bucket *gocb.Bucket, statement string, params interface{}) (n1qlRows, error) {
+
+	form := url.Values{}
+	form.Set("statement", statement)
+	switch p := params.(type) {
+	case nil:
+	case []interface{}:
+		args, err := json.Marshal(p)
+		if err != nil {
+			return nil, err
+		}
+		form.Set("args", string(args))
+	case map[string]interface{}:
+		for name, val := range p {
+			valBytes, err := json.Marshal(val)
+			if err != nil {
+				return nil, err
+			}
+			form.Set("$"+strings.TrimPrefix(name, "$"), string(valBytes))
+		}
+	default:
+		return nil, fmt.Errorf("Unsupported N1QL params type: %T", params)
+	}
+
+	scheme := "http"
+	if baseURL, err := managementURL(e.ConnSpec); err == nil && strings.HasPrefix(baseURL, "https://") {
+		scheme = "https"
+	}
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("%v://%v/query/service", scheme, node), strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	spec := e.bucketSpec(bucket)
+	req.SetBasicAuth(spec.username(), spec.Password)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Error querying node %v.  Err: %v", node, err)
+	}
+
+	rows := &restQueryRows{body: resp.Body, decoder: json.NewDecoder(resp.Body), node: node}
+	if err := rows.seekResults(); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return rows, nil
+}
+
+// Streams the rows of a query service REST response without reading it all into memory
+type restQueryRows struct {
+	body      io.ReadCloser
+	decoder   *json.Decoder
+	node      string
+	inResults bool
+	err       error
+}
+
+// Advance the decoder to the start of the "results" array
+func (r *restQueryRows) seekResults() error {
+	if _, err := r.decoder.Token(); err != nil { // opening {
+		return err
+	}
+	for r.decoder.More() {
+		key, err := r.decoder.Token()
+		if err != nil {
+			return err
+		}
+		if key == "results" {
+			if _, err := r.decoder.Token(); err != nil { // opening [
+				return err
+			}
+			r.inResults = true
+			return nil
+		}
+		if key == "errors" {
+			errs := []interface{}{}
+			if err := r.decoder.Decode(&errs); err != nil {
+				return err
+			}
+			return fmt.Errorf("Query on node %v failed: %v", r.node, errs)
+		}
+		// Skip over any other field
+		var skipped json.RawMessage
+		if err := r.decoder.Decode(&skipped); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *restQueryRows) Next(valuePtr interface{}) bool {
+	if !r.inResults || r.err != nil {
+		return false
+	}
+	if !r.decoder.More() {
+		r.inResults = false
+		return false
+	}
+	if err := r.decoder.Decode(valuePtr); err != nil {
+		r.err = err
+		return false
+	}
+	return true
+}
+
+// Read the rest of the response, returning any errors reported after the results
+func (r *restQueryRows) Close() error {
+	defer r.body.Close()
+	if r.err != nil {
+		return r.err
+	}
+	if r.inResults {
+		// Skip unread results
+		for r.decoder.More() {
+			var skipped json.RawMessage
+			if err := r.decoder.Decode(&skipped); err != nil {
+				return err
+			}
+		}
+		r.inResults = false
+	}
+	if _, err := r.decoder.Token(); err != nil && err != io.EOF { // closing ]
+		return err
+	}
+	return r.seekErrors()
+}
+
+func (r *restQueryRows) seekErrors() error {
+	for r.decoder.More() {
+		key, err := r.decoder.Token()
+		if err != nil {
+			return err
+		}
+		if key == "errors" {
+			errs := []interface{}{}
+			if err := r.decoder.Decode(&errs); err != nil {
+				return err
+			}
+			return fmt.Errorf("Query on node %v failed: %v", r.node, errs)
+		}
+		var skipped json.RawMessage
+		if err := r.decoder.Decode(&skipped); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package gocbexample
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Fetch up to this many pages ahead of the one being processed.  0 fetches the next page only once
+// the current one has been processed
+func WithReadAhead(pages int) Option {
+	return func(e *ExampleApp) error {
+		if pages < 0 {
+			return fmt.Errorf("Invalid read-ahead: %v pages.  Must be at least 0", pages)
+		}
+		e.ReadAheadPages = pages
+		return nil
+	}
+}
+
+// Hand pages to docProcessor on a goroutine of their own, so whoever produces the pages can fetch the next
+// one while the previous one is being processed.  Pages passed to the returned DocProcessor are queued,
+// blocking once pages pages are waiting.  finish waits until the queued pages are processed and returns
+// the first processing error, which is also returned by the next call to queue.
+func readAhead(docProcessor DocProcessor, pages int) (queue DocProcessor, finish func() error) {
+
+	pending := make(chan DocProcessorInput, pages)
+	done := make(chan struct{})
+
+	var errMutex sync.Mutex
+	var firstErr error
+	processErr := func() error {
+		errMutex.Lock()
+		defer errMutex.Unlock()
+		return firstErr
+	}
+
+	go func() {
+		defer close(done)
+		for page := range pending {
+			if processErr() != nil {
+				// Drop the pages queued after a failure, the producer is told to stop on its next call
+				continue
+			}
+			if err := docProcessor(page.DocIds, page.Docs); err != nil {
+				errMutex.Lock()
+				firstErr = err
+				errMutex.Unlock()
+			}
+		}
+	}()
+
+	queue = func(docIds []string, docs []interface{}) error {
+		if err := processErr(); err != nil {
+			return err
+		}
+		pending <- DocProcessorInput{DocIds: docIds, Docs: docs}
+		return nil
+	}
+
+	finish = func() error {
+		close(pending)
+		<-done
+		return processErr()
+	}
+
+	return queue, finish
+}
@@ -0,0 +1,228 @@
+package gocbexample
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+const (
+	// How long to wait for indexes/views to finish building before giving up
+	defaultReadinessTimeout = 10 * time.Minute
+
+	// How often to poll the index/view build status
+	readinessPollInterval = 5 * time.Second
+)
+
+// An entry from /pools/default/tasks
+type clusterTask struct {
+	Type           string `json:"type"`
+	Bucket         string `json:"bucket"`
+	DesignDocument string `json:"designDocument"`
+	Progress       int    `json:"progress"`
+}
+
+// Wait until the primary indexes (N1QL) or scan views have finished building on both buckets,
+// reporting progress as it goes.  Without this, the first queries after Connect either fail or
+// silently return partial data while indexing is still in progress.
+func (e *ExampleApp) WaitForScanIndexes() error {
+
+	timeout := e.ReadinessTimeout
+	if timeout <= 0 {
+		timeout = defaultReadinessTimeout
+	}
+	deadline := time.Now().Add(timeout)
+
+	if !e.UseN1ql {
+		for _, bucket := range []*gocb.Bucket{e.SourceBucket, e.TargetBucket} {
+			if err := e.waitForScanView(bucket, deadline); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return e.ForEachCollectionPair(func(CollectionPair) error {
+		for _, bucket := range []*gocb.Bucket{e.SourceBucket, e.TargetBucket} {
+			if err := e.waitForPrimaryIndex(bucket, deadline); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (e *ExampleApp) waitForPrimaryIndex(bucket *gocb.Bucket, deadline time.Time) error {
+
+	keyspace := e.keyspaceName(bucket)
+	for {
+
+		indexes, err := e.collection(bucket).QueryIndexes().GetAllIndexes(nil)
+		if err != nil {
+			return fmt.Errorf("Error getting indexes for: %v.  Err: %v", keyspace, err)
+		}
+
+		state := "missing"
+		for _, index := range indexes {
+			if index.IsPrimary {
+				state = index.State
+			}
+		}
+		if state == "online" {
+			e.logf("Primary index on %v is online", keyspace)
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Timed out waiting for primary index on %v, state: %v", keyspace, state)
+		}
+		e.logf("Waiting for primary index on %v, state: %v", keyspace, state)
+		time.Sleep(readinessPollInterval)
+	}
+
+}
+
+func (e *ExampleApp) waitForScanView(bucket *gocb.Bucket, deadline time.Time) error {
+
+	// Views are built lazily, so query it once to kick off the build
+	kickOpts := e.newScanViewOptions()
+	kickOpts.Limit = 1
+	if results, err := e.executeScanViewQuery(bucket, kickOpts); err == nil {
+		results.Close()
+	}
+
+	designDocId := "_design/" + e.scanDesignDocName()
+
+	for {
+
+		tasks := []clusterTask{}
+		if err := e.managementGet("/pools/default/tasks", &tasks); err != nil {
+			e.logf("Unable to get view indexing progress for bucket %v: %v", bucket.Name(), err)
+			break
+		}
+
+		building := false
+		for _, task := range tasks {
+			if task.Type == "indexer" && task.Bucket == bucket.Name() && task.DesignDocument == designDocId {
+				building = true
+				e.logf("Waiting for view %v in bucket %v to build: %v%%", designDocId, bucket.Name(), task.Progress)
+			}
+		}
+		if !building {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Timed out waiting for view %v in bucket %v to build", designDocId, bucket.Name())
+		}
+		time.Sleep(readinessPollInterval)
+	}
+
+	// A stale=false query blocks until the index has caught up with every mutation
+	readyOpts := e.newScanViewOptions()
+	readyOpts.ScanConsistency, readyOpts.Limit = gocb.ViewScanConsistencyRequestPlus, 1
+	results, err := e.executeScanViewQuery(bucket, readyOpts)
+	if err == nil {
+		err = results.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("Error waiting for view %v in bucket %v.  Err: %v", designDocId, bucket.Name(), err)
+	}
+
+	e.logf("View %v in bucket %v is ready", designDocId, bucket.Name())
+	return nil
+}
+
+// Wait until the indexes on the target bucket have caught up with every mutation written so far, so
+// that queries run right after the job (eg by downstream tests) don't see partial data.  Each online GSI
+// index is queried with request_plus consistency, which blocks until the index has processed every
+// mutation made before the query, and the scan view (if views are used) is queried with stale=false.
+func (e *ExampleApp) WaitForTargetIndexes() error {
+
+	timeout := e.ReadinessTimeout
+	if timeout <= 0 {
+		timeout = defaultReadinessTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	bucket := e.TargetBucket
+
+	if !e.UseN1ql {
+		if err := e.waitForScanView(bucket, deadline); err != nil {
+			return err
+		}
+	}
+
+	return e.ForEachCollectionPair(func(CollectionPair) error {
+		return e.waitForTargetIndexes(bucket, deadline)
+	})
+}
+
+// Wait until the GSI indexes on the collection of the bucket have caught up
+func (e *ExampleApp) waitForTargetIndexes(bucket *gocb.Bucket, deadline time.Time) error {
+
+	keyspace := e.keyspaceName(bucket)
+	for {
+
+		indexes, err := e.collection(bucket).QueryIndexes().GetAllIndexes(nil)
+		if err != nil {
+			return fmt.Errorf("Error getting indexes for: %v.  Err: %v", keyspace, err)
+		}
+
+		var building []string
+		for _, index := range indexes {
+			if index.Type != gocb.QueryIndexTypeGsi {
+				continue
+			}
+			if index.State != "online" {
+				building = append(building, fmt.Sprintf("%v (%v)", index.Name, index.State))
+				continue
+			}
+			if err := e.waitForIndexConsistency(bucket, index); err != nil {
+				return err
+			}
+		}
+		if len(building) == 0 {
+			e.logf("Indexes on %v have caught up", keyspace)
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Timed out waiting for indexes on %v to build: %v", keyspace, building)
+		}
+		e.logf("Waiting for indexes on %v to build: %v", keyspace, building)
+		time.Sleep(readinessPollInterval)
+	}
+
+}
+
+// Block until the index has caught up, by querying it with request_plus consistency
+func (e *ExampleApp) waitForIndexConsistency(bucket *gocb.Bucket, index gocb.QueryIndex) error {
+
+	keyspace := e.keyspaceName(bucket)
+	predicate := ""
+	if !index.IsPrimary {
+		if len(index.IndexKey) == 0 || !strings.HasPrefix(index.IndexKey[0], "`") {
+			// Eg an array index, which a simple predicate can't select
+			e.logf("Not waiting for index %v on %v, its leading key %v isn't a plain field", index.Name, keyspace, index.IndexKey)
+			return nil
+		}
+		predicate = fmt.Sprintf(" WHERE %v IS NOT MISSING", index.IndexKey[0])
+	}
+	path, _ := e.n1qlKeyspace(bucket)
+	statement := fmt.Sprintf("SELECT RAW META().id FROM %v USE INDEX (`%v` USING GSI)%v LIMIT 1", path, index.Name, predicate)
+
+	results, err := e.cluster(bucket).Query(statement, &gocb.QueryOptions{ScanConsistency: gocb.QueryScanConsistencyRequestPlus})
+	if err == nil {
+		err = results.Close()
+	}
+	if err != nil {
+		// Eg a partial index the predicate doesn't satisfy the condition of
+		e.logf("Unable to wait for index %v on %v to catch up: %v", index.Name, keyspace, err)
+		return nil
+	}
+
+	e.logf("Index %v on %v has caught up", index.Name, keyspace)
+	return nil
+}
@@ -0,0 +1,65 @@
+package gocbexample
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// Returned (wrapped) when a code path tries to write docs to the source bucket, eg because the source and
+// target were swapped in the config.  The source is only read, apart from the scan view or primary index
+// created on it.
+var ErrSourceWrite = errors.New("the source bucket is read-only")
+
+// Let the source and target be the same bucket, transforming its docs in place.  Without it, Connect refuses a
+// target that is the source, since the copy would feed on its own output.
+func WithInPlace() Option {
+	return func(e *ExampleApp) error {
+		e.InPlace = true
+		return nil
+	}
+}
+
+// Refuse to run with the source and target resolving to the same bucket (the same name on the one cluster)
+// unless InPlace is set
+func (e *ExampleApp) checkSameBucket() error {
+	if e.SourceBucketSpec.Name != e.TargetBucketSpec.Name || e.InPlace || len(e.CollectionMap) > 0 {
+		// Collections mapped to other collections of the same bucket are checked once they're resolved
+		return nil
+	}
+	return fmt.Errorf("The source and target are the same bucket: %v, so the copy would feed on its own output.  "+
+		"Set a different target, a collectionMap to copy to other collections of the bucket, or inPlace (-in-place) to transform the bucket in place", e.SourceBucketSpec.Name)
+}
+
+// The collection of the bucket for operation to write to.  Writes to buckets all go through here or doBulk, so
+// that whatever the code path, writing to the source fails loudly unless it's being transformed in place.
+func (e *ExampleApp) writeCollection(bucket *gocb.Bucket, operation string) (*gocb.Collection, error) {
+	if err := e.checkWritable(bucket, operation); err != nil {
+		return nil, err
+	}
+	return e.collection(bucket), nil
+}
+
+// Do any of the bulk ops write?
+func bulkOpsWrite(items []gocb.BulkOp) bool {
+	for _, item := range items {
+		if _, ok := item.(*gocb.GetOp); !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Fail loudly if operation would write docs to the source bucket.  bucket may be nil, eg when the sink isn't a
+// bucket.  The source is matched by name as well, since the target spec can name it and open it separately.
+func (e *ExampleApp) checkWritable(bucket *gocb.Bucket, operation string) error {
+	if bucket == nil || e.InPlace {
+		return nil
+	}
+	if bucket == e.SourceBucket || (bucket.Name() == e.SourceBucketSpec.Name && e.keyspace(bucket) == e.keyspace(e.SourceBucket)) {
+		return fmt.Errorf("%v would write to %v, which is the source.  Are the source and target swapped in the config?  Err: %w",
+			operation, e.keyspaceName(bucket), ErrSourceWrite)
+	}
+	return nil
+}
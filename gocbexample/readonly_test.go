@@ -0,0 +1,31 @@
+package gocbexample
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+func TestWritesToSourceRejected(t *testing.T) {
+
+	// The target swapped for the source: the copy would write to the bucket it reads
+	source := &gocb.Bucket{}
+	e := &ExampleApp{SourceBucket: source, TargetBucket: source}
+
+	if _, err := e.NewBucketSink(e.TargetBucket).WriteDocsWithResults([]string{"a", "b"}, []interface{}{1, 2}); !errors.Is(err, ErrSourceWrite) {
+		t.Errorf("Writing to the source via the sink = %v, want %v", err, ErrSourceWrite)
+	}
+	if _, err := e.doBulk(e.TargetBucket, []gocb.BulkOp{&gocb.RemoveOp{ID: "a"}}, isTemporaryError); !errors.Is(err, ErrSourceWrite) {
+		t.Errorf("Bulk remove from the source = %v, want %v", err, ErrSourceWrite)
+	}
+	if err := e.mutateInKeepingExpiry(e.TargetBucket, "", "a", gocb.SubdocDocFlagNone, 0, nil); !errors.Is(err, ErrSourceWrite) {
+		t.Errorf("Subdoc mutation of the source = %v, want %v", err, ErrSourceWrite)
+	}
+
+	// In place, the source is written to on purpose
+	e.InPlace = true
+	if _, err := e.writeCollection(e.SourceBucket, "Writing back in place"); err != nil {
+		t.Errorf("Writing to the source in place = %v, want nil", err)
+	}
+}
@@ -0,0 +1,193 @@
+package gocbexample
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// How docs are stored in Redis: as a JSON string, or as a hash of their top-level fields
+	RedisEncodingJSON = "json"
+	RedisEncodingHash = "hash"
+
+	// The key of each doc, unless a key template is set
+	defaultRedisKeyTemplate = "${meta.id}"
+)
+
+// Where and how the docs copied are also written to Redis, eg to warm the cache of the application under test
+type RedisConfig struct {
+
+	// eg redis://:password@localhost:6379/0, or rediss:// for TLS
+	URL string `json:"url"`
+
+	// Build keys from the fields of the docs, eg "user:${email}".  ${meta.id} is the doc id, and the default
+	KeyTemplate string `json:"keyTemplate,omitempty"`
+
+	// Expire the keys after this many seconds.  0 means they don't expire
+	TTLSeconds int `json:"ttlSeconds,omitempty"`
+
+	// json (the default) stores each doc as a string of its JSON, hash as a hash of its top-level fields, with
+	// strings as is and other values as JSON
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// Also write the docs copied to Redis.  See RedisConfig
+func WithRedis(config RedisConfig) Option {
+	return func(e *ExampleApp) error {
+		if _, err := redis.ParseURL(config.URL); err != nil {
+			return fmt.Errorf("Invalid redis url: %v.  Err: %v", config.URL, err)
+		}
+		if config.KeyTemplate == "" {
+			config.KeyTemplate = defaultRedisKeyTemplate
+		}
+		if _, err := NewDocKeyTemplate(config.KeyTemplate); err != nil {
+			return err
+		}
+		if config.TTLSeconds < 0 {
+			return fmt.Errorf("Invalid redis ttlSeconds: %v.  Must not be negative", config.TTLSeconds)
+		}
+		switch config.Encoding {
+		case "":
+			config.Encoding = RedisEncodingJSON
+		case RedisEncodingJSON, RedisEncodingHash:
+		default:
+			return fmt.Errorf("Unknown redis encoding: %v.  Expected %v or %v", config.Encoding, RedisEncodingJSON, RedisEncodingHash)
+		}
+		e.Redis = &config
+		return nil
+	}
+}
+
+// A Sink that writes docs to Redis, a key per doc, each batch in a single pipeline
+type RedisSink struct {
+	Config RedisConfig
+
+	client      *redis.Client
+	keyTemplate *DocKeyTemplate
+}
+
+// Connect to Redis, checking that it answers
+func NewRedisSink(config RedisConfig) (*RedisSink, error) {
+
+	options, err := redis.ParseURL(config.URL)
+	if err != nil {
+		return nil, err
+	}
+	if config.KeyTemplate == "" {
+		config.KeyTemplate = defaultRedisKeyTemplate
+	}
+	keyTemplate, err := NewDocKeyTemplate(config.KeyTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	client := redis.NewClient(options)
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("Error connecting to Redis at: %v.  Err: %v", options.Addr, err)
+	}
+	return &RedisSink{Config: config, client: client, keyTemplate: keyTemplate}, nil
+}
+
+func (s *RedisSink) Name() string {
+	return fmt.Sprintf("redis:%v", s.client.Options().Addr)
+}
+
+// The key of a doc.  The template sees the doc's fields, and its id as meta.id
+func (s *RedisSink) key(docId string, doc interface{}) (string, error) {
+	fields := map[string]interface{}{}
+	if body, ok := doc.(map[string]interface{}); ok {
+		for field, val := range body {
+			fields[field] = val
+		}
+	}
+	fields["meta"] = map[string]interface{}{"id": docId}
+	return s.keyTemplate.key(fields)
+}
+
+func (s *RedisSink) WriteDocs(docIds []string, docs []interface{}) error {
+
+	ttl := time.Duration(s.Config.TTLSeconds) * time.Second
+	ctx := context.Background()
+	pipe := s.client.Pipeline()
+
+	// The doc of each command queued, in order
+	var cmdDocIds []string
+	for i, docId := range docIds {
+		key, err := s.key(docId, docs[i])
+		if err != nil {
+			return newDocError(PhaseTargetWrite, docId, fmt.Errorf("Error building the Redis key.  Err: %w", err))
+		}
+
+		if s.Config.Encoding == RedisEncodingHash {
+			fields, err := redisHashFields(docs[i])
+			if err != nil {
+				return newDocError(PhaseTargetWrite, docId, err)
+			}
+			// Replace the hash, rather than merging into the fields of an earlier copy
+			pipe.Del(ctx, key)
+			cmdDocIds = append(cmdDocIds, docId)
+			if len(fields) > 0 {
+				pipe.HSet(ctx, key, fields...)
+				cmdDocIds = append(cmdDocIds, docId)
+			}
+			if ttl > 0 {
+				pipe.Expire(ctx, key, ttl)
+				cmdDocIds = append(cmdDocIds, docId)
+			}
+			continue
+		}
+
+		docJSON, err := json.Marshal(docs[i])
+		if err != nil {
+			return newDocError(PhaseTargetWrite, docId, err)
+		}
+		pipe.Set(ctx, key, docJSON, ttl)
+		cmdDocIds = append(cmdDocIds, docId)
+	}
+
+	cmds, err := pipe.Exec(ctx)
+	if err == nil {
+		return nil
+	}
+	for i, cmd := range cmds {
+		if cmd.Err() != nil && i < len(cmdDocIds) {
+			return newDocError(PhaseTargetWrite, cmdDocIds[i], fmt.Errorf("Error writing to Redis: %v.  Err: %w", cmd.Name(), cmd.Err()))
+		}
+	}
+	return newDocError(PhaseTargetWrite, "", fmt.Errorf("Error writing to Redis.  Err: %w", err))
+}
+
+// The fields of a hash encoded doc, as field, value pairs
+func redisHashFields(doc interface{}) ([]interface{}, error) {
+
+	body, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Doc isn't a JSON object, so can't be stored as a Redis hash")
+	}
+	fields := make([]interface{}, 0, 2*len(body))
+	for field, val := range body {
+		switch typed := val.(type) {
+		case string:
+			fields = append(fields, field, typed)
+		case float64:
+			fields = append(fields, field, strconv.FormatFloat(typed, 'f', -1, 64))
+		default:
+			valJSON, err := json.Marshal(val)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, field, string(valJSON))
+		}
+	}
+	return fields, nil
+}
+
+func (s *RedisSink) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,239 @@
+package gocbexample
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// Derives new doc ids from old ones, eg "^user_(\d+)$" -> "user::$1"
+type KeyRewrite struct {
+	pattern  *regexp.Regexp
+	template string
+}
+
+// Create a rewrite of the doc ids matching pattern to template, which can refer to the groups of the pattern as
+// $1 or ${name}
+func NewKeyRewrite(pattern, template string) (*KeyRewrite, error) {
+	if pattern == "" || template == "" {
+		return nil, fmt.Errorf("A key rewrite needs both a pattern and a template")
+	}
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid key pattern: %v.  Err: %v", pattern, err)
+	}
+	return &KeyRewrite{pattern: compiled, template: template}, nil
+}
+
+// The new id of a doc, or false if its id doesn't match the pattern or wouldn't change
+func (r *KeyRewrite) newKey(docId string) (string, bool) {
+	match := r.pattern.FindStringSubmatchIndex(docId)
+	if match == nil {
+		return "", false
+	}
+	newKey := string(r.pattern.ExpandString(nil, r.template, docId, match))
+	if newKey == "" || newKey == docId {
+		return "", false
+	}
+	return newKey, true
+}
+
+// What the rekey command did, or in a dry run would have done.  The old -> new id mapping is written to the
+// workspace rather than the report, since it has an entry per doc.
+type RekeyReport struct {
+	DryRun bool `json:"dryRun,omitempty"`
+
+	// Docs read, docs matching the filter and the rewrite pattern, and matching docs moved to their new id
+	DocsScanned int64 `json:"docsScanned"`
+	DocsMatched int64 `json:"docsMatched"`
+	DocsRekeyed int64 `json:"docsRekeyed"`
+
+	// Docs left under their old id because their new id was already taken
+	Collisions []string `json:"collisions,omitempty"`
+
+	// Docs left under their old id because they changed since they were read
+	Conflicts []string `json:"conflicts,omitempty"`
+
+	// Docs left under their old id because they have XATTRs, which subdoc can't list, so can't be moved with them
+	WithXattrs []string `json:"withXattrs,omitempty"`
+}
+
+// Move the docs of the source bucket matching the filter and the rewrite to their new ids.  Each doc is inserted
+// under its new id and, once that has succeeded, its old id removed with the CAS it was read with.  A doc whose
+// new id is taken, or which changed since it was read, is left under its old id (the new copy of a changed doc is
+// removed again), and reported.  The doc bodies and expiry are moved unchanged.  XATTRs can't be listed, so can't
+// be moved, and a doc that has any is left under its old id and reported.  On servers without XATTRs the expiry
+// can't be read either, so is lost.  Returns the old -> new id mapping of the moved docs, or in a dry run the docs
+// that would be moved.
+func (e *ExampleApp) RekeyDocs(filter DocFilter, rewrite *KeyRewrite, dryRun bool) (report RekeyReport, mapping map[string]string, err error) {
+
+	report.DryRun = dryRun
+	mapping = map[string]string{}
+	var mutex sync.Mutex
+
+	// The new ids of the docs moved, so that docs moved ahead of the scan aren't moved again.  Only set once the
+	// insert has succeeded, so that a doc that was already at a new id isn't skipped
+	created := map[string]bool{}
+
+	rekeyDocs := func(docIds []string, docs []interface{}, cas []gocb.Cas) error {
+
+		var matchedIds []string
+		var matchedKeys []string
+		var matchedDocs []interface{}
+		var matchedCas []gocb.Cas
+		mutex.Lock()
+		for i, docId := range docIds {
+			newKey, ok := rewrite.newKey(docId)
+			if !ok || created[docId] {
+				continue
+			}
+			report.DocsMatched++
+			matchedIds = append(matchedIds, docId)
+			matchedKeys = append(matchedKeys, newKey)
+			matchedDocs = append(matchedDocs, docs[i])
+			matchedCas = append(matchedCas, cas[i])
+		}
+		mutex.Unlock()
+		if len(matchedIds) == 0 {
+			return nil
+		}
+
+		// The expiry to move with each doc, and whether it has XATTRs, which can't be moved
+		metas, deleted, err := e.lookupRekeyMeta(matchedIds)
+		if err != nil {
+			return err
+		}
+		var oldIds []string
+		var inserts []gocb.BulkOp
+		var insertedDocs []interface{}
+		var readCas []gocb.Cas
+		var withXattrs []string
+		var conflicts []string
+		for i, docId := range matchedIds {
+			switch {
+			case deleted[i] || (metas[i].Cas != 0 && metas[i].Cas != matchedCas[i]):
+				conflicts = append(conflicts, docId)
+			case metas[i].HasXattrs:
+				withXattrs = append(withXattrs, docId)
+			default:
+				oldIds = append(oldIds, docId)
+				inserts = append(inserts, &gocb.InsertOp{ID: matchedKeys[i], Value: matchedDocs[i], Expiry: expiryDuration(metas[i].Expiry)})
+				insertedDocs = append(insertedDocs, matchedDocs[i])
+				readCas = append(readCas, matchedCas[i])
+			}
+		}
+
+		moved := map[string]string{}
+		var collisions []string
+		if dryRun {
+			for i, item := range inserts {
+				moved[oldIds[i]] = item.(*gocb.InsertOp).ID
+			}
+		} else if len(inserts) > 0 {
+
+			e.writeLimiter.Wait(docsSize(oldIds, insertedDocs))
+			if _, err := e.doBulk(e.SourceBucket, inserts, isTemporaryError); err != nil {
+				return newDocError(PhaseTargetWrite, "", err)
+			}
+			var removes []gocb.BulkOp
+			var removedInserts []*gocb.InsertOp
+			for i, item := range inserts {
+				insertItem := item.(*gocb.InsertOp)
+				if insertItem.Err != nil {
+					if errors.Is(wrapGocbError(insertItem.Err), ErrDocExists) {
+						collisions = append(collisions, oldIds[i])
+						continue
+					}
+					return newDocError(PhaseTargetWrite, insertItem.ID, insertItem.Err)
+				}
+				mutex.Lock()
+				created[insertItem.ID] = true
+				mutex.Unlock()
+				removes = append(removes, &gocb.RemoveOp{ID: oldIds[i], Cas: readCas[i]})
+				removedInserts = append(removedInserts, insertItem)
+			}
+
+			if len(removes) > 0 {
+				if _, err := e.doBulk(e.SourceBucket, removes, isTemporaryError); err != nil {
+					return newDocError(PhaseTargetWrite, "", err)
+				}
+			}
+			var rollbacks []gocb.BulkOp
+			for i, item := range removes {
+				removeItem := item.(*gocb.RemoveOp)
+				insertItem := removedInserts[i]
+				if removeItem.Err == nil {
+					moved[removeItem.ID] = insertItem.ID
+					continue
+				}
+				wrappedErr := wrapGocbError(removeItem.Err)
+				if !errors.Is(wrappedErr, ErrDocExists) && !errors.Is(wrappedErr, ErrDocNotFound) {
+					return newDocError(PhaseTargetWrite, removeItem.ID, removeItem.Err)
+				}
+				// The doc changed or was deleted since it was read, so the new copy is stale
+				conflicts = append(conflicts, removeItem.ID)
+				rollbacks = append(rollbacks, &gocb.RemoveOp{ID: insertItem.ID, Cas: insertItem.Result.Cas()})
+			}
+			if len(rollbacks) > 0 {
+				if _, err := e.doBulk(e.SourceBucket, rollbacks, isTemporaryError); err != nil {
+					return newDocError(PhaseTargetWrite, "", err)
+				}
+				for _, item := range rollbacks {
+					if rollbackErr := item.(*gocb.RemoveOp).Err; rollbackErr != nil {
+						return newDocError(PhaseTargetWrite, item.(*gocb.RemoveOp).ID, fmt.Errorf("Error removing the new copy of a doc that changed since it was read.  Err: %v", rollbackErr))
+					}
+				}
+			}
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		for oldId, newId := range moved {
+			mapping[oldId] = newId
+		}
+		if !dryRun {
+			report.DocsRekeyed += int64(len(moved))
+		}
+		report.Collisions = append(report.Collisions, collisions...)
+		report.Conflicts = append(report.Conflicts, conflicts...)
+		report.WithXattrs = append(report.WithXattrs, withXattrs...)
+		return nil
+	}
+
+	report.DocsScanned, _, err = e.forEachMatchingDoc("rekey", filter, rekeyDocs)
+	sort.Strings(report.Collisions)
+	sort.Strings(report.Conflicts)
+	sort.Strings(report.WithXattrs)
+	return report, mapping, err
+}
+
+// Read the metadata of the docs to move: the expiry to move with them, their CAS, to tell if they've changed since
+// they were read, and whether they have XATTRs.  Docs that have been deleted since are flagged.  Servers without
+// XATTRs can't report any of it, so the metadata is left empty.
+func (e *ExampleApp) lookupRekeyMeta(docIds []string) (metas []DocMeta, deleted []bool, err error) {
+
+	metas = make([]DocMeta, len(docIds))
+	deleted = make([]bool, len(docIds))
+	if !e.SupportsXattrs(e.SourceBucket.Name()) {
+		return metas, deleted, nil
+	}
+
+	collection := e.collection(e.SourceBucket)
+	err = forEachConcurrently(e.SubdocConcurrency, len(docIds), func(i int) error {
+		meta, err := lookupDocMeta(collection, docIds[i], nil)
+		if err != nil {
+			if errors.Is(wrapGocbError(err), ErrDocNotFound) {
+				deleted[i] = true
+				return nil
+			}
+			return newDocError(PhaseSourceRead, docIds[i], err)
+		}
+		metas[i] = meta
+		return nil
+	})
+	return metas, deleted, err
+}
@@ -0,0 +1,71 @@
+package gocbexample
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Timeout for requests to the cluster management REST API
+const managementRequestTimeout = 30 * time.Second
+
+// Get the base URL of the cluster management REST API from the first host in the connection string,
+// eg couchbase://host1,host2 -> http://host1:8091
+func managementURL(connSpec string) (string, error) {
+
+	if !strings.Contains(connSpec, "://") {
+		connSpec = "couchbase://" + connSpec
+	}
+	parsed, err := url.Parse(connSpec)
+	if err != nil {
+		return "", fmt.Errorf("Error parsing connection string: %v.  Err: %v", connSpec, err)
+	}
+
+	host := strings.Split(parsed.Host, ",")[0]
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		// Any port in the connection string is a KV port, not the management port
+		host = hostname
+	}
+	if host == "" {
+		return "", fmt.Errorf("No host in connection string: %v", connSpec)
+	}
+
+	switch parsed.Scheme {
+	case "couchbases", "https":
+		return fmt.Sprintf("https://%v:18091", host), nil
+	default:
+		return fmt.Sprintf("http://%v:8091", host), nil
+	}
+}
+
+// GET a path from the cluster management REST API as the Administrator, decoding the JSON response into result
+func (e *ExampleApp) managementGet(path string, result interface{}) error {
+
+	baseURL, err := managementURL(e.ConnSpec)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("GET", baseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth("Administrator", e.SourceBucketSpec.AdminPassword)
+
+	client := http.Client{Timeout: managementRequestTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unexpected status from GET %v: %v", path, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
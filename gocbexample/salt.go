@@ -0,0 +1,119 @@
+package gocbexample
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+)
+
+// Environment variable the anonymization salt is read from, unless configured otherwise
+const defaultSaltEnv = "ANONYMIZE_SALT"
+
+// Message whose HMAC identifies a salt without revealing it
+const saltFingerprintMessage = "gocb-example salt fingerprint"
+
+// A secret that is never printed: fmt and log show "<redacted>"
+type secret []byte
+
+func (s secret) String() string {
+	return "<redacted>"
+}
+
+func (s secret) GoString() string {
+	return "<redacted>"
+}
+
+// Load the salt from SaltFile if set, otherwise from the SaltEnv environment variable.  Returns nil if neither is set.
+func (c AnonymizeConfig) loadSalt() (secret, error) {
+
+	if c.SaltFile != "" {
+		saltBytes, err := ioutil.ReadFile(c.SaltFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading salt file: %v.  Err: %v", c.SaltFile, err)
+		}
+		salt := strings.TrimRight(string(saltBytes), "\r\n")
+		if salt == "" {
+			return nil, fmt.Errorf("Salt file: %v is empty", c.SaltFile)
+		}
+		return secret(salt), nil
+	}
+
+	if salt := os.Getenv(c.saltEnv()); salt != "" {
+		return secret(salt), nil
+	}
+	return nil, nil
+}
+
+// A fingerprint of the salt that is safe to store: the same salt always gives the same fingerprint,
+// but the salt can't be recovered from it.  Empty if there's no salt.
+func (s secret) fingerprint() string {
+	if len(s) == 0 {
+		return ""
+	}
+	return hex.EncodeToString(s.hmac(saltFingerprintMessage))[:payloadHashLength]
+}
+
+func (s secret) hmac(message string) []byte {
+	mac := hmac.New(sha256.New, s)
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
+
+// Deterministically anonymize a value: the same value and salt always give the same result, so
+// references between docs survive anonymization.  Strings become hex digests, integers become
+// integers with the same number of digits, and other numbers keep their magnitude.  Object fields
+// matching skipFields are left as is.
+func (s secret) anonymize(val interface{}, skipFields func(key string) bool) interface{} {
+
+	switch v := val.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			if skipFields(key) {
+				result[key] = child
+			} else {
+				result[key] = s.anonymize(child, skipFields)
+			}
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, child := range v {
+			result[i] = s.anonymize(child, skipFields)
+		}
+		return result
+	case string:
+		return s.anonymizeString(v)
+	case float64:
+		return s.anonymizeNumber(v)
+	default:
+		// Booleans and nulls carry too little information to be worth anonymizing
+		return val
+	}
+}
+
+func (s secret) anonymizeString(val string) string {
+	return hex.EncodeToString(s.hmac("s:" + val))[:payloadHashLength]
+}
+
+func (s secret) anonymizeNumber(val float64) float64 {
+
+	digest := s.hmac(fmt.Sprintf("n:%v", val))
+	fraction := float64(binary.BigEndian.Uint64(digest)) / float64(math.MaxUint64)
+
+	magnitude := math.Pow(10, math.Max(1, math.Ceil(math.Log10(math.Abs(val)+1))))
+	result := fraction * magnitude
+	if val == math.Trunc(val) {
+		result = math.Trunc(result)
+	}
+	if val < 0 {
+		result = -result
+	}
+	return result
+}
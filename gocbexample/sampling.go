@@ -0,0 +1,98 @@
+package gocbexample
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+const (
+	// Replica reads immediately after a write can miss the doc, so retry a few times
+	sampleReplicaAttempts   = 5
+	sampleReplicaRetryDelay = 200 * time.Millisecond
+)
+
+// Counters for the inline read-your-own-writes sampling
+type WriteSampleStats struct {
+	Written int64 `json:"written"`
+	Sampled int64 `json:"sampled"`
+}
+
+// Read back every SampleEveryN'th written doc from the target and compare it with what was written,
+// catching transcoding or transform bugs early rather than at final verification
+func (e *ExampleApp) sampleWrittenDocs(docIds []string, docs []interface{}) error {
+
+	if e.SampleEveryN <= 0 {
+		return nil
+	}
+
+	for i, docId := range docIds {
+
+		written := atomic.AddInt64(&e.sampleStats.Written, 1)
+		if written%int64(e.SampleEveryN) != 0 {
+			continue
+		}
+
+		readBack, err := e.readBackDoc(docId)
+		if err != nil {
+			return fmt.Errorf("Error reading back sampled doc id: %v.  Err: %v", docId, err)
+		}
+
+		writtenHash, err := hashComparableDoc(docs[i])
+		if err != nil {
+			return err
+		}
+		readHash, err := hashComparableDoc(readBack)
+		if err != nil {
+			return err
+		}
+		if writtenHash != readHash {
+			return fmt.Errorf("Sampled doc id: %v does not match what was written.  Written: %+v  Read back: %+v", docId, docs[i], readBack)
+		}
+
+		atomic.AddInt64(&e.sampleStats.Sampled, 1)
+	}
+
+	return nil
+}
+
+// Read a doc from the target bucket, from a replica if SampleFromReplica is set
+func (e *ExampleApp) readBackDoc(docId string) (doc interface{}, err error) {
+
+	collection := e.collection(e.TargetBucket)
+	if !e.SampleFromReplica {
+		result, err := collection.Get(docId, nil)
+		if err != nil {
+			return nil, err
+		}
+		err = result.Content(&doc)
+		return doc, err
+	}
+
+	for attempt := 1; attempt <= sampleReplicaAttempts; attempt++ {
+		// Reads from whichever replica responds first
+		var result *gocb.GetReplicaResult
+		result, err = collection.GetAnyReplica(docId, nil)
+		if err == nil {
+			err = result.Content(&doc)
+		}
+		if err == nil {
+			return doc, nil
+		}
+		if attempt < sampleReplicaAttempts {
+			e.logf("Replica read of sampled doc %v failed (attempt %v), retrying: %v", docId, attempt, err)
+			time.Sleep(sampleReplicaRetryDelay)
+		}
+	}
+	return nil, err
+}
+
+// Get the read-your-own-writes sampling counters
+func (e *ExampleApp) WriteSampleStats() WriteSampleStats {
+	return WriteSampleStats{
+		Written: atomic.LoadInt64(&e.sampleStats.Written),
+		Sampled: atomic.LoadInt64(&e.sampleStats.Sampled),
+	}
+}
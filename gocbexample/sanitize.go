@@ -0,0 +1,173 @@
+package gocbexample
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Couchbase rejects doc keys longer than 250 bytes
+const maxDocKeyLength = 250
+
+// Records a doc key that had to be changed by the Sanitizer
+type KeyModification struct {
+	Original  string
+	Sanitized string
+	Reasons   []string
+}
+
+// An optional pre-insert stage that NFC-normalizes all strings in doc bodies and sanitizes
+// doc keys so they are valid (and consistent) in the target bucket.
+type Sanitizer struct {
+
+	// NFC-normalize string values and field names in doc bodies
+	NormalizeStrings bool
+
+	// Strip control characters from doc keys and enforce MaxKeyLength
+	SanitizeKeys bool
+
+	// Lowercase doc keys
+	LowercaseKeys bool
+
+	// Maximum doc key length in bytes.  Defaults to 250, the server limit.
+	MaxKeyLength int
+
+	mutex         sync.Mutex
+	modifications []KeyModification
+}
+
+// Create a Sanitizer that normalizes strings and sanitizes keys
+func NewSanitizer(lowercaseKeys bool) *Sanitizer {
+	return &Sanitizer{
+		NormalizeStrings: true,
+		SanitizeKeys:     true,
+		LowercaseKeys:    lowercaseKeys,
+		MaxKeyLength:     maxDocKeyLength,
+	}
+}
+
+// Sanitize a single doc key, returning the new key and the reasons it was changed (if any)
+func (s *Sanitizer) SanitizeKey(docId string) (sanitized string, reasons []string) {
+
+	sanitized = docId
+
+	if s.NormalizeStrings {
+		if normalized := norm.NFC.String(sanitized); normalized != sanitized {
+			sanitized = normalized
+			reasons = append(reasons, "nfc")
+		}
+	}
+
+	if s.SanitizeKeys {
+		stripped := strings.Map(func(r rune) rune {
+			if unicode.IsControl(r) {
+				return -1
+			}
+			return r
+		}, sanitized)
+		if stripped != sanitized {
+			sanitized = stripped
+			reasons = append(reasons, "control-characters")
+		}
+	}
+
+	if s.LowercaseKeys {
+		if lowered := strings.ToLower(sanitized); lowered != sanitized {
+			sanitized = lowered
+			reasons = append(reasons, "lowercase")
+		}
+	}
+
+	if s.SanitizeKeys {
+		maxLength := s.MaxKeyLength
+		if maxLength <= 0 || maxLength > maxDocKeyLength {
+			maxLength = maxDocKeyLength
+		}
+		if len(sanitized) > maxLength {
+			// Truncate on a rune boundary so the key stays valid UTF-8
+			truncated := sanitized[:maxLength]
+			for !utf8.ValidString(truncated) {
+				truncated = truncated[:len(truncated)-1]
+			}
+			sanitized = truncated
+			reasons = append(reasons, "max-length")
+		}
+	}
+
+	return sanitized, reasons
+}
+
+// The pre-insert transform, suitable for adding to ExampleApp.Transforms
+func (s *Sanitizer) Transform(input DocProcessorInput) (output DocProcessorInput, err error) {
+
+	output = DocProcessorInput{
+		DocIds: make([]string, len(input.DocIds)),
+		Docs:   make([]interface{}, len(input.Docs)),
+	}
+
+	for i, docId := range input.DocIds {
+
+		sanitizedId, reasons := s.SanitizeKey(docId)
+		if len(reasons) > 0 {
+			s.recordModification(KeyModification{
+				Original:  docId,
+				Sanitized: sanitizedId,
+				Reasons:   reasons,
+			})
+		}
+		output.DocIds[i] = sanitizedId
+
+		doc := input.Docs[i]
+		if s.NormalizeStrings {
+			doc = normalizeValue(doc)
+		}
+		output.Docs[i] = doc
+
+	}
+
+	return output, nil
+}
+
+// Recursively NFC-normalize all strings (including field names) in a JSON value
+func normalizeValue(val interface{}) interface{} {
+	switch v := val.(type) {
+	case string:
+		return norm.NFC.String(v)
+	case map[string]interface{}:
+		normalized := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			normalized[norm.NFC.String(key)] = normalizeValue(child)
+		}
+		return normalized
+	case []interface{}:
+		normalized := make([]interface{}, len(v))
+		for i, child := range v {
+			normalized[i] = normalizeValue(child)
+		}
+		return normalized
+	default:
+		return val
+	}
+}
+
+func (s *Sanitizer) recordModification(modification KeyModification) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.modifications = append(s.modifications, modification)
+}
+
+// Get every doc key that the Sanitizer had to modify, sorted by original key
+func (s *Sanitizer) Report() []KeyModification {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	modifications := append([]KeyModification{}, s.modifications...)
+	sort.Slice(modifications, func(i, j int) bool {
+		return modifications[i].Original < modifications[j].Original
+	})
+	return modifications
+}
@@ -0,0 +1,194 @@
+package gocbexample
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// Bump this whenever the map function changes, so existing installs get migrated to the new version
+const scanViewVersion = 2
+
+// Design docs with this prefix are development design docs
+const devDesignDocPrefix = "dev_"
+
+// Marker included in the map function, used to recognize design docs created by this tool
+const scanViewMarker = "gocb-example scan view"
+
+// Javascript map function that emits just the doc id.  The doc bodies are fetched separately via
+// bulk KV gets, since emitting the entire doc doubles the index disk usage on the source.
+var scanViewMapFunction = fmt.Sprintf(`function(doc, meta) {
+               // %s v%d
+               emit(meta.id, null)
+        }`, scanViewMarker, scanViewVersion)
+
+// The name the scan design doc is stored under, including the dev_ prefix for development views
+func (e *ExampleApp) scanDesignDocName() string {
+	name := e.DesignDoc
+	if name == "" {
+		name = designDoc
+	}
+	if e.DevelopmentViews && !strings.HasPrefix(name, devDesignDocPrefix) {
+		name = devDesignDocPrefix + name
+	}
+	return name
+}
+
+func (e *ExampleApp) scanViewName() string {
+	if e.ViewName == "" {
+		return viewName
+	}
+	return e.ViewName
+}
+
+// The design doc + view used to iterate over all docs in a bucket.  gocb names design docs without the dev_
+// prefix, which it adds for the development namespace.
+func (e *ExampleApp) scanDesignDoc() gocb.DesignDocument {
+	return gocb.DesignDocument{
+		Name: strings.TrimPrefix(e.scanDesignDocName(), devDesignDocPrefix),
+		Views: map[string]gocb.View{
+			e.scanViewName(): {
+				Map: scanViewMapFunction,
+			},
+		},
+	}
+}
+
+// The namespace of the scan design doc: development if its name has the dev_ prefix
+func (e *ExampleApp) scanDesignDocNamespace() gocb.DesignDocumentNamespace {
+	if strings.HasPrefix(e.scanDesignDocName(), devDesignDocPrefix) {
+		return gocb.DesignDocumentNamespaceDevelopment
+	}
+	return gocb.DesignDocumentNamespaceProduction
+}
+
+// Options of a query against the scan view
+func (e *ExampleApp) newScanViewOptions() *gocb.ViewOptions {
+	opts := &gocb.ViewOptions{Namespace: e.scanDesignDocNamespace()}
+	if opts.Namespace == gocb.DesignDocumentNamespaceDevelopment {
+		// Development views only index a subset of vbuckets unless full_set is requested
+		opts.Raw = map[string]string{"full_set": "true"}
+	}
+	return opts
+}
+
+// Query the scan view of the bucket
+func (e *ExampleApp) executeScanViewQuery(bucket *gocb.Bucket, opts *gocb.ViewOptions) (*gocb.ViewResult, error) {
+	return bucket.ViewQuery(e.scanDesignDoc().Name, e.scanViewName(), opts)
+}
+
+// Add the scan design doc + view to the bucket.  An existing design doc from an older version of this
+// tool (which emitted the whole doc as the view value) is migrated by upserting the new version.  A
+// design doc with the same name that wasn't created by this tool is never overwritten, unless
+// OverwriteDesignDoc is set.
+func (e *ExampleApp) upsertScanDesignDoc(bucket *gocb.Bucket, spec BucketSpec) error {
+
+	viewIndexes := bucket.ViewIndexes()
+	gocbDesignDoc := e.scanDesignDoc()
+	name := e.scanDesignDocName()
+	namespace := e.scanDesignDocNamespace()
+
+	existing, err := viewIndexes.GetDesignDocument(gocbDesignDoc.Name, namespace, nil)
+	if err == nil && existing != nil {
+		existingView, ok := existing.Views[e.scanViewName()]
+		if ok && existingView.Map == scanViewMapFunction {
+			// Already up to date, don't upsert since that would trigger a rebuild of the index
+			return nil
+		}
+		switch {
+		case ok && strings.Contains(existingView.Map, scanViewMarker):
+			e.logf("Migrating design doc %v in bucket %v to scan view v%d", name, bucket.Name(), scanViewVersion)
+		case e.OverwriteDesignDoc:
+			e.logf("Overwriting existing design doc %v in bucket %v", name, bucket.Name())
+		default:
+			return fmt.Errorf("Design doc %v already exists in bucket %v and was not created by this tool.  "+
+				"Configure a different design doc name, or set overwriteDesignDoc to replace it", name, bucket.Name())
+		}
+	}
+
+	return viewIndexes.UpsertDesignDocument(gocbDesignDoc, namespace, nil)
+}
+
+// Fetch the bodies of the given docs via bulk ops.  Docs that were deleted since they were indexed
+// are left out of the result.
+func (e *ExampleApp) fetchDocs(bucket *gocb.Bucket, docIds []string) (foundDocIds []string, docs []interface{}, err error) {
+
+	if len(docIds) == 0 {
+		return docIds, []interface{}{}, nil
+	}
+
+	items := make([]gocb.BulkOp, len(docIds))
+	for i, docId := range docIds {
+		items[i] = &gocb.GetOp{ID: docId}
+	}
+	startedAt := time.Now()
+	attempts, err := e.doBulk(bucket, items, isTemporaryError)
+	if err != nil {
+		return nil, nil, newDocError(PhaseSourceRead, "", err)
+	}
+	retries, failures := 0, 0
+	for _, item := range items {
+		retries += attempts[item] - 1
+		if isTemporaryError(bulkOpErr(item)) {
+			failures++
+		}
+	}
+	e.throughput.recordReads(len(items), time.Since(startedAt), retries, failures)
+
+	foundDocIds = make([]string, 0, len(docIds))
+	docs = make([]interface{}, 0, len(docIds))
+	for _, item := range items {
+		getOp := item.(*gocb.GetOp)
+		var doc interface{}
+		var cas gocb.Cas
+		if getOp.Err == nil {
+			if err := getOp.Result.Content(&doc); err != nil {
+				return nil, nil, newDocError(PhaseSourceRead, getOp.ID, err)
+			}
+			cas = getOp.Result.Cas()
+		} else {
+			if errors.Is(wrapGocbError(getOp.Err), ErrDocNotFound) {
+				e.logf("Doc %v was deleted since it was indexed, skipping", getOp.ID)
+				continue
+			}
+			if !e.ReplicaReadFallback {
+				return nil, nil, withAttempts(newDocError(PhaseSourceRead, getOp.ID, getOp.Err), attempts[item])
+			}
+
+			// The active node is overloaded or failing over, read the doc from a replica instead
+			replicaResult, replicaErr := e.collection(bucket).GetAnyReplica(getOp.ID, nil)
+			if replicaErr == nil {
+				replicaErr = replicaResult.Content(&doc)
+			}
+			if replicaErr != nil {
+				return nil, nil, withAttempts(newDocError(PhaseSourceRead, getOp.ID, fmt.Errorf("%w.  Replica read also failed: %v", wrapGocbError(getOp.Err), replicaErr)), attempts[item])
+			}
+			e.logf("Read doc %v from a replica after active read failed: %v", getOp.ID, getOp.Err)
+			e.recordReplicaRead(getOp.ID)
+			cas = replicaResult.Cas()
+		}
+		if e.readCas != nil && bucket == e.SourceBucket {
+			e.readCas.record(getOp.ID, cas)
+		}
+		foundDocIds = append(foundDocIds, getOp.ID)
+		docs = append(docs, doc)
+	}
+
+	return foundDocIds, docs, nil
+}
+
+func (e *ExampleApp) recordReplicaRead(docId string) {
+	e.replicaReadsMutex.Lock()
+	defer e.replicaReadsMutex.Unlock()
+	e.replicaReads = append(e.replicaReads, docId)
+}
+
+// Get the ids of the docs that were read from a replica because the active read failed
+func (e *ExampleApp) ReplicaReads() []string {
+	e.replicaReadsMutex.Lock()
+	defer e.replicaReadsMutex.Unlock()
+	return append([]string{}, e.replicaReads...)
+}
@@ -0,0 +1,221 @@
+package gocbexample
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// A daily window that the job is allowed to run in, as minutes since midnight.  If End is before
+// Start the window wraps past midnight, eg 22:00-06:00.
+type RunWindow struct {
+	Start int
+	End   int
+}
+
+// Parse a window of the form "HH:MM-HH:MM"
+func ParseRunWindow(window string) (RunWindow, error) {
+	parts := strings.Split(window, "-")
+	if len(parts) != 2 {
+		return RunWindow{}, fmt.Errorf("Error parsing run window: %v.  Err: expected HH:MM-HH:MM", window)
+	}
+	start, err := parseClockTime(parts[0])
+	if err != nil {
+		return RunWindow{}, fmt.Errorf("Error parsing run window: %v.  Err: %v", window, err)
+	}
+	end, err := parseClockTime(parts[1])
+	if err != nil {
+		return RunWindow{}, fmt.Errorf("Error parsing run window: %v.  Err: %v", window, err)
+	}
+	if start == end {
+		return RunWindow{}, fmt.Errorf("Error parsing run window: %v.  Err: window is empty", window)
+	}
+	return RunWindow{Start: start, End: end}, nil
+}
+
+// Parse HH:MM into minutes since midnight
+func parseClockTime(clock string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(clock))
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (w RunWindow) contains(minute int) bool {
+	if w.Start < w.End {
+		return minute >= w.Start && minute < w.End
+	}
+	return minute >= w.Start || minute < w.End
+}
+
+func (w RunWindow) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", w.Start/60, w.Start%60, w.End/60, w.End%60)
+}
+
+// The windows a job is allowed to run in.  Outside of them the job pauses between the pages of its scans,
+// once no result stream is open, and resumes from where it left off when a window reopens.
+type RunSchedule struct {
+	Windows []RunWindow
+
+	// The time zone the windows are in, ie the cluster's time zone.  Defaults to local time
+	Location *time.Location
+
+	mutex     sync.Mutex
+	pauses    int
+	pausedFor time.Duration
+
+	// When the pause in progress ends, so that callers waiting for the same window count as one pause
+	resumeAt time.Time
+}
+
+// Parse a schedule from a list of "HH:MM-HH:MM" windows and an optional IANA time zone name.
+// Returns nil (always run) if there are no windows.
+func ParseRunSchedule(windows []string, timeZone string) (*RunSchedule, error) {
+
+	if len(windows) == 0 {
+		return nil, nil
+	}
+
+	schedule := &RunSchedule{Location: time.Local}
+	if timeZone != "" {
+		location, err := time.LoadLocation(timeZone)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading run window time zone: %v.  Err: %v", timeZone, err)
+		}
+		schedule.Location = location
+	}
+
+	for _, window := range windows {
+		runWindow, err := ParseRunWindow(window)
+		if err != nil {
+			return nil, err
+		}
+		schedule.Windows = append(schedule.Windows, runWindow)
+	}
+
+	return schedule, nil
+}
+
+// Is t inside one of the windows?
+func (s *RunSchedule) Open(t time.Time) bool {
+	t = t.In(s.Location)
+	minute := t.Hour()*60 + t.Minute()
+	for _, window := range s.Windows {
+		if window.contains(minute) {
+			return true
+		}
+	}
+	return false
+}
+
+// The next time at or after t that a window opens
+func (s *RunSchedule) NextOpen(t time.Time) time.Time {
+	t = t.In(s.Location)
+	if s.Open(t) {
+		return t
+	}
+	var next time.Time
+	for _, window := range s.Windows {
+		// By the wall clock rather than adding to midnight, which is an hour out on the days the clocks change
+		opens := time.Date(t.Year(), t.Month(), t.Day(), 0, window.Start, 0, 0, s.Location)
+		if !opens.After(t) {
+			opens = opens.AddDate(0, 0, 1)
+		}
+		if next.IsZero() || opens.Before(next) {
+			next = opens
+		}
+	}
+	return next
+}
+
+// Block until a window is open, logging the pause with logf.  Safe to call on a nil schedule, which
+// never blocks.  Concurrent callers all wait for the same window, which counts as one pause.
+func (s *RunSchedule) Wait(logf func(format string, args ...interface{})) {
+
+	if s == nil {
+		return
+	}
+
+	// The mutex isn't held while sleeping, so Report() can be called during a pause
+	s.mutex.Lock()
+	now := time.Now()
+	if s.Open(now) {
+		s.mutex.Unlock()
+		return
+	}
+	resumeAt := s.NextOpen(now)
+	first := !resumeAt.Equal(s.resumeAt)
+	if first {
+		s.resumeAt = resumeAt
+		s.pauses += 1
+	}
+	s.mutex.Unlock()
+
+	if first {
+		logf("Outside of run windows %v, pausing until %v", s.Windows, resumeAt)
+	}
+	time.Sleep(resumeAt.Sub(now))
+	if !first {
+		return
+	}
+	logf("Run window open, resuming after %v", time.Since(now))
+
+	s.mutex.Lock()
+	s.pausedFor += time.Since(now)
+	s.mutex.Unlock()
+}
+
+// Summary of the time spent paused outside of the run windows, for the job report
+type RunScheduleReport struct {
+	Windows   []string
+	TimeZone  string
+	Pauses    int
+	PausedFor string
+}
+
+func (s *RunSchedule) Report() RunScheduleReport {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	report := RunScheduleReport{
+		TimeZone:  s.Location.String(),
+		Pauses:    s.pauses,
+		PausedFor: s.pausedFor.String(),
+	}
+	for _, window := range s.Windows {
+		report.Windows = append(report.Windows, window.String())
+	}
+	return report
+}
+
+// Scans that run a single query stream their results for as long as the scan takes, so can't pause
+// between pages without holding the stream open
+func (e *ExampleApp) checkSchedulable(engine Engine) error {
+	if e.Schedule == nil {
+		return nil
+	}
+	switch engine {
+	case EngineN1ql, EngineSourceQuery, EngineAnalytics:
+		return fmt.Errorf("Run windows can't pause a scan with the %v engine, which streams the results of a single query.  Use views, DCP or n1qlPageSize", engine)
+	}
+	return nil
+}
+
+// Wait for a run window to be open before the next page or query of a scan of the bucket.  Called between
+// queries, so no result stream is held open while paused.  The copy checkpoint is saved first, so that a
+// job stopped while paused resumes from here.
+func (e *ExampleApp) waitForRunWindow(bucket *gocb.Bucket) error {
+	if e.Schedule == nil || e.Schedule.Open(time.Now()) {
+		return nil
+	}
+	if e.copyCheckpoints.tracks(bucket) {
+		if err := e.copyCheckpoints.save(true); err != nil {
+			return err
+		}
+	}
+	e.Schedule.Wait(e.logf)
+	return nil
+}
@@ -0,0 +1,127 @@
+package gocbexample
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRunWindow(t *testing.T) {
+
+	window, err := ParseRunWindow(" 22:00 - 06:30 ")
+	if err != nil {
+		t.Fatalf("ParseRunWindow failed: %v", err)
+	}
+	if window.Start != 22*60 || window.End != 6*60+30 {
+		t.Errorf("ParseRunWindow = %+v, want minutes 1320 to 390", window)
+	}
+	if window.String() != "22:00-06:30" {
+		t.Errorf("String() = %v, want 22:00-06:30", window.String())
+	}
+
+	for _, invalid := range []string{"22:00", "22:00-22:00", "24:00-06:00", "10pm-6am", "01:00-02:00-03:00"} {
+		if _, err := ParseRunWindow(invalid); err == nil {
+			t.Errorf("ParseRunWindow(%q) succeeded, want an error", invalid)
+		}
+	}
+}
+
+// Walks two days a minute at a time, checking Open against the windows and NextOpen against the first
+// open minute that follows
+func TestRunScheduleOpen(t *testing.T) {
+
+	schedule, err := ParseRunSchedule([]string{"22:00-06:00", "12:00-13:00"}, "UTC")
+	if err != nil {
+		t.Fatalf("ParseRunSchedule failed: %v", err)
+	}
+
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	const minutes = 2 * 24 * 60
+	open := make([]bool, minutes)
+	for i := range open {
+		minute := i % (24 * 60)
+		open[i] = minute >= 22*60 || minute < 6*60 || (minute >= 12*60 && minute < 13*60)
+	}
+
+	for i := 0; i < 24*60; i++ {
+		at := start.Add(time.Duration(i)*time.Minute + 30*time.Second)
+		if got := schedule.Open(at); got != open[i] {
+			t.Fatalf("Open(%v) = %v, want %v", at, got, open[i])
+		}
+
+		want := at
+		if !open[i] {
+			next := i + 1
+			for !open[next] {
+				next++
+			}
+			want = start.Add(time.Duration(next) * time.Minute)
+		}
+		if got := schedule.NextOpen(at); !got.Equal(want) {
+			t.Fatalf("NextOpen(%v) = %v, want %v", at, got, want)
+		}
+	}
+}
+
+// The windows are in the schedule's time zone, whatever the zone of the time checked
+func TestRunScheduleTimeZone(t *testing.T) {
+
+	schedule, err := ParseRunSchedule([]string{"22:00-06:00"}, "Asia/Tokyo")
+	if err != nil {
+		t.Fatalf("ParseRunSchedule failed: %v", err)
+	}
+
+	// 22:30 in Tokyo
+	if at := time.Date(2024, 3, 1, 13, 30, 0, 0, time.UTC); !schedule.Open(at) {
+		t.Errorf("Open(%v) = false, want true", at)
+	}
+
+	// 09:00 in Tokyo, so the window opens 13 hours later
+	at := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	if next := schedule.NextOpen(at); !next.Equal(at.Add(13 * time.Hour)) {
+		t.Errorf("NextOpen(%v) = %v, want %v", at, next, at.Add(13*time.Hour))
+	}
+
+	if _, err := ParseRunSchedule([]string{"22:00-06:00"}, "Mars/Olympus_Mons"); err == nil {
+		t.Errorf("ParseRunSchedule with an unknown time zone succeeded, want an error")
+	}
+	if schedule, err := ParseRunSchedule(nil, "Asia/Tokyo"); schedule != nil || err != nil {
+		t.Errorf("ParseRunSchedule of no windows = %v, %v, want no schedule", schedule, err)
+	}
+}
+
+// Scans of a single query can't pause between pages
+func TestCheckSchedulable(t *testing.T) {
+
+	schedule, err := ParseRunSchedule([]string{"22:00-06:00"}, "")
+	if err != nil {
+		t.Fatalf("ParseRunSchedule failed: %v", err)
+	}
+	paged := map[Engine]bool{EngineViews: true, EngineN1qlPaged: true, EngineDCP: true}
+	for _, engine := range []Engine{EngineViews, EngineN1qlPaged, EngineDCP, EngineN1ql, EngineSourceQuery, EngineAnalytics} {
+		if err := (&ExampleApp{Schedule: schedule}).checkSchedulable(engine); (err == nil) != paged[engine] {
+			t.Errorf("checkSchedulable(%v) = %v, want an error: %v", engine, err, !paged[engine])
+		}
+		if err := (&ExampleApp{}).checkSchedulable(engine); err != nil {
+			t.Errorf("checkSchedulable(%v) without a schedule = %v, want no error", engine, err)
+		}
+	}
+}
+
+// On the days the clocks change, a window opens when the wall clock reaches its start, not a fixed number of
+// hours after midnight
+func TestRunScheduleNextOpenClockChanges(t *testing.T) {
+
+	schedule, err := ParseRunSchedule([]string{"22:00-06:00"}, "Europe/Paris")
+	if err != nil {
+		t.Fatalf("ParseRunSchedule failed: %v", err)
+	}
+	paris := schedule.Location
+
+	// Summer time starts at 02:00 on 31 March 2024, and ends at 03:00 on 27 October
+	for _, day := range []time.Time{time.Date(2024, 3, 31, 12, 0, 0, 0, paris), time.Date(2024, 10, 27, 12, 0, 0, 0, paris)} {
+		want := time.Date(day.Year(), day.Month(), day.Day(), 22, 0, 0, 0, paris)
+		if next := schedule.NextOpen(day); !next.Equal(want) {
+			t.Errorf("NextOpen(%v) = %v, want %v", day, next, want)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package gocbexample
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"unicode"
+)
+
+// Docs without a type field are grouped under this type
+const untypedSchemaType = "(untyped)"
+
+// The schema inferred for each doc type
+type InferredSchema struct {
+	TypeField string
+	Types     []TypeSchema
+}
+
+// The fields seen in the sampled docs of a type
+type TypeSchema struct {
+	Type string
+
+	// Docs sampled, out of the docs of this type seen
+	Sampled int
+	Seen    int
+
+	Fields []FieldSchema
+
+	// The same, as a JSON Schema (draft-07) document
+	JSONSchema map[string]interface{}
+}
+
+// A field path, eg "$.geo.lat" or "$.schedule[*].day"
+type FieldSchema struct {
+	Path string
+
+	// JSON types seen: string, integer, number, boolean, object, array or null
+	Types []string
+
+	// Number of sampled docs the path appeared in, and whether it was missing from any object that
+	// could have held it
+	Present  int
+	Optional bool
+
+	// The shape of a value seen, with letters and digits masked, eg "XXX-999" for "SFO-123"
+	Example string `json:",omitempty"`
+}
+
+// Inferred structure of the values seen at one place in the docs
+type schemaNode struct {
+	types   map[string]bool
+	count   int
+	docs    int
+	example string
+
+	// Times the value was an object, its properties, and the elements when it was an array
+	objects    int
+	properties map[string]*schemaNode
+	items      *schemaNode
+
+	lastDoc int
+}
+
+func newSchemaNode() *schemaNode {
+	return &schemaNode{types: map[string]bool{}, properties: map[string]*schemaNode{}}
+}
+
+type typeSample struct {
+	root    *schemaNode
+	sampled int
+	seen    int
+}
+
+// Infers the schema of each doc type from a sample of its docs.  Process is a DocProcessor.
+type SchemaInferrer struct {
+	typeField      string
+	samplesPerType int
+
+	mutex sync.Mutex
+	types map[string]*typeSample
+}
+
+// typeField defaults to "type".  samplesPerType of 0 samples every doc.
+func NewSchemaInferrer(typeField string, samplesPerType int) *SchemaInferrer {
+	if typeField == "" {
+		typeField = defaultTypeField
+	}
+	return &SchemaInferrer{
+		typeField:      typeField,
+		samplesPerType: samplesPerType,
+		types:          map[string]*typeSample{},
+	}
+}
+
+func (s *SchemaInferrer) Process(docIds []string, docs []interface{}) error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, doc := range docs {
+		docType := schemaDocType(doc, s.typeField)
+		sample := s.types[docType]
+		if sample == nil {
+			sample = &typeSample{root: newSchemaNode()}
+			s.types[docType] = sample
+		}
+		sample.seen += 1
+		if s.samplesPerType > 0 && sample.sampled >= s.samplesPerType {
+			continue
+		}
+		sample.sampled += 1
+		sample.root.observe(doc, sample.sampled)
+	}
+	return nil
+}
+
+// The type of a doc, or untypedSchemaType if it has no string type field
+func schemaDocType(doc interface{}, typeField string) string {
+	if docMap, ok := doc.(map[string]interface{}); ok {
+		if typeVal, ok := docMap[typeField].(string); ok {
+			return typeVal
+		}
+	}
+	return untypedSchemaType
+}
+
+// Merge a value into the node.  docNum identifies the doc, so that a path is counted once per doc.
+func (n *schemaNode) observe(val interface{}, docNum int) {
+
+	n.count += 1
+	if n.lastDoc != docNum {
+		n.lastDoc = docNum
+		n.docs += 1
+	}
+	n.types[jsonSchemaType(val)] = true
+
+	switch v := val.(type) {
+	case map[string]interface{}:
+		n.objects += 1
+		for key, child := range v {
+			childNode := n.properties[key]
+			if childNode == nil {
+				childNode = newSchemaNode()
+				n.properties[key] = childNode
+			}
+			childNode.observe(child, docNum)
+		}
+	case []interface{}:
+		if n.items == nil {
+			n.items = newSchemaNode()
+		}
+		for _, child := range v {
+			n.items.observe(child, docNum)
+		}
+	default:
+		if n.example == "" && val != nil {
+			n.example = redactExample(val)
+		}
+	}
+}
+
+func jsonSchemaType(val interface{}) string {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case bool:
+		return "boolean"
+	default:
+		return "null"
+	}
+}
+
+// The shape of a value with its content masked: letters become X or x and digits 9
+func redactExample(val interface{}) string {
+	var raw string
+	switch v := val.(type) {
+	case string:
+		raw = v
+	case float64:
+		raw = fmt.Sprintf("%v", v)
+	case bool:
+		return "<boolean>"
+	}
+	runes := []rune(raw)
+	for i, r := range runes {
+		switch {
+		case unicode.IsUpper(r):
+			runes[i] = 'X'
+		case unicode.IsLetter(r):
+			runes[i] = 'x'
+		case unicode.IsDigit(r):
+			runes[i] = '9'
+		}
+	}
+	return string(runes)
+}
+
+func (s *SchemaInferrer) Schema() InferredSchema {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	schema := InferredSchema{TypeField: s.typeField}
+	for docType, sample := range s.types {
+		typeSchema := TypeSchema{
+			Type:       docType,
+			Sampled:    sample.sampled,
+			Seen:       sample.seen,
+			JSONSchema: sample.root.jsonSchema(),
+		}
+		typeSchema.JSONSchema["$schema"] = "http://json-schema.org/draft-07/schema#"
+		typeSchema.JSONSchema["title"] = docType
+		sample.root.fields("$", &typeSchema.Fields)
+		schema.Types = append(schema.Types, typeSchema)
+	}
+	sort.Slice(schema.Types, func(i, j int) bool {
+		return schema.Types[i].Type < schema.Types[j].Type
+	})
+	return schema
+}
+
+func (n *schemaNode) sortedTypes() []string {
+	types := make([]string, 0, len(n.types))
+	for t := range n.types {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+func (n *schemaNode) sortedProperties() []string {
+	keys := make([]string, 0, len(n.properties))
+	for key := range n.properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Flatten the node's descendants into fields, sorted by path
+func (n *schemaNode) fields(path string, fields *[]FieldSchema) {
+	for _, key := range n.sortedProperties() {
+		child := n.properties[key]
+		childPath := path + "." + key
+		*fields = append(*fields, FieldSchema{
+			Path:     childPath,
+			Types:    child.sortedTypes(),
+			Present:  child.docs,
+			Optional: child.count < n.objects,
+			Example:  child.example,
+		})
+		child.fields(childPath, fields)
+	}
+	if n.items != nil {
+		n.items.fields(path+"[*]", fields)
+	}
+}
+
+// The node as a JSON Schema
+func (n *schemaNode) jsonSchema() map[string]interface{} {
+
+	schema := map[string]interface{}{}
+	types := []string{}
+	for _, t := range n.sortedTypes() {
+		// integer is a subset of number
+		if t == "integer" && n.types["number"] {
+			continue
+		}
+		types = append(types, t)
+	}
+	if len(types) == 1 {
+		schema["type"] = types[0]
+	} else {
+		schema["type"] = types
+	}
+
+	if len(n.properties) > 0 {
+		properties := map[string]interface{}{}
+		required := []string{}
+		for _, key := range n.sortedProperties() {
+			child := n.properties[key]
+			properties[key] = child.jsonSchema()
+			if child.count == n.objects {
+				required = append(required, key)
+			}
+		}
+		schema["properties"] = properties
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+	}
+	if n.items != nil {
+		schema["items"] = n.items.jsonSchema()
+	}
+	return schema
+}
+
+// Sample the docs of the source bucket and infer the schema of each doc type
+func (e *ExampleApp) InferSchema(inferrer *SchemaInferrer) (schema InferredSchema, err error) {
+
+	e.startPhase("infer-schema")
+	if err := e.Walk(SourceBucketRole, WalkOptions{}, e.countProgress(inferrer.Process)); err != nil {
+		return schema, err
+	}
+
+	schema = inferrer.Schema()
+	e.logf("Inferred the schema of %v doc types", len(schema.Types))
+
+	return schema, nil
+}
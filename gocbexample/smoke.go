@@ -0,0 +1,163 @@
+package gocbexample
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// Placeholder in smoke queries and the source query that is replaced by the name of the bucket queried.  Quoted as
+// `{bucket}`, it's replaced by the path of the collection being copied, eg `travel-sample`.`inventory`.`hotel`
+const queryBucketPlaceholder = "{bucket}"
+
+// A N1QL assertion checked against the target bucket once a copy has finished.  Set ExpectedRows,
+// ExpectedValue or SourceQuery.
+type SmokeQuery struct {
+	Name string `json:"name"`
+
+	// Run against the target bucket, eg "SELECT COUNT(*) FROM `{bucket}` WHERE type = 'airline'"
+	Query string `json:"query"`
+
+	// The number of rows the query should return
+	ExpectedRows *int `json:"expectedRows,omitempty"`
+
+	// The value the query should return.  A row with a single field is compared by the value of that field
+	ExpectedValue interface{} `json:"expectedValue,omitempty"`
+
+	// Expect the value this query returns when run against the source bucket, eg the same count query
+	SourceQuery string `json:"sourceQuery,omitempty"`
+}
+
+// The outcome of a smoke query
+type SmokeQueryResult struct {
+	Name     string
+	Passed   bool
+	Rows     int
+	Value    interface{} `json:",omitempty"`
+	Expected interface{} `json:",omitempty"`
+	Error    string      `json:",omitempty"`
+}
+
+// Run the smoke queries against the target bucket.  Returns an error naming the queries that failed.
+func (e *ExampleApp) RunSmokeQueries(queries []SmokeQuery) (results []SmokeQueryResult, err error) {
+
+	var failed []string
+	for i, query := range queries {
+		if query.Name == "" {
+			query.Name = fmt.Sprintf("smokeQueries[%v]", i)
+		}
+		result := e.runSmokeQuery(query)
+		if result.Passed {
+			e.logf("Smoke query %v passed", result.Name)
+		} else {
+			e.logf("Smoke query %v failed: %v", result.Name, result.Error)
+			failed = append(failed, result.Name)
+		}
+		results = append(results, result)
+	}
+
+	if len(failed) > 0 {
+		return results, fmt.Errorf("%v of %v smoke queries failed: %v", len(failed), len(queries), strings.Join(failed, ", "))
+	}
+	return results, nil
+}
+
+func (e *ExampleApp) runSmokeQuery(query SmokeQuery) SmokeQueryResult {
+
+	result := SmokeQueryResult{Name: query.Name}
+
+	rows, err := e.querySmokeRows(e.TargetBucket, query.Query)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Rows = len(rows)
+	result.Value = smokeQueryValue(rows)
+
+	switch {
+	case query.ExpectedRows != nil:
+		result.Expected = *query.ExpectedRows
+		if result.Rows != *query.ExpectedRows {
+			result.Error = fmt.Sprintf("Expected %v rows, got %v", *query.ExpectedRows, result.Rows)
+			return result
+		}
+	case query.SourceQuery != "":
+		sourceRows, err := e.querySmokeRows(e.SourceBucket, query.SourceQuery)
+		if err != nil {
+			result.Error = fmt.Sprintf("Error running source query.  Err: %v", err)
+			return result
+		}
+		result.Expected = smokeQueryValue(sourceRows)
+	case query.ExpectedValue != nil:
+		result.Expected = query.ExpectedValue
+	default:
+		result.Error = "Smoke query has no expectedRows, expectedValue or sourceQuery"
+		return result
+	}
+
+	if query.ExpectedRows == nil && !reflect.DeepEqual(result.Value, result.Expected) {
+		result.Error = fmt.Sprintf("Expected %v, got %v", result.Expected, result.Value)
+		return result
+	}
+
+	result.Passed = true
+	return result
+}
+
+// Run a query against bucket and read all of its rows
+func (e *ExampleApp) querySmokeRows(bucket *gocb.Bucket, statement string) ([]interface{}, error) {
+
+	statement = e.replaceQueryBucket(statement, bucket)
+	results, err := e.executeN1qlQuery(bucket, statement, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := []interface{}{}
+	var row interface{}
+	for results.Next(&row) {
+		rows = append(rows, row)
+		row = nil
+	}
+	if err := results.Close(); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// The value of a query result: the first row, or the value of its field if it has a single field (eg a count)
+func smokeQueryValue(rows []interface{}) interface{} {
+	if len(rows) == 0 {
+		return nil
+	}
+	if row, ok := rows[0].(map[string]interface{}); ok && len(row) == 1 {
+		for _, val := range row {
+			return val
+		}
+	}
+	return rows[0]
+}
+
+// The final phase of a command that wrote to the target bucket: wait for the target indexes to catch up
+// if configured, compare the source and target bucket stats, then run the smoke queries
+func (j *Job) CheckTarget() error {
+	if j.Config.WaitForTargetIndexes {
+		if err := j.App.WaitForTargetIndexes(); err != nil {
+			return err
+		}
+	}
+	j.CompareBucketStats()
+	return j.RunSmokeQueries()
+}
+
+// Run the configured smoke queries, adding their results to the report
+func (j *Job) RunSmokeQueries() error {
+	if len(j.Config.SmokeQueries) == 0 {
+		return nil
+	}
+	results, err := j.App.RunSmokeQueries(j.Config.SmokeQueries)
+	j.AddResult("smokeQueries", results)
+	return err
+}
@@ -0,0 +1,59 @@
+package gocbexample
+
+import (
+	"fmt"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// Fields a custom source query has to project
+const (
+	sourceQueryIdField  = "id"
+	sourceQueryDocField = "doc"
+)
+
+// Loop over the rows of the custom SourceQuery rather than a table scan of the bucket.  Each row is copied
+// as a doc, so the query can join, UNNEST or filter its way to the docs to copy, as long as it projects the
+// doc id as id and the doc body as doc, eg:
+//
+//	SELECT META(r).id AS id, OBJECT_CONCAT(r, {"airline": a.name}) AS doc
+//	FROM `{bucket}` r JOIN `{bucket}` a ON KEYS r.airlineid WHERE r.type = "route"
+func (e *ExampleApp) ForEachDocIdSourceQuery(docProcessor DocProcessor, bucket *gocb.Bucket) (err error) {
+
+	e.logf("Performing operation over source query on bucket: %v", e.keyspaceName(bucket))
+	defer e.logf("Finished operation over source query on bucket: %v", e.keyspaceName(bucket))
+
+	statement := e.replaceQueryBucket(e.SourceQuery, bucket)
+	rows, err := e.executeN1qlQuery(bucket, statement, nil)
+	if err != nil {
+		return newDocError(PhaseSourceRead, "", fmt.Errorf("Error running source query: %v.  Err: %v", statement, err))
+	}
+	defer rows.Close()
+
+	for {
+		row := map[string]interface{}{}
+		if !rows.Next(&row) {
+			break
+		}
+
+		docId, ok := row[sourceQueryIdField].(string)
+		if !ok {
+			return fmt.Errorf("Source query row has no string %v field.  The query must project the doc id AS %v.  Row: %+v",
+				sourceQueryIdField, sourceQueryIdField, row)
+		}
+		doc, ok := row[sourceQueryDocField]
+		if !ok {
+			return fmt.Errorf("Source query row for doc id %v has no %v field.  The query must project the doc body AS %v",
+				docId, sourceQueryDocField, sourceQueryDocField)
+		}
+
+		if docProcessor != nil {
+			if err := docProcessor([]string{docId}, []interface{}{doc}); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Surface any errors that were reported after the results
+	return newDocError(PhaseSourceRead, "", rows.Close())
+}
@@ -0,0 +1,179 @@
+package gocbexample
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// Which copied docs to spot check.  Docs in DocIds are always checked, plus Count random copied docs
+// whose id matches KeyPattern and whose type is one of Types (either can be left empty to match any doc)
+type SpotCheckConfig struct {
+	Count      int      `json:"count"`
+	KeyPattern string   `json:"keyPattern,omitempty"`
+	Types      []string `json:"types,omitempty"`
+	DocIds     []string `json:"docIds,omitempty"`
+}
+
+// Random copied docs spot checked when the config has no spotChecks section
+const defaultSpotChecks = 3
+
+// What a spot check read back from a copied doc
+type SpotCheckResult struct {
+	DocId string `json:"docId"`
+
+	// The type field after the copy, and after the namespace was added
+	Type      interface{} `json:"type,omitempty"`
+	TypeAfter interface{} `json:"typeAfter,omitempty"`
+
+	// The Metadata XATTR stamped by the copy
+	Xattr interface{} `json:"xattr,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// A uniform random sample of up to size of the doc ids passed to add (reservoir sampling), so the sample
+// can be taken while docs are copied, without knowing how many there will be.  Only docs matching the key
+// pattern and types are sampled
+type docIdSampler struct {
+	size       int
+	keyPattern *regexp.Regexp
+	types      map[string]bool
+
+	mutex sync.Mutex
+	seen  int
+	ids   []string
+	rng   *rand.Rand
+}
+
+func newDocIdSampler(config SpotCheckConfig) (*docIdSampler, error) {
+	sampler := &docIdSampler{
+		size: config.Count,
+		rng:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	if config.KeyPattern != "" {
+		keyPattern, err := regexp.Compile(config.KeyPattern)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid spotChecks keyPattern: %v.  Err: %v", config.KeyPattern, err)
+		}
+		sampler.keyPattern = keyPattern
+	}
+	if len(config.Types) > 0 {
+		sampler.types = map[string]bool{}
+		for _, docType := range config.Types {
+			sampler.types[docType] = true
+		}
+	}
+	return sampler, nil
+}
+
+func (s *docIdSampler) matches(docId string, doc interface{}) bool {
+	if s.keyPattern != nil && !s.keyPattern.MatchString(docId) {
+		return false
+	}
+	if s.types != nil {
+		body, ok := doc.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		docType, _ := body[defaultTypeField].(string)
+		return s.types[docType]
+	}
+	return true
+}
+
+func (s *docIdSampler) add(docIds []string, docs []interface{}) {
+	if s.size == 0 {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i, docId := range docIds {
+		if !s.matches(docId, docs[i]) {
+			continue
+		}
+		s.seen++
+		if len(s.ids) < s.size {
+			s.ids = append(s.ids, docId)
+		} else if i := s.rng.Intn(s.seen); i < s.size {
+			s.ids[i] = docId
+		}
+	}
+}
+
+func (s *docIdSampler) sample() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string{}, s.ids...)
+}
+
+// Pick the docs to spot check once the next copy has finished: the given doc ids, plus random docs out
+// of the matching docs the copy writes
+func (e *ExampleApp) SampleCopiedDocs(config SpotCheckConfig) error {
+	sampler, err := newDocIdSampler(config)
+	if err != nil {
+		return err
+	}
+	e.copiedDocSampler = sampler
+	e.spotCheckDocIds = config.DocIds
+	return nil
+}
+
+// The docs picked by SampleCopiedDocs
+func (e *ExampleApp) SampledCopiedDocs() []string {
+	if e.copiedDocSampler == nil {
+		return nil
+	}
+	return append(append([]string{}, e.spotCheckDocIds...), e.copiedDocSampler.sample()...)
+}
+
+// Read back the type field and Metadata XATTR of copied docs, in a single lookup per doc.  A doc that
+// can't be read is reported with the error rather than failing the job
+func (e *ExampleApp) SpotCheck(docIds []string) []SpotCheckResult {
+
+	withXattr := e.SupportsXattrs(e.TargetBucketSpec.Name)
+
+	results := make([]SpotCheckResult, len(docIds))
+	for i, docId := range docIds {
+
+		result := SpotCheckResult{DocId: docId}
+
+		specs := []gocb.LookupInSpec{gocb.GetSpec("type", nil)}
+		if withXattr {
+			specs = append(specs, gocb.GetSpec(e.MetadataXattrKey, &gocb.GetSpecOptions{IsXattr: true}))
+		}
+		lookup, err := e.collection(e.TargetBucket).LookupIn(docId, specs, nil)
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			lookup.ContentAt(0, &result.Type)
+			if withXattr {
+				lookup.ContentAt(1, &result.Xattr)
+			}
+		}
+
+		e.logf("Spot check of doc %v: type: %+v, %v XATTR: %+v %v", docId, result.Type, e.MetadataXattrKey, result.Xattr, result.Error)
+		results[i] = result
+	}
+	return results
+}
+
+// Read back the type field of spot checked docs again, eg after it was namespaced
+func (e *ExampleApp) SpotCheckTypesAfter(results []SpotCheckResult) {
+	for i := range results {
+		if results[i].Error != "" {
+			continue
+		}
+		typeAfter, err := e.GetSubdocField(results[i].DocId, "type")
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		results[i].TypeAfter = typeAfter
+		e.logf("Spot check of doc %v: type (before): %+v, type (after): %+v", results[i].DocId, results[i].Type, typeAfter)
+	}
+}
@@ -0,0 +1,62 @@
+package gocbexample
+
+import (
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// How a scan that hits a startup race is retried: about a minute in all, which covers the first seconds
+// after a bucket, design doc or index is created
+var defaultStartupRetryPolicy = RetryPolicy{MaxAttempts: 8, InitialBackoff: time.Second, MaxBackoff: 15 * time.Second}
+
+// Errors a freshly created bucket returns until its design docs and indexes are ready, eg
+// "not_found - missing" from a view or "No index available on keyspace" from N1QL
+var startupRaceErrors = []string{
+	"not_found",
+	"view not found",
+	"design document not found",
+	"no index available",
+	"index not found",
+	"not ready",
+	"not online",
+}
+
+// Is err one of the errors a bucket returns until its views and indexes are ready?
+func isStartupRaceError(err error) bool {
+	if err == nil {
+		return false
+	}
+	message := strings.ToLower(err.Error())
+	for _, raceError := range startupRaceErrors {
+		if strings.Contains(message, raceError) {
+			return true
+		}
+	}
+	return false
+}
+
+// Scan the bucket, retrying the whole scan with the StartupRetryPolicy if it fails with a startup race
+// before any docs were processed.  Once docs have been processed the error is returned as is, since it
+// can't be a startup race and retrying would process them again.
+func (e *ExampleApp) retryStartupRaces(bucket *gocb.Bucket, docProcessor DocProcessor, scan func(docProcessor DocProcessor, bucket *gocb.Bucket) error) error {
+
+	var processed int32
+	trackingDocProcessor := docProcessor
+	if docProcessor != nil {
+		trackingDocProcessor = func(docIds []string, docs []interface{}) error {
+			atomic.StoreInt32(&processed, 1)
+			return docProcessor(docIds, docs)
+		}
+	}
+
+	retryable := func(err error) bool {
+		return atomic.LoadInt32(&processed) == 0 && isStartupRaceError(err)
+	}
+	_, err := e.StartupRetryPolicy.do(e.logf, "scan of bucket "+bucket.Name()+", which isn't ready yet,", retryable, func() error {
+		return scan(trackingDocProcessor, bucket)
+	})
+	return err
+}
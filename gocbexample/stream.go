@@ -0,0 +1,46 @@
+package gocbexample
+
+import (
+	"errors"
+)
+
+// The outcome of copying a batch of docs, sent by CopyBucketStream
+type BatchResult struct {
+	BatchId string
+
+	// Ids of the docs written to the sink, after the transforms (which may have renamed or dropped docs)
+	DocIds []string
+
+	// Why the batch failed, or nil.  Docs written before a post-insert failure are still listed in DocIds.
+	// A result with an empty BatchId reports a failure outside of any batch, eg of the source scan
+	Err error
+}
+
+// Copy the source to the sink like CopyBucketWithCallback, streaming the outcome of each batch on the
+// returned channel, which is closed once the copy has finished.  The channel must be drained, since the
+// copy waits for each result to be received.  The last result carries the error the copy stopped with,
+// if it didn't come from a batch.
+func (e *ExampleApp) CopyBucketStream(preInsertCallback DocProcessorReturnDocs, postInsertCallback DocProcessor) <-chan BatchResult {
+
+	results := make(chan BatchResult, e.Workers)
+
+	go func() {
+		defer close(results)
+
+		err := e.copyBucket(copyCallbacks{
+			preInsert:  preInsertCallback,
+			postInsert: postInsertCallback,
+			onBatch: func(result BatchResult) {
+				results <- result
+			},
+		})
+
+		// Batch failures have already been sent with their batch
+		var docErr *DocError
+		if err != nil && !(errors.As(err, &docErr) && docErr.BatchId != "") {
+			results <- BatchResult{Err: err}
+		}
+	}()
+
+	return results
+}
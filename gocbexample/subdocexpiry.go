@@ -0,0 +1,73 @@
+package gocbexample
+
+import (
+	"errors"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// How many times a subdoc mutation is retried when the doc changes between reading its expiry and mutating it
+const maxKeepExpiryAttempts = 3
+
+// Run a subdoc mutation of a doc, keeping its expiry.  Mutations set the expiry they're passed, so passing 0
+// clears the TTL on servers that don't preserve it.  The expiry is read from the $document virtual XATTR along
+// with the CAS, and the mutation made with that CAS so that a touch in between can't be undone.  If cas is set, the
+// mutation is made with it instead, failing if the doc has changed since.  On servers without XATTRs the expiry
+// can't be read, so the mutation is made with expiry 0.
+func (e *ExampleApp) mutateInKeepingExpiry(bucket *gocb.Bucket, bucketName, docId string, docFlags gocb.SubdocDocFlag, cas gocb.Cas,
+	specs []gocb.MutateInSpec) (err error) {
+
+	collection, err := e.writeCollection(bucket, "Subdoc mutation")
+	if err != nil {
+		return err
+	}
+	if !e.SupportsXattrs(bucketName) || docFlags&gocb.SubdocDocFlagAccessDeleted != 0 {
+		// Tombstones have no expiry to keep
+		_, err = collection.MutateIn(docId, specs, mutateInOptions(docFlags, cas, 0))
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		meta, err := lookupDocMeta(collection, docId, nil)
+		if err != nil {
+			return err
+		}
+		mutationCas := meta.Cas
+		if cas != 0 {
+			mutationCas = cas
+		}
+		_, err = collection.MutateIn(docId, specs, mutateInOptions(docFlags, mutationCas, meta.Expiry))
+		if err == nil || cas != 0 || attempt >= maxKeepExpiryAttempts || !errors.Is(wrapGocbError(err), ErrDocExists) {
+			return err
+		}
+		e.logf("Doc %v changed while it was being mutated, retrying", docId)
+	}
+}
+
+// Options of a subdoc mutation with the doc flags, CAS and expiry, given as the Unix time the doc expires at
+func mutateInOptions(docFlags gocb.SubdocDocFlag, cas gocb.Cas, expiry uint32) *gocb.MutateInOptions {
+	opts := &gocb.MutateInOptions{Cas: cas, Expiry: expiryDuration(expiry)}
+	// gocb only takes access deleted through its internal doc flags
+	opts.Internal.DocFlags = docFlags
+	return opts
+}
+
+// Options of a subdoc lookup with the doc flags
+func lookupInOptions(docFlags gocb.SubdocDocFlag) *gocb.LookupInOptions {
+	opts := &gocb.LookupInOptions{}
+	opts.Internal.DocFlags = docFlags
+	return opts
+}
+
+// The duration gocb takes for a doc to expire at the Unix time expiry, which it sends back as that time.  0 if the
+// doc doesn't expire.  A doc that has already expired is given the shortest expiry rather than none.
+func expiryDuration(expiry uint32) time.Duration {
+	if expiry == 0 {
+		return 0
+	}
+	if duration := time.Until(time.Unix(int64(expiry), 0)); duration > time.Second {
+		return duration
+	}
+	return time.Second
+}
@@ -0,0 +1,64 @@
+package gocbexample
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Run up to this many subdoc mutations of the post-insert phases (XATTR stamping, type namespacing) at once
+func WithSubdocConcurrency(concurrency int) Option {
+	return func(e *ExampleApp) error {
+		if concurrency < 1 {
+			return fmt.Errorf("Invalid subdoc concurrency: %v.  Must be at least 1", concurrency)
+		}
+		e.SubdocConcurrency = concurrency
+		return nil
+	}
+}
+
+// Call fn for each of the count items on a pool of concurrency goroutines.  gocb pipelines the requests of
+// concurrent goroutines over its connections, so per-doc subdoc ops run concurrently don't each pay a round
+// trip.  Items not started yet are skipped once one fails.  Returns the first error.
+func forEachConcurrently(concurrency, count int, fn func(i int) error) error {
+
+	if concurrency > count {
+		concurrency = count
+	}
+
+	items := make(chan int)
+	var errMutex sync.Mutex
+	var firstErr error
+	failed := func() bool {
+		errMutex.Lock()
+		defer errMutex.Unlock()
+		return firstErr != nil
+	}
+
+	wg := sync.WaitGroup{}
+	for worker := 0; worker < concurrency; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range items {
+				if failed() {
+					continue
+				}
+				if err := fn(i); err != nil {
+					errMutex.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					errMutex.Unlock()
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < count; i++ {
+		items <- i
+	}
+	close(items)
+	wg.Wait()
+
+	return firstErr
+}
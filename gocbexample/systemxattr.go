@@ -0,0 +1,120 @@
+package gocbexample
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// Couchbase limits XATTR keys to this many bytes
+const maxXattrKeyLength = 16
+
+// System XATTRs are prefixed with an underscore.  They need the system XATTR RBAC privileges to read and write, and
+// unlike user XATTRs are kept on the tombstone when a doc is deleted.
+func isSystemXattr(key string) bool {
+	return strings.HasPrefix(key, "_")
+}
+
+// Check an XATTR key can be written: not empty, within the length limit, and not a virtual XATTR such as $document
+func validateXattrKey(key string) error {
+	switch {
+	case key == "":
+		return fmt.Errorf("An XATTR key is required")
+	case len(key) > maxXattrKeyLength:
+		return fmt.Errorf("Invalid XATTR key: %v.  Must be at most %v bytes", key, maxXattrKeyLength)
+	case strings.HasPrefix(key, "$"):
+		return fmt.Errorf("Invalid XATTR key: %v.  Virtual XATTRs are read-only", key)
+	}
+	return nil
+}
+
+// The subdoc spec options to write an XATTR with.  Writing a system XATTR needs the create path flag, which works for
+// user XATTRs too.
+func xattrUpsertOptions() *gocb.UpsertSpecOptions {
+	return &gocb.UpsertSpecOptions{IsXattr: true, CreatePath: true}
+}
+
+// The subdoc doc flags for reading and writing XATTRs, letting them reach the system XATTRs of tombstones if
+// XattrAccessDeleted is set
+func (e *ExampleApp) xattrDocFlags() gocb.SubdocDocFlag {
+	if e.XattrAccessDeleted {
+		return gocb.SubdocDocFlagAccessDeleted
+	}
+	return gocb.SubdocDocFlagNone
+}
+
+// Stamp copied docs with the given XATTR key rather than Metadata, eg a system XATTR such as "_migration" that
+// apps reading the doc don't see and that stays on the tombstone once the doc is deleted
+func WithMetadataXattrKey(key string) Option {
+	return func(e *ExampleApp) error {
+		if err := validateXattrKey(key); err != nil {
+			return err
+		}
+		e.MetadataXattrKey = key
+		return nil
+	}
+}
+
+// Read and write XATTRs of deleted docs too, via their tombstones, which keep only system XATTRs
+func WithXattrAccessDeleted() Option {
+	return func(e *ExampleApp) error {
+		e.XattrAccessDeleted = true
+		return nil
+	}
+}
+
+// Server-side macros, expanded to the values of the mutation that writes them.  Only expanded when they're the
+// whole value of a path, written as the gocb macro.
+const (
+	macroMutationCas    = "${Mutation.CAS}"
+	macroMutationSeqno  = "${Mutation.seqno}"
+	macroMutationCrc32c = "${Mutation.value_crc32c}"
+)
+
+var mutationMacros = map[string]gocb.MutationMacro{
+	macroMutationCas:    gocb.MutationMacroCAS,
+	macroMutationSeqno:  gocb.MutationMacroSeqNo,
+	macroMutationCrc32c: gocb.MutationMacroValueCRC32c,
+}
+
+func isMutationMacro(val interface{}) bool {
+	macro, ok := val.(string)
+	_, isMacro := mutationMacros[macro]
+	return ok && isMacro
+}
+
+// Add an upsert of an XATTR to the specs of a mutation, expanding the macros among the values of its top-level
+// fields: they're left out of the value, then upserted at their own paths as macros.  (A mutation can only write
+// one XATTR key, but can write several paths within it.)
+func upsertXattrWithMacros(specs []gocb.MutateInSpec, key string, val interface{}) []gocb.MutateInSpec {
+
+	fields, ok := val.(map[string]interface{})
+	if !ok {
+		return append(specs, gocb.UpsertSpec(key, val, xattrUpsertOptions()))
+	}
+
+	plain := make(map[string]interface{}, len(fields))
+	macros := map[string]interface{}{}
+	for field, fieldVal := range fields {
+		if isMutationMacro(fieldVal) {
+			macros[field] = fieldVal
+		} else {
+			plain[field] = fieldVal
+		}
+	}
+	specs = append(specs, gocb.UpsertSpec(key, plain, xattrUpsertOptions()))
+	for field, macro := range macros {
+		specs = append(specs, gocb.UpsertSpec(key+"."+field, mutationMacros[macro.(string)], xattrUpsertOptions()))
+	}
+	return specs
+}
+
+// Stamp copied docs' metadata XATTR with the CAS, seqno and CRC32C of the mutation that writes it, expanded by the
+// server, as well as the client's time
+func WithMetadataMacros() Option {
+	return func(e *ExampleApp) error {
+		e.MetadataMacros = true
+		return nil
+	}
+}
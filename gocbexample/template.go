@@ -0,0 +1,80 @@
+package gocbexample
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// A ${NAME} variable reference in a config file.  $${NAME} is left as a literal ${NAME}
+var configVariablePattern = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// How many templates a config can extend, through templates that extend templates
+const maxConfigExtendsDepth = 10
+
+// The variables every config can use, on top of -var flags and the environment
+func builtinConfigVariables(now time.Time) map[string]string {
+	return map[string]string{
+		"DATE": now.Format("20060102"),
+		"TIME": now.Format("150405"),
+	}
+}
+
+// Replace the ${NAME} references in a config file with -var values, the built-in variables (DATE, TIME)
+// or environment variables, in that order.  Values are JSON string escaped, since variables are used
+// inside JSON strings.  A reference to a variable that isn't set is an error, to catch typos.
+func substituteConfigVariables(path string, configBytes []byte, vars map[string]string, now time.Time) ([]byte, error) {
+
+	substituted, missing := substituteVariables(configBytes, vars, now)
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("Config file %v uses variables that aren't set: %v.  Set them with -var NAME=value or the environment",
+			path, strings.Join(missing, ", "))
+	}
+	return substituted, nil
+}
+
+// Replace the ${NAME} references in JSON with vars, the built-in variables or environment variables, JSON string
+// escaped.  Returns the names of the variables that aren't set, whose references are left as they are.
+func substituteVariables(template []byte, vars map[string]string, now time.Time) (substituted []byte, missing []string) {
+
+	builtins := builtinConfigVariables(now)
+
+	substituted = configVariablePattern.ReplaceAllFunc(template, func(ref []byte) []byte {
+		if strings.HasPrefix(string(ref), "$$") {
+			return ref[1:]
+		}
+		name := configVariablePattern.FindSubmatch(ref)[1]
+
+		val, ok := vars[string(name)]
+		if !ok {
+			val, ok = builtins[string(name)]
+		}
+		if !ok {
+			val, ok = os.LookupEnv(string(name))
+		}
+		if !ok {
+			missing = append(missing, string(name))
+			return ref
+		}
+
+		escaped, _ := json.Marshal(val)
+		return escaped[1 : len(escaped)-1]
+	})
+	return substituted, missing
+}
+
+// Parse -var NAME=value flags
+func parseConfigVariables(assignments []string) (map[string]string, error) {
+	vars := map[string]string{}
+	for _, assignment := range assignments {
+		parts := strings.SplitN(assignment, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("Invalid variable: %v.  Expected NAME=value", assignment)
+		}
+		vars[parts[0]] = parts[1]
+	}
+	return vars, nil
+}
@@ -0,0 +1,107 @@
+package gocbexample
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// A token bucket limiting throughput to a number of bytes per second.  Bursts of up to one
+// second's worth of bytes are allowed.  A single request bigger than that is let through once
+// enough time has passed to "pay" for it.
+type ByteRateLimiter struct {
+	bytesPerSecond int64
+
+	mutex     sync.Mutex
+	available float64
+	lastFill  time.Time
+}
+
+// Create a limiter allowing bytesPerSecond.  Returns nil (no limit) if bytesPerSecond <= 0
+func NewByteRateLimiter(bytesPerSecond int64) *ByteRateLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &ByteRateLimiter{
+		bytesPerSecond: bytesPerSecond,
+		available:      float64(bytesPerSecond),
+		lastFill:       time.Now(),
+	}
+}
+
+// Change the rate, eg to slow down while the cluster is under pressure.  Safe to call on a nil limiter.
+func (l *ByteRateLimiter) SetBytesPerSecond(bytesPerSecond int64) {
+
+	if l == nil {
+		return
+	}
+	if bytesPerSecond < 1 {
+		bytesPerSecond = 1
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.bytesPerSecond = bytesPerSecond
+	if l.available > float64(bytesPerSecond) {
+		l.available = float64(bytesPerSecond)
+	}
+}
+
+// Block until numBytes may be sent.  Safe to call on a nil limiter, which never blocks.
+func (l *ByteRateLimiter) Wait(numBytes int) {
+
+	if l == nil || numBytes <= 0 {
+		return
+	}
+
+	l.mutex.Lock()
+
+	now := time.Now()
+	l.available += now.Sub(l.lastFill).Seconds() * float64(l.bytesPerSecond)
+	if l.available > float64(l.bytesPerSecond) {
+		l.available = float64(l.bytesPerSecond)
+	}
+	l.lastFill = now
+
+	// Go into debt for this request, and sleep until the debt is paid off
+	l.available -= float64(numBytes)
+	var wait time.Duration
+	if l.available < 0 {
+		wait = time.Duration(-l.available / float64(l.bytesPerSecond) * float64(time.Second))
+	}
+
+	l.mutex.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// The approximate size on the wire of a batch of docs
+func docsSize(docIds []string, docs []interface{}) int {
+	size := 0
+	for i, docId := range docIds {
+		size += len(docId)
+		if docBytes, err := json.Marshal(docs[i]); err == nil {
+			size += len(docBytes)
+		}
+	}
+	return size
+}
+
+// Wrap a DocProcessor so that docs are handed to it no faster than the read byte rate limit
+func (e *ExampleApp) throttleReads(docProcessor DocProcessor) DocProcessor {
+	if e.readLimiter == nil || docProcessor == nil {
+		return docProcessor
+	}
+	return func(docIds []string, docs []interface{}) error {
+		e.readLimiter.Wait(docsSize(docIds, docs))
+		return docProcessor(docIds, docs)
+	}
+}
+
+// Set up the read/write byte rate limiters from ReadBytesPerSecond and WriteBytesPerSecond
+func (e *ExampleApp) setupThrottles() {
+	e.readLimiter = NewByteRateLimiter(e.ReadBytesPerSecond)
+	e.writeLimiter = NewByteRateLimiter(e.WriteBytesPerSecond)
+}
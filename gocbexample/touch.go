@@ -0,0 +1,80 @@
+package gocbexample
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// What the touch command changed, or in a dry run would have changed
+type TouchReport struct {
+	DryRun bool `json:"dryRun,omitempty"`
+
+	// When the docs expire, or empty if their expiry was cleared
+	ExpiresAt string `json:"expiresAt,omitempty"`
+
+	// Docs read, docs matching the filter, and matching docs touched
+	DocsScanned int64 `json:"docsScanned"`
+	DocsMatched int64 `json:"docsMatched"`
+	DocsTouched int64 `json:"docsTouched"`
+}
+
+// Set the expiry of the docs of the source bucket matching the filter so that they expire after ttl, eg to make
+// an anonymized dataset expire in 30 days, or clear it if ttl is 0.  The docs aren't otherwise changed.  The
+// touches are paced by the write byte rate limit, counting the size of the touched docs, and a dry run only counts
+// the docs that would be touched.
+func (e *ExampleApp) TouchDocs(filter DocFilter, ttl time.Duration, dryRun bool) (report TouchReport, err error) {
+
+	if ttl < 0 {
+		return report, fmt.Errorf("Invalid TTL: %v.  Must not be negative", ttl)
+	}
+
+	now := time.Now()
+	report.DryRun = dryRun
+	if ttl > 0 {
+		report.ExpiresAt = now.Add(ttl).UTC().Format(time.RFC3339)
+	}
+	var reportMutex sync.Mutex
+
+	touchDocs := func(docIds []string, docs []interface{}, _ []gocb.Cas) error {
+
+		if dryRun {
+			return nil
+		}
+
+		items := make([]gocb.BulkOp, len(docIds))
+		for i, docId := range docIds {
+			// gocb sends ttls longer than the 30 days Couchbase treats as relative as the Unix time they end at
+			items[i] = &gocb.TouchOp{ID: docId, Expiry: ttl}
+		}
+		e.writeLimiter.Wait(docsSize(docIds, docs))
+		if _, err := e.doBulk(e.SourceBucket, items, isTemporaryError); err != nil {
+			return newDocError(PhaseTargetWrite, "", err)
+		}
+
+		var touched int64
+		for _, item := range items {
+			touchItem := item.(*gocb.TouchOp)
+			if touchItem.Err == nil {
+				touched++
+				continue
+			}
+			if errors.Is(wrapGocbError(touchItem.Err), ErrDocNotFound) {
+				e.logf("Doc %v was deleted since it was read, skipping", touchItem.ID)
+				continue
+			}
+			return newDocError(PhaseTargetWrite, touchItem.ID, touchItem.Err)
+		}
+
+		reportMutex.Lock()
+		defer reportMutex.Unlock()
+		report.DocsTouched += touched
+		return nil
+	}
+
+	report.DocsScanned, report.DocsMatched, err = e.forEachMatchingDoc("touch", filter, touchDocs)
+	return report, err
+}
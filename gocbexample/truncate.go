@@ -0,0 +1,184 @@
+package gocbexample
+
+import (
+	"fmt"
+	"sync"
+	"unicode/utf8"
+)
+
+// Suffix of the sibling field that records the original length of a truncated value
+const defaultLengthFieldSuffix = "_originalLength"
+
+// Limits on the size of string and array values.  Zero disables a limit.
+type TruncationConfig struct {
+
+	// Strings longer than this many characters are cut down to it
+	MaxStringLength int `json:"maxStringLength,omitempty"`
+
+	// Arrays with more elements than this keep only the first MaxArrayLength
+	MaxArrayLength int `json:"maxArrayLength,omitempty"`
+
+	// Only truncate the values matched by these JSONPaths.  Defaults to every value
+	Paths []string `json:"paths,omitempty"`
+
+	// The original length of a truncated object field is recorded in a sibling field with this suffix,
+	// eg schedule -> schedule_originalLength.  Defaults to "_originalLength"
+	LengthFieldSuffix string `json:"lengthFieldSuffix,omitempty"`
+}
+
+// A pre-insert stage that truncates oversized string and array values, eg to keep docs small
+// on dev clusters with less RAM
+type Truncator struct {
+	config TruncationConfig
+	paths  []JSONPath
+
+	mutex     sync.Mutex
+	docs      int
+	truncated int
+	pathHits  []bool
+}
+
+// Summary of what a Truncator did
+type TruncationReport struct {
+	// Docs with at least one truncated value
+	Docs int
+
+	// Total values truncated
+	Values int
+}
+
+func NewTruncator(config TruncationConfig) (*Truncator, error) {
+
+	if config.MaxStringLength < 0 || config.MaxArrayLength < 0 {
+		return nil, fmt.Errorf("Invalid truncation limits: %+v", config)
+	}
+	if config.LengthFieldSuffix == "" {
+		config.LengthFieldSuffix = defaultLengthFieldSuffix
+	}
+	paths, err := ParseJSONPaths(config.Paths)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Truncator{config: config, paths: paths, pathHits: make([]bool, len(paths))}, nil
+}
+
+func (t *Truncator) Transform(input DocProcessorInput) (output DocProcessorInput, err error) {
+
+	output = DocProcessorInput{
+		DocIds: input.DocIds,
+		Docs:   make([]interface{}, len(input.Docs)),
+	}
+	docs, truncated := 0, 0
+	pathHits := make([]bool, len(t.paths))
+	for i, doc := range input.Docs {
+		state := &truncateState{pathHits: pathHits}
+		output.Docs[i] = t.truncate(doc, nil, state)
+		if state.truncated > 0 {
+			docs += 1
+			truncated += state.truncated
+		}
+	}
+
+	t.mutex.Lock()
+	t.docs += docs
+	t.truncated += truncated
+	for i, hit := range pathHits {
+		t.pathHits[i] = t.pathHits[i] || hit
+	}
+	t.mutex.Unlock()
+
+	return output, nil
+}
+
+// Progress of truncating a doc
+type truncateState struct {
+	truncated int
+
+	// Which of the paths have matched a value
+	pathHits []bool
+}
+
+// Should the value at location be truncated?
+func (t *Truncator) inScope(location []jsonPathSegment, state *truncateState) bool {
+	if len(t.paths) == 0 {
+		return true
+	}
+	for i, path := range t.paths {
+		if path.matches(location) {
+			state.pathHits[i] = true
+			return true
+		}
+	}
+	return false
+}
+
+// Return a copy of val with oversized values truncated, counting the truncations.  Values are not modified in place.
+func (t *Truncator) truncate(val interface{}, location []jsonPathSegment, state *truncateState) interface{} {
+
+	switch v := val.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			childLocation := append(location[:len(location):len(location)], jsonPathSegment{field: key})
+			truncated, originalLength := t.truncateValue(child, childLocation, state)
+			result[key] = truncated
+			if originalLength >= 0 {
+				result[key+t.config.LengthFieldSuffix] = originalLength
+			}
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, child := range v {
+			childLocation := append(location[:len(location):len(location)], jsonPathSegment{index: i, isIndex: true})
+			result[i], _ = t.truncateValue(child, childLocation, state)
+		}
+		return result
+	default:
+		return val
+	}
+}
+
+// Truncate a single value if it's in scope and oversized, returning its original length (or -1 if
+// it wasn't truncated).  Containers are truncated and then recursed into.
+func (t *Truncator) truncateValue(val interface{}, location []jsonPathSegment, state *truncateState) (interface{}, int) {
+
+	if !t.inScope(location, state) {
+		return t.truncate(val, location, state), -1
+	}
+
+	switch v := val.(type) {
+	case string:
+		length := utf8.RuneCountInString(v)
+		if t.config.MaxStringLength > 0 && length > t.config.MaxStringLength {
+			state.truncated += 1
+			return string([]rune(v)[:t.config.MaxStringLength]), length
+		}
+	case []interface{}:
+		if t.config.MaxArrayLength > 0 && len(v) > t.config.MaxArrayLength {
+			state.truncated += 1
+			return t.truncate(v[:t.config.MaxArrayLength], location, state), len(v)
+		}
+	}
+	return t.truncate(val, location, state), -1
+}
+
+func (t *Truncator) Report() TruncationReport {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return TruncationReport{Docs: t.docs, Values: t.truncated}
+}
+
+// The paths that never matched a value, which usually means they have a typo
+func (t *Truncator) UnusedRules() []string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	var unused []string
+	for i, hit := range t.pathHits {
+		if !hit {
+			unused = append(unused, fmt.Sprintf("truncation.paths[%v] %v", i, t.paths[i]))
+		}
+	}
+	return unused
+}
@@ -0,0 +1,146 @@
+package gocbexample
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// How long opening a bucket waits for its connections to be ready
+const bucketReadyTimeout = 30 * time.Second
+
+// gocb tuning for a bucket.  Zero values keep the gocb defaults
+type BucketTuning struct {
+
+	// Timeout of single KV operations, and of bulk operations (eg a page of bulk gets) as a whole
+	OperationTimeoutMillis     int `json:"operationTimeoutMillis,omitempty"`
+	BulkOperationTimeoutMillis int `json:"bulkOperationTimeoutMillis,omitempty"`
+
+	// KV connections per node, and the number of operations queued per connection before new ones are
+	// rejected with a queue overflow error
+	KvPoolSize   int `json:"kvPoolSize,omitempty"`
+	MaxQueueSize int `json:"maxQueueSize,omitempty"`
+}
+
+func (t BucketTuning) validate(bucketName string) error {
+	if t.OperationTimeoutMillis < 0 || t.BulkOperationTimeoutMillis < 0 || t.KvPoolSize < 0 || t.MaxQueueSize < 0 {
+		return fmt.Errorf("tuning of bucket %v has negative values", bucketName)
+	}
+	return nil
+}
+
+// The connection string options gocb reads the pool sizes from
+func (t BucketTuning) connSpecOptions() url.Values {
+	options := url.Values{}
+	if t.KvPoolSize > 0 {
+		options.Set("kv_pool_size", strconv.Itoa(t.KvPoolSize))
+	}
+	if t.MaxQueueSize > 0 {
+		options.Set("max_queue_size", strconv.Itoa(t.MaxQueueSize))
+	}
+	return options
+}
+
+// Add options to a connection string, eg couchbase://host -> couchbase://host?kv_pool_size=4
+func withConnSpecOptions(connSpec string, options url.Values) string {
+	if len(options) == 0 {
+		return connSpec
+	}
+	separator := "?"
+	if strings.Contains(connSpec, "?") {
+		separator = "&"
+	}
+	return connSpec + separator + options.Encode()
+}
+
+// The RBAC user of a bucket spec
+func (s BucketSpec) username() string {
+	if s.Username != "" {
+		return s.Username
+	}
+	return s.Name
+}
+
+// A bucket's cluster connection and the timeout of its bulk operations, which gocb v2 takes per operation
+type bucketConnection struct {
+	cluster       *gocb.Cluster
+	bulkOpTimeout time.Duration
+}
+
+// The tuning of a bucket spec, the zero value if it has none
+func (s BucketSpec) tuning() BucketTuning {
+	if s.Tuning == nil {
+		return BucketTuning{}
+	}
+	return *s.Tuning
+}
+
+// Connect to the cluster as the user of the bucket spec, with its tuning
+func (e *ExampleApp) connectCluster(spec BucketSpec) (*gocb.Cluster, error) {
+	tuning := spec.tuning()
+	options := gocb.ClusterOptions{
+		Authenticator: gocb.PasswordAuthenticator{Username: spec.username(), Password: spec.Password},
+	}
+	if tuning.OperationTimeoutMillis > 0 {
+		options.TimeoutsConfig.KVTimeout = time.Duration(tuning.OperationTimeoutMillis) * time.Millisecond
+	}
+	return gocb.Connect(withConnSpecOptions(e.ConnSpec, tuning.connSpecOptions()), options)
+}
+
+// Open a bucket, applying its tuning.  gocb authenticates cluster connections rather than buckets, and only reads
+// the pool sizes from the connection string, so a bucket opened as another user than the source, or with other
+// tuning, is opened through a cluster connection of its own
+func (e *ExampleApp) openBucket(spec BucketSpec) (*gocb.Bucket, error) {
+
+	cluster := e.ClusterConnection
+	source := e.SourceBucketSpec
+	if spec.username() != source.username() || spec.Password != source.Password || spec.tuning() != source.tuning() {
+		var err error
+		cluster, err = e.connectCluster(spec)
+		if err != nil {
+			return nil, fmt.Errorf("Error connecting as the user of bucket: %v.  Err: %v", spec.Name, err)
+		}
+	}
+
+	bucket := cluster.Bucket(spec.Name)
+	if err := bucket.WaitUntilReady(bucketReadyTimeout, nil); err != nil {
+		if cluster != e.ClusterConnection {
+			cluster.Close(nil)
+		}
+		return nil, fmt.Errorf("Error opening bucket: %v.  Err: %v", spec.Name, err)
+	}
+
+	tuning := spec.tuning()
+	connection := bucketConnection{
+		cluster:       cluster,
+		bulkOpTimeout: time.Duration(tuning.BulkOperationTimeoutMillis) * time.Millisecond,
+	}
+	if e.bucketConnections == nil {
+		e.bucketConnections = map[*gocb.Bucket]bucketConnection{}
+	}
+	e.bucketConnections[bucket] = connection
+
+	if spec.Tuning != nil {
+		e.logf("Opened bucket %v with operation timeout %v, bulk operation timeout %v, connection options %q",
+			spec.Name, time.Duration(tuning.OperationTimeoutMillis)*time.Millisecond, connection.bulkOpTimeout, tuning.connSpecOptions().Encode())
+	}
+
+	return bucket, nil
+}
+
+// The cluster connection the bucket was opened through, which queries of the bucket are run on
+func (e *ExampleApp) cluster(bucket *gocb.Bucket) *gocb.Cluster {
+	if connection, ok := e.bucketConnections[bucket]; ok {
+		return connection.cluster
+	}
+	return e.ClusterConnection
+}
+
+// Options of bulk operations on the bucket, with its bulk operation timeout
+func (e *ExampleApp) bulkOpOptions(bucket *gocb.Bucket) *gocb.BulkOpOptions {
+	return &gocb.BulkOpOptions{Timeout: e.bucketConnections[bucket].bulkOpTimeout}
+}
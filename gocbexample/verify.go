@@ -0,0 +1,230 @@
+package gocbexample
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// Requested XATTRs are placed under this virtual top-level field when comparing docs, so they
+// can be targeted by ignore-paths, eg "$xattrs.Metadata"
+const xattrsField = "$xattrs"
+
+// Controls what is compared by the verify and checksum commands
+type VerifyOptions struct {
+
+	// Paths removed from every doc before hashing/diffing, eg timestamps that legitimately differ
+	IgnorePaths []JSONPath
+
+	// XATTR keys to include in the comparison
+	Xattrs []string
+}
+
+// Result of comparing the target bucket against the source bucket
+type VerifyReport struct {
+	DocsChecked int
+	Missing     []string
+	Mismatched  []string
+}
+
+// An order-independent checksum over every doc in a bucket
+type ChecksumReport struct {
+	Bucket   string
+	Docs     int
+	Checksum string
+}
+
+// Build the value that is hashed/diffed for a doc: the body plus any requested XATTRs, minus the ignore-paths
+func (e *ExampleApp) comparableDoc(bucket *gocb.Bucket, docId string, doc interface{}, opts VerifyOptions) (interface{}, error) {
+
+	if len(opts.Xattrs) > 0 {
+		xattrs, err := fetchXattrs(e.collection(bucket), docId, opts.Xattrs)
+		if err != nil {
+			return nil, fmt.Errorf("Error getting xattrs for doc id: %v.  Err: %v", docId, err)
+		}
+		withXattrs := map[string]interface{}{}
+		if docMap, ok := doc.(map[string]interface{}); ok {
+			for k, v := range docMap {
+				withXattrs[k] = v
+			}
+		} else {
+			withXattrs["$body"] = doc
+		}
+		withXattrs[xattrsField] = xattrs
+		doc = withXattrs
+	}
+
+	for _, ignorePath := range opts.IgnorePaths {
+		doc = ignorePath.Remove(doc)
+	}
+
+	return doc, nil
+}
+
+// Get the given XATTR keys for a doc.  XATTRs that don't exist are left out of the result.
+func fetchXattrs(collection *gocb.Collection, docId string, keys []string) (map[string]interface{}, error) {
+
+	specs := make([]gocb.LookupInSpec, len(keys))
+	for i, key := range keys {
+		specs[i] = gocb.GetSpec(key, &gocb.GetSpecOptions{IsXattr: true})
+	}
+	result, err := collection.LookupIn(docId, specs, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	xattrs := map[string]interface{}{}
+	for i, key := range keys {
+		var val interface{}
+		if err := result.ContentAt(uint(i), &val); err == nil {
+			xattrs[key] = val
+		}
+	}
+	return xattrs, nil
+}
+
+// Hash a comparable doc.  json.Marshal sorts map keys, so equal docs always produce equal hashes.
+func hashComparableDoc(doc interface{}) (string, error) {
+	docBytes, err := json.Marshal(doc)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(docBytes)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Check that every doc in the source bucket exists in the target bucket with the same content
+func (e *ExampleApp) VerifyCopy(opts VerifyOptions) (report VerifyReport, err error) {
+
+	if len(opts.Xattrs) > 0 {
+		for _, bucketName := range []string{e.SourceBucketSpec.Name, e.TargetBucketSpec.Name} {
+			if err := e.requireXattrs(bucketName, "Comparing XATTRs"); err != nil {
+				return report, err
+			}
+		}
+	}
+
+	mutex := sync.Mutex{}
+
+	verifyEachDoc := func(docIds []string, docs []interface{}) error {
+
+		// Fetch the target docs via bulk ops
+		items := make([]gocb.BulkOp, len(docIds))
+		for i, docId := range docIds {
+			items[i] = &gocb.GetOp{ID: docId}
+		}
+		if err := e.collection(e.TargetBucket).Do(items, e.bulkOpOptions(e.TargetBucket)); err != nil {
+			return err
+		}
+
+		var missing, mismatched []string
+		for i, docId := range docIds {
+
+			getOp := items[i].(*gocb.GetOp)
+			if getOp.Err != nil {
+				if errors.Is(wrapGocbError(getOp.Err), ErrDocNotFound) {
+					missing = append(missing, docId)
+					continue
+				}
+				return fmt.Errorf("Error getting target doc id: %v.  Err: %v", docId, getOp.Err)
+			}
+
+			var targetBody interface{}
+			if err := getOp.Result.Content(&targetBody); err != nil {
+				return fmt.Errorf("Error decoding target doc id: %v.  Err: %v", docId, err)
+			}
+			sourceDoc, err := e.comparableDoc(e.SourceBucket, docId, docs[i], opts)
+			if err != nil {
+				return err
+			}
+			targetDoc, err := e.comparableDoc(e.TargetBucket, docId, targetBody, opts)
+			if err != nil {
+				return err
+			}
+			sourceHash, err := hashComparableDoc(sourceDoc)
+			if err != nil {
+				return err
+			}
+			targetHash, err := hashComparableDoc(targetDoc)
+			if err != nil {
+				return err
+			}
+			if sourceHash != targetHash {
+				mismatched = append(mismatched, docId)
+			}
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		report.DocsChecked += len(docIds)
+		report.Missing = append(report.Missing, missing...)
+		report.Mismatched = append(report.Mismatched, mismatched...)
+
+		return nil
+	}
+
+	e.startPhase("verify")
+	if err := e.Walk(SourceBucketRole, WalkOptions{}, e.countProgress(verifyEachDoc)); err != nil {
+		return report, err
+	}
+
+	sort.Strings(report.Missing)
+	sort.Strings(report.Mismatched)
+	e.logf("Verified %v docs: %v missing, %v mismatched", report.DocsChecked, len(report.Missing), len(report.Mismatched))
+
+	return report, nil
+}
+
+// Compute an order-independent checksum over every doc in the bucket, so that two buckets can be
+// compared without diffing them doc by doc
+func (e *ExampleApp) ChecksumBucket(bucket *gocb.Bucket, opts VerifyOptions) (report ChecksumReport, err error) {
+
+	if len(opts.Xattrs) > 0 {
+		if err := e.requireXattrs(bucket.Name(), "Checksumming XATTRs"); err != nil {
+			return report, err
+		}
+	}
+
+	mutex := sync.Mutex{}
+	checksum := make([]byte, sha256.Size)
+
+	checksumEachDoc := func(docIds []string, docs []interface{}) error {
+		for i, docId := range docIds {
+			doc, err := e.comparableDoc(bucket, docId, docs[i], opts)
+			if err != nil {
+				return err
+			}
+			docHash, err := hashComparableDoc(doc)
+			if err != nil {
+				return err
+			}
+
+			// XOR the hash of each (id, content) pair so the iteration order doesn't matter
+			sum := sha256.Sum256([]byte(docId + "\x00" + docHash))
+			mutex.Lock()
+			for j := range checksum {
+				checksum[j] ^= sum[j]
+			}
+			report.Docs += 1
+			mutex.Unlock()
+		}
+		return nil
+	}
+
+	e.startPhase("checksum")
+	if err := e.forEachDocIdBucket(e.countProgress(checksumEachDoc), bucket); err != nil {
+		return report, err
+	}
+
+	report.Bucket = bucket.Name()
+	report.Checksum = hex.EncodeToString(checksum)
+	e.logf("Checksum of bucket %v over %v docs: %v", report.Bucket, report.Docs, report.Checksum)
+
+	return report, nil
+}
@@ -0,0 +1,52 @@
+package gocbexample
+
+import (
+	"flag"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+
+	"github.com/couchbaselabs/gocb-example/transforms"
+)
+
+// Set at build time by release.go, eg -ldflags "-X github.com/couchbaselabs/gocb-example/gocbexample.version=v1.2.0"
+var (
+	version   = ""
+	commit    = ""
+	buildDate = ""
+)
+
+// The version of this build.  Binaries built with `go install module@version` get the module version,
+// anything else built without ldflags reports "dev"
+func toolVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "dev"
+}
+
+// Print the tool version, commit, build date and platform, and the custom transforms compiled in
+func setupVersion(flags *flag.FlagSet) func(job *Job) error {
+
+	return func(job *Job) error {
+
+		fmt.Printf("gocb-example %v\n", toolVersion())
+		if commit != "" {
+			fmt.Printf("commit:     %v\n", commit)
+		}
+		if buildDate != "" {
+			fmt.Printf("built:      %v\n", buildDate)
+		}
+		fmt.Printf("gocb:       %v\n", sdkVersion())
+		fmt.Printf("go:         %v %v/%v\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+		if names := transforms.Names(); len(names) > 0 {
+			fmt.Printf("transforms: %v\n", strings.Join(names, ", "))
+		}
+		return nil
+	}
+
+}
@@ -0,0 +1,110 @@
+package gocbexample
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// A range of view keys: [StartKey, EndKey).  Empty keys leave that end of the range open.
+type viewKeyRange struct {
+	StartKey string
+	EndKey   string
+}
+
+// Split the view keyspace into (at most) numRanges ranges holding roughly the same number of rows.
+//
+// The boundaries are real keys sampled from the index (via skip), rather than computed from the
+// id bytes, since view keys are sorted with unicode collation rather than byte order.
+func (e *ExampleApp) viewKeyRanges(bucket *gocb.Bucket, numRanges int) ([]viewKeyRange, error) {
+
+	// Get the total number of rows, fetching just one since gocb takes a limit of 0 as no limit
+	viewOpts := e.newScanViewOptions()
+	viewOpts.Limit = 1
+	viewResults, err := e.executeScanViewQuery(bucket, viewOpts)
+	if err != nil {
+		return nil, fmt.Errorf("Error executing view query: %v.  Err: %v", e.scanViewName(), err)
+	}
+	for viewResults.Next() {
+	}
+	metaData, err := viewResults.MetaData()
+	if err != nil {
+		return nil, err
+	}
+	totalRows := int(metaData.TotalRows)
+	e.ScanProgress(bucket.Name()).setTotal(totalRows)
+	e.phaseProgress.setTotal(totalRows)
+
+	boundaries := []string{}
+	for i := 1; i < numRanges; i++ {
+
+		skip := i * totalRows / numRanges
+		if skip == 0 {
+			continue
+		}
+
+		boundaryOpts := e.newScanViewOptions()
+		boundaryOpts.Skip, boundaryOpts.Limit = uint32(skip), 1
+		boundaryResults, err := e.executeScanViewQuery(bucket, boundaryOpts)
+		if err != nil {
+			return nil, fmt.Errorf("Error executing view query: %v with skip: %v.  Err: %v", e.scanViewName(), skip, err)
+		}
+		gotRow := boundaryResults.Next()
+		boundary := boundaryResults.Row().ID
+		if err := boundaryResults.Close(); err != nil {
+			return nil, err
+		}
+		if !gotRow {
+			break
+		}
+		if len(boundaries) > 0 && boundaries[len(boundaries)-1] == boundary {
+			continue
+		}
+		boundaries = append(boundaries, boundary)
+	}
+
+	ranges := []viewKeyRange{}
+	startKey := ""
+	for _, boundary := range boundaries {
+		ranges = append(ranges, viewKeyRange{StartKey: startKey, EndKey: boundary})
+		startKey = boundary
+	}
+	ranges = append(ranges, viewKeyRange{StartKey: startKey})
+
+	e.logf("Split %v view rows in bucket %v into %v key ranges", totalRows, bucket.Name(), len(ranges))
+
+	return ranges, nil
+}
+
+// Loop over each doc in the bucket by splitting the id keyspace into numRanges ranges and querying
+// them concurrently.  docProcessor will be called from multiple goroutines.
+func (e *ExampleApp) ForEachDocIdBucketViewsParallel(docProcessor DocProcessor, bucket *gocb.Bucket, numRanges int) (err error) {
+
+	e.logf("Performing operation via %v parallel view queries over bucket: %v", numRanges, bucket.Name())
+	defer e.logf("Finished operation via parallel view queries over bucket: %v", bucket.Name())
+
+	ranges, err := e.viewKeyRanges(bucket, numRanges)
+	if err != nil {
+		return err
+	}
+
+	wg := sync.WaitGroup{}
+	errs := make(chan error, len(ranges))
+
+	for _, keyRange := range ranges {
+		wg.Add(1)
+		go func(keyRange viewKeyRange) {
+			defer wg.Done()
+			if err := e.ForEachDocIdBucketViewRange(docProcessor, bucket, keyRange.StartKey, keyRange.EndKey); err != nil {
+				errs <- fmt.Errorf("Error processing view key range [%q, %q).  Err: %w", keyRange.StartKey, keyRange.EndKey, err)
+			}
+		}(keyRange)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	// Return the first error, if any
+	return <-errs
+}
@@ -0,0 +1,156 @@
+package gocbexample
+
+import (
+	"fmt"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// Which of the app's buckets to walk
+type BucketRole string
+
+const (
+	SourceBucketRole BucketRole = "source"
+	TargetBucketRole BucketRole = "target"
+)
+
+// How a bucket is iterated
+type Engine string
+
+const (
+	// The engine configured for the app: SourceQuery or AnalyticsDataset for the source, otherwise DCP if it's the
+	// app's Engine, N1QL (paged if N1qlPageSize is set) if UseN1ql is set, otherwise views
+	EngineConfigured Engine = ""
+
+	EngineViews       Engine = "views"
+	EngineN1ql        Engine = "n1ql"
+	EngineN1qlPaged   Engine = "n1ql-paged"
+	EngineAnalytics   Engine = "analytics"
+	EngineSourceQuery Engine = "source-query"
+
+	// Stream the docs from the KV engine with gocbcore, without an index or view
+	EngineDCP Engine = "dcp"
+)
+
+// Options of a Walk.  The zero value walks the bucket like the app's own commands.
+type WalkOptions struct {
+
+	// The engine to iterate the bucket with.  EngineAnalytics and EngineSourceQuery only walk the source, using the
+	// AnalyticsDataset and SourceQuery
+	Engine Engine
+
+	// Only pass on the docs matching this filter, on top of the app's Filter for the source
+	Filter *DocFilter
+
+	// Number of goroutines the pages of a views walk are processed by.  0 uses the app's Workers
+	Workers int
+
+	// Skip the N1QL cursor checkpoint: a paged N1QL walk starts from the beginning of the bucket, rather than
+	// resuming a scan of it, and doesn't record its progress, eg for a one-off walk during a copy
+	NoCheckpoint bool
+}
+
+// Call fn with each page of docs in the source or target bucket, subject like every scan to the read byte rate
+// limit, run windows, health gate and startup race retries.  The walk is paced and filtered by the app's settings,
+// with opts choosing the engine, narrowing the filter and setting the concurrency.
+func (e *ExampleApp) Walk(role BucketRole, opts WalkOptions, fn DocProcessor) error {
+
+	var bucket *gocb.Bucket
+	switch role {
+	case SourceBucketRole:
+		bucket = e.SourceBucket
+	case TargetBucketRole:
+		bucket = e.TargetBucket
+	default:
+		return fmt.Errorf("Unknown bucket role: %v.  Expected source or target", role)
+	}
+
+	if opts.Filter != nil {
+		filter, err := newDocFilter(*opts.Filter)
+		if err != nil {
+			return err
+		}
+		if opts.Filter.Where != "" {
+			return fmt.Errorf("Walk filters can't have a where clause, which would change the engine.  Use EngineSourceQuery instead")
+		}
+		fn = filter.docProcessor(fn)
+	}
+	return e.walkBucket(bucket, opts, fn)
+}
+
+func (e *ExampleApp) walkBucket(bucket *gocb.Bucket, opts WalkOptions, docProcessor DocProcessor) error {
+
+	engine, err := e.walkEngine(bucket, opts.Engine)
+	if err != nil {
+		return err
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = e.Workers
+	}
+
+	if err := e.checkSchedulable(engine); err != nil {
+		return err
+	}
+
+	docProcessor = e.forgetDroppedCas(bucket, e.healthGate(e.throttleReads(e.filterSourceDocs(bucket, docProcessor))))
+	return e.retryStartupRaces(bucket, docProcessor, func(docProcessor DocProcessor, bucket *gocb.Bucket) error {
+		switch engine {
+		case EngineSourceQuery:
+			return e.ForEachDocIdSourceQuery(docProcessor, bucket)
+		case EngineAnalytics:
+			return e.ForEachDocIdAnalytics(docProcessor, bucket)
+		case EngineN1qlPaged:
+			return e.forEachDocIdBucketN1qlPaged(docProcessor, bucket, !opts.NoCheckpoint)
+		case EngineN1ql:
+			return e.ForEachDocIdBucketN1ql(docProcessor, bucket)
+		case EngineDCP:
+			return e.ForEachDocIdBucketDCP(docProcessor, bucket)
+		default:
+			return e.forEachDocIdBucketViewsConcurrent(docProcessor, bucket, workers)
+		}
+	})
+}
+
+// The engine to walk the bucket with: the one asked for, or the configured one
+func (e *ExampleApp) walkEngine(bucket *gocb.Bucket, engine Engine) (Engine, error) {
+
+	isSource := bucket == e.SourceBucket
+	switch engine {
+	case EngineConfigured:
+		switch {
+		case e.SourceQuery != "" && isSource:
+			return EngineSourceQuery, nil
+		case e.AnalyticsDataset != "" && isSource:
+			return EngineAnalytics, nil
+		case e.Engine == EngineDCP:
+			return EngineDCP, nil
+		case e.UseN1ql && e.N1qlPageSize > 0:
+			return EngineN1qlPaged, nil
+		case e.UseN1ql:
+			return EngineN1ql, nil
+		}
+		return EngineViews, e.checkViewKeyspace(bucket)
+	case EngineViews:
+		return engine, e.checkViewKeyspace(bucket)
+	case EngineN1ql, EngineDCP:
+		return engine, nil
+	case EngineN1qlPaged:
+		if e.N1qlPageSize <= 0 {
+			return engine, fmt.Errorf("The %v engine needs n1qlPageSize to be set", engine)
+		}
+		return engine, nil
+	case EngineSourceQuery, EngineAnalytics:
+		if !isSource {
+			return engine, fmt.Errorf("The %v engine can only walk the source bucket", engine)
+		}
+		if engine == EngineSourceQuery && e.SourceQuery == "" {
+			return engine, fmt.Errorf("The %v engine needs sourceQuery to be set", engine)
+		}
+		if engine == EngineAnalytics && e.AnalyticsDataset == "" {
+			return engine, fmt.Errorf("The %v engine needs analyticsDataset to be set", engine)
+		}
+		return engine, nil
+	}
+	return engine, fmt.Errorf("Unknown engine: %v.  Expected one of: %v, %v, %v, %v, %v, %v", engine, EngineViews, EngineN1ql, EngineN1qlPaged, EngineAnalytics, EngineSourceQuery, EngineDCP)
+}
@@ -0,0 +1,302 @@
+package gocbexample
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+const (
+	// Defaults of the WASM plugin config
+	defaultWasmFuel           = 100 * time.Millisecond
+	defaultWasmMaxMemoryPages = 512 // 32MiB
+
+	// The largest memory a plugin can be given: the 4GiB that 32 bit WASM addresses
+	maxWasmMemoryPages = 65536
+)
+
+// A transform compiled to WASM, so that it can be written in any language that targets WASM and run sandboxed: a
+// plugin has no access to the filesystem, network or environment, only its own memory, which is capped.
+//
+// The plugin exports its memory, alloc(size i32) i32, which returns the address of size bytes for the input, and
+// transform(addr i32, size i32) i64, which is called with each doc as {"id": .., "doc": ..} and returns the address
+// of its output in the upper 32 bits and the size in the lower 32.  The output is the doc in the same shape, which
+// can change the id, null to drop the doc, or {"error": ..} to fail it.  If the plugin exports free(addr i32, size i32),
+// it's called with the input and output once they've been read.  WASI modules are supported, eg built with
+// GOOS=wasip1 GOARCH=wasm go build -buildmode=c-shared, and their _initialize is called once per instance.
+type WasmPluginConfig struct {
+
+	// The .wasm file
+	Path string `json:"path"`
+
+	// How long the plugin can run for on each doc before it's stopped and the doc fails.  WASM has no instruction
+	// counter, so fuel is measured in time.  Defaults to 100ms
+	FuelMillis int `json:"fuelMillis,omitempty"`
+
+	// The most memory each instance of the plugin can grow to, in 64KiB pages.  Defaults to 512 (32MiB)
+	MaxMemoryPages int `json:"maxMemoryPages,omitempty"`
+}
+
+// The input and output of a plugin's transform
+type wasmPluginDoc struct {
+	Id    string      `json:"id"`
+	Doc   interface{} `json:"doc"`
+	Error string      `json:"error,omitempty"`
+}
+
+// A transform stage that runs the docs through a WASM plugin.  Each instance of the plugin transforms one doc at
+// a time, so the plugin is instantiated once per concurrent batch, and an instance that runs out of fuel or traps
+// is thrown away rather than reused with its memory in an unknown state.
+type WasmTransform struct {
+	config   WasmPluginConfig
+	fuel     time.Duration
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+
+	mutex     sync.Mutex
+	instances []api.Module
+	report    WasmPluginReport
+}
+
+// Summary of the docs a WasmTransform transformed
+type WasmPluginReport struct {
+	Path      string
+	Docs      int
+	Dropped   int
+	Failed    int
+	OutOfFuel int
+	Instances int
+}
+
+// Compile the plugin, checking that it exports the functions it needs
+func NewWasmTransform(config WasmPluginConfig) (*WasmTransform, error) {
+
+	if config.FuelMillis < 0 || config.MaxMemoryPages < 0 || config.MaxMemoryPages > maxWasmMemoryPages {
+		return nil, fmt.Errorf("Invalid WASM plugin config: %v.  fuelMillis can't be negative, and maxMemoryPages must be between 0 and %v", config.Path, maxWasmMemoryPages)
+	}
+	fuel := defaultWasmFuel
+	if config.FuelMillis > 0 {
+		fuel = time.Duration(config.FuelMillis) * time.Millisecond
+	}
+	maxMemoryPages := defaultWasmMaxMemoryPages
+	if config.MaxMemoryPages > 0 {
+		maxMemoryPages = config.MaxMemoryPages
+	}
+
+	wasm, err := os.ReadFile(config.Path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading WASM plugin: %v.  Err: %v", config.Path, err)
+	}
+
+	// Closing the module when the context is done is what stops a plugin that has used up its fuel
+	ctx := context.Background()
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithCloseOnContextDone(true).
+		WithMemoryLimitPages(uint32(maxMemoryPages)))
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, runtime); err != nil {
+		runtime.Close(ctx)
+		return nil, err
+	}
+	compiled, err := runtime.CompileModule(ctx, wasm)
+	if err != nil {
+		runtime.Close(ctx)
+		return nil, fmt.Errorf("Error compiling WASM plugin: %v.  Err: %v", config.Path, err)
+	}
+
+	exports := compiled.ExportedFunctions()
+	for name, signature := range map[string]string{"alloc": "i32 -> i32", "transform": "i32, i32 -> i64"} {
+		if exports[name] == nil {
+			runtime.Close(ctx)
+			return nil, fmt.Errorf("WASM plugin: %v doesn't export %v(%v)", config.Path, name, signature)
+		}
+	}
+	if _, ok := compiled.ExportedMemories()["memory"]; !ok {
+		runtime.Close(ctx)
+		return nil, fmt.Errorf("WASM plugin: %v doesn't export its memory", config.Path)
+	}
+
+	return &WasmTransform{
+		config:   config,
+		fuel:     fuel,
+		runtime:  runtime,
+		compiled: compiled,
+		report:   WasmPluginReport{Path: config.Path},
+	}, nil
+}
+
+func (w *WasmTransform) Transform(input DocProcessorInput) (output DocProcessorInput, err error) {
+
+	output = DocProcessorInput{DocIds: []string{}, Docs: []interface{}{}}
+
+	instance, err := w.instance()
+	if err != nil {
+		return output, newDocError(PhaseTransform, "", err)
+	}
+	defer func() {
+		w.release(instance)
+	}()
+
+	var failed DocErrors
+	for i, docId := range input.DocIds {
+		if instance == nil {
+			// Replace the instance that the last doc closed
+			if instance, err = w.instance(); err != nil {
+				return output, newDocError(PhaseTransform, "", err)
+			}
+		}
+		result, err := w.transformDoc(instance, wasmPluginDoc{Id: docId, Doc: input.Docs[i]})
+		if err != nil {
+			if instance.IsClosed() {
+				// Out of fuel, or trapped and closed below
+				instance = nil
+			}
+			w.count(func(report *WasmPluginReport) { report.Failed++ })
+			failed = failed.add(docId, input.Docs[i], err)
+			continue
+		}
+		if result == nil {
+			w.count(func(report *WasmPluginReport) { report.Docs++; report.Dropped++ })
+			continue
+		}
+		w.count(func(report *WasmPluginReport) { report.Docs++ })
+		output.DocIds = append(output.DocIds, result.Id)
+		output.Docs = append(output.Docs, result.Doc)
+	}
+	return output, failed.err()
+}
+
+// Run the plugin's transform on one doc, within its fuel.  Returns nil if the plugin dropped the doc.
+func (w *WasmTransform) transformDoc(instance api.Module, doc wasmPluginDoc) (*wasmPluginDoc, error) {
+
+	inputBytes, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), w.fuel)
+	defer cancel()
+
+	call := func(name string, params ...uint64) ([]uint64, error) {
+		results, err := instance.ExportedFunction(name).Call(ctx, params...)
+		if err == nil {
+			return results, nil
+		}
+		var exitErr *sys.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == sys.ExitCodeDeadlineExceeded {
+			w.count(func(report *WasmPluginReport) { report.OutOfFuel++ })
+			return nil, fmt.Errorf("WASM plugin: %v ran out of fuel (%v) in %v", w.config.Path, w.fuel, name)
+		}
+		// A trap leaves the instance's memory in an unknown state, so it isn't reused
+		instance.Close(context.Background())
+		return nil, fmt.Errorf("Error calling %v of WASM plugin: %v.  Err: %v", name, w.config.Path, err)
+	}
+	free := func(addr, size uint32) error {
+		if instance.ExportedFunction("free") == nil || size == 0 {
+			return nil
+		}
+		_, err := call("free", uint64(addr), uint64(size))
+		return err
+	}
+
+	results, err := call("alloc", uint64(len(inputBytes)))
+	if err != nil {
+		return nil, err
+	}
+	inputAddr := uint32(results[0])
+	if !instance.Memory().Write(inputAddr, inputBytes) {
+		return nil, fmt.Errorf("WASM plugin: %v allocated %v bytes out of range of its memory", w.config.Path, len(inputBytes))
+	}
+
+	results, err = call("transform", uint64(inputAddr), uint64(len(inputBytes)))
+	if err != nil {
+		return nil, err
+	}
+	if err := free(inputAddr, uint32(len(inputBytes))); err != nil {
+		return nil, err
+	}
+	outputAddr, outputSize := uint32(results[0]>>32), uint32(results[0])
+	outputBytes, ok := instance.Memory().Read(outputAddr, outputSize)
+	if !ok {
+		return nil, fmt.Errorf("WASM plugin: %v returned %v bytes out of range of its memory", w.config.Path, outputSize)
+	}
+
+	// Decode before freeing, since Read returns a view of the plugin's memory
+	var result *wasmPluginDoc
+	if err := json.Unmarshal(outputBytes, &result); err != nil {
+		return nil, fmt.Errorf("Error decoding output of WASM plugin: %v.  Err: %v", w.config.Path, err)
+	}
+	if err := free(outputAddr, outputSize); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case result == nil:
+		return nil, nil
+	case result.Error != "":
+		return nil, fmt.Errorf("WASM plugin: %v failed the doc.  Err: %v", w.config.Path, result.Error)
+	case result.Id == "":
+		return nil, fmt.Errorf("WASM plugin: %v returned a doc with no id", w.config.Path)
+	}
+	return result, nil
+}
+
+// An idle instance of the plugin, instantiated if there are none
+func (w *WasmTransform) instance() (api.Module, error) {
+
+	w.mutex.Lock()
+	if n := len(w.instances); n > 0 {
+		instance := w.instances[n-1]
+		w.instances = w.instances[:n-1]
+		w.mutex.Unlock()
+		return instance, nil
+	}
+	w.mutex.Unlock()
+
+	// Anonymous, so that it can be instantiated more than once, with stderr for the plugin's own logging
+	moduleConfig := wazero.NewModuleConfig().WithName("").WithStartFunctions("_initialize").WithStderr(os.Stderr)
+	instance, err := w.runtime.InstantiateModule(context.Background(), w.compiled, moduleConfig)
+	if err != nil {
+		return nil, fmt.Errorf("Error instantiating WASM plugin: %v.  Err: %v", w.config.Path, err)
+	}
+	w.count(func(report *WasmPluginReport) { report.Instances++ })
+	return instance, nil
+}
+
+// Return an instance for reuse, unless it was thrown away
+func (w *WasmTransform) release(instance api.Module) {
+	if instance == nil || instance.IsClosed() {
+		return
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.instances = append(w.instances, instance)
+}
+
+func (w *WasmTransform) count(update func(report *WasmPluginReport)) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	update(&w.report)
+}
+
+func (w *WasmTransform) Report() WasmPluginReport {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.report
+}
+
+// Close the plugin's instances and free its compiled code
+func (w *WasmTransform) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.instances = nil
+	return w.runtime.Close(context.Background())
+}
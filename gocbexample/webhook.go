@@ -0,0 +1,223 @@
+package gocbexample
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	// Defaults of the webhook config
+	defaultWebhookTimeout      = 30 * time.Second
+	defaultWebhookMaxBatchSize = 100
+	defaultWebhookMaxAttempts  = 3
+
+	// How much of an error response is quoted in the error
+	webhookErrorBodyLimit = 512
+)
+
+// An external HTTP endpoint that transforms the docs, eg a service that owns some of the business rules
+type WebhookConfig struct {
+
+	// Batches of docs are POSTed here as {"docs": [{"id": .., "doc": ..}]}, and the endpoint responds with the
+	// transformed docs in the same shape.  Docs it leaves out of the response are dropped, and it can change ids
+	URL string `json:"url"`
+
+	// Added to each request, eg {"Authorization": "Bearer ..."}
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Timeout of each request.  Defaults to 30s
+	TimeoutMillis int `json:"timeoutMillis,omitempty"`
+
+	// Docs per request, larger pages are split.  Defaults to 100
+	MaxBatchSize int `json:"maxBatchSize,omitempty"`
+
+	// Retry requests that fail to connect, time out or get a 429 or 5xx response.  Defaults to 3 attempts
+	Retry *RetryConfig `json:"retry,omitempty"`
+}
+
+// A doc in the requests and responses of a webhook
+type webhookDoc struct {
+	Id  string      `json:"id"`
+	Doc interface{} `json:"doc"`
+}
+
+type webhookBody struct {
+	Docs []webhookDoc `json:"docs"`
+}
+
+// A response the webhook might succeed on if the request is retried
+type webhookStatusError struct {
+	status int
+	body   string
+}
+
+func (e *webhookStatusError) Error() string {
+	return fmt.Sprintf("Webhook responded with status: %v.  Body: %v", e.status, e.body)
+}
+
+// A transform stage that sends the docs to a webhook, and passes on the docs it returns
+type WebhookTransform struct {
+	config      WebhookConfig
+	client      *http.Client
+	retryPolicy RetryPolicy
+
+	// Logs the retries.  The standard logger, unless the transform belongs to an app
+	logf func(format string, args ...interface{})
+
+	mutex  sync.Mutex
+	report WebhookReport
+}
+
+// Summary of the requests a WebhookTransform made
+type WebhookReport struct {
+	Requests     int
+	Retries      int
+	DocsSent     int
+	DocsReturned int
+}
+
+func NewWebhookTransform(config WebhookConfig) (*WebhookTransform, error) {
+
+	parsed, err := url.Parse(config.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("Invalid webhook url: %q.  Expected an http or https url", config.URL)
+	}
+	if config.TimeoutMillis < 0 || config.MaxBatchSize < 0 {
+		return nil, fmt.Errorf("Invalid webhook config: timeoutMillis and maxBatchSize can't be negative")
+	}
+	if config.MaxBatchSize == 0 {
+		config.MaxBatchSize = defaultWebhookMaxBatchSize
+	}
+	timeout := defaultWebhookTimeout
+	if config.TimeoutMillis > 0 {
+		timeout = time.Duration(config.TimeoutMillis) * time.Millisecond
+	}
+
+	retryPolicy := RetryPolicy{MaxAttempts: defaultWebhookMaxAttempts, InitialBackoff: 500 * time.Millisecond, MaxBackoff: 10 * time.Second}
+	if config.Retry != nil {
+		retryPolicy = RetryPolicy{
+			MaxAttempts:    config.Retry.MaxAttempts,
+			InitialBackoff: time.Duration(config.Retry.InitialBackoffMillis) * time.Millisecond,
+			MaxBackoff:     time.Duration(config.Retry.MaxBackoffMillis) * time.Millisecond,
+		}
+	}
+
+	return &WebhookTransform{
+		config:      config,
+		client:      &http.Client{Timeout: timeout},
+		retryPolicy: retryPolicy,
+		logf:        log.Printf,
+	}, nil
+}
+
+func (w *WebhookTransform) Transform(input DocProcessorInput) (output DocProcessorInput, err error) {
+
+	output = DocProcessorInput{DocIds: []string{}, Docs: []interface{}{}}
+	for start := 0; start < len(input.DocIds); start += w.config.MaxBatchSize {
+		end := start + w.config.MaxBatchSize
+		if end > len(input.DocIds) {
+			end = len(input.DocIds)
+		}
+		request := webhookBody{Docs: make([]webhookDoc, end-start)}
+		for i := start; i < end; i++ {
+			request.Docs[i-start] = webhookDoc{Id: input.DocIds[i], Doc: input.Docs[i]}
+		}
+
+		response, err := w.send(request)
+		if err != nil {
+			return output, err
+		}
+		for _, doc := range response.Docs {
+			if doc.Id == "" {
+				return output, fmt.Errorf("Webhook: %v returned a doc with no id", w.config.URL)
+			}
+			output.DocIds = append(output.DocIds, doc.Id)
+			output.Docs = append(output.Docs, doc.Doc)
+		}
+	}
+	return output, nil
+}
+
+// POST the docs to the webhook, retrying per the retry policy, and decode the docs it returns
+func (w *WebhookTransform) send(request webhookBody) (*webhookBody, error) {
+
+	requestBytes, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	// Retry timeouts and errors connecting, and responses saying the endpoint is overloaded or failed, but not
+	// responses that can't be decoded
+	retryable := func(err error) bool {
+		var statusErr *webhookStatusError
+		if errors.As(err, &statusErr) {
+			return statusErr.status == http.StatusTooManyRequests || statusErr.status >= 500
+		}
+		var urlErr *url.Error
+		return errors.As(err, &urlErr)
+	}
+
+	var response *webhookBody
+	attempts, err := w.retryPolicy.do(w.logf, fmt.Sprintf("webhook request of %v docs", len(request.Docs)), retryable, func() error {
+		var err error
+		response, err = w.post(requestBytes)
+		return err
+	})
+
+	w.mutex.Lock()
+	w.report.Requests += attempts
+	w.report.Retries += attempts - 1
+	w.report.DocsSent += len(request.Docs)
+	if err == nil {
+		w.report.DocsReturned += len(response.Docs)
+	}
+	w.mutex.Unlock()
+
+	if err != nil {
+		return nil, fmt.Errorf("Error calling webhook: %v after %v attempts.  Err: %w", w.config.URL, attempts, err)
+	}
+	return response, nil
+}
+
+func (w *WebhookTransform) post(requestBytes []byte) (*webhookBody, error) {
+
+	req, err := http.NewRequest("POST", w.config.URL, bytes.NewReader(requestBytes))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, val := range w.config.Headers {
+		req.Header.Set(name, val)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, webhookErrorBodyLimit))
+		return nil, &webhookStatusError{status: resp.StatusCode, body: string(body)}
+	}
+
+	response := &webhookBody{}
+	if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+		return nil, fmt.Errorf("Error decoding webhook response.  Err: %v", err)
+	}
+	return response, nil
+}
+
+func (w *WebhookTransform) Report() WebhookReport {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.report
+}
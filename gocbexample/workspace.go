@@ -0,0 +1,106 @@
+package gocbexample
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// Names of the artifacts kept in a job workspace
+const (
+	workspaceConfigFile      = "config.json"
+	workspaceCheckpointFile  = "checkpoint.json"
+	workspaceDeadLetterFile  = "dead-letter.jsonl"
+	workspaceReportFile      = "report.json"
+	workspaceLogFile         = "job.log"
+	workspaceSchemaFile      = "schema.json"
+	workspaceRekeyFile       = "rekey-mapping.json"
+	workspaceCalibrationFile = "calibration.json"
+)
+
+// A per-job directory holding the effective config, checkpoints, dead-letter file, report and logs,
+// so that multiple migrations don't trample each other's state files
+type Workspace struct {
+	Dir   string
+	JobId string
+
+	logFile *os.File
+}
+
+// Create (or reopen) the workspace for jobId under root
+func NewWorkspace(root, jobId string) (*Workspace, error) {
+	dir := filepath.Join(root, jobId)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Workspace{
+		Dir:   dir,
+		JobId: jobId,
+	}, nil
+}
+
+// Path of a file in the workspace
+func (w *Workspace) Path(name string) string {
+	return filepath.Join(w.Dir, name)
+}
+
+func (w *Workspace) CheckpointPath() string {
+	return w.Path(workspaceCheckpointFile)
+}
+
+func (w *Workspace) DeadLetterPath() string {
+	return w.Path(workspaceDeadLetterFile)
+}
+
+func (w *Workspace) ReportPath() string {
+	return w.Path(workspaceReportFile)
+}
+
+// Write the effective config, with passwords redacted
+func (w *Workspace) WriteConfig(config Config) error {
+	return w.WriteJSON(workspaceConfigFile, config.Redacted())
+}
+
+// Write the final report
+func (w *Workspace) WriteReport(report interface{}) error {
+	return w.WriteJSON(workspaceReportFile, report)
+}
+
+// Write val as indented JSON to a file in the workspace
+func (w *Workspace) WriteJSON(name string, val interface{}) error {
+	return writeJSONFile(w.Path(name), val)
+}
+
+// Write val as indented JSON to path
+func writeJSONFile(path string, val interface{}) error {
+	valBytes, err := json.MarshalIndent(val, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, valBytes, 0644)
+}
+
+// Send log output to the workspace log file as well as stderr
+func (w *Workspace) StartLogging() error {
+	logFile, err := os.OpenFile(w.Path(workspaceLogFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	w.logFile = logFile
+	log.SetOutput(io.MultiWriter(os.Stderr, logFile))
+	return nil
+}
+
+// Stop logging to the workspace log file
+func (w *Workspace) Close() error {
+	if w.logFile == nil {
+		return nil
+	}
+	log.SetOutput(os.Stderr)
+	err := w.logFile.Close()
+	w.logFile = nil
+	return err
+}
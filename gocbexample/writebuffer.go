@@ -0,0 +1,214 @@
+package gocbexample
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// When the write buffer flushes transformed docs to the sink.  A zero value disables that trigger
+type WriteBatching struct {
+	MaxDocs  int
+	MaxBytes int
+	MaxWait  time.Duration
+}
+
+// Write docs to the sink in batches of their own, rather than one batch per read page, flushing once a
+// batch reaches maxDocs docs or maxBytes bytes, or maxWait after its first doc was buffered
+func WithWriteBatching(batching WriteBatching) Option {
+	return func(e *ExampleApp) error {
+		if batching.MaxDocs < 0 || batching.MaxBytes < 0 || batching.MaxWait < 0 {
+			return fmt.Errorf("Invalid write batching: %+v.  Limits can't be negative", batching)
+		}
+		e.WriteBatching = batching
+		return nil
+	}
+}
+
+func (b WriteBatching) enabled() bool {
+	return b.MaxDocs > 0 || b.MaxBytes > 0 || b.MaxWait > 0
+}
+
+// Accumulates transformed docs from any number of read pages (and workers), and hands them to flush in
+// write batches sized by the WriteBatching limits
+type writeBuffer struct {
+	batching WriteBatching
+	flush    func(docIds []string, docs []interface{}) error
+
+	mutex  sync.Mutex
+	docIds []string
+	docs   []interface{}
+	bytes  int
+	oldest time.Time
+
+	// The first error of a flush triggered by MaxWait, returned by the next add or close
+	err error
+
+	// Held for reading by each add and flush, and for writing by flushBuffered to wait for them
+	flushing sync.RWMutex
+
+	stop    chan struct{}
+	stopped sync.WaitGroup
+}
+
+func newWriteBuffer(batching WriteBatching, flush func(docIds []string, docs []interface{}) error) *writeBuffer {
+	b := &writeBuffer{
+		batching: batching,
+		flush:    flush,
+		stop:     make(chan struct{}),
+	}
+	if batching.MaxWait > 0 {
+		b.stopped.Add(1)
+		go b.flushStale()
+	}
+	return b
+}
+
+// Buffer docs, flushing any batches that are full
+func (b *writeBuffer) add(docIds []string, docs []interface{}) error {
+
+	size := 0
+	if b.batching.MaxBytes > 0 {
+		size = docsSize(docIds, docs)
+	}
+
+	b.flushing.RLock()
+	defer b.flushing.RUnlock()
+
+	b.mutex.Lock()
+	if b.err != nil {
+		b.mutex.Unlock()
+		return b.err
+	}
+	if len(b.docIds) == 0 {
+		b.oldest = time.Now()
+	}
+	b.docIds = append(b.docIds, docIds...)
+	b.docs = append(b.docs, docs...)
+	b.bytes += size
+
+	var batches []DocProcessorInput
+	for b.full() {
+		batches = append(batches, b.take(b.batching.MaxDocs))
+	}
+	b.mutex.Unlock()
+
+	for _, batch := range batches {
+		if err := b.flush(batch.DocIds, batch.Docs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush whatever is buffered, once the flushes in progress have finished, so that every doc added before the call
+// has been written when it returns, eg before checkpointing the pages read
+func (b *writeBuffer) flushBuffered() error {
+
+	b.flushing.Lock()
+	defer b.flushing.Unlock()
+
+	b.mutex.Lock()
+	err := b.err
+	batch := b.take(0)
+	b.mutex.Unlock()
+
+	if err != nil {
+		return err
+	}
+	if len(batch.DocIds) == 0 {
+		return nil
+	}
+	return b.flush(batch.DocIds, batch.Docs)
+}
+
+// Flush whatever is buffered and stop flushing on MaxWait.  Returns the first flush error
+func (b *writeBuffer) close() error {
+
+	close(b.stop)
+	b.stopped.Wait()
+
+	b.mutex.Lock()
+	err := b.err
+	batch := b.take(0)
+	b.mutex.Unlock()
+
+	if len(batch.DocIds) > 0 {
+		if flushErr := b.flush(batch.DocIds, batch.Docs); flushErr != nil && err == nil {
+			err = flushErr
+		}
+	}
+	return err
+}
+
+// Is there a full batch buffered?  Must be called with the mutex held
+func (b *writeBuffer) full() bool {
+	if len(b.docIds) == 0 {
+		return false
+	}
+	return (b.batching.MaxDocs > 0 && len(b.docIds) >= b.batching.MaxDocs) ||
+		(b.batching.MaxBytes > 0 && b.bytes >= b.batching.MaxBytes)
+}
+
+// Remove up to maxDocs docs (0 means all) from the buffer.  Must be called with the mutex held
+func (b *writeBuffer) take(maxDocs int) DocProcessorInput {
+
+	n := len(b.docIds)
+	if maxDocs > 0 && maxDocs < n {
+		n = maxDocs
+	}
+	batch := DocProcessorInput{
+		DocIds: append([]string{}, b.docIds[:n]...),
+		Docs:   append([]interface{}{}, b.docs[:n]...),
+	}
+	b.docIds = b.docIds[n:]
+	b.docs = b.docs[n:]
+
+	if len(b.docIds) == 0 {
+		b.bytes = 0
+	} else if b.batching.MaxBytes > 0 {
+		b.bytes = docsSize(b.docIds, b.docs)
+	}
+	b.oldest = time.Now()
+	return batch
+}
+
+// Flush the buffer once its oldest doc has waited MaxWait, so a slow source doesn't hold docs back
+func (b *writeBuffer) flushStale() {
+
+	defer b.stopped.Done()
+
+	interval := b.batching.MaxWait / 2
+	if interval <= 0 {
+		interval = b.batching.MaxWait
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+		}
+
+		b.flushing.RLock()
+		b.mutex.Lock()
+		var batch DocProcessorInput
+		if b.err == nil && len(b.docIds) > 0 && time.Since(b.oldest) >= b.batching.MaxWait {
+			batch = b.take(0)
+		}
+		b.mutex.Unlock()
+
+		if len(batch.DocIds) > 0 {
+			if err := b.flush(batch.DocIds, batch.Docs); err != nil {
+				b.mutex.Lock()
+				if b.err == nil {
+					b.err = err
+				}
+				b.mutex.Unlock()
+			}
+		}
+		b.flushing.RUnlock()
+	}
+}
@@ -0,0 +1,124 @@
+package gocbexample
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// Subdoc can't enumerate the XATTRs of a doc, so these are looked up: the XATTRs the tool writes, the Sync Gateway
+// system XATTR, and the virtual XATTR with the doc's metadata (CAS, expiry, seqno ..)
+var knownXattrKeys = []string{xattrKey, namespaceXattrKey, "_sync", documentVirtualXattr}
+
+// Look up the XATTRs of a doc, returning those it has.  System XATTRs the user isn't permitted to read are
+// left out, as are XATTRs it doesn't have.  Returns an ErrDocNotFound error if the doc doesn't exist, or with
+// XattrAccessDeleted, if it has no tombstone either.
+func (e *ExampleApp) LookupXattrs(bucket *gocb.Bucket, docId string, keys []string) (xattrs map[string]interface{}, err error) {
+
+	xattrs = map[string]interface{}{}
+	for _, key := range keys {
+		result, err := e.collection(bucket).LookupIn(docId, []gocb.LookupInSpec{gocb.GetSpec(key, &gocb.GetSpecOptions{IsXattr: true})},
+			lookupInOptions(e.xattrDocFlags()))
+		var val interface{}
+		if err == nil {
+			err = result.ContentAt(0, &val)
+		}
+		if err != nil {
+			if errors.Is(wrapGocbError(err), ErrDocNotFound) {
+				return nil, fmt.Errorf("Doc %v not found in bucket: %v.  Err: %w", docId, e.keyspaceName(bucket), wrapGocbError(err))
+			}
+			// The doc doesn't have the XATTR, or it's a system XATTR the user can't read
+			e.logf("Skipping XATTR %v of doc %v in bucket %v: %v", key, docId, e.keyspaceName(bucket), err)
+			continue
+		}
+		xattrs[key] = val
+	}
+	return xattrs, nil
+}
+
+// The differences between the XATTRs of a doc in the source and target buckets
+type XattrDiff struct {
+	DocId string `json:"docId"`
+
+	// Keys only the source or only the target doc has
+	SourceOnly []string `json:"sourceOnly,omitempty"`
+	TargetOnly []string `json:"targetOnly,omitempty"`
+
+	// Keys with different values, with the source and target values
+	Differing map[string]XattrValues `json:"differing,omitempty"`
+
+	// Keys with the same value
+	Same []string `json:"same,omitempty"`
+}
+
+type XattrValues struct {
+	Source interface{} `json:"source"`
+	Target interface{} `json:"target"`
+}
+
+// Compare the XATTRs of a doc in the source and target buckets.  The virtual $document XATTR is left out, since
+// the CAS and seqno of a copy always differ.
+func (e *ExampleApp) DiffXattrs(docId string, keys []string) (diff XattrDiff, err error) {
+
+	var compared []string
+	for _, key := range keys {
+		if key != documentVirtualXattr {
+			compared = append(compared, key)
+		}
+	}
+	sourceXattrs, err := e.LookupXattrs(e.SourceBucket, docId, compared)
+	if err != nil {
+		return diff, err
+	}
+	targetXattrs, err := e.LookupXattrs(e.TargetBucket, docId, compared)
+	if err != nil {
+		return diff, err
+	}
+
+	diff.DocId = docId
+	for key, sourceVal := range sourceXattrs {
+		targetVal, ok := targetXattrs[key]
+		if !ok {
+			diff.SourceOnly = append(diff.SourceOnly, key)
+			continue
+		}
+		sourceBytes, _ := json.Marshal(sourceVal)
+		targetBytes, _ := json.Marshal(targetVal)
+		if string(sourceBytes) == string(targetBytes) {
+			diff.Same = append(diff.Same, key)
+			continue
+		}
+		if diff.Differing == nil {
+			diff.Differing = map[string]XattrValues{}
+		}
+		diff.Differing[key] = XattrValues{Source: sourceVal, Target: targetVal}
+	}
+	for key := range targetXattrs {
+		if _, ok := sourceXattrs[key]; !ok {
+			diff.TargetOnly = append(diff.TargetOnly, key)
+		}
+	}
+	sort.Strings(diff.SourceOnly)
+	sort.Strings(diff.TargetOnly)
+	sort.Strings(diff.Same)
+	return diff, nil
+}
+
+// The XATTR keys to look up: the known keys, the key copies are stamped with, and those listed
+func (e *ExampleApp) xattrKeysToLookUp(extraKeys []string) []string {
+	keys := append([]string{}, knownXattrKeys...)
+	extraKeys = append([]string{e.MetadataXattrKey}, extraKeys...)
+	for _, key := range extraKeys {
+		known := false
+		for _, existing := range keys {
+			known = known || existing == key
+		}
+		if !known {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
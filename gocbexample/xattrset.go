@@ -0,0 +1,148 @@
+package gocbexample
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// What the xattr set command stamped, or in a dry run would have stamped
+type XattrSetReport struct {
+	Key    string `json:"key"`
+	DryRun bool   `json:"dryRun,omitempty"`
+
+	// Docs read, docs matching the filter, and matching docs stamped
+	DocsScanned int64 `json:"docsScanned"`
+	DocsMatched int64 `json:"docsMatched"`
+	DocsStamped int64 `json:"docsStamped"`
+
+	// Matching docs that changed since they were read, so weren't stamped
+	Conflicts []string `json:"conflicts,omitempty"`
+}
+
+// An XATTR value, as JSON that can refer to ${DOC_ID}, ${DOC_TYPE}, ${JOB_ID} and ${BUCKET} as well as the
+// built-in variables (DATE, TIME) and environment variables of config files.  Top-level fields whose value is
+// a mutation macro, eg "${Mutation.CAS}", are expanded by the server.
+type XattrTemplate struct {
+	template []byte
+	now      time.Time
+}
+
+// Parse a template, checking that it's JSON once the variables are substituted
+func NewXattrTemplate(template string, now time.Time) (*XattrTemplate, error) {
+
+	t := &XattrTemplate{template: []byte(template), now: now}
+	if _, err := t.value("doc", map[string]interface{}{}, "job", "bucket"); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// The value of the XATTR of a doc
+func (t *XattrTemplate) value(docId string, doc interface{}, jobId, bucketName string) (interface{}, error) {
+
+	docType := ""
+	if body, ok := doc.(map[string]interface{}); ok {
+		docType, _ = body[defaultTypeField].(string)
+	}
+	vars := map[string]string{
+		"DOC_ID":   docId,
+		"DOC_TYPE": docType,
+		"JOB_ID":   jobId,
+		"BUCKET":   bucketName,
+	}
+
+	substituted, missing := substituteVariables(t.template, vars, t.now)
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("The XATTR value uses variables that aren't set: %v.  Set them in the environment", strings.Join(missing, ", "))
+	}
+	var val interface{}
+	if err := json.Unmarshal(substituted, &val); err != nil {
+		return nil, fmt.Errorf("Invalid XATTR value: %s.  Must be JSON, eg '{\"owner\": \"payments\"}'.  Err: %v", substituted, err)
+	}
+	return val, nil
+}
+
+// Set the XATTR key of the docs of the source bucket matching the filter to the template's value, eg to stamp
+// environment or ownership metadata after a copy.  Each doc is stamped via a subdoc mutation with the CAS it was
+// read with, so docs changed since the scan aren't stamped and are reported as conflicts.  The stamps are paced by
+// the write byte rate limit, counting the size of the values, and a dry run only counts the docs that would be
+// stamped.
+//
+// If docIds are given, only those docs are stamped, without a scan or CAS check.  With XattrAccessDeleted this can
+// stamp the system XATTRs of deleted docs' tombstones, which scans don't return.
+func (e *ExampleApp) SetXattrs(filter DocFilter, docIds []string, key string, template *XattrTemplate, dryRun bool) (report XattrSetReport, err error) {
+
+	if err := validateXattrKey(key); err != nil {
+		return report, err
+	}
+	if err := e.requireXattrs(e.SourceBucketSpec.Name, "Setting XATTRs"); err != nil {
+		return report, err
+	}
+
+	report.Key = key
+	report.DryRun = dryRun
+	var reportMutex sync.Mutex
+
+	setXattrs := func(docIds []string, docs []interface{}, cas []gocb.Cas) error {
+
+		values := make([]interface{}, len(docIds))
+		for i, docId := range docIds {
+			val, err := template.value(docId, docs[i], e.JobId, e.SourceBucket.Name())
+			if err != nil {
+				return newDocError(PhaseTransform, docId, err)
+			}
+			values[i] = val
+		}
+		if dryRun {
+			return nil
+		}
+		e.writeLimiter.Wait(docsSize(docIds, values))
+
+		var stamped int64
+		var conflicts []string
+		err := forEachConcurrently(e.SubdocConcurrency, len(docIds), func(i int) error {
+
+			err := e.mutateInKeepingExpiry(e.SourceBucket, e.SourceBucketSpec.Name, docIds[i], e.xattrDocFlags(), cas[i],
+				upsertXattrWithMacros(nil, key, values[i]))
+
+			reportMutex.Lock()
+			defer reportMutex.Unlock()
+			if err == nil {
+				stamped++
+				return nil
+			}
+			wrappedErr := wrapGocbError(err)
+			if errors.Is(wrappedErr, ErrDocExists) || errors.Is(wrappedErr, ErrDocNotFound) {
+				// A CAS mismatch matches ErrDocExists
+				conflicts = append(conflicts, docIds[i])
+				return nil
+			}
+			return newDocError(PhaseTargetWrite, docIds[i], err)
+		})
+
+		reportMutex.Lock()
+		defer reportMutex.Unlock()
+		report.DocsStamped += stamped
+		report.Conflicts = append(report.Conflicts, conflicts...)
+		return err
+	}
+
+	if len(docIds) > 0 {
+		if e.XattrAccessDeleted && !isSystemXattr(key) {
+			return report, fmt.Errorf("Invalid XATTR key: %v.  Tombstones only keep system XATTRs, whose keys start with _", key)
+		}
+		report.DocsScanned, report.DocsMatched = int64(len(docIds)), int64(len(docIds))
+		err = setXattrs(docIds, make([]interface{}, len(docIds)), make([]gocb.Cas, len(docIds)))
+	} else {
+		report.DocsScanned, report.DocsMatched, err = e.forEachMatchingDoc("xattr set", filter, setXattrs)
+	}
+	sort.Strings(report.Conflicts)
+	return report, err
+}
@@ -0,0 +1,158 @@
+//go:build ignore
+// +build ignore
+
+// Builds the release binaries, with the version and commit embedded via ldflags:
+//
+//	go run release.go -version v1.2.0
+//
+// The binaries are statically linked (no cgo), so they run on hosts without a Go toolchain or libc
+// of a particular version.
+//
+// Custom binaries compile in the transforms that packages outside this repo register with transforms.Register:
+//
+//	go run release.go -version v1.2.0-acme -with example.com/acme/transforms@v0.3.0
+//
+// go.mod and go.sum are restored once the binaries are built.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The package whose version variables the ldflags set
+const versionPackage = "github.com/couchbaselabs/gocb-example/gocbexample"
+
+// The file importing the -with packages, which is removed once the binaries are built
+const customTransformsFile = "zz_custom_transforms.go"
+
+// A flag that can be repeated
+type stringsFlag []string
+
+func (f *stringsFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// The platforms a release is built for
+var releasePlatforms = []struct{ goos, goarch string }{
+	{"linux", "amd64"},
+	{"linux", "arm64"},
+	{"windows", "amd64"},
+}
+
+func main() {
+
+	version := flag.String("version", "", "Release version, eg v1.2.0.  Defaults to `git describe`")
+	outDir := flag.String("out", "dist", "Directory to write the binaries to")
+	var with stringsFlag
+	flag.Var(&with, "with", "Package of custom transforms to compile in, optionally with its module version, eg example.com/acme/transforms@v0.3.0.  Can be repeated")
+	flag.Parse()
+
+	// Undo the changes of -with before exiting, even on an error
+	cleanup := func() {}
+	fatalf := func(format string, args ...interface{}) {
+		cleanup()
+		log.Fatalf(format, args...)
+	}
+
+	commit, err := gitOutput("rev-parse", "--short", "HEAD")
+	if err != nil {
+		fatalf("Error getting the commit: %v", err)
+	}
+	if *version == "" {
+		if *version, err = gitOutput("describe", "--tags", "--always", "--dirty"); err != nil {
+			fatalf("Error getting the version: %v", err)
+		}
+	}
+
+	// After git describe, so that the generated file doesn't mark the version dirty
+	if len(with) > 0 {
+		if cleanup, err = addCustomTransforms(with); err != nil {
+			log.Fatalf("Error adding custom transforms: %v", err)
+		}
+		defer cleanup()
+	}
+
+	ldflags := strings.Join([]string{
+		"-s", "-w",
+		"-X", versionPackage + ".version=" + *version,
+		"-X", versionPackage + ".commit=" + commit,
+		"-X", versionPackage + ".buildDate=" + time.Now().UTC().Format(time.RFC3339),
+	}, " ")
+
+	if err := os.MkdirAll(*outDir, 0755); err != nil {
+		fatalf("Error creating %v: %v", *outDir, err)
+	}
+
+	for _, platform := range releasePlatforms {
+		binary := fmt.Sprintf("gocb-example-%v-%v-%v", *version, platform.goos, platform.goarch)
+		if platform.goos == "windows" {
+			binary += ".exe"
+		}
+		path := filepath.Join(*outDir, binary)
+
+		cmd := exec.Command("go", "build", "-trimpath", "-ldflags", ldflags, "-o", path, ".")
+		cmd.Env = append(os.Environ(), "CGO_ENABLED=0", "GOOS="+platform.goos, "GOARCH="+platform.goarch)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			fatalf("Error building %v: %v", binary, err)
+		}
+		log.Printf("Built %v", path)
+	}
+
+}
+
+func gitOutput(args ...string) (string, error) {
+	out, err := exec.Command("git", args...).Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// Import the packages in a file of their own and add their modules to go.mod, so that their init functions
+// register their transforms.  Returns a func restoring go.mod and go.sum and removing the file.
+func addCustomTransforms(packages []string) (cleanup func(), err error) {
+
+	goMod, err := os.ReadFile("go.mod")
+	if err != nil {
+		return nil, err
+	}
+	goSum, err := os.ReadFile("go.sum")
+	if err != nil {
+		return nil, err
+	}
+	cleanup = func() {
+		os.Remove(customTransformsFile)
+		os.WriteFile("go.mod", goMod, 0644)
+		os.WriteFile("go.sum", goSum, 0644)
+	}
+
+	source := "// Code generated by release.go -with.  DO NOT EDIT.\n\npackage main\n\nimport (\n"
+	for _, pkg := range packages {
+		source += fmt.Sprintf("\t_ %q\n", strings.SplitN(pkg, "@", 2)[0])
+	}
+	source += ")\n"
+	if err := os.WriteFile(customTransformsFile, []byte(source), 0644); err != nil {
+		return nil, err
+	}
+
+	for _, pkg := range packages {
+		cmd := exec.Command("go", "get", pkg)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			cleanup()
+			return nil, fmt.Errorf("go get %v: %v", pkg, err)
+		}
+		log.Printf("Added custom transforms: %v", pkg)
+	}
+	return cleanup, nil
+}
@@ -0,0 +1,64 @@
+// Package transforms is the registry of transforms that live outside gocb-example but run in its pipeline, eg
+// proprietary business rules.  A package registers its transforms in init:
+//
+//	func init() {
+//		transforms.Register("acme-redact", newRedactor)
+//	}
+//
+// and is compiled into a custom gocb-example binary with `go run release.go -with example.com/acme/transforms`,
+// after which a config runs it with "customTransforms": [{"name": "acme-redact", "config": {..}}].
+package transforms
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Transforms a page of docs, returning the docs to write in their place.  It can drop docs and change ids, and is
+// called from several goroutines at once.
+type Transform func(docIds []string, docs []interface{}) (outputIds []string, outputDocs []interface{}, err error)
+
+// Builds a Transform from the config it's given in the customTransforms of a config file, which is null if it has
+// none.  Called once per job.
+type TransformFactory func(config json.RawMessage) (Transform, error)
+
+var (
+	mutex     sync.RWMutex
+	factories = map[string]TransformFactory{}
+)
+
+// Make a transform available to configs under name.  Panics if the name is empty or already registered, or the
+// factory is nil, since that's a bug in the binary rather than the config.
+func Register(name string, factory TransformFactory) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if name == "" || factory == nil {
+		panic("transforms: Register needs a name and a factory")
+	}
+	if _, ok := factories[name]; ok {
+		panic(fmt.Sprintf("transforms: Register called twice for transform: %v", name))
+	}
+	factories[name] = factory
+}
+
+// The factory registered under name
+func Lookup(name string) (TransformFactory, bool) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	factory, ok := factories[name]
+	return factory, ok
+}
+
+// The names of the registered transforms, sorted
+func Names() []string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}